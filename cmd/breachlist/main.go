@@ -0,0 +1,69 @@
+// Command breachlist builds the breached password list the password policy
+// checks against.
+//
+//	go run ./cmd/breachlist [-sha1] <input> <output>
+//
+// The input has one password per line. With -sha1 it has one SHA-1 hash per
+// line instead, optionally followed by ":<count>", as in the Have I Been Pwned
+// downloads.
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/MinhNHHH/online-store/pkg/passwords"
+)
+
+func main() {
+	hashed := flag.Bool("sha1", false, "the input holds SHA-1 hashes instead of passwords")
+	flag.Parse()
+	if flag.NArg() != 2 {
+		fmt.Println("Usage: go run ./cmd/breachlist [-sha1] <input> <output>")
+		os.Exit(1)
+	}
+
+	in, err := os.Open(flag.Arg(0))
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer in.Close()
+
+	var hashes []string
+	scanner := bufio.NewScanner(in)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if line == "" {
+			continue
+		}
+		if *hashed {
+			hash, _, _ := strings.Cut(line, ":")
+			hashes = append(hashes, hash)
+		} else {
+			hashes = append(hashes, passwords.Hash(line))
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		log.Fatal(err)
+	}
+
+	out, err := os.Create(flag.Arg(1))
+	if err != nil {
+		log.Fatal(err)
+	}
+	w := bufio.NewWriter(out)
+	if err := passwords.WriteBreachedList(w, hashes); err != nil {
+		log.Fatal(err)
+	}
+	if err := w.Flush(); err != nil {
+		log.Fatal(err)
+	}
+	if err := out.Close(); err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("Read %d entries, wrote %s", len(hashes), flag.Arg(1))
+}
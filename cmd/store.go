@@ -6,9 +6,12 @@ import (
 	"net/http"
 	"os"
 	"strconv"
+	"time"
 
 	"github.com/MinhNHHH/online-store/pkg/cfgs"
 	"github.com/MinhNHHH/online-store/pkg/databases/repositories/dbrepo"
+	"github.com/MinhNHHH/online-store/pkg/mailer"
+	"github.com/MinhNHHH/online-store/pkg/storage"
 	"github.com/MinhNHHH/online-store/pkg/store"
 )
 
@@ -21,10 +24,41 @@ func initializeApp(cfgs cfgs.Configs) store.OnlineStore {
 	}
 	app.DB = &dbrepo.DBRepo{SqlConn: sqlConn}
 	app.Cfgs = cfgs
+	if err := store.CheckEmailVerification(cfgs); err != nil {
+		log.Fatal(err)
+	}
+	app.Session = store.NewSessionManager(dbrepo.NewSessionStore(sqlConn, time.Minute*5), cfgs)
+	app.Mailer = newMailer(cfgs)
+	app.Keys, err = store.LoadKeySet(cfgs)
+	if err != nil {
+		log.Fatal(err)
+	}
+	providers, err := cfgs.OIDCProviders()
+	if err != nil {
+		log.Fatal(err)
+	}
+	app.OIDC = store.NewOIDCProviders(providers)
+	app.Passwords, err = store.LoadPasswordPolicy(cfgs)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if _, err := cfgs.ThumbnailSizes(); err != nil {
+		log.Fatal(err)
+	}
+	app.Storage = storage.NewLocalStorage(cfgs.MEDIA_DIR, cfgs.MEDIA_BASE_URL)
 	return app
 }
 
+func newMailer(cfgs cfgs.Configs) mailer.Mailer {
+	if cfgs.MAILER == "smtp" {
+		return mailer.NewSMTPMailer(cfgs.SMTP_HOST, cfgs.SMTP_PORT, cfgs.SMTP_USERNAME, cfgs.SMTP_PASSWORD, cfgs.MAIL_FROM)
+	}
+	return mailer.NewOutboxMailer(cfgs.MAIL_OUTBOX_DIR, cfgs.MAIL_FROM)
+}
+
 func startServer(app store.OnlineStore) {
+	go app.PurgeDeletedUsersEvery(time.Hour)
+
 	log.Println("Starting server on :8080")
 	err := http.ListenAndServe(":8080", app.Routes())
 	if err != nil {
@@ -61,6 +95,8 @@ func handleCommand(app store.OnlineStore, command string, args []string) {
 		handleMigrate(app, args)
 	case "create":
 		handleCreate(app, args)
+	case "purge-users":
+		app.PurgeDeletedUsers()
 	default:
 		startServer(app)
 	}
@@ -75,6 +111,7 @@ func main() {
 		fmt.Println("Commands:")
 		fmt.Println("  migrate [steps] - Run migrations (optional number of steps)")
 		fmt.Println("  create [name]   - Create new migration files")
+		fmt.Println("  purge-users     - Delete accounts whose deletion grace period is over")
 		fmt.Println("  start           - Run server")
 		os.Exit(1)
 	}
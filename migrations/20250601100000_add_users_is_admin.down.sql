@@ -0,0 +1,2 @@
+-- Add your down migration here
+ALTER TABLE users DROP COLUMN IF EXISTS is_admin;
@@ -0,0 +1,2 @@
+-- Add your up migration here
+ALTER TABLE users ADD COLUMN IF NOT EXISTS is_admin BOOLEAN DEFAULT FALSE;
@@ -0,0 +1,14 @@
+-- Add your up migration here
+CREATE TABLE refresh_tokens (
+	jti VARCHAR(64) PRIMARY KEY,
+	user_id INT NOT NULL,
+	family_id VARCHAR(64) NOT NULL,
+	replaced_by VARCHAR(64),
+	expires_at TIMESTAMP NOT NULL,
+	revoked_at TIMESTAMP,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_refresh_tokens_user_id ON refresh_tokens(user_id);
+CREATE INDEX idx_refresh_tokens_family_id ON refresh_tokens(family_id);
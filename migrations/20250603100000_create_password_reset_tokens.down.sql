@@ -0,0 +1,4 @@
+-- Add your down migration here
+DROP INDEX IF EXISTS idx_password_reset_tokens_user_id;
+
+DROP TABLE IF EXISTS password_reset_tokens;
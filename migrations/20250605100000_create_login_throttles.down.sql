@@ -0,0 +1,6 @@
+-- Add your down migration here
+DROP INDEX IF EXISTS idx_login_lockouts_scope_key;
+DROP INDEX IF EXISTS idx_login_lockouts_created_at;
+
+DROP TABLE IF EXISTS login_lockouts;
+DROP TABLE IF EXISTS login_throttles;
@@ -0,0 +1,27 @@
+-- Add your up migration here
+CREATE TABLE login_throttles (
+	scope VARCHAR(16) NOT NULL CHECK (scope IN ('account', 'ip')),
+	key VARCHAR(255) NOT NULL,
+	failures INT NOT NULL DEFAULT 0,
+	last_failure_at TIMESTAMP,
+	locked_until TIMESTAMP,
+	PRIMARY KEY (scope, key)
+);
+
+CREATE TABLE login_lockouts (
+	id SERIAL PRIMARY KEY,
+	scope VARCHAR(16) NOT NULL,
+	key VARCHAR(255) NOT NULL,
+	user_id INT,
+	ip VARCHAR(64),
+	failures INT NOT NULL,
+	locked_until TIMESTAMP NOT NULL,
+	unlocked_at TIMESTAMP,
+	unlocked_by INT,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+	FOREIGN KEY (unlocked_by) REFERENCES users(id) ON DELETE SET NULL
+);
+
+CREATE INDEX idx_login_lockouts_scope_key ON login_lockouts(scope, key);
+CREATE INDEX idx_login_lockouts_created_at ON login_lockouts(created_at);
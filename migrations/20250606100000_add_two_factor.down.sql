@@ -0,0 +1,10 @@
+-- Add your down migration here
+DROP INDEX IF EXISTS idx_recovery_codes_user_id;
+
+DROP TABLE IF EXISTS recovery_codes;
+
+ALTER TABLE refresh_tokens DROP COLUMN IF EXISTS mfa;
+
+ALTER TABLE users DROP COLUMN IF EXISTS totp_last_step;
+ALTER TABLE users DROP COLUMN IF EXISTS totp_enabled_at;
+ALTER TABLE users DROP COLUMN IF EXISTS totp_secret;
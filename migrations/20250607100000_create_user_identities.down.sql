@@ -0,0 +1,4 @@
+-- Add your down migration here
+DROP INDEX IF EXISTS idx_user_identities_user_id;
+
+DROP TABLE IF EXISTS user_identities;
@@ -0,0 +1,2 @@
+-- Add your down migration here
+DROP TABLE IF EXISTS api_keys;
@@ -0,0 +1,4 @@
+-- Add your down migration here
+DROP INDEX IF EXISTS idx_sessions_expiry;
+
+DROP TABLE IF EXISTS sessions;
@@ -0,0 +1,2 @@
+-- Add your up migration here
+ALTER TABLE users ADD COLUMN deactivated_at TIMESTAMP;
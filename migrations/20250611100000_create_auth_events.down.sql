@@ -0,0 +1,4 @@
+-- Add your down migration here
+DROP TABLE IF EXISTS auth_events;
+
+DROP FUNCTION IF EXISTS auth_events_append_only();
@@ -0,0 +1,32 @@
+-- Add your up migration here
+-- auth_events has no foreign keys: events outlive the users they name.
+CREATE TABLE auth_events (
+	id SERIAL PRIMARY KEY,
+	event_type VARCHAR(64) NOT NULL,
+	outcome VARCHAR(16) NOT NULL CHECK (outcome IN ('success', 'failure')),
+	reason VARCHAR(255),
+	user_id INT,
+	actor_id INT,
+	email VARCHAR(255),
+	ip VARCHAR(64),
+	user_agent TEXT,
+	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_auth_events_created_at ON auth_events(created_at);
+CREATE INDEX idx_auth_events_user_id ON auth_events(user_id, created_at);
+CREATE INDEX idx_auth_events_event_type ON auth_events(event_type, created_at);
+
+CREATE FUNCTION auth_events_append_only() RETURNS TRIGGER AS $$
+BEGIN
+	RAISE EXCEPTION 'auth_events is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER auth_events_no_update_or_delete
+	BEFORE UPDATE OR DELETE ON auth_events
+	FOR EACH ROW EXECUTE FUNCTION auth_events_append_only();
+
+CREATE TRIGGER auth_events_no_truncate
+	BEFORE TRUNCATE ON auth_events
+	FOR EACH STATEMENT EXECUTE FUNCTION auth_events_append_only();
@@ -0,0 +1,11 @@
+-- Add your down migration here
+DELETE FROM reviews WHERE user_id IS NULL;
+ALTER TABLE reviews DROP CONSTRAINT reviews_user_id_fkey;
+ALTER TABLE reviews ADD CONSTRAINT reviews_user_id_fkey
+	FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE;
+ALTER TABLE reviews ALTER COLUMN user_id SET NOT NULL;
+
+DROP INDEX IF EXISTS idx_users_deletion_scheduled_at;
+
+ALTER TABLE users DROP COLUMN IF EXISTS deletion_mode;
+ALTER TABLE users DROP COLUMN IF EXISTS deletion_scheduled_at;
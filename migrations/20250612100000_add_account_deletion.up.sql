@@ -0,0 +1,11 @@
+-- Add your up migration here
+ALTER TABLE users ADD COLUMN deletion_scheduled_at TIMESTAMP;
+ALTER TABLE users ADD COLUMN deletion_mode VARCHAR(16) CHECK (deletion_mode IN ('delete', 'anonymize'));
+
+CREATE INDEX idx_users_deletion_scheduled_at ON users(deletion_scheduled_at);
+
+-- reviews of erased users may be kept without their author
+ALTER TABLE reviews ALTER COLUMN user_id DROP NOT NULL;
+ALTER TABLE reviews DROP CONSTRAINT reviews_user_id_fkey;
+ALTER TABLE reviews ADD CONSTRAINT reviews_user_id_fkey
+	FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE SET NULL;
@@ -0,0 +1,5 @@
+-- Add your down migration here
+DROP INDEX IF EXISTS idx_product_variants_product_id;
+DROP TABLE IF EXISTS product_variants;
+
+ALTER TABLE products DROP COLUMN IF EXISTS options;
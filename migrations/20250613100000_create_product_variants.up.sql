@@ -0,0 +1,20 @@
+-- Add your up migration here
+-- the option axes a product's variants are defined by, e.g. ["size", "color"]
+ALTER TABLE products ADD COLUMN options JSONB NOT NULL DEFAULT '[]';
+
+CREATE TABLE product_variants (
+	id SERIAL PRIMARY KEY,
+	product_id INT NOT NULL,
+	sku VARCHAR(64) NOT NULL UNIQUE CHECK (sku <> ''),
+	options JSONB NOT NULL DEFAULT '{}',
+	-- NULL uses the product's price
+	price DECIMAL(10, 2) CHECK (price >= 0),
+	stock_quantity INT NOT NULL DEFAULT 0 CHECK (stock_quantity >= 0),
+	status VARCHAR(50) NOT NULL DEFAULT 'in_stock' CHECK (status IN ('in_stock', 'out_of_stock', 'draft')),
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY (product_id) REFERENCES products(id) ON DELETE CASCADE,
+	UNIQUE (product_id, options)
+);
+
+CREATE INDEX idx_product_variants_product_id ON product_variants(product_id);
@@ -0,0 +1,18 @@
+-- Add your up migration here
+CREATE TABLE product_images (
+	id SERIAL PRIMARY KEY,
+	product_id INT NOT NULL,
+	storage_key VARCHAR(255) NOT NULL,
+	content_type VARCHAR(64) NOT NULL,
+	width INT NOT NULL,
+	height INT NOT NULL,
+	size_bytes BIGINT NOT NULL,
+	alt_text VARCHAR(255) NOT NULL DEFAULT '',
+	position INT NOT NULL DEFAULT 0,
+	-- storage keys of the thumbnails, by the length of their longest side
+	thumbnails JSONB NOT NULL DEFAULT '{}',
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY (product_id) REFERENCES products(id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_product_images_product_id ON product_images(product_id, position);
@@ -0,0 +1,14 @@
+-- Add your down migration here
+DROP INDEX IF EXISTS idx_products_search_vector;
+
+DROP TRIGGER IF EXISTS categories_search_vector_refresh ON categories;
+DROP TRIGGER IF EXISTS product_categories_search_vector_refresh ON product_categories;
+DROP TRIGGER IF EXISTS products_search_vector_refresh ON products;
+
+DROP FUNCTION IF EXISTS categories_search_vector_refresh();
+DROP FUNCTION IF EXISTS product_categories_search_vector_refresh();
+DROP FUNCTION IF EXISTS products_search_vector_refresh();
+DROP FUNCTION IF EXISTS product_search_vector(INT, TEXT, TEXT);
+DROP FUNCTION IF EXISTS search_vector_of(TEXT, "char");
+
+ALTER TABLE products DROP COLUMN IF EXISTS search_vector;
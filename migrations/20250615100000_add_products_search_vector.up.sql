@@ -0,0 +1,67 @@
+-- Add your up migration here
+-- search_vector covers the name (weight A), description (B) and category names
+-- (C) of a product. Category names live in other tables, so triggers keep it
+-- up to date rather than a generated column. Words are kept both stemmed and
+-- as written, so that prefixes of whole words match too.
+ALTER TABLE products ADD COLUMN search_vector TSVECTOR;
+
+CREATE FUNCTION search_vector_of(document TEXT, weight "char") RETURNS TSVECTOR AS $$
+	SELECT setweight(to_tsvector('english', coalesce(document, ''))
+		|| to_tsvector('simple', coalesce(document, '')), weight);
+$$ LANGUAGE sql IMMUTABLE;
+
+CREATE FUNCTION product_search_vector(product_id INT, name TEXT, description TEXT) RETURNS TSVECTOR AS $$
+	SELECT search_vector_of(name, 'A')
+		|| search_vector_of(description, 'B')
+		|| search_vector_of((
+			SELECT string_agg(c.name, ' ')
+			FROM product_categories pc
+			INNER JOIN categories c ON pc.category_id = c.id
+			WHERE pc.product_id = product_search_vector.product_id
+		), 'C');
+$$ LANGUAGE sql STABLE;
+
+CREATE FUNCTION products_search_vector_refresh() RETURNS TRIGGER AS $$
+BEGIN
+	NEW.search_vector := product_search_vector(NEW.id, NEW.name, NEW.description);
+	RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER products_search_vector_refresh
+	BEFORE INSERT OR UPDATE OF name, description ON products
+	FOR EACH ROW EXECUTE FUNCTION products_search_vector_refresh();
+
+CREATE FUNCTION product_categories_search_vector_refresh() RETURNS TRIGGER AS $$
+BEGIN
+	IF TG_OP IN ('INSERT', 'UPDATE') THEN
+		UPDATE products SET search_vector = product_search_vector(id, name, description)
+		WHERE id = NEW.product_id;
+	END IF;
+	IF TG_OP IN ('UPDATE', 'DELETE') THEN
+		UPDATE products SET search_vector = product_search_vector(id, name, description)
+		WHERE id = OLD.product_id;
+	END IF;
+	RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER product_categories_search_vector_refresh
+	AFTER INSERT OR UPDATE OR DELETE ON product_categories
+	FOR EACH ROW EXECUTE FUNCTION product_categories_search_vector_refresh();
+
+CREATE FUNCTION categories_search_vector_refresh() RETURNS TRIGGER AS $$
+BEGIN
+	UPDATE products SET search_vector = product_search_vector(id, name, description)
+	WHERE id IN (SELECT product_id FROM product_categories WHERE category_id = NEW.id);
+	RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER categories_search_vector_refresh
+	AFTER UPDATE OF name ON categories
+	FOR EACH ROW EXECUTE FUNCTION categories_search_vector_refresh();
+
+UPDATE products SET search_vector = product_search_vector(id, name, description);
+
+CREATE INDEX idx_products_search_vector ON products USING GIN (search_vector);
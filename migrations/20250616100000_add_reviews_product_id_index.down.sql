@@ -0,0 +1,2 @@
+-- Add your down migration here
+DROP INDEX IF EXISTS idx_reviews_product_id;
@@ -0,0 +1,3 @@
+-- Add your up migration here
+-- product lists aggregate the ratings of each product
+CREATE INDEX idx_reviews_product_id ON reviews(product_id, rating);
@@ -0,0 +1,2 @@
+-- Add your down migration here
+ALTER TABLE products DROP COLUMN IF EXISTS slug;
@@ -0,0 +1,36 @@
+-- Add your up migration here
+-- slug names a product in URLs. New products get theirs from the application;
+-- existing ones get one from their name here, without accented letters.
+ALTER TABLE products ADD COLUMN slug VARCHAR(80);
+
+UPDATE products
+SET slug = trim(BOTH '-' FROM left(trim(BOTH '-' FROM regexp_replace(lower(name), '[^a-z0-9]+', '-', 'g')), 80));
+
+-- slugs of digits alone would be taken for IDs
+UPDATE products SET slug = left(trim(BOTH '-' FROM 'product-' || slug), 80) WHERE slug !~ '[a-z]';
+
+-- later rows sharing a slug are numbered from 2 like the application does,
+-- skipping numbers whose slug is already taken
+DO $$
+DECLARE
+	dup RECORD;
+	n INT;
+	candidate VARCHAR(80);
+BEGIN
+	FOR dup IN
+		SELECT p.id, p.slug FROM products AS p
+		WHERE EXISTS (SELECT 1 FROM products AS o WHERE o.slug = p.slug AND o.id < p.id)
+		ORDER BY p.id
+	LOOP
+		n := 2;
+		LOOP
+			candidate := rtrim(left(dup.slug, 79 - length(n::TEXT)), '-') || '-' || n;
+			EXIT WHEN NOT EXISTS (SELECT 1 FROM products WHERE slug = candidate);
+			n := n + 1;
+		END LOOP;
+		UPDATE products SET slug = candidate WHERE id = dup.id;
+	END LOOP;
+END $$;
+
+ALTER TABLE products ALTER COLUMN slug SET NOT NULL;
+ALTER TABLE products ADD CONSTRAINT products_slug_key UNIQUE (slug);
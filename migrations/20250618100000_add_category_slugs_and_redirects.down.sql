@@ -0,0 +1,7 @@
+-- Add your down migration here
+DROP INDEX IF EXISTS idx_category_slug_redirects_category_id;
+DROP INDEX IF EXISTS idx_product_slug_redirects_product_id;
+DROP TABLE IF EXISTS category_slug_redirects;
+DROP TABLE IF EXISTS product_slug_redirects;
+
+ALTER TABLE categories DROP COLUMN IF EXISTS slug;
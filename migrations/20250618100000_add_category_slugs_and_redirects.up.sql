@@ -0,0 +1,53 @@
+-- Add your up migration here
+-- slug names a category in URLs, made from the name like product slugs.
+ALTER TABLE categories ADD COLUMN slug VARCHAR(80);
+
+UPDATE categories
+SET slug = trim(BOTH '-' FROM left(trim(BOTH '-' FROM regexp_replace(lower(name), '[^a-z0-9]+', '-', 'g')), 80));
+
+-- slugs of digits alone would be taken for IDs
+UPDATE categories SET slug = left(trim(BOTH '-' FROM 'category-' || slug), 80) WHERE slug !~ '[a-z]';
+
+-- later rows sharing a slug are numbered from 2, skipping taken numbers, as
+-- for products
+DO $$
+DECLARE
+	dup RECORD;
+	n INT;
+	candidate VARCHAR(80);
+BEGIN
+	FOR dup IN
+		SELECT c.id, c.slug FROM categories AS c
+		WHERE EXISTS (SELECT 1 FROM categories AS o WHERE o.slug = c.slug AND o.id < c.id)
+		ORDER BY c.id
+	LOOP
+		n := 2;
+		LOOP
+			candidate := rtrim(left(dup.slug, 79 - length(n::TEXT)), '-') || '-' || n;
+			EXIT WHEN NOT EXISTS (SELECT 1 FROM categories WHERE slug = candidate);
+			n := n + 1;
+		END LOOP;
+		UPDATE categories SET slug = candidate WHERE id = dup.id;
+	END LOOP;
+END $$;
+
+ALTER TABLE categories ALTER COLUMN slug SET NOT NULL;
+ALTER TABLE categories ADD CONSTRAINT categories_slug_key UNIQUE (slug);
+
+-- old slugs keep redirecting to what they named
+CREATE TABLE product_slug_redirects (
+	slug VARCHAR(80) PRIMARY KEY,
+	product_id INT NOT NULL,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY (product_id) REFERENCES products(id) ON DELETE CASCADE
+);
+
+CREATE TABLE category_slug_redirects (
+	slug VARCHAR(80) PRIMARY KEY,
+	category_id INT NOT NULL,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY (category_id) REFERENCES categories(id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_product_slug_redirects_product_id ON product_slug_redirects(product_id);
+CREATE INDEX idx_category_slug_redirects_category_id ON category_slug_redirects(category_id);
@@ -0,0 +1,5 @@
+-- Add your down migration here
+DROP INDEX IF EXISTS idx_categories_parent_id;
+ALTER TABLE categories DROP CONSTRAINT IF EXISTS categories_parent_id_check;
+ALTER TABLE categories DROP CONSTRAINT IF EXISTS categories_parent_id_fkey;
+ALTER TABLE categories DROP COLUMN IF EXISTS parent_id;
@@ -0,0 +1,8 @@
+-- Add your up migration here
+-- parent_id nests categories into a tree; top-level categories have none.
+ALTER TABLE categories ADD COLUMN parent_id INT;
+ALTER TABLE categories ADD CONSTRAINT categories_parent_id_fkey
+	FOREIGN KEY (parent_id) REFERENCES categories(id) ON DELETE SET NULL;
+ALTER TABLE categories ADD CONSTRAINT categories_parent_id_check CHECK (parent_id <> id);
+
+CREATE INDEX idx_categories_parent_id ON categories(parent_id);
@@ -0,0 +1,4 @@
+-- Add your down migration here
+DELETE FROM login_throttles WHERE scope = 'challenge';
+ALTER TABLE login_throttles DROP CONSTRAINT IF EXISTS login_throttles_scope_check;
+ALTER TABLE login_throttles ADD CONSTRAINT login_throttles_scope_check CHECK (scope IN ('account', 'ip'));
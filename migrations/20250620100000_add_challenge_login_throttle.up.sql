@@ -0,0 +1,4 @@
+-- Add your up migration here
+-- challenge counts the failed codes sent with one two-factor challenge.
+ALTER TABLE login_throttles DROP CONSTRAINT IF EXISTS login_throttles_scope_check;
+ALTER TABLE login_throttles ADD CONSTRAINT login_throttles_scope_check CHECK (scope IN ('account', 'ip', 'challenge'));
@@ -0,0 +1,2 @@
+-- Add your up migration here
+ALTER TABLE users ADD COLUMN session_generation INT NOT NULL DEFAULT 0;
@@ -0,0 +1,6 @@
+-- Add your down migration here
+CREATE OR REPLACE FUNCTION auth_events_append_only() RETURNS TRIGGER AS $$
+BEGIN
+	RAISE EXCEPTION 'auth_events is append-only';
+END;
+$$ LANGUAGE plpgsql;
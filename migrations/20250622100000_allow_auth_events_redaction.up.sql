@@ -0,0 +1,14 @@
+-- Add your up migration here
+-- Purging a user blanks the email, IP and user agent of their events; any
+-- other change to an event is still refused.
+CREATE OR REPLACE FUNCTION auth_events_append_only() RETURNS TRIGGER AS $$
+BEGIN
+	IF TG_OP = 'UPDATE'
+		AND NEW.email IS NULL AND NEW.ip IS NULL AND NEW.user_agent IS NULL
+		AND (NEW.id, NEW.event_type, NEW.outcome, NEW.reason, NEW.user_id, NEW.actor_id, NEW.created_at)
+			IS NOT DISTINCT FROM (OLD.id, OLD.event_type, OLD.outcome, OLD.reason, OLD.user_id, OLD.actor_id, OLD.created_at) THEN
+		RETURN NEW;
+	END IF;
+	RAISE EXCEPTION 'auth_events is append-only';
+END;
+$$ LANGUAGE plpgsql;
@@ -1,17 +1,116 @@
 package cfgs
 
 import (
+	"fmt"
 	"log"
 	"os"
 	"reflect"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
 )
 
+// Configs is filled from SO_<FIELD NAME> environment variables. Fields without
+// a default tag are required.
 type Configs struct {
 	DB_CONNECTION_URI string
 	JWT_SECRET        string
 	DOMAIN            string
+	APP_URL           string `default:"http://localhost:8080"`
+	MAILER            string `default:"outbox"`
+	MAIL_FROM         string `default:"no-reply@localhost"`
+	MAIL_OUTBOX_DIR   string `default:"outbox"`
+	SMTP_HOST         string `default:""`
+	SMTP_PORT         string `default:"587"`
+	SMTP_USERNAME     string `default:""`
+	SMTP_PASSWORD     string `default:""`
+	// EMAIL_VERIFICATION is one of "optional", "limited" or "required".
+	EMAIL_VERIFICATION string `default:"optional"`
+	// ADMIN_REQUIRE_2FA only lets admins use admin routes after logging in
+	// with a second factor.
+	ADMIN_REQUIRE_2FA bool `default:"false"`
+	// JWT_ALGORITHM is one of "HS256", "RS256" or "EdDSA". The asymmetric ones
+	// sign with JWT_PRIVATE_KEY_FILE and also accept tokens from the keys in
+	// the comma separated JWT_PUBLIC_KEY_FILES, for rotations.
+	JWT_ALGORITHM        string `default:"HS256"`
+	JWT_PRIVATE_KEY_FILE string `default:""`
+	JWT_PUBLIC_KEY_FILES string `default:""`
+	// OIDC_PROVIDERS is a comma separated list of OpenID Connect provider
+	// names, each configured by SO_OIDC_<NAME>_ISSUER, _CLIENT_ID and
+	// _CLIENT_SECRET. See OIDCProviders.
+	OIDC_PROVIDERS string `default:""`
+	// SESSION_IDLE_TIMEOUT ends cookie sessions unused for that long, and
+	// SESSION_LIFETIME ends them that long after login regardless of use.
+	SESSION_IDLE_TIMEOUT time.Duration `default:"30m"`
+	SESSION_LIFETIME     time.Duration `default:"12h"`
+	// PASSWORD_MIN_LENGTH counts characters and PASSWORD_MAX_BYTES bytes; the
+	// latter is capped at the 72 bcrypt hashes. New passwords found in
+	// PASSWORD_BREACHED_LIST, made by cmd/breachlist, are refused. An empty
+	// path turns that check off.
+	PASSWORD_MIN_LENGTH    int    `default:"8"`
+	PASSWORD_MAX_BYTES     int    `default:"72"`
+	PASSWORD_BREACHED_LIST string `default:"data/breached-passwords.txt"`
+	// ACCOUNT_DELETION_GRACE is how long a deleted account can still be
+	// restored by logging in before it is purged.
+	ACCOUNT_DELETION_GRACE time.Duration `default:"720h"`
+	// MEDIA_DIR is where uploaded images are kept, and MEDIA_BASE_URL where
+	// they are served from. Uploads are capped at IMAGE_MAX_BYTES, and each
+	// image gets a thumbnail for every size in the comma separated
+	// IMAGE_THUMBNAIL_SIZES: the length in pixels of its longest side.
+	MEDIA_DIR             string `default:"media"`
+	MEDIA_BASE_URL        string `default:"/media"`
+	IMAGE_MAX_BYTES       int    `default:"10485760"`
+	IMAGE_THUMBNAIL_SIZES string `default:"160,480"`
+}
+
+// ThumbnailSizes parses IMAGE_THUMBNAIL_SIZES.
+func (c Configs) ThumbnailSizes() ([]int, error) {
+	var sizes []int
+	for _, value := range strings.Split(c.IMAGE_THUMBNAIL_SIZES, ",") {
+		value = strings.TrimSpace(value)
+		if value == "" {
+			continue
+		}
+		size, err := strconv.Atoi(value)
+		if err != nil || size < 1 {
+			return nil, fmt.Errorf("invalid thumbnail size %q", value)
+		}
+		sizes = append(sizes, size)
+	}
+	return sizes, nil
+}
+
+// OIDCProvider is an OpenID Connect provider users can sign in with.
+type OIDCProvider struct {
+	Name         string
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+}
+
+// OIDCProviders reads the settings of every provider named in OIDC_PROVIDERS.
+func (c Configs) OIDCProviders() ([]OIDCProvider, error) {
+	var providers []OIDCProvider
+	for _, name := range strings.Split(c.OIDC_PROVIDERS, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		prefix := "SO_OIDC_" + strings.ToUpper(name) + "_"
+		provider := OIDCProvider{
+			Name:         strings.ToLower(name),
+			Issuer:       os.Getenv(prefix + "ISSUER"),
+			ClientID:     os.Getenv(prefix + "CLIENT_ID"),
+			ClientSecret: os.Getenv(prefix + "CLIENT_SECRET"),
+		}
+		if provider.Issuer == "" || provider.ClientID == "" {
+			return nil, fmt.Errorf("OIDC provider %s needs %sISSUER and %sCLIENT_ID", name, prefix, prefix)
+		}
+		providers = append(providers, provider)
+	}
+	return providers, nil
 }
 
 func LoadConfigs() Configs {
@@ -27,11 +126,55 @@ func LoadConfigs() Configs {
 	for i := 0; i < configType.NumField(); i++ {
 		field := configType.Field(i)
 		envKey := "SO_" + field.Name
-		if value := os.Getenv(envKey); value != "" {
-			configValue.Field(i).SetString(value)
-		} else {
-			log.Fatalf("Error: Required environment variable %s not set", envKey)
+		value := os.Getenv(envKey)
+		if value == "" {
+			defaultValue, ok := field.Tag.Lookup("default")
+			if !ok {
+				log.Fatalf("Error: Required environment variable %s not set", envKey)
+			}
+			value = defaultValue
+		}
+		if err := setField(configValue.Field(i), value); err != nil {
+			log.Fatalf("Error: Invalid value for %s: %v", envKey, err)
 		}
 	}
 	return defaultConfig
 }
+
+func setField(field reflect.Value, value string) error {
+	if field.Type() == reflect.TypeOf(time.Duration(0)) {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(d))
+		return nil
+	}
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+	case reflect.Bool:
+		if value == "" {
+			field.SetBool(false)
+			return nil
+		}
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		field.SetBool(b)
+	case reflect.Int:
+		if value == "" {
+			field.SetInt(0)
+			return nil
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(n))
+	default:
+		return fmt.Errorf("unsupported config type %s", field.Kind())
+	}
+	return nil
+}
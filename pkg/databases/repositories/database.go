@@ -2,31 +2,86 @@ package databases
 
 import (
 	"database/sql"
+	"time"
 
 	"github.com/MinhNHHH/online-store/pkg/databases/schema"
 )
 
 type DatabaseRepo interface {
 	SQLConnection() *sql.DB
-	AllCategories(name string, page, pageSize int) ([]*schema.Category, int, error)
+	AllCategories(name string, page schema.Page) ([]*schema.Category, *schema.PageInfo, error)
+	GetCategory(id int) (*schema.Category, error)
+	GetCategoryBySlug(slug string) (*schema.Category, error)
 	InsertCategory(category *schema.Category) (int, error)
 	UpdateCategory(category *schema.Category) error
 	DeleteCategory(id int) error
-	AllProducts(name, categoryName, status string, page, pageSize int) ([]*schema.Product, int, error)
+	CategoryTree() ([]*schema.Category, error)
+	MoveCategory(id int, parentID *int) error
+	AllProducts(filter schema.ProductFilter, page schema.Page) ([]*schema.Product, *schema.PageInfo, error)
+	ProductFacets(filter schema.ProductFilter) (*schema.ProductFacets, error)
 	InsertProduct(product *schema.Product) (int, error)
 	UpdateProduct(product *schema.Product) error
 	DeleteProduct(id int) error
-	ReviewsByProductID(productID int) ([]*schema.Review, error)
+	GetProduct(id int) (*schema.Product, error)
+	GetProductBySlug(slug string) (*schema.Product, error)
+	RatingHistogram(productID int) (map[int]int, error)
+	InsertProductVariant(variant *schema.ProductVariant) (int, error)
+	UpdateProductVariant(variant *schema.ProductVariant) error
+	DeleteProductVariant(productID, variantID int) error
+	InsertProductImage(image *schema.ProductImage) (int, error)
+	SetProductImageAltText(productID, imageID int, altText string) error
+	ReorderProductImages(productID int, imageIDs []int) error
+	DeleteProductImage(productID, imageID int) (*schema.ProductImage, error)
+	ReviewsByProductID(productID int, page schema.Page) ([]*schema.Review, *schema.PageInfo, error)
+	ReviewsByUserID(userID int) ([]*schema.Review, error)
+	GetReview(id int) (*schema.Review, error)
 	InsertReview(review *schema.Review) (int, error)
 	DeleteReview(id int) error
 	AddToWishlist(userID, productID int) error
 	RemoveFromWishlist(userID, productID int) error
 	GetWishlist(userID int) ([]*schema.Product, error)
-	AllUsers() ([]*schema.User, error)
+	AllUsers(search string, page, pageSize int) ([]*schema.User, int, error)
 	GetUser(id int) (*schema.User, error)
 	GetUserByEmail(email string) (*schema.User, error)
 	UpdateUser(user schema.User) error
 	DeleteUser(id int) error
+	ScheduleUserDeletion(id int, mode string, at time.Time) error
+	CancelUserDeletion(id int) error
+	PurgeDueUsers(now time.Time) ([]int, error)
+	SetUserAdmin(id int, isAdmin bool) error
+	SetUserDeactivated(id int, deactivated bool) error
+	RevokeUserSessions(id int) (int, error)
 	InsertUser(user schema.User) (int, error)
 	ResetPassword(id int, password string) error
+	InsertRefreshToken(token *schema.RefreshToken) error
+	GetRefreshToken(jti string) (*schema.RefreshToken, error)
+	RotateRefreshToken(oldJTI string, next *schema.RefreshToken) error
+	RevokeRefreshTokenFamily(familyID string) error
+	RevokeUserRefreshTokens(userID int) error
+	InsertPasswordResetToken(userID int, tokenHash string, expiresAt time.Time) error
+	ResetPasswordWithToken(tokenHash, password string) (int, error)
+	InsertEmailVerificationToken(userID int, email, tokenHash string, expiresAt time.Time) error
+	VerifyEmailWithToken(tokenHash string) (int, error)
+	GetLoginThrottle(scope, key string) (*schema.LoginThrottle, error)
+	RecordLoginFailure(scope, key string, window time.Duration) (*schema.LoginThrottle, error)
+	LockLogin(lockout *schema.Lockout) error
+	ForgiveLoginFailure(scope, key string) error
+	ClearLoginFailures(scope, key string) error
+	AllLockouts(activeOnly bool) ([]*schema.Lockout, error)
+	UnlockLogin(scope, key string, adminID int) error
+	SetTOTPSecret(userID int, secret string) error
+	EnableTOTP(userID int, recoveryCodeHashes []string) error
+	DisableTOTP(userID int) error
+	UseTOTPStep(userID int, step int64) error
+	UseRecoveryCode(userID int, codeHash string) error
+	GetUserByIdentity(provider, subject string) (*schema.User, error)
+	InsertUserIdentity(identity *schema.UserIdentity) error
+	InsertUserWithIdentity(user schema.User, identity *schema.UserIdentity) (int, error)
+	InsertAPIKey(key *schema.APIKey, keyHash string) (int, error)
+	AllAPIKeys() ([]*schema.APIKey, error)
+	UseAPIKey(keyHash string) (*schema.APIKey, error)
+	RevokeAPIKey(id int) error
+	InsertAuthEvent(event *schema.AuthEvent) error
+	AllAuthEvents(filter schema.AuthEventFilter, page, pageSize int) ([]*schema.AuthEvent, int, error)
+	AuthEventsBefore(filter schema.AuthEventFilter, beforeID, limit int) ([]*schema.AuthEvent, error)
 }
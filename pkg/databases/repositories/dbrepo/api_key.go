@@ -0,0 +1,131 @@
+package dbrepo
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/MinhNHHH/online-store/pkg/databases/schema"
+)
+
+// apiKeyColumns is the column list scanned by scanAPIKey. Scopes are stored
+// space separated.
+const apiKeyColumns = `id, name, prefix, scopes, created_by, last_used_at, expires_at, revoked_at, created_at`
+
+func scanAPIKey(row rowScanner) (*schema.APIKey, error) {
+	var key schema.APIKey
+	var scopes string
+	var createdBy sql.NullInt64
+	var lastUsedAt, expiresAt, revokedAt sql.NullTime
+	err := row.Scan(
+		&key.ID,
+		&key.Name,
+		&key.Prefix,
+		&scopes,
+		&createdBy,
+		&lastUsedAt,
+		&expiresAt,
+		&revokedAt,
+		&key.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	key.Scopes = strings.Fields(scopes)
+	if createdBy.Valid {
+		id := int(createdBy.Int64)
+		key.CreatedBy = &id
+	}
+	if lastUsedAt.Valid {
+		key.LastUsedAt = &lastUsedAt.Time
+	}
+	if expiresAt.Valid {
+		key.ExpiresAt = &expiresAt.Time
+	}
+	if revokedAt.Valid {
+		key.RevokedAt = &revokedAt.Time
+	}
+	return &key, nil
+}
+
+func (p *DBRepo) InsertAPIKey(key *schema.APIKey, keyHash string) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	var newID int
+	stmt := `insert into api_keys (name, prefix, key_hash, scopes, created_by, expires_at, created_at)
+		values ($1, $2, $3, $4, $5, $6, $7) returning id`
+
+	err := p.SqlConn.QueryRowContext(ctx, stmt,
+		key.Name,
+		key.Prefix,
+		keyHash,
+		strings.Join(key.Scopes, " "),
+		key.CreatedBy,
+		key.ExpiresAt,
+		time.Now(),
+	).Scan(&newID)
+	if err != nil {
+		return 0, err
+	}
+	return newID, nil
+}
+
+func (p *DBRepo) AllAPIKeys() ([]*schema.APIKey, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `select ` + apiKeyColumns + ` from api_keys order by created_at desc`
+
+	rows, err := p.SqlConn.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []*schema.APIKey{}
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// UseAPIKey returns the unexpired, unrevoked key with keyHash and records that
+// it was used. Any other key gives sql.ErrNoRows.
+func (p *DBRepo) UseAPIKey(keyHash string) (*schema.APIKey, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	now := time.Now()
+	stmt := `update api_keys set last_used_at = $1
+		where key_hash = $2 and revoked_at is null and (expires_at is null or expires_at > $1)
+		returning ` + apiKeyColumns
+
+	return scanAPIKey(p.SqlConn.QueryRowContext(ctx, stmt, now, keyHash))
+}
+
+// RevokeAPIKey revokes the key with id. It returns sql.ErrNoRows when there is
+// no such key or it was already revoked.
+func (p *DBRepo) RevokeAPIKey(id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	stmt := `update api_keys set revoked_at = $1 where id = $2 and revoked_at is null`
+	result, err := p.SqlConn.ExecContext(ctx, stmt, time.Now(), id)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
@@ -0,0 +1,48 @@
+package dbrepo
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/MinhNHHH/online-store/pkg/databases/schema"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAPIKeys(t *testing.T) {
+	id, err := testRepo.InsertAPIKey(&schema.APIKey{
+		Name:   "ERP sync",
+		Prefix: "so_12345678",
+		Scopes: []string{"products:read", "products:write"},
+	}, "erp-hash")
+	assert.NoError(t, err)
+
+	expired := time.Now().Add(-time.Hour)
+	_, err = testRepo.InsertAPIKey(&schema.APIKey{
+		Name:      "Expired",
+		Prefix:    "so_87654321",
+		Scopes:    []string{"categories:read"},
+		ExpiresAt: &expired,
+	}, "expired-hash")
+	assert.NoError(t, err)
+
+	key, err := testRepo.UseAPIKey("erp-hash")
+	assert.NoError(t, err)
+	assert.Equal(t, id, key.ID)
+	assert.Equal(t, []string{"products:read", "products:write"}, key.Scopes)
+	assert.NotNil(t, key.LastUsedAt)
+
+	_, err = testRepo.UseAPIKey("expired-hash")
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+	_, err = testRepo.UseAPIKey("unknown-hash")
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+
+	keys, err := testRepo.AllAPIKeys()
+	assert.NoError(t, err)
+	assert.Len(t, keys, 2)
+
+	assert.NoError(t, testRepo.RevokeAPIKey(id))
+	assert.ErrorIs(t, testRepo.RevokeAPIKey(id), sql.ErrNoRows)
+	_, err = testRepo.UseAPIKey("erp-hash")
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+}
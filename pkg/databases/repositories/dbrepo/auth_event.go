@@ -0,0 +1,160 @@
+package dbrepo
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/MinhNHHH/online-store/pkg/databases/schema"
+)
+
+func (p *DBRepo) InsertAuthEvent(event *schema.AuthEvent) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	stmt := `insert into auth_events (event_type, outcome, reason, user_id, actor_id, email, ip, user_agent, created_at)
+		values ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		returning id`
+
+	event.CreatedAt = time.Now()
+	return p.SqlConn.QueryRowContext(ctx, stmt,
+		event.Type,
+		event.Outcome,
+		event.Reason,
+		event.UserID,
+		event.ActorID,
+		event.Email,
+		event.IP,
+		event.UserAgent,
+		event.CreatedAt,
+	).Scan(&event.ID)
+}
+
+// AllAuthEvents returns a page of the events matching filter, newest first,
+// together with the number of matching events.
+func (p *DBRepo) AllAuthEvents(filter schema.AuthEventFilter, page, pageSize int) ([]*schema.AuthEvent, int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	where, args := authEventConditions(filter)
+
+	var total int
+	err := p.SqlConn.QueryRowContext(ctx, `select count(*) from auth_events e where 1=1`+where, args...).Scan(&total)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	offset := (page - 1) * pageSize
+	query := authEventsQuery + where + fmt.Sprintf(" order by e.created_at desc, e.id desc LIMIT $%d OFFSET $%d", len(args)+1, len(args)+2)
+	args = append(args, pageSize, offset)
+
+	rows, err := p.SqlConn.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	events, err := scanAuthEvents(rows)
+	return events, total, err
+}
+
+// AuthEventsBefore returns up to limit events matching filter with an ID
+// below beforeID, or the newest ones when beforeID is zero, highest ID first.
+// Passing the last ID returned reads the next batch; events added meanwhile
+// are not mixed in.
+func (p *DBRepo) AuthEventsBefore(filter schema.AuthEventFilter, beforeID, limit int) ([]*schema.AuthEvent, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	where, args := authEventConditions(filter)
+	if beforeID > 0 {
+		args = append(args, beforeID)
+		where += fmt.Sprintf(" and e.id < $%d", len(args))
+	}
+	query := authEventsQuery + where + fmt.Sprintf(" order by e.id desc LIMIT $%d", len(args)+1)
+	args = append(args, limit)
+
+	rows, err := p.SqlConn.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanAuthEvents(rows)
+}
+
+const authEventsQuery = `select e.id, e.event_type, e.outcome, e.reason, e.user_id, e.actor_id, e.email, e.ip, e.user_agent, e.created_at
+		from auth_events e where 1=1`
+
+// authEventConditions returns the SQL conditions selecting the events that
+// match filter, each starting with " and ", and their arguments.
+func authEventConditions(filter schema.AuthEventFilter) (string, []interface{}) {
+	where := ""
+	args := []interface{}{}
+	addFilter := func(condition string, value interface{}) {
+		args = append(args, value)
+		where += fmt.Sprintf(" and "+condition, len(args))
+	}
+
+	if filter.Type != "" {
+		addFilter("e.event_type = $%d", filter.Type)
+	}
+	if filter.Outcome != "" {
+		addFilter("e.outcome = $%d", filter.Outcome)
+	}
+	if filter.UserID != 0 {
+		addFilter("e.user_id = $%d", filter.UserID)
+	}
+	if filter.Email != "" {
+		addFilter("lower(e.email) = lower($%d)", filter.Email)
+	}
+	if filter.IP != "" {
+		addFilter("e.ip = $%d", filter.IP)
+	}
+	if filter.Since != nil {
+		addFilter("e.created_at >= $%d", *filter.Since)
+	}
+	if filter.Until != nil {
+		addFilter("e.created_at < $%d", *filter.Until)
+	}
+	return where, args
+}
+
+func scanAuthEvents(rows *sql.Rows) ([]*schema.AuthEvent, error) {
+	events := []*schema.AuthEvent{}
+	for rows.Next() {
+		var event schema.AuthEvent
+		var reason, email, ip, userAgent sql.NullString
+		var userID, actorID sql.NullInt64
+		err := rows.Scan(
+			&event.ID,
+			&event.Type,
+			&event.Outcome,
+			&reason,
+			&userID,
+			&actorID,
+			&email,
+			&ip,
+			&userAgent,
+			&event.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		if userID.Valid {
+			id := int(userID.Int64)
+			event.UserID = &id
+		}
+		if actorID.Valid {
+			id := int(actorID.Int64)
+			event.ActorID = &id
+		}
+		event.Reason = reason.String
+		event.Email = email.String
+		event.IP = ip.String
+		event.UserAgent = userAgent.String
+		events = append(events, &event)
+	}
+	return events, rows.Err()
+}
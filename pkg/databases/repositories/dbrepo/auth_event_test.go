@@ -0,0 +1,64 @@
+package dbrepo
+
+import (
+	"testing"
+	"time"
+
+	"github.com/MinhNHHH/online-store/pkg/databases/schema"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuthEvents(t *testing.T) {
+	userID, actorID := 41, 42
+	events := []*schema.AuthEvent{
+		{Type: "login", Outcome: "failure", Reason: "wrong_password", UserID: &userID, Email: "Audit@example.com", IP: "192.0.2.1", UserAgent: "curl/8.0"},
+		{Type: "login", Outcome: "success", UserID: &userID, Email: "audit@example.com", IP: "192.0.2.1"},
+		{Type: "user_promoted", Outcome: "success", UserID: &userID, ActorID: &actorID, IP: "192.0.2.9"},
+	}
+	for _, event := range events {
+		assert.NoError(t, testRepo.InsertAuthEvent(event))
+		assert.NotZero(t, event.ID)
+	}
+
+	found, total, err := testRepo.AllAuthEvents(schema.AuthEventFilter{UserID: userID}, 1, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, total)
+	assert.Len(t, found, 2)
+	assert.Equal(t, events[2].ID, found[0].ID)
+	assert.Equal(t, actorID, *found[0].ActorID)
+
+	found, total, err = testRepo.AllAuthEvents(schema.AuthEventFilter{Type: "login", Outcome: "failure", Email: "audit@example.com"}, 1, 20)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, total)
+	assert.Equal(t, "wrong_password", found[0].Reason)
+	assert.Equal(t, "curl/8.0", found[0].UserAgent)
+	assert.Nil(t, found[0].ActorID)
+
+	future := time.Now().Add(time.Hour)
+	_, total, err = testRepo.AllAuthEvents(schema.AuthEventFilter{UserID: userID, Since: &future}, 1, 20)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, total)
+
+	// batches continue below the last ID read
+	batch, err := testRepo.AuthEventsBefore(schema.AuthEventFilter{UserID: userID}, 0, 2)
+	assert.NoError(t, err)
+	assert.Len(t, batch, 2)
+	assert.Equal(t, events[2].ID, batch[0].ID)
+	assert.Equal(t, events[1].ID, batch[1].ID)
+	batch, err = testRepo.AuthEventsBefore(schema.AuthEventFilter{UserID: userID}, batch[1].ID, 2)
+	assert.NoError(t, err)
+	assert.Len(t, batch, 1)
+	assert.Equal(t, events[0].ID, batch[0].ID)
+	assert.Equal(t, "curl/8.0", batch[0].UserAgent)
+
+	// the table is append-only
+	_, err = testDB.Exec(`update auth_events set outcome = 'success' where id = $1`, events[0].ID)
+	assert.Error(t, err)
+	_, err = testDB.Exec(`update auth_events set email = null, ip = null, user_agent = null, outcome = 'success' where id = $1`, events[0].ID)
+	assert.Error(t, err)
+	// except for blanking the personal data of a purged user
+	_, err = testDB.Exec(`update auth_events set email = null, ip = null, user_agent = null where id = $1`, events[0].ID)
+	assert.NoError(t, err)
+	_, err = testDB.Exec(`delete from auth_events where id = $1`, events[0].ID)
+	assert.Error(t, err)
+}
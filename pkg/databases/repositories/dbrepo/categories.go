@@ -2,12 +2,18 @@ package dbrepo
 
 import (
 	"context"
+	"database/sql"
 	"fmt"
 
+	databases "github.com/MinhNHHH/online-store/pkg/databases/repositories"
 	"github.com/MinhNHHH/online-store/pkg/databases/schema"
+	"github.com/MinhNHHH/online-store/pkg/slug"
 )
 
-func (p *DBRepo) AllCategories(name string, page, pageSize int) ([]*schema.Category, int, error) {
+// categoriesOrder lists the newest categories first.
+var categoriesOrder = keyset{name: "newest:desc", key: "created_at", keyType: "timestamp", id: "id", desc: true}
+
+func (p *DBRepo) AllCategories(name string, page schema.Page) ([]*schema.Category, *schema.PageInfo, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
 	defer cancel()
 
@@ -15,7 +21,7 @@ func (p *DBRepo) AllCategories(name string, page, pageSize int) ([]*schema.Categ
 	countQuery := `select count(*) from categories where 1=1`
 
 	// Base query for fetching records
-	query := `select id, name, description from categories where 1=1`
+	query := `select id, slug, name, description, parent_id, ` + categoriesOrder.column() + ` from categories where 1=1`
 
 	args := []interface{}{}
 	argCount := 1
@@ -27,76 +33,255 @@ func (p *DBRepo) AllCategories(name string, page, pageSize int) ([]*schema.Categ
 		argCount++
 	}
 
-	offset := (page - 1) * pageSize
-	query += fmt.Sprintf(" order by created_at desc LIMIT $%d OFFSET $%d", argCount, argCount+1)
-	args = append(args, pageSize, offset)
-
+	// Only numbered pages are counted
 	var total int
-	err := p.SqlConn.QueryRowContext(ctx, countQuery, args[:len(args)-2]...).Scan(&total)
+	if page.Number > 0 {
+		err := p.SqlConn.QueryRowContext(ctx, countQuery, args...).Scan(&total)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	after, tail, pageArgs, err := categoriesOrder.page(page, argCount)
 	if err != nil {
-		return nil, 0, err
+		return nil, nil, err
 	}
+	query += after + tail
+	args = append(args, pageArgs...)
 
 	rows, err := p.SqlConn.QueryContext(ctx, query, args...)
 	if err != nil {
-		return nil, 0, err
+		return nil, nil, err
 	}
 	defer rows.Close()
 
 	categories := []*schema.Category{}
+	var cursors []schema.Cursor
 	for rows.Next() {
 		var category schema.Category
-		err := rows.Scan(&category.ID, &category.Name, &category.Description)
+		var key string
+		err := rows.Scan(&category.ID, &category.Slug, &category.Name, &category.Description, &category.ParentID, &key)
 		if err != nil {
-			return nil, 0, err
+			return nil, nil, err
 		}
 		categories = append(categories, &category)
+		cursors = append(cursors, categoriesOrder.cursor(key, category.ID))
 	}
-	return categories, total, nil
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	categories, info := keysetPage(page, categories, cursors)
+	info.Total = total
+	return categories, info, nil
 }
 
+// GetCategory returns the category with the given id, or sql.ErrNoRows.
+func (p *DBRepo) GetCategory(id int) (*schema.Category, error) {
+	return p.getCategory("c.id = $1", id)
+}
+
+// GetCategoryBySlug is GetCategory for the category with the given slug, or
+// that had it before. The category has its current slug.
+func (p *DBRepo) GetCategoryBySlug(slug string) (*schema.Category, error) {
+	return p.getCategory(categorySlugs.lookup("c", 1), slug)
+}
+
+func (p *DBRepo) getCategory(condition string, arg any) (*schema.Category, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `select c.id, c.slug, c.name, coalesce(c.description, ''), c.parent_id, c.created_at from categories as c where ` + condition
+
+	var category schema.Category
+	err := p.SqlConn.QueryRowContext(ctx, query, arg).Scan(
+		&category.ID,
+		&category.Slug,
+		&category.Name,
+		&category.Description,
+		&category.ParentID,
+		&category.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &category, nil
+}
+
+// InsertCategory adds a category, with a slug made from its name, under its
+// parent if it has one.
 func (p *DBRepo) InsertCategory(category *schema.Category) (int, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
 	defer cancel()
 
-	query := `insert into categories (name, description) values ($1, $2) returning id`
-	rows, err := p.SqlConn.QueryContext(ctx, query, category.Name, category.Description)
+	tx, err := p.SqlConn.BeginTx(ctx, nil)
 	if err != nil {
 		return 0, err
 	}
-	defer rows.Close()
+	defer tx.Rollback()
 
-	var newID int
-	if !rows.Next() {
-		return 0, fmt.Errorf("no rows returned from insert")
+	if category.ParentID != nil {
+		if err := parentExists(ctx, tx, *category.ParentID); err != nil {
+			return 0, err
+		}
 	}
-	err = rows.Scan(&newID)
+
+	var newID int
+	query := `insert into categories (slug, name, description, parent_id) values ($1, $2, $3, $4) returning id`
+	err = categorySlugs.insert(ctx, tx, slug.Make(category.Name, "category"), func(categorySlug string) error {
+		return tx.QueryRowContext(ctx, query, categorySlug, category.Name, category.Description, category.ParentID).Scan(&newID)
+	})
 	if err != nil {
 		return 0, err
 	}
-	return newID, nil
+	return newID, tx.Commit()
 }
 
+// UpdateCategory saves the name and description of a category, and its slug
+// when it has one. Its place in the tree is changed by MoveCategory.
 func (p *DBRepo) UpdateCategory(category *schema.Category) error {
 	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
 	defer cancel()
 
+	tx, err := p.SqlConn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
 	query := `update categories set name = $1, description = $2 where id = $3`
-	_, err := p.SqlConn.ExecContext(ctx, query, category.Name, category.Description, category.ID)
+	_, err = tx.ExecContext(ctx, query, category.Name, category.Description, category.ID)
 	if err != nil {
 		return err
 	}
-	return nil
+	if category.Slug != "" {
+		if err := categorySlugs.change(ctx, tx, category.ID, category.Slug); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
 }
 
+// DeleteCategory deletes a category. Its children take its place under its
+// parent.
 func (p *DBRepo) DeleteCategory(id int) error {
 	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
 	defer cancel()
 
+	tx, err := p.SqlConn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt := `update categories set parent_id = (select parent_id from categories where id = $1) where parent_id = $1`
+	if _, err := tx.ExecContext(ctx, stmt, id); err != nil {
+		return err
+	}
 	query := `delete from categories where id = $1`
-	_, err := p.SqlConn.ExecContext(ctx, query, id)
+	_, err = tx.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// CategoryTree returns the top-level categories, each with its children
+// nested in it, by name.
+func (p *DBRepo) CategoryTree() ([]*schema.Category, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `select id, slug, name, coalesce(description, ''), parent_id, created_at from categories order by lower(name), id`
+	rows, err := p.SqlConn.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var categories []*schema.Category
+	byID := map[int]*schema.Category{}
+	for rows.Next() {
+		var category schema.Category
+		err := rows.Scan(&category.ID, &category.Slug, &category.Name, &category.Description, &category.ParentID, &category.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		categories = append(categories, &category)
+		byID[category.ID] = &category
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	roots := []*schema.Category{}
+	for _, category := range categories {
+		if category.ParentID == nil {
+			roots = append(roots, category)
+			continue
+		}
+		parent := byID[*category.ParentID]
+		parent.Children = append(parent.Children, category)
+	}
+	return roots, nil
+}
+
+// MoveCategory puts a category, with everything under it, under parentID, or
+// at the top of the tree when parentID is nil.
+func (p *DBRepo) MoveCategory(id int, parentID *int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	tx, err := p.SqlConn.BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
+	defer tx.Rollback()
+
+	// Moves are taken one at a time, or two crossing moves could each pass the
+	// check below and together make a cycle.
+	if _, err := tx.ExecContext(ctx, `lock table categories in share row exclusive mode`); err != nil {
+		return err
+	}
+	if parentID != nil {
+		if err := parentExists(ctx, tx, *parentID); err != nil {
+			return err
+		}
+		var cycle bool
+		query := `select $2::int in (` + categorySubtree("id = $1") + `)`
+		if err := tx.QueryRowContext(ctx, query, id, *parentID).Scan(&cycle); err != nil {
+			return err
+		}
+		if cycle {
+			return databases.ErrCategoryCycle
+		}
+	}
+
+	err = execAffectingRow(ctx, tx, `update categories set parent_id = $1 where id = $2`, parentID, id)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func parentExists(ctx context.Context, tx *sql.Tx, id int) error {
+	var exists bool
+	err := tx.QueryRowContext(ctx, `select exists (select 1 from categories where id = $1)`, id).Scan(&exists)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return databases.ErrParentCategoryNotFound
+	}
 	return nil
 }
+
+// categorySubtree selects the ids of the categories matching condition and of
+// everything under them.
+func categorySubtree(condition string) string {
+	return `with recursive subtree as (
+			select id from categories where ` + condition + `
+			union
+			select c.id from categories as c inner join subtree as s on c.parent_id = s.id)
+		select id from subtree`
+}
@@ -1,8 +1,10 @@
 package dbrepo
 
 import (
+	"database/sql"
 	"testing"
 
+	databases "github.com/MinhNHHH/online-store/pkg/databases/repositories"
 	"github.com/MinhNHHH/online-store/pkg/databases/schema"
 	"github.com/stretchr/testify/assert"
 )
@@ -40,16 +42,16 @@ func TestAllCategories(t *testing.T) {
 		assert.Greater(t, id, 0)
 	}
 
-	categories, total, err := testRepo.AllCategories("", 1, 10)
+	categories, info, err := testRepo.AllCategories("", schema.Page{Number: 1, Size: 10})
 	assert.NoError(t, err)
-	assert.Equal(t, 2, total)
+	assert.Equal(t, 2, info.Total)
 	assert.LessOrEqual(t, len(categories), 10)
 }
 
 func TestGetCategoryByFilter(t *testing.T) {
-	categories, total, err := testRepo.AllCategories("test category 1", 1, 10)
+	categories, info, err := testRepo.AllCategories("test category 1", schema.Page{Number: 1, Size: 10})
 	assert.NoError(t, err)
-	assert.Equal(t, 1, total)
+	assert.Equal(t, 1, info.Total)
 	assert.Equal(t, "Test Category 1", categories[0].Name)
 }
 
@@ -185,3 +187,131 @@ func TestDeleteCategory(t *testing.T) {
 		})
 	}
 }
+
+func TestCategorySlugs(t *testing.T) {
+	id, err := testRepo.InsertCategory(&schema.Category{Name: "Áo Sơ Mi"})
+	assert.NoError(t, err)
+	category, err := testRepo.GetCategory(id)
+	assert.NoError(t, err)
+	assert.Equal(t, "ao-so-mi", category.Slug)
+
+	productID, err := testRepo.InsertProduct(&schema.Product{
+		Name:          "Slugged Category Product",
+		Price:         10,
+		StockQuantity: 1,
+		Status:        schema.ProductStatusInStock,
+		Categories:    []schema.CategoryRef{{ID: id}},
+	})
+	assert.NoError(t, err)
+
+	// A renamed category is found, and filters products, by either slug.
+	assert.NoError(t, testRepo.UpdateCategory(&schema.Category{ID: id, Name: "Shirts", Slug: "shirts-vn"}))
+	for _, s := range []string{"shirts-vn", "ao-so-mi"} {
+		category, err = testRepo.GetCategoryBySlug(s)
+		assert.NoError(t, err)
+		assert.Equal(t, id, category.ID)
+		assert.Equal(t, "shirts-vn", category.Slug)
+
+		products, _, err := testRepo.AllProducts(schema.ProductFilter{CategorySlugs: []string{s}}, schema.Page{Number: 1, Size: 10})
+		assert.NoError(t, err)
+		assert.Len(t, products, 1)
+		assert.Equal(t, productID, products[0].ID)
+	}
+
+	// An update without a slug keeps it.
+	assert.NoError(t, testRepo.UpdateCategory(&schema.Category{ID: id, Name: "Shirts"}))
+	category, err = testRepo.GetCategory(id)
+	assert.NoError(t, err)
+	assert.Equal(t, "shirts-vn", category.Slug)
+
+	otherID, err := testRepo.InsertCategory(&schema.Category{Name: "Ao So Mi"})
+	assert.NoError(t, err)
+	other, err := testRepo.GetCategory(otherID)
+	assert.NoError(t, err)
+	assert.Equal(t, "ao-so-mi-2", other.Slug)
+	err = testRepo.UpdateCategory(&schema.Category{ID: otherID, Name: "Ao So Mi", Slug: "ao-so-mi"})
+	assert.ErrorIs(t, err, databases.ErrSlugTaken)
+
+	_, err = testRepo.GetCategoryBySlug("no-such-category")
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+}
+
+func TestCategoryTree(t *testing.T) {
+	insert := func(name string, parentID *int) int {
+		id, err := testRepo.InsertCategory(&schema.Category{Name: name, ParentID: parentID})
+		assert.NoError(t, err)
+		return id
+	}
+	clothing := insert("Tree Clothing", nil)
+	men := insert("Tree Men", &clothing)
+	shirts := insert("Tree Shirts", &men)
+
+	missing := 999999
+	_, err := testRepo.InsertCategory(&schema.Category{Name: "Tree Orphan", ParentID: &missing})
+	assert.ErrorIs(t, err, databases.ErrParentCategoryNotFound)
+
+	productID, err := testRepo.InsertProduct(&schema.Product{
+		Name:          "Tree Oxford Shirt",
+		Price:         40,
+		StockQuantity: 1,
+		Status:        schema.ProductStatusInStock,
+		Categories:    []schema.CategoryRef{{ID: shirts}},
+	})
+	assert.NoError(t, err)
+
+	tree, err := testRepo.CategoryTree()
+	assert.NoError(t, err)
+	var root *schema.Category
+	for _, category := range tree {
+		if category.ID == clothing {
+			root = category
+		}
+	}
+	if assert.NotNil(t, root) && assert.Len(t, root.Children, 1) && assert.Len(t, root.Children[0].Children, 1) {
+		assert.Equal(t, men, root.Children[0].ID)
+		assert.Equal(t, shirts, root.Children[0].Children[0].ID)
+	}
+
+	// A product is found by any category above its own.
+	inCategory := func(filter schema.ProductFilter) bool {
+		filter.Name = "Tree Oxford"
+		products, _, err := testRepo.AllProducts(filter, schema.Page{Number: 1, Size: 10})
+		assert.NoError(t, err)
+		return len(products) == 1 && products[0].ID == productID
+	}
+	assert.True(t, inCategory(schema.ProductFilter{CategoryIDs: []int{clothing}}))
+	assert.True(t, inCategory(schema.ProductFilter{CategorySlugs: []string{"tree-men"}}))
+	assert.True(t, inCategory(schema.ProductFilter{CategoryIDs: []int{shirts}}))
+
+	product, err := testRepo.GetProduct(productID)
+	assert.NoError(t, err)
+	assert.Equal(t, [][]schema.CategoryRef{{
+		{ID: clothing, Slug: "tree-clothing", Name: "Tree Clothing"},
+		{ID: men, Slug: "tree-men", Name: "Tree Men"},
+		{ID: shirts, Slug: "tree-shirts", Name: "Tree Shirts"},
+	}}, product.Breadcrumbs)
+
+	// A category cannot go under itself or anything under it.
+	assert.ErrorIs(t, testRepo.MoveCategory(clothing, &clothing), databases.ErrCategoryCycle)
+	assert.ErrorIs(t, testRepo.MoveCategory(clothing, &shirts), databases.ErrCategoryCycle)
+	assert.ErrorIs(t, testRepo.MoveCategory(men, &missing), databases.ErrParentCategoryNotFound)
+	assert.ErrorIs(t, testRepo.MoveCategory(missing, nil), sql.ErrNoRows)
+
+	// Moving a category takes everything under it along.
+	sale := insert("Tree Sale", nil)
+	assert.NoError(t, testRepo.MoveCategory(men, &sale))
+	assert.False(t, inCategory(schema.ProductFilter{CategoryIDs: []int{clothing}}))
+	assert.True(t, inCategory(schema.ProductFilter{CategoryIDs: []int{sale}}))
+
+	// Deleting a category puts its children in its place.
+	assert.NoError(t, testRepo.DeleteCategory(men))
+	category, err := testRepo.GetCategory(shirts)
+	assert.NoError(t, err)
+	assert.Equal(t, &sale, category.ParentID)
+	product, err = testRepo.GetProduct(productID)
+	assert.NoError(t, err)
+	assert.Equal(t, []schema.CategoryRef{
+		{ID: sale, Slug: "tree-sale", Name: "Tree Sale"},
+		{ID: shirts, Slug: "tree-shirts", Name: "Tree Shirts"},
+	}, product.Breadcrumbs[0])
+}
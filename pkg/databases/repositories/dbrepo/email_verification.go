@@ -0,0 +1,59 @@
+package dbrepo
+
+import (
+	"context"
+	"time"
+)
+
+func (p *DBRepo) InsertEmailVerificationToken(userID int, email, tokenHash string, expiresAt time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	stmt := `insert into email_verification_tokens (user_id, email, token_hash, expires_at, created_at)
+		values ($1, $2, $3, $4, $5)`
+
+	_, err := p.SqlConn.ExecContext(ctx, stmt, userID, email, tokenHash, expiresAt, time.Now())
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// VerifyEmailWithToken spends the verification token matching tokenHash and
+// marks its user's email as verified. The token only counts while the user
+// still has the address it was sent to. It returns sql.ErrNoRows when the
+// token is unknown, expired, used or stale.
+func (p *DBRepo) VerifyEmailWithToken(tokenHash string) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	tx, err := p.SqlConn.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	var userID int
+	stmt := `update email_verification_tokens t set used_at = $1
+		from users u
+		where t.user_id = u.id and t.email = u.email
+		and t.token_hash = $2 and t.used_at is null and t.expires_at > $1
+		returning t.user_id`
+
+	err = tx.QueryRowContext(ctx, stmt, now, tokenHash).Scan(&userID)
+	if err != nil {
+		return 0, err
+	}
+
+	stmt = `update users set email_verified_at = $1, updated_at = $1 where id = $2`
+	_, err = tx.ExecContext(ctx, stmt, now, userID)
+	if err != nil {
+		return 0, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, err
+	}
+	return userID, nil
+}
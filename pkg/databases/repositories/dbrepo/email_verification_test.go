@@ -0,0 +1,36 @@
+package dbrepo
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/MinhNHHH/online-store/pkg/databases/schema"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVerifyEmailWithToken(t *testing.T) {
+	userID, err := testRepo.InsertUser(schema.User{
+		Name:     "Verify User",
+		Email:    "verify@example.com",
+		Password: "secret",
+	})
+	assert.NoError(t, err)
+
+	assert.NoError(t, testRepo.InsertEmailVerificationToken(userID, "verify@example.com", "verify-hash", time.Now().Add(time.Hour)))
+	assert.NoError(t, testRepo.InsertEmailVerificationToken(userID, "old@example.com", "stale-hash", time.Now().Add(time.Hour)))
+
+	_, err = testRepo.VerifyEmailWithToken("stale-hash")
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+
+	id, err := testRepo.VerifyEmailWithToken("verify-hash")
+	assert.NoError(t, err)
+	assert.Equal(t, userID, id)
+
+	_, err = testRepo.VerifyEmailWithToken("verify-hash")
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+
+	user, err := testRepo.GetUser(userID)
+	assert.NoError(t, err)
+	assert.NotNil(t, user.EmailVerifiedAt)
+}
@@ -0,0 +1,116 @@
+package dbrepo
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"time"
+
+	databases "github.com/MinhNHHH/online-store/pkg/databases/repositories"
+	"github.com/MinhNHHH/online-store/pkg/databases/schema"
+)
+
+// keyset is the order of a paged list: by a key, then by id, both in the same
+// direction, so the key and id of a row tell where the rows after it start.
+type keyset struct {
+	// name tells cursors taken in other orders apart.
+	name string
+	// key is the sort expression. Cursors carry its value as text, which is
+	// cast back to keyType.
+	key     string
+	keyType string
+	id      string
+	desc    bool
+}
+
+// column selects the key of each row as text, for its cursor.
+func (k keyset) column() string {
+	return "(" + k.key + ")::text"
+}
+
+func (k keyset) orderBy(reverse bool) string {
+	direction := "asc"
+	if k.desc != reverse {
+		direction = "desc"
+	}
+	return fmt.Sprintf("%[1]s %[3]s, %[2]s %[3]s", k.key, k.id, direction)
+}
+
+// page returns the condition and the order by and limit clauses selecting
+// page, with their arguments, which are numbered from nextArg. Pages before a
+// cursor are fetched in reverse. Cursor pages fetch one row more than they
+// hold, which tells keysetPage whether there are more.
+func (k keyset) page(page schema.Page, nextArg int) (where, tail string, args []interface{}, err error) {
+	if page.Number > 0 {
+		tail = fmt.Sprintf(" order by %s limit $%d offset $%d", k.orderBy(false), nextArg, nextArg+1)
+		return "", tail, []interface{}{page.Size, (page.Number - 1) * page.Size}, nil
+	}
+
+	cursor := page.Cursor
+	if cursor == nil {
+		tail = fmt.Sprintf(" order by %s limit $%d", k.orderBy(false), nextArg)
+		return "", tail, []interface{}{page.Size + 1}, nil
+	}
+	if cursor.Sort != k.name || !k.validKey(cursor.Key) {
+		return "", "", nil, databases.ErrInvalidCursor
+	}
+	op := ">"
+	if k.desc != cursor.Before {
+		op = "<"
+	}
+	where = fmt.Sprintf(" and (%s, %s) %s ($%d::%s, $%d)", k.key, k.id, op, nextArg, k.keyType, nextArg+1)
+	tail = fmt.Sprintf(" order by %s limit $%d", k.orderBy(cursor.Before), nextArg+2)
+	return where, tail, []interface{}{cursor.Key, cursor.ID, page.Size + 1}, nil
+}
+
+// validKey reports whether key can be cast to the key type, so a tampered
+// cursor is turned away rather than failing the query.
+func (k keyset) validKey(key string) bool {
+	var err error
+	switch k.keyType {
+	case "timestamp":
+		_, err = time.Parse("2006-01-02 15:04:05.999999", key)
+	case "numeric", "float4", "float8":
+		_, err = strconv.ParseFloat(key, 64)
+	}
+	return err == nil
+}
+
+func (k keyset) cursor(key string, id int) schema.Cursor {
+	return schema.Cursor{Sort: k.name, Key: key, ID: id}
+}
+
+// keysetPage puts the rows fetched for a page in list order and drops the
+// extra one, and points at the pages around it. cursors has the cursor of
+// each row.
+func keysetPage[T any](page schema.Page, rows []T, cursors []schema.Cursor) ([]T, *schema.PageInfo) {
+	info := &schema.PageInfo{}
+	if page.Number > 0 {
+		return rows, info
+	}
+
+	more := len(rows) > page.Size
+	if more {
+		rows, cursors = rows[:page.Size], cursors[:page.Size]
+	}
+	backwards := page.Cursor != nil && page.Cursor.Before
+	if backwards {
+		slices.Reverse(rows)
+		slices.Reverse(cursors)
+	}
+	if len(rows) == 0 {
+		return rows, info
+	}
+
+	// A page reached from a cursor has rows on the side it was reached from.
+	if more && !backwards || backwards {
+		next := cursors[len(cursors)-1]
+		info.Next = &next
+	}
+	if more && backwards || page.Cursor != nil && !backwards {
+		prev := cursors[0]
+		prev.Before = true
+		info.Prev = &prev
+	}
+	return rows, info
+}
@@ -0,0 +1,106 @@
+package dbrepo
+
+import (
+	"testing"
+
+	databases "github.com/MinhNHHH/online-store/pkg/databases/repositories"
+	"github.com/MinhNHHH/online-store/pkg/databases/schema"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKeysetPagination(t *testing.T) {
+	categoryID, err := testRepo.InsertCategory(&schema.Category{Name: "Keyset Category"})
+	assert.NoError(t, err)
+
+	// Two products share a price, so the id breaks the tie.
+	var ids []int
+	for _, price := range []float64{10, 20, 20, 30, 40} {
+		id, err := testRepo.InsertProduct(&schema.Product{
+			Name:          "Keyset Product",
+			Price:         price,
+			StockQuantity: 1,
+			Status:        schema.ProductStatusInStock,
+			Categories:    []schema.CategoryRef{{ID: categoryID}},
+		})
+		assert.NoError(t, err)
+		ids = append(ids, id)
+	}
+	filter := schema.ProductFilter{CategoryIDs: []int{categoryID}, Sort: schema.ProductSortPrice}
+	pageIDs := func(page schema.Page) ([]int, *schema.PageInfo) {
+		products, info, err := testRepo.AllProducts(filter, page)
+		assert.NoError(t, err)
+		var ids []int
+		for _, p := range products {
+			ids = append(ids, p.ID)
+		}
+		return ids, info
+	}
+
+	first, info := pageIDs(schema.Page{Size: 2})
+	assert.Equal(t, ids[:2], first)
+	assert.Nil(t, info.Prev)
+	assert.NotNil(t, info.Next)
+	assert.Zero(t, info.Total)
+
+	second, info := pageIDs(schema.Page{Size: 2, Cursor: info.Next})
+	assert.Equal(t, ids[2:4], second)
+	assert.NotNil(t, info.Prev)
+	assert.NotNil(t, info.Next)
+
+	// A product added before the cursor does not shift the next page.
+	_, err = testRepo.InsertProduct(&schema.Product{
+		Name:       "Keyset Product",
+		Price:      5,
+		Status:     schema.ProductStatusInStock,
+		Categories: []schema.CategoryRef{{ID: categoryID}},
+	})
+	assert.NoError(t, err)
+	last, lastInfo := pageIDs(schema.Page{Size: 2, Cursor: info.Next})
+	assert.Equal(t, ids[4:], last)
+	assert.Nil(t, lastInfo.Next)
+	assert.NotNil(t, lastInfo.Prev)
+
+	back, backInfo := pageIDs(schema.Page{Size: 2, Cursor: lastInfo.Prev})
+	assert.Equal(t, ids[2:4], back)
+	assert.NotNil(t, backInfo.Next)
+	assert.NotNil(t, backInfo.Prev)
+
+	// Numbered pages are counted.
+	numbered, numberedInfo := pageIDs(schema.Page{Size: 2, Number: 2})
+	assert.Equal(t, []int{ids[1], ids[2]}, numbered)
+	assert.Equal(t, 6, numberedInfo.Total)
+
+	// A cursor only works in the order it was taken in.
+	filter.Descending = true
+	_, _, err = testRepo.AllProducts(filter, schema.Page{Size: 2, Cursor: info.Next})
+	assert.ErrorIs(t, err, databases.ErrInvalidCursor)
+	_, _, err = testRepo.AllProducts(filter, schema.Page{Size: 2, Cursor: &schema.Cursor{Sort: "price:desc", Key: "cheap", ID: 1}})
+	assert.ErrorIs(t, err, databases.ErrInvalidCursor)
+}
+
+func TestKeysetPage(t *testing.T) {
+	k := keyset{name: "newest:desc", key: "created_at", keyType: "timestamp", id: "id", desc: true}
+	cursors := func(ids ...int) []schema.Cursor {
+		var cursors []schema.Cursor
+		for _, id := range ids {
+			cursors = append(cursors, k.cursor("2025-06-01 10:00:00", id))
+		}
+		return cursors
+	}
+
+	// Backwards pages are fetched in reverse, with one row to spare.
+	cursor := k.cursor("2025-06-01 10:00:00", 9)
+	cursor.Before = true
+	rows, info := keysetPage(schema.Page{Size: 2, Cursor: &cursor}, []int{8, 7, 6}, cursors(8, 7, 6))
+	assert.Equal(t, []int{7, 8}, rows)
+	assert.Equal(t, 8, info.Next.ID)
+	assert.False(t, info.Next.Before)
+	assert.Equal(t, 7, info.Prev.ID)
+	assert.True(t, info.Prev.Before)
+
+	where, tail, args, err := k.page(schema.Page{Size: 2, Cursor: &cursor}, 3)
+	assert.NoError(t, err)
+	assert.Equal(t, " and (created_at, id) > ($3::timestamp, $4)", where)
+	assert.Equal(t, " order by created_at asc, id asc limit $5", tail)
+	assert.Equal(t, []interface{}{"2025-06-01 10:00:00", 9, 3}, args)
+}
@@ -0,0 +1,207 @@
+package dbrepo
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/MinhNHHH/online-store/pkg/databases/schema"
+)
+
+func (p *DBRepo) GetLoginThrottle(scope, key string) (*schema.LoginThrottle, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `select scope, key, failures, last_failure_at, locked_until
+		from login_throttles
+		where scope = $1 and key = $2`
+
+	return scanLoginThrottle(p.SqlConn.QueryRowContext(ctx, query, scope, key))
+}
+
+// RecordLoginFailure adds one failure to the counter for scope and key. A
+// counter whose last failure is older than window starts again from one.
+func (p *DBRepo) RecordLoginFailure(scope, key string, window time.Duration) (*schema.LoginThrottle, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	now := time.Now()
+	stmt := `insert into login_throttles (scope, key, failures, last_failure_at)
+		values ($1, $2, 1, $3)
+		on conflict (scope, key) do update set
+			failures = case
+				when login_throttles.last_failure_at < $4 then 1
+				else login_throttles.failures + 1
+			end,
+			last_failure_at = $3
+		returning scope, key, failures, last_failure_at, locked_until`
+
+	return scanLoginThrottle(p.SqlConn.QueryRowContext(ctx, stmt, scope, key, now, now.Add(-window)))
+}
+
+// LockLogin blocks lockout.Scope and lockout.Key until lockout.LockedUntil and
+// records the lock so admins can review it.
+func (p *DBRepo) LockLogin(lockout *schema.Lockout) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	tx, err := p.SqlConn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt := `update login_throttles set locked_until = $1 where scope = $2 and key = $3`
+	_, err = tx.ExecContext(ctx, stmt, lockout.LockedUntil, lockout.Scope, lockout.Key)
+	if err != nil {
+		return err
+	}
+
+	stmt = `insert into login_lockouts (scope, key, user_id, ip, failures, locked_until, created_at)
+		values ($1, $2, $3, $4, $5, $6, $7)`
+	_, err = tx.ExecContext(ctx, stmt,
+		lockout.Scope,
+		lockout.Key,
+		lockout.UserID,
+		lockout.IP,
+		lockout.Failures,
+		lockout.LockedUntil,
+		time.Now(),
+	)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// ForgiveLoginFailure takes one failure off the counter for scope and key.
+func (p *DBRepo) ForgiveLoginFailure(scope, key string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	stmt := `update login_throttles set failures = greatest(failures - 1, 0) where scope = $1 and key = $2`
+	_, err := p.SqlConn.ExecContext(ctx, stmt, scope, key)
+	return err
+}
+
+func (p *DBRepo) ClearLoginFailures(scope, key string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	stmt := `delete from login_throttles where scope = $1 and key = $2`
+	_, err := p.SqlConn.ExecContext(ctx, stmt, scope, key)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// AllLockouts lists lock events, newest first. With activeOnly it leaves out
+// locks that have expired or were lifted by an admin.
+func (p *DBRepo) AllLockouts(activeOnly bool) ([]*schema.Lockout, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `select id, scope, key, user_id, ip, failures, locked_until, unlocked_at, unlocked_by, created_at
+		from login_lockouts`
+	args := []interface{}{}
+	if activeOnly {
+		query += ` where unlocked_at is null and locked_until > $1`
+		args = append(args, time.Now())
+	}
+	query += ` order by created_at desc`
+
+	rows, err := p.SqlConn.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	lockouts := []*schema.Lockout{}
+	for rows.Next() {
+		var lockout schema.Lockout
+		var userID, unlockedBy sql.NullInt64
+		var ip sql.NullString
+		var unlockedAt sql.NullTime
+		err := rows.Scan(
+			&lockout.ID,
+			&lockout.Scope,
+			&lockout.Key,
+			&userID,
+			&ip,
+			&lockout.Failures,
+			&lockout.LockedUntil,
+			&unlockedAt,
+			&unlockedBy,
+			&lockout.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		if userID.Valid {
+			id := int(userID.Int64)
+			lockout.UserID = &id
+		}
+		if unlockedBy.Valid {
+			id := int(unlockedBy.Int64)
+			lockout.UnlockedBy = &id
+		}
+		if unlockedAt.Valid {
+			lockout.UnlockedAt = &unlockedAt.Time
+		}
+		lockout.IP = ip.String
+		lockouts = append(lockouts, &lockout)
+	}
+	return lockouts, nil
+}
+
+// UnlockLogin lifts any lock on scope and key, resets its failure counter
+// and marks open lock events as lifted by adminID.
+func (p *DBRepo) UnlockLogin(scope, key string, adminID int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	tx, err := p.SqlConn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt := `delete from login_throttles where scope = $1 and key = $2`
+	_, err = tx.ExecContext(ctx, stmt, scope, key)
+	if err != nil {
+		return err
+	}
+
+	stmt = `update login_lockouts set unlocked_at = $1, unlocked_by = $2
+		where scope = $3 and key = $4 and unlocked_at is null`
+	_, err = tx.ExecContext(ctx, stmt, time.Now(), adminID, scope, key)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func scanLoginThrottle(row rowScanner) (*schema.LoginThrottle, error) {
+	var throttle schema.LoginThrottle
+	var lastFailureAt, lockedUntil sql.NullTime
+	err := row.Scan(
+		&throttle.Scope,
+		&throttle.Key,
+		&throttle.Failures,
+		&lastFailureAt,
+		&lockedUntil,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if lastFailureAt.Valid {
+		throttle.LastFailureAt = &lastFailureAt.Time
+	}
+	if lockedUntil.Valid {
+		throttle.LockedUntil = &lockedUntil.Time
+	}
+	return &throttle, nil
+}
@@ -0,0 +1,61 @@
+package dbrepo
+
+import (
+	"testing"
+	"time"
+
+	"github.com/MinhNHHH/online-store/pkg/databases/schema"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoginThrottle(t *testing.T) {
+	for i := 1; i <= 3; i++ {
+		throttle, err := testRepo.RecordLoginFailure("account", "throttle@example.com", time.Hour)
+		assert.NoError(t, err)
+		assert.Equal(t, i, throttle.Failures)
+	}
+
+	// failures outside the window are forgotten
+	throttle, err := testRepo.RecordLoginFailure("account", "throttle@example.com", 0)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, throttle.Failures)
+
+	adminID, err := testRepo.InsertUser(schema.User{
+		Name:     "Throttle Admin",
+		Email:    "throttle-admin@example.com",
+		Password: "secret",
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, testRepo.SetUserAdmin(adminID, true))
+
+	err = testRepo.LockLogin(&schema.Lockout{
+		Scope:       "account",
+		Key:         "throttle@example.com",
+		IP:          "192.0.2.1",
+		Failures:    10,
+		LockedUntil: time.Now().Add(time.Hour),
+	})
+	assert.NoError(t, err)
+
+	throttle, err = testRepo.GetLoginThrottle("account", "throttle@example.com")
+	assert.NoError(t, err)
+	assert.NotNil(t, throttle.LockedUntil)
+
+	lockouts, err := testRepo.AllLockouts(true)
+	assert.NoError(t, err)
+	assert.Len(t, lockouts, 1)
+
+	assert.NoError(t, testRepo.UnlockLogin("account", "throttle@example.com", adminID))
+
+	_, err = testRepo.GetLoginThrottle("account", "throttle@example.com")
+	assert.Error(t, err)
+
+	lockouts, err = testRepo.AllLockouts(true)
+	assert.NoError(t, err)
+	assert.Len(t, lockouts, 0)
+
+	lockouts, err = testRepo.AllLockouts(false)
+	assert.NoError(t, err)
+	assert.Len(t, lockouts, 1)
+	assert.Equal(t, adminID, *lockouts[0].UnlockedBy)
+}
@@ -0,0 +1,70 @@
+package dbrepo
+
+import (
+	"context"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func (p *DBRepo) InsertPasswordResetToken(userID int, tokenHash string, expiresAt time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	stmt := `insert into password_reset_tokens (user_id, token_hash, expires_at, created_at)
+		values ($1, $2, $3, $4)`
+
+	_, err := p.SqlConn.ExecContext(ctx, stmt, userID, tokenHash, expiresAt, time.Now())
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// ResetPasswordWithToken spends the reset token matching tokenHash and sets the
+// new password of its owner. Every other outstanding reset token of that user
+// is spent as well. It returns sql.ErrNoRows when the token is unknown,
+// expired or already used.
+func (p *DBRepo) ResetPasswordWithToken(tokenHash, password string) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), 12)
+	if err != nil {
+		return 0, err
+	}
+
+	tx, err := p.SqlConn.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	var userID int
+	stmt := `update password_reset_tokens set used_at = $1
+		where token_hash = $2 and used_at is null and expires_at > $1
+		returning user_id`
+
+	err = tx.QueryRowContext(ctx, stmt, now, tokenHash).Scan(&userID)
+	if err != nil {
+		return 0, err
+	}
+
+	stmt = `update password_reset_tokens set used_at = $1 where user_id = $2 and used_at is null`
+	_, err = tx.ExecContext(ctx, stmt, now, userID)
+	if err != nil {
+		return 0, err
+	}
+
+	stmt = `update users set password = $1, updated_at = $2 where id = $3`
+	_, err = tx.ExecContext(ctx, stmt, hashedPassword, now, userID)
+	if err != nil {
+		return 0, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, err
+	}
+	return userID, nil
+}
@@ -0,0 +1,50 @@
+package dbrepo
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/MinhNHHH/online-store/pkg/databases/schema"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestResetPasswordWithToken(t *testing.T) {
+	userID, err := testRepo.InsertUser(schema.User{
+		Name:     "Reset User",
+		Email:    "reset@example.com",
+		Password: "old-secret",
+	})
+	assert.NoError(t, err)
+
+	assert.NoError(t, testRepo.InsertPasswordResetToken(userID, "valid-hash", time.Now().Add(time.Hour)))
+	assert.NoError(t, testRepo.InsertPasswordResetToken(userID, "expired-hash", time.Now().Add(-time.Hour)))
+
+	tests := []struct {
+		name      string
+		tokenHash string
+		wantErr   error
+	}{
+		{name: "Valid token", tokenHash: "valid-hash", wantErr: nil},
+		{name: "Token already used", tokenHash: "valid-hash", wantErr: sql.ErrNoRows},
+		{name: "Expired token", tokenHash: "expired-hash", wantErr: sql.ErrNoRows},
+		{name: "Unknown token", tokenHash: "unknown-hash", wantErr: sql.ErrNoRows},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id, err := testRepo.ResetPasswordWithToken(tt.tokenHash, "new-secret")
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, userID, id)
+		})
+	}
+
+	user, err := testRepo.GetUser(userID)
+	assert.NoError(t, err)
+	assert.NoError(t, bcrypt.CompareHashAndPassword([]byte(user.Password), []byte("new-secret")))
+}
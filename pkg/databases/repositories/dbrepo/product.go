@@ -2,101 +2,465 @@ package dbrepo
 
 import (
 	"context"
+	"database/sql"
+	"encoding/json"
 	"fmt"
 	"log"
 	"strconv"
 	"time"
 
 	"github.com/MinhNHHH/online-store/pkg/databases/schema"
+	"github.com/MinhNHHH/online-store/pkg/slug"
 )
 
-func (p *DBRepo) AllProducts(name, categoryName, status string, page, pageSize int) ([]*schema.Product, int, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
-	defer cancel()
+// productsFrom joins products with the average rating of their reviews.
+const productsFrom = `products as p
+	left join lateral (
+		select avg(rating)::float8 as average_rating, count(*) as review_count
+		from reviews where product_id = p.id
+	) as r on true`
+
+// productColumns are the columns scanProduct scans, from productsFrom.
+const productColumns = `p.id, p.slug, p.name, coalesce(p.description, ''), p.price, p.stock_quantity, p.status, p.options,
+	p.created_at, p.updated_at, r.average_rating, r.review_count`
+
+// productQuery holds the conditions selecting the products that match a
+// filter, and their arguments.
+type productQuery struct {
+	where string
+	args  []interface{}
+	// tsquery is the search expression, when the filter has a query.
+	tsquery string
+}
 
-	// Base query for counting total records
-	countQuery := `select count(*) from products as p 
-		inner join product_categories as pc on p.id = pc.product_id
-		inner join categories as c on pc.category_id = c.id 
-		where 1=1`
+func newProductQuery(filter schema.ProductFilter) *productQuery {
+	q := &productQuery{}
+	if filter.Name != "" {
+		q.add("p.name ILIKE $%d", "%"+filter.Name+"%")
+	}
+	if filter.CategoryName != "" {
+		q.add(`exists (
+			select 1 from product_categories as pc
+			inner join categories as c on pc.category_id = c.id
+			where pc.product_id = p.id and c.name ILIKE $%d)`, "%"+filter.CategoryName+"%")
+	}
+	if len(filter.CategoryIDs) > 0 || len(filter.CategorySlugs) > 0 {
+		n := q.nextArg()
+		// The categories are looked up once, with everything under them.
+		subtree := categorySubtree(fmt.Sprintf(`id = any($%d::int[]) or slug = any($%[2]d::text[])
+				or id in (select category_id from category_slug_redirects where slug = any($%[2]d::text[]))`, n, n+1))
+		q.where += ` and exists (
+			select 1 from product_categories as pc
+			where pc.product_id = p.id and pc.category_id = any(array(` + subtree + `)))`
+		q.args = append(q.args, filter.CategoryIDs, filter.CategorySlugs)
+	}
+	if filter.Status != "" {
+		q.add("p.status = lower($%d)", filter.Status)
+	}
+	if filter.InStock {
+		q.where += " and p.status = 'in_stock' and p.stock_quantity > 0"
+	}
+	if filter.MinPrice != nil {
+		q.add("p.price >= $%d", *filter.MinPrice)
+	}
+	if filter.MaxPrice != nil {
+		q.add("p.price <= $%d", *filter.MaxPrice)
+	}
+	if filter.MinRating != nil {
+		q.add("r.average_rating >= $%d", *filter.MinRating)
+	}
+	if words := searchTerms(filter.Query); len(words) > 0 {
+		var queryArgs []interface{}
+		q.tsquery, queryArgs = searchQuery(words, q.nextArg())
+		q.where += " and p.search_vector @@ (" + q.tsquery + ")"
+		q.args = append(q.args, queryArgs...)
+	}
+	return q
+}
 
-	// Base query for fetching records
-	query := `select p.id, p.name, p.description, p.price, p.stock_quantity, p.status, c.name
-		from products as p 
-		inner join product_categories as pc on p.id = pc.product_id
-		inner join categories as c on pc.category_id = c.id 
-		where 1=1`
+func (q *productQuery) add(condition string, value interface{}) {
+	q.where += fmt.Sprintf(" and "+condition, q.nextArg())
+	q.args = append(q.args, value)
+}
 
-	args := []interface{}{}
-	argCount := 1
+func (q *productQuery) nextArg() int {
+	return len(q.args) + 1
+}
 
-	if name != "" {
-		query += fmt.Sprintf(" AND p.name ILIKE $%d", argCount)
-		countQuery += fmt.Sprintf(" AND p.name ILIKE $%d", argCount)
-		args = append(args, "%"+name+"%")
-		argCount++
+// keyset is the order filter asks for.
+func (q *productQuery) keyset(filter schema.ProductFilter) keyset {
+	sort, desc := filter.Sort, filter.Descending
+	if sort == "" {
+		sort, desc = schema.ProductSortNewest, true
+		if q.tsquery != "" {
+			sort = schema.ProductSortRelevance
+		}
 	}
 
-	if categoryName != "" {
-		query += fmt.Sprintf(" AND c.name ILIKE $%d", argCount)
-		countQuery += fmt.Sprintf(" AND c.name ILIKE $%d", argCount)
-		args = append(args, "%"+categoryName+"%")
-		argCount++
+	k := keyset{key: "p.created_at", keyType: "timestamp", id: "p.id", desc: desc}
+	switch sort {
+	case schema.ProductSortPrice:
+		k.key, k.keyType = "p.price", "numeric"
+	case schema.ProductSortName:
+		k.key, k.keyType = "lower(p.name)", "text"
+	case schema.ProductSortRating:
+		// Products without reviews come last either way.
+		k.key, k.keyType = "coalesce(r.average_rating, 'Infinity')", "float8"
+		if desc {
+			k.key = "coalesce(r.average_rating, '-Infinity')"
+		}
+	case schema.ProductSortRelevance:
+		if q.tsquery != "" {
+			k.key, k.keyType = "ts_rank(p.search_vector, ("+q.tsquery+"))", "float4"
+		} else {
+			sort = schema.ProductSortNewest
+		}
+	default:
+		sort = schema.ProductSortNewest
 	}
-
-	if status != "" {
-		query += fmt.Sprintf(" AND p.status ILIKE $%d", argCount)
-		countQuery += fmt.Sprintf(" AND p.status ILIKE $%d", argCount)
-		args = append(args, "%"+status+"%")
-		argCount++
+	k.name = sort + ":asc"
+	if desc {
+		k.name = sort + ":desc"
 	}
+	return k
+}
+
+// AllProducts returns a page of the products matching filter, in the order
+// it asks for, each with all its categories, variants and images.
+// filter.CategoryName matches products in any category with a matching name;
+// without it, uncategorized products are included too.
+func (p *DBRepo) AllProducts(filter schema.ProductFilter, page schema.Page) ([]*schema.Product, *schema.PageInfo, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
 
-	offset := (page - 1) * pageSize
-	query += fmt.Sprintf(" order by p.created_at desc LIMIT $%d OFFSET $%d", argCount, argCount+1)
-	args = append(args, pageSize, offset)
+	q := newProductQuery(filter)
+	k := q.keyset(filter)
 
-	// Get total count
+	// Only numbered pages are counted
 	var total int
-	err := p.SqlConn.QueryRowContext(ctx, countQuery, args[:len(args)-2]...).Scan(&total)
+	if page.Number > 0 {
+		err := p.SqlConn.QueryRowContext(ctx, `select count(*) from `+productsFrom+` where 1=1`+q.where, q.args...).Scan(&total)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	args := q.args
+	columns := productColumns + `, ` + k.column()
+	if q.tsquery != "" {
+		// The headline is parsed without stemming, like the words as written.
+		columns += fmt.Sprintf(`, ts_headline('simple', p.name || ': ' || coalesce(p.description, ''), (%s), $%d)`, q.tsquery, len(args)+1)
+		args = append(args, headlineOptions)
+	}
+
+	after, tail, pageArgs, err := k.page(page, len(args)+1)
 	if err != nil {
-		return nil, 0, err
+		return nil, nil, err
 	}
+	query := fmt.Sprintf(`select %s from %s where 1=1%s%s%s`, columns, productsFrom, q.where, after, tail)
+	args = append(args, pageArgs...)
 
-	// Get paginated results
 	rows, err := p.SqlConn.QueryContext(ctx, query, args...)
 	if err != nil {
-		return nil, 0, err
+		return nil, nil, err
 	}
 	defer rows.Close()
 
 	products := []*schema.Product{}
+	var cursors []schema.Cursor
 	for rows.Next() {
-		var product schema.Product
-		var priceStr string
-		err := rows.Scan(
-			&product.ID,
-			&product.Name,
-			&product.Description,
-			&priceStr,
-			&product.StockQuantity,
-			&product.Status,
-			&product.CategoryName,
-		)
+		var key, snippet string
+		extra := []any{&key}
+		if q.tsquery != "" {
+			extra = append(extra, &snippet)
+		}
+		product, err := scanProduct(rows, extra...)
 		if err != nil {
 			log.Println("Error scanning", err)
-			return nil, 0, err
+			return nil, nil, err
 		}
+		product.Snippet = markSnippet(snippet)
+		products = append(products, product)
+		cursors = append(cursors, k.cursor(key, product.ID))
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
 
-		price, err := strconv.ParseFloat(priceStr, 64)
-		if err != nil {
-			log.Println("Error parsing price", err)
-			return nil, 0, err
+	products, info := keysetPage(page, products, cursors)
+	info.Total = total
+	if err := p.loadProductCategories(ctx, products); err != nil {
+		return nil, nil, err
+	}
+	if err := p.loadProductVariants(ctx, products); err != nil {
+		return nil, nil, err
+	}
+	if err := p.loadProductImages(ctx, products); err != nil {
+		return nil, nil, err
+	}
+	return products, info, nil
+}
+
+// priceBuckets are the lower bounds of the price ranges counted by
+// ProductFacets.
+var priceBuckets = []float64{0, 25, 50, 100, 250, 500}
+
+// ProductFacets counts the products matching filter by category, status and
+// price range. Every price range is listed, also when empty.
+func (p *DBRepo) ProductFacets(filter schema.ProductFilter) (*schema.ProductFacets, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	q := newProductQuery(filter)
+	matching := `with matching as (select p.id, p.status, p.price from ` + productsFrom + ` where 1=1` + q.where + `) `
+
+	facets := &schema.ProductFacets{
+		Categories: []schema.CategoryFacet{},
+		Statuses:   []schema.StatusFacet{},
+		Prices:     []schema.PriceFacet{},
+	}
+
+	rows, err := p.SqlConn.QueryContext(ctx, matching+`select c.id, c.name, count(*)
+		from matching as m
+		inner join product_categories as pc on pc.product_id = m.id
+		inner join categories as c on pc.category_id = c.id
+		group by c.id, c.name
+		order by count(*) desc, c.name`, q.args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var facet schema.CategoryFacet
+		if err := rows.Scan(&facet.ID, &facet.Name, &facet.Count); err != nil {
+			return nil, err
+		}
+		facets.Categories = append(facets.Categories, facet)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = p.SqlConn.QueryContext(ctx, matching+`select status, count(*) from matching group by status order by status`, q.args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var facet schema.StatusFacet
+		if err := rows.Scan(&facet.Status, &facet.Count); err != nil {
+			return nil, err
+		}
+		facets.Statuses = append(facets.Statuses, facet)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// width_bucket numbers the range starting at priceBuckets[i] i + 1.
+	counts := make([]int, len(priceBuckets))
+	query := fmt.Sprintf(`select width_bucket(price, $%d::numeric[]), count(*) from matching group by 1`, q.nextArg())
+	rows, err = p.SqlConn.QueryContext(ctx, matching+query, append(q.args, priceBuckets)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var bucket, count int
+		if err := rows.Scan(&bucket, &count); err != nil {
+			return nil, err
+		}
+		if bucket >= 1 && bucket <= len(counts) {
+			counts[bucket-1] = count
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	for i, min := range priceBuckets {
+		facet := schema.PriceFacet{Min: min, Count: counts[i]}
+		if i+1 < len(priceBuckets) {
+			facet.Max = &priceBuckets[i+1]
+		}
+		facets.Prices = append(facets.Prices, facet)
+	}
+
+	return facets, nil
+}
+
+// scanProduct scans a row of productColumns, followed by extra columns into
+// extra.
+func scanProduct(row interface{ Scan(...any) error }, extra ...any) (*schema.Product, error) {
+	var product schema.Product
+	var priceStr string
+	var options []byte
+	var averageRating sql.NullFloat64
+	dest := []any{
+		&product.ID,
+		&product.Slug,
+		&product.Name,
+		&product.Description,
+		&priceStr,
+		&product.StockQuantity,
+		&product.Status,
+		&options,
+		&product.CreatedAt,
+		&product.UpdatedAt,
+		&averageRating,
+		&product.ReviewCount,
+	}
+	err := row.Scan(append(dest, extra...)...)
+	if err != nil {
+		return nil, err
+	}
+
+	price, err := strconv.ParseFloat(priceStr, 64)
+	if err != nil {
+		return nil, fmt.Errorf("parsing price: %w", err)
+	}
+	product.Price = price
+
+	if err := json.Unmarshal(options, &product.Options); err != nil {
+		return nil, fmt.Errorf("parsing options: %w", err)
+	}
+	if averageRating.Valid {
+		product.AverageRating = &averageRating.Float64
+	}
+	return &product, nil
+}
+
+// GetProduct returns the product with the given id, with its categories,
+// variants and images, or sql.ErrNoRows.
+func (p *DBRepo) GetProduct(id int) (*schema.Product, error) {
+	return p.getProduct("p.id = $1", id)
+}
+
+// GetProductBySlug is GetProduct for the product with the given slug, or
+// that had it before. The product has its current slug.
+func (p *DBRepo) GetProductBySlug(slug string) (*schema.Product, error) {
+	return p.getProduct(productSlugs.lookup("p", 1), slug)
+}
+
+func (p *DBRepo) getProduct(condition string, arg any) (*schema.Product, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `select ` + productColumns + ` from ` + productsFrom + ` where ` + condition
+
+	product, err := scanProduct(p.SqlConn.QueryRowContext(ctx, query, arg))
+	if err != nil {
+		return nil, err
+	}
+
+	products := []*schema.Product{product}
+	if err := p.loadProductCategories(ctx, products); err != nil {
+		return nil, err
+	}
+	if err := p.loadProductVariants(ctx, products); err != nil {
+		return nil, err
+	}
+	if err := p.loadProductImages(ctx, products); err != nil {
+		return nil, err
+	}
+	return product, nil
+}
+
+// RatingHistogram counts the reviews of a product by their rating, from 1 to
+// 5 stars, with every rating present.
+func (p *DBRepo) RatingHistogram(productID int) (map[int]int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	rows, err := p.SqlConn.QueryContext(ctx, `select rating, count(*) from reviews where product_id = $1 group by rating`, productID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	histogram := map[int]int{1: 0, 2: 0, 3: 0, 4: 0, 5: 0}
+	for rows.Next() {
+		var rating, count int
+		if err := rows.Scan(&rating, &count); err != nil {
+			return nil, err
 		}
-		product.Price = price
+		histogram[rating] = count
+	}
+	return histogram, rows.Err()
+}
 
-		products = append(products, &product)
+// loadProductCategories fills in the categories of products, and their
+// breadcrumbs, with a single query.
+func (p *DBRepo) loadProductCategories(ctx context.Context, products []*schema.Product) error {
+	if len(products) == 0 {
+		return nil
+	}
+	byID := make(map[int]*schema.Product, len(products))
+	ids := make([]int, 0, len(products))
+	for _, product := range products {
+		product.Categories = []schema.CategoryRef{}
+		product.Breadcrumbs = [][]schema.CategoryRef{}
+		byID[product.ID] = product
+		ids = append(ids, product.ID)
 	}
-	return products, total, nil
+
+	// Each category of a product is walked up to the top of the tree, and its
+	// trail read top down, ending with the category itself.
+	query := `with recursive trail as (
+			select pc.product_id, c.id as leaf, c.name as leaf_name, c.id, c.parent_id, c.slug, c.name, 0 as depth
+			from product_categories as pc
+			inner join categories as c on pc.category_id = c.id
+			where pc.product_id = any($1)
+			union all
+			select t.product_id, t.leaf, t.leaf_name, c.id, c.parent_id, c.slug, c.name, t.depth + 1
+			from trail as t
+			inner join categories as c on c.id = t.parent_id)
+		select product_id, leaf, id, slug, name, depth
+		from trail
+		order by product_id, leaf_name, leaf, depth desc`
+
+	rows, err := p.SqlConn.QueryContext(ctx, query, ids)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var trail []schema.CategoryRef
+	for rows.Next() {
+		var productID, leaf, depth int
+		var category schema.CategoryRef
+		if err := rows.Scan(&productID, &leaf, &category.ID, &category.Slug, &category.Name, &depth); err != nil {
+			return err
+		}
+		trail = append(trail, category)
+		if depth == 0 {
+			product := byID[productID]
+			product.Categories = append(product.Categories, category)
+			product.Breadcrumbs = append(product.Breadcrumbs, trail)
+			trail = nil
+		}
+	}
+	return rows.Err()
+}
+
+// setProductCategories replaces the categories of a product.
+func setProductCategories(ctx context.Context, tx *sql.Tx, productID int, categories []schema.CategoryRef) error {
+	_, err := tx.ExecContext(ctx, `delete from product_categories where product_id = $1`, productID)
+	if err != nil {
+		return err
+	}
+	if len(categories) == 0 {
+		return nil
+	}
+
+	ids := make([]int, 0, len(categories))
+	for _, category := range categories {
+		ids = append(ids, category.ID)
+	}
+	stmt := `insert into product_categories (product_id, category_id)
+		select $1::int, unnest($2::int[])
+		on conflict do nothing`
+	_, err = tx.ExecContext(ctx, stmt, productID, ids)
+	return err
 }
 
 func (p *DBRepo) InsertProduct(product *schema.Product) (int, error) {
@@ -110,28 +474,33 @@ func (p *DBRepo) InsertProduct(product *schema.Product) (int, error) {
 	}
 	defer tx.Rollback()
 
-	stmt := `insert into products (name, description, price, stock_quantity, status, created_at, updated_at)
-		values ($1, $2, $3, $4, $5, $6, $7) returning id`
-
-	err = p.SqlConn.QueryRowContext(ctx, stmt,
-		product.Name,
-		product.Description,
-		product.Price,
-		product.StockQuantity,
-		product.Status,
-		time.Now(),
-		time.Now(),
-	).Scan(&newID)
-
+	options, err := optionsJSON(product.Options)
 	if err != nil {
 		return 0, err
 	}
 
-	stmt = `insert into product_categories (product_id, category_id)
-		values ($1, $2)`
+	stmt := `insert into products (slug, name, description, price, stock_quantity, status, options, created_at, updated_at)
+		values ($1, $2, $3, $4, $5, $6, $7, $8, $9) returning id`
+
+	err = productSlugs.insert(ctx, tx, slug.Make(product.Name, "product"), func(productSlug string) error {
+		return tx.QueryRowContext(ctx, stmt,
+			productSlug,
+			product.Name,
+			product.Description,
+			product.Price,
+			product.StockQuantity,
+			product.Status,
+			options,
+			time.Now(),
+			time.Now(),
+		).Scan(&newID)
+	})
 
-	_, err = tx.ExecContext(ctx, stmt, newID, product.CategoryID)
+	if err != nil {
+		return 0, err
+	}
 
+	err = setProductCategories(ctx, tx, newID, product.Categories)
 	if err != nil {
 		return 0, err
 	}
@@ -143,26 +512,47 @@ func (p *DBRepo) InsertProduct(product *schema.Product) (int, error) {
 	return newID, nil
 }
 
+// UpdateProduct saves product. Its categories and options are replaced when
+// product.Categories and product.Options are not nil, so an update without
+// them keeps the current ones. The stock and status of a product with
+// variants stay rolled up from theirs.
 func (p *DBRepo) UpdateProduct(product *schema.Product) error {
 	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
 	defer cancel()
 
+	tx, err := p.SqlConn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var options *string
+	if product.Options != nil {
+		encoded, err := optionsJSON(product.Options)
+		if err != nil {
+			return err
+		}
+		options = &encoded
+	}
+
 	stmt := `update products set
 		name = $1,
 		description = $2,
 		price = $3,
 		stock_quantity = $4,
 		status = $5,
-		updated_at = $6
-		where id = $7
+		options = coalesce($6::jsonb, options),
+		updated_at = $7
+		where id = $8
 	`
 
-	_, err := p.SqlConn.ExecContext(ctx, stmt,
+	_, err = tx.ExecContext(ctx, stmt,
 		product.Name,
 		product.Description,
 		product.Price,
 		product.StockQuantity,
 		product.Status,
+		options,
 		time.Now(),
 		product.ID,
 	)
@@ -171,7 +561,24 @@ func (p *DBRepo) UpdateProduct(product *schema.Product) error {
 		return err
 	}
 
-	return nil
+	if product.Slug != "" {
+		if err = productSlugs.change(ctx, tx, product.ID, product.Slug); err != nil {
+			return err
+		}
+	}
+
+	if product.Categories != nil {
+		err = setProductCategories(ctx, tx, product.ID, product.Categories)
+		if err != nil {
+			return err
+		}
+	}
+
+	if err = rollUpVariants(ctx, tx, product.ID); err != nil {
+		return err
+	}
+
+	return tx.Commit()
 }
 
 func (p *DBRepo) DeleteProduct(id int) error {
@@ -188,3 +595,12 @@ func (p *DBRepo) DeleteProduct(id int) error {
 
 	return nil
 }
+
+// optionsJSON encodes product options for the options column.
+func optionsJSON(options []string) (string, error) {
+	if options == nil {
+		options = []string{}
+	}
+	encoded, err := json.Marshal(options)
+	return string(encoded), err
+}
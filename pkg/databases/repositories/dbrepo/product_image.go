@@ -0,0 +1,174 @@
+package dbrepo
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	databases "github.com/MinhNHHH/online-store/pkg/databases/repositories"
+	"github.com/MinhNHHH/online-store/pkg/databases/schema"
+)
+
+// InsertProductImage adds an image after the other images of its product and
+// sets image.Position accordingly.
+func (p *DBRepo) InsertProductImage(image *schema.ProductImage) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	thumbnails, err := json.Marshal(thumbnailKeys(image.ThumbnailKeys))
+	if err != nil {
+		return 0, err
+	}
+
+	stmt := `insert into product_images (product_id, storage_key, content_type, width, height, size_bytes, alt_text, thumbnails, position, created_at)
+		values ($1, $2, $3, $4, $5, $6, $7, $8,
+			(select coalesce(max(position) + 1, 0) from product_images where product_id = $1), $9)
+		returning id, position`
+
+	var newID int
+	err = p.SqlConn.QueryRowContext(ctx, stmt,
+		image.ProductID,
+		image.Key,
+		image.ContentType,
+		image.Width,
+		image.Height,
+		image.Size,
+		image.AltText,
+		string(thumbnails),
+		time.Now(),
+	).Scan(&newID, &image.Position)
+	if err != nil {
+		return 0, err
+	}
+	return newID, nil
+}
+
+// SetProductImageAltText returns sql.ErrNoRows when the product has no such
+// image.
+func (p *DBRepo) SetProductImageAltText(productID, imageID int, altText string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	stmt := `update product_images set alt_text = $1 where id = $2 and product_id = $3`
+	return execAffectingRow(ctx, p.SqlConn, stmt, altText, imageID, productID)
+}
+
+// ReorderProductImages puts the images of a product in the order of
+// imageIDs, which must list each of them once, or returns
+// databases.ErrImageOrder.
+func (p *DBRepo) ReorderProductImages(productID int, imageIDs []int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	tx, err := p.SqlConn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var count int
+	err = tx.QueryRowContext(ctx, `select count(*) from product_images where product_id = $1`, productID).Scan(&count)
+	if err != nil {
+		return err
+	}
+	if count != len(imageIDs) {
+		return databases.ErrImageOrder
+	}
+
+	// A repeated ID updates its row once, so it shows up as a missing one.
+	stmt := `update product_images as i set position = o.position - 1
+		from unnest($2::int[]) with ordinality as o(id, position)
+		where i.id = o.id and i.product_id = $1`
+
+	result, err := tx.ExecContext(ctx, stmt, productID, imageIDs)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if int(affected) != count {
+		return databases.ErrImageOrder
+	}
+	return tx.Commit()
+}
+
+// DeleteProductImage returns the deleted image, so its files can be removed
+// from storage, or sql.ErrNoRows when the product has no such image.
+func (p *DBRepo) DeleteProductImage(productID, imageID int) (*schema.ProductImage, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	stmt := `delete from product_images where id = $1 and product_id = $2
+		returning id, product_id, storage_key, content_type, width, height, size_bytes, alt_text, position, thumbnails`
+
+	return scanProductImage(p.SqlConn.QueryRowContext(ctx, stmt, imageID, productID))
+}
+
+// loadProductImages fills in the images of products with a single query.
+func (p *DBRepo) loadProductImages(ctx context.Context, products []*schema.Product) error {
+	if len(products) == 0 {
+		return nil
+	}
+	byID := make(map[int]*schema.Product, len(products))
+	ids := make([]int, 0, len(products))
+	for _, product := range products {
+		product.Images = []schema.ProductImage{}
+		byID[product.ID] = product
+		ids = append(ids, product.ID)
+	}
+
+	query := `select id, product_id, storage_key, content_type, width, height, size_bytes, alt_text, position, thumbnails
+		from product_images
+		where product_id = any($1)
+		order by position, id`
+
+	rows, err := p.SqlConn.QueryContext(ctx, query, ids)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		image, err := scanProductImage(rows)
+		if err != nil {
+			return err
+		}
+		product := byID[image.ProductID]
+		product.Images = append(product.Images, *image)
+	}
+	return rows.Err()
+}
+
+func scanProductImage(row interface{ Scan(...any) error }) (*schema.ProductImage, error) {
+	var image schema.ProductImage
+	var thumbnails []byte
+	err := row.Scan(
+		&image.ID,
+		&image.ProductID,
+		&image.Key,
+		&image.ContentType,
+		&image.Width,
+		&image.Height,
+		&image.Size,
+		&image.AltText,
+		&image.Position,
+		&thumbnails,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(thumbnails, &image.ThumbnailKeys); err != nil {
+		return nil, fmt.Errorf("parsing thumbnails: %w", err)
+	}
+	return &image, nil
+}
+
+func thumbnailKeys(keys map[string]string) map[string]string {
+	if keys == nil {
+		return map[string]string{}
+	}
+	return keys
+}
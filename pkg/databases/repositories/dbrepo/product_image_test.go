@@ -0,0 +1,65 @@
+package dbrepo
+
+import (
+	"database/sql"
+	"testing"
+
+	databases "github.com/MinhNHHH/online-store/pkg/databases/repositories"
+	"github.com/MinhNHHH/online-store/pkg/databases/schema"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProductImages(t *testing.T) {
+	productID, err := testRepo.InsertProduct(&schema.Product{
+		Name:          "Pictured Product",
+		Description:   "Has images",
+		Price:         10,
+		StockQuantity: 1,
+		Status:        schema.ProductStatusInStock,
+	})
+	assert.NoError(t, err)
+
+	var ids []int
+	for i, key := range []string{"products/a.jpg", "products/b.jpg", "products/c.jpg"} {
+		image := &schema.ProductImage{
+			ProductID:     productID,
+			Key:           key,
+			ContentType:   "image/jpeg",
+			Width:         640,
+			Height:        480,
+			Size:          1024,
+			ThumbnailKeys: map[string]string{"160": key + "_160"},
+		}
+		id, err := testRepo.InsertProductImage(image)
+		assert.NoError(t, err)
+		assert.Equal(t, i, image.Position)
+		ids = append(ids, id)
+	}
+
+	assert.NoError(t, testRepo.SetProductImageAltText(productID, ids[0], "Front"))
+	assert.ErrorIs(t, testRepo.SetProductImageAltText(productID+1, ids[0], "Front"), sql.ErrNoRows)
+
+	// Every image must be listed exactly once.
+	assert.ErrorIs(t, testRepo.ReorderProductImages(productID, []int{ids[2], ids[0]}), databases.ErrImageOrder)
+	assert.ErrorIs(t, testRepo.ReorderProductImages(productID, []int{ids[2], ids[0], ids[0]}), databases.ErrImageOrder)
+	assert.NoError(t, testRepo.ReorderProductImages(productID, []int{ids[2], ids[0], ids[1]}))
+
+	product, err := testRepo.GetProduct(productID)
+	assert.NoError(t, err)
+	assert.Len(t, product.Images, 3)
+	assert.Equal(t, ids[2], product.Images[0].ID)
+	assert.Equal(t, ids[0], product.Images[1].ID)
+	assert.Equal(t, "Front", product.Images[1].AltText)
+	assert.Equal(t, map[string]string{"160": "products/a.jpg_160"}, product.Images[1].ThumbnailKeys)
+
+	deleted, err := testRepo.DeleteProductImage(productID, ids[0])
+	assert.NoError(t, err)
+	assert.Equal(t, "products/a.jpg", deleted.Key)
+	assert.Equal(t, map[string]string{"160": "products/a.jpg_160"}, deleted.ThumbnailKeys)
+	_, err = testRepo.DeleteProductImage(productID, ids[0])
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+
+	products, _, err := testRepo.AllProducts(schema.ProductFilter{Name: "Pictured Product"}, schema.Page{Number: 1, Size: 10})
+	assert.NoError(t, err)
+	assert.Len(t, products[0].Images, 2)
+}
@@ -1,8 +1,11 @@
 package dbrepo
 
 import (
+	"context"
+	"database/sql"
 	"testing"
 
+	databases "github.com/MinhNHHH/online-store/pkg/databases/repositories"
 	"github.com/MinhNHHH/online-store/pkg/databases/schema"
 	"github.com/stretchr/testify/assert"
 )
@@ -24,7 +27,7 @@ func TestAllProducts(t *testing.T) {
 			Price:         99.99,
 			StockQuantity: 10,
 			Status:        "in_stock",
-			CategoryID:    categoryID,
+			Categories:    []schema.CategoryRef{{ID: categoryID}},
 		},
 		{
 			Name:          "Test Product 2",
@@ -32,7 +35,7 @@ func TestAllProducts(t *testing.T) {
 			Price:         99.99,
 			StockQuantity: 10,
 			Status:        "in_stock",
-			CategoryID:    categoryID,
+			Categories:    []schema.CategoryRef{{ID: categoryID}},
 		},
 		{
 			Name:          "Test Product 3",
@@ -40,7 +43,7 @@ func TestAllProducts(t *testing.T) {
 			Price:         99.99,
 			StockQuantity: 10,
 			Status:        "in_stock",
-			CategoryID:    categoryID,
+			Categories:    []schema.CategoryRef{{ID: categoryID}},
 		},
 		{
 			Name:          "Test Product 4",
@@ -48,7 +51,7 @@ func TestAllProducts(t *testing.T) {
 			Price:         99.99,
 			StockQuantity: 10,
 			Status:        "out_of_stock",
-			CategoryID:    categoryID,
+			Categories:    []schema.CategoryRef{{ID: categoryID}},
 		},
 	}
 
@@ -112,9 +115,9 @@ func TestAllProducts(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			products, total, err := testRepo.AllProducts(tt.filterName, tt.categoryName, tt.status, tt.page, tt.pageSize)
+			products, info, err := testRepo.AllProducts(schema.ProductFilter{Name: tt.filterName, CategoryName: tt.categoryName, Status: tt.status}, schema.Page{Number: tt.page, Size: tt.pageSize})
 			assert.NoError(t, err)
-			assert.Equal(t, tt.wantTotal, total)
+			assert.Equal(t, tt.wantTotal, info.Total)
 			assert.LessOrEqual(t, len(products), tt.pageSize)
 		})
 	}
@@ -135,7 +138,7 @@ func TestInsertProduct(t *testing.T) {
 				Price:         99.99,
 				StockQuantity: 10,
 				Status:        "in_stock",
-				CategoryID:    1,
+				Categories:    []schema.CategoryRef{{ID: 1}},
 			},
 			wantErr:     false,
 			description: "Should successfully insert a valid product",
@@ -148,7 +151,7 @@ func TestInsertProduct(t *testing.T) {
 				Price:         -10.0,
 				StockQuantity: 10,
 				Status:        "in_stock",
-				CategoryID:    1,
+				Categories:    []schema.CategoryRef{{ID: 1}},
 			},
 			wantErr:     true,
 			description: "Should fail when price is negative",
@@ -161,7 +164,7 @@ func TestInsertProduct(t *testing.T) {
 				Price:         99.99,
 				StockQuantity: -5,
 				Status:        "in_stock",
-				CategoryID:    1,
+				Categories:    []schema.CategoryRef{{ID: 1}},
 			},
 			wantErr:     true,
 			description: "Should fail when stock quantity is negative",
@@ -190,7 +193,7 @@ func TestUpdateProduct(t *testing.T) {
 		Price:         99.99,
 		StockQuantity: 10,
 		Status:        "in_stock",
-		CategoryID:    1,
+		Categories:    []schema.CategoryRef{{ID: 1}},
 	}
 	id, err := testRepo.InsertProduct(product)
 	assert.NoError(t, err)
@@ -250,7 +253,7 @@ func TestDeleteProduct(t *testing.T) {
 		Price:         99.99,
 		StockQuantity: 10,
 		Status:        "in_stock",
-		CategoryID:    1,
+		Categories:    []schema.CategoryRef{{ID: 1}},
 	}
 	id, err := testRepo.InsertProduct(product)
 	assert.NoError(t, err)
@@ -287,3 +290,278 @@ func TestDeleteProduct(t *testing.T) {
 		})
 	}
 }
+
+func TestProductCategories(t *testing.T) {
+	first, err := testRepo.InsertCategory(&schema.Category{Name: "Multi Category A"})
+	assert.NoError(t, err)
+	second, err := testRepo.InsertCategory(&schema.Category{Name: "Multi Category B"})
+	assert.NoError(t, err)
+
+	product := &schema.Product{
+		Name:          "Multi Category Product",
+		Description:   "In two categories",
+		Price:         10,
+		StockQuantity: 1,
+		Status:        "in_stock",
+		Categories:    []schema.CategoryRef{{ID: first}, {ID: second}},
+	}
+	id, err := testRepo.InsertProduct(product)
+	assert.NoError(t, err)
+
+	uncategorizedID, err := testRepo.InsertProduct(&schema.Product{
+		Name:          "Multi Category Uncategorized",
+		Description:   "In no category",
+		Price:         10,
+		StockQuantity: 1,
+		Status:        "in_stock",
+	})
+	assert.NoError(t, err)
+
+	// The product is listed once, with both categories.
+	products, info, err := testRepo.AllProducts(schema.ProductFilter{Name: "Multi Category"}, schema.Page{Number: 1, Size: 10})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, info.Total)
+	assert.Len(t, products, 2)
+	byID := map[int]*schema.Product{}
+	for _, p := range products {
+		byID[p.ID] = p
+	}
+	assert.Equal(t, []schema.CategoryRef{
+		{ID: first, Slug: "multi-category-a", Name: "Multi Category A"},
+		{ID: second, Slug: "multi-category-b", Name: "Multi Category B"},
+	}, byID[id].Categories)
+	assert.Empty(t, byID[uncategorizedID].Categories)
+
+	// Filtering by either category finds it.
+	_, info, err = testRepo.AllProducts(schema.ProductFilter{CategoryName: "Multi Category B"}, schema.Page{Number: 1, Size: 10})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, info.Total)
+
+	// An update without categories keeps them.
+	product.ID = id
+	product.Categories = nil
+	assert.NoError(t, testRepo.UpdateProduct(product))
+	products, _, err = testRepo.AllProducts(schema.ProductFilter{Name: "Multi Category Product"}, schema.Page{Number: 1, Size: 10})
+	assert.NoError(t, err)
+	assert.Len(t, products[0].Categories, 2)
+
+	// An update with categories replaces them.
+	product.Categories = []schema.CategoryRef{{ID: second}}
+	assert.NoError(t, testRepo.UpdateProduct(product))
+	products, _, err = testRepo.AllProducts(schema.ProductFilter{Name: "Multi Category Product"}, schema.Page{Number: 1, Size: 10})
+	assert.NoError(t, err)
+	assert.Equal(t, []schema.CategoryRef{{ID: second, Slug: "multi-category-b", Name: "Multi Category B"}}, products[0].Categories)
+
+	// An empty list clears them.
+	product.Categories = []schema.CategoryRef{}
+	assert.NoError(t, testRepo.UpdateProduct(product))
+	products, _, err = testRepo.AllProducts(schema.ProductFilter{Name: "Multi Category Product"}, schema.Page{Number: 1, Size: 10})
+	assert.NoError(t, err)
+	assert.Empty(t, products[0].Categories)
+}
+
+func TestProductFacets(t *testing.T) {
+	categoryID, err := testRepo.InsertCategory(&schema.Category{Name: "Facet Category"})
+	assert.NoError(t, err)
+	otherID, err := testRepo.InsertCategory(&schema.Category{Name: "Facet Other"})
+	assert.NoError(t, err)
+
+	insert := func(name string, price float64, stock int, status string, categories ...schema.CategoryRef) int {
+		id, err := testRepo.InsertProduct(&schema.Product{
+			Name:          name,
+			Price:         price,
+			StockQuantity: stock,
+			Status:        status,
+			Categories:    categories,
+		})
+		assert.NoError(t, err)
+		return id
+	}
+	cheapID := insert("Facet Cheap", 10, 5, schema.ProductStatusInStock, schema.CategoryRef{ID: categoryID})
+	middleID := insert("facet middle", 60, 5, schema.ProductStatusInStock, schema.CategoryRef{ID: categoryID}, schema.CategoryRef{ID: otherID})
+	dearID := insert("Facet Dear", 600, 0, schema.ProductStatusOutOfStock, schema.CategoryRef{ID: categoryID})
+
+	for _, review := range []schema.Review{
+		{ProductID: cheapID, UserID: 1, Rating: 2},
+		{ProductID: middleID, UserID: 1, Rating: 5},
+		{ProductID: middleID, UserID: 2, Rating: 4},
+	} {
+		_, err := testRepo.InsertReview(&review)
+		assert.NoError(t, err)
+	}
+
+	ids := func(products []*schema.Product) []int {
+		var ids []int
+		for _, p := range products {
+			ids = append(ids, p.ID)
+		}
+		return ids
+	}
+	inCategory := schema.ProductFilter{CategoryIDs: []int{categoryID}}
+
+	var tests = []struct {
+		name     string
+		sort     string
+		desc     bool
+		expected []int
+	}{
+		{"price ascending", schema.ProductSortPrice, false, []int{cheapID, middleID, dearID}},
+		{"price descending", schema.ProductSortPrice, true, []int{dearID, middleID, cheapID}},
+		{"name ignores case", schema.ProductSortName, false, []int{cheapID, dearID, middleID}},
+		{"unrated last", schema.ProductSortRating, true, []int{middleID, cheapID, dearID}},
+		{"newest", schema.ProductSortNewest, true, []int{dearID, middleID, cheapID}},
+	}
+	for _, tt := range tests {
+		filter := inCategory
+		filter.Sort, filter.Descending = tt.sort, tt.desc
+		products, info, err := testRepo.AllProducts(filter, schema.Page{Number: 1, Size: 10})
+		assert.NoError(t, err, tt.name)
+		assert.Equal(t, 3, info.Total, tt.name)
+		assert.Equal(t, tt.expected, ids(products), tt.name)
+	}
+
+	products, _, err := testRepo.AllProducts(inCategory, schema.Page{Number: 1, Size: 10})
+	assert.NoError(t, err)
+	byID := map[int]*schema.Product{}
+	for _, p := range products {
+		byID[p.ID] = p
+	}
+	assert.Equal(t, 4.5, *byID[middleID].AverageRating)
+	assert.Equal(t, 2, byID[middleID].ReviewCount)
+	assert.Nil(t, byID[dearID].AverageRating)
+
+	minPrice, maxPrice, minRating := 20.0, 100.0, 3.0
+	filter := inCategory
+	filter.MinPrice, filter.MaxPrice = &minPrice, &maxPrice
+	products, _, err = testRepo.AllProducts(filter, schema.Page{Number: 1, Size: 10})
+	assert.NoError(t, err)
+	assert.Equal(t, []int{middleID}, ids(products))
+
+	filter = inCategory
+	filter.MinRating = &minRating
+	products, _, err = testRepo.AllProducts(filter, schema.Page{Number: 1, Size: 10})
+	assert.NoError(t, err)
+	assert.Equal(t, []int{middleID}, ids(products))
+
+	filter = inCategory
+	filter.InStock = true
+	_, info, err := testRepo.AllProducts(filter, schema.Page{Number: 1, Size: 10})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, info.Total)
+
+	facets, err := testRepo.ProductFacets(inCategory)
+	assert.NoError(t, err)
+	assert.Equal(t, []schema.CategoryFacet{
+		{ID: categoryID, Name: "Facet Category", Count: 3},
+		{ID: otherID, Name: "Facet Other", Count: 1},
+	}, facets.Categories)
+	assert.Equal(t, []schema.StatusFacet{
+		{Status: schema.ProductStatusInStock, Count: 2},
+		{Status: schema.ProductStatusOutOfStock, Count: 1},
+	}, facets.Statuses)
+	assert.Len(t, facets.Prices, len(priceBuckets))
+	counts := []int{}
+	for _, bucket := range facets.Prices {
+		counts = append(counts, bucket.Count)
+	}
+	assert.Equal(t, []int{1, 0, 1, 0, 0, 1}, counts)
+	assert.Nil(t, facets.Prices[len(facets.Prices)-1].Max)
+}
+
+func TestProductSlugs(t *testing.T) {
+	product := &schema.Product{Name: "Red Wool Scarf", Price: 15, StockQuantity: 1, Status: schema.ProductStatusInStock}
+	firstID, err := testRepo.InsertProduct(product)
+	assert.NoError(t, err)
+	secondID, err := testRepo.InsertProduct(product)
+	assert.NoError(t, err)
+
+	first, err := testRepo.GetProduct(firstID)
+	assert.NoError(t, err)
+	assert.Equal(t, "red-wool-scarf", first.Slug)
+	assert.False(t, first.CreatedAt.IsZero())
+	second, err := testRepo.GetProductBySlug("red-wool-scarf-2")
+	assert.NoError(t, err)
+	assert.Equal(t, secondID, second.ID)
+
+	_, err = testRepo.GetProductBySlug("no-such-scarf")
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+
+	for _, rating := range []int{5, 5, 3} {
+		_, err := testRepo.InsertReview(&schema.Review{ProductID: firstID, UserID: 1, Rating: rating})
+		assert.NoError(t, err)
+	}
+	histogram, err := testRepo.RatingHistogram(firstID)
+	assert.NoError(t, err)
+	assert.Equal(t, map[int]int{1: 0, 2: 0, 3: 1, 4: 0, 5: 2}, histogram)
+
+	first, err = testRepo.GetProduct(firstID)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, first.ReviewCount)
+	assert.InDelta(t, 13.0/3, *first.AverageRating, 0.001)
+}
+
+func TestInsertSlugTakenMeanwhile(t *testing.T) {
+	ctx := context.Background()
+	tx, err := testDB.BeginTx(ctx, nil)
+	assert.NoError(t, err)
+	defer tx.Rollback()
+
+	stmt := `insert into products (slug, name, price, stock_quantity, status) values ($1, 'Raced Product', 1, 1, 'in_stock')`
+	var tried []string
+	err = productSlugs.insert(ctx, tx, "raced-product", func(productSlug string) error {
+		tried = append(tried, productSlug)
+		if len(tried) == 1 {
+			// another transaction takes the slug after it was checked
+			_, err := testDB.Exec(stmt, productSlug)
+			assert.NoError(t, err)
+		}
+		_, err := tx.ExecContext(ctx, stmt, productSlug)
+		return err
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"raced-product", "raced-product-2"}, tried)
+	assert.NoError(t, tx.Commit())
+
+	raced, err := testRepo.GetProductBySlug("raced-product-2")
+	assert.NoError(t, err)
+	assert.Equal(t, "Raced Product", raced.Name)
+}
+
+func TestChangeProductSlug(t *testing.T) {
+	product := &schema.Product{Name: "Linen Shirt", Price: 30, StockQuantity: 1, Status: schema.ProductStatusInStock}
+	id, err := testRepo.InsertProduct(product)
+	assert.NoError(t, err)
+	otherID, err := testRepo.InsertProduct(&schema.Product{Name: "Linen Trousers", Price: 40, StockQuantity: 1, Status: schema.ProductStatusInStock})
+	assert.NoError(t, err)
+
+	// The old slug still finds the product, which now goes by the new one.
+	product.ID = id
+	product.Slug = "summer-linen-shirt"
+	assert.NoError(t, testRepo.UpdateProduct(product))
+	found, err := testRepo.GetProductBySlug("linen-shirt")
+	assert.NoError(t, err)
+	assert.Equal(t, id, found.ID)
+	assert.Equal(t, "summer-linen-shirt", found.Slug)
+
+	// Neither its current nor its old slug can be given to another product.
+	for _, taken := range []string{"summer-linen-shirt", "linen-shirt"} {
+		err = testRepo.UpdateProduct(&schema.Product{ID: otherID, Name: "Linen Trousers", Price: 40, StockQuantity: 1, Status: schema.ProductStatusInStock, Slug: taken})
+		assert.ErrorIs(t, err, databases.ErrSlugTaken)
+	}
+	// Nor to a new product, which is numbered instead.
+	newID, err := testRepo.InsertProduct(&schema.Product{Name: "Linen Shirt", Price: 30, StockQuantity: 1, Status: schema.ProductStatusInStock})
+	assert.NoError(t, err)
+	fresh, err := testRepo.GetProduct(newID)
+	assert.NoError(t, err)
+	assert.Equal(t, "linen-shirt-2", fresh.Slug)
+
+	// The product can take its old slug back, and keeps the other as old.
+	product.Slug = "linen-shirt"
+	assert.NoError(t, testRepo.UpdateProduct(product))
+	for _, s := range []string{"linen-shirt", "summer-linen-shirt"} {
+		found, err = testRepo.GetProductBySlug(s)
+		assert.NoError(t, err)
+		assert.Equal(t, id, found.ID)
+		assert.Equal(t, "linen-shirt", found.Slug)
+	}
+}
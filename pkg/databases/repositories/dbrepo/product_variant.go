@@ -0,0 +1,237 @@
+package dbrepo
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	databases "github.com/MinhNHHH/online-store/pkg/databases/repositories"
+	"github.com/MinhNHHH/online-store/pkg/databases/schema"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// InsertProductVariant adds a variant to its product and rolls the product's
+// stock and status up again. It returns databases.ErrDuplicateSKU or
+// databases.ErrDuplicateVariant when the SKU or options are taken.
+func (p *DBRepo) InsertProductVariant(variant *schema.ProductVariant) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	options, err := json.Marshal(variant.Options)
+	if err != nil {
+		return 0, err
+	}
+
+	tx, err := p.SqlConn.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	stmt := `insert into product_variants (product_id, sku, options, price, stock_quantity, status, created_at, updated_at)
+		values ($1, $2, $3, $4, $5, $6, $7, $8) returning id`
+
+	var newID int
+	err = tx.QueryRowContext(ctx, stmt,
+		variant.ProductID,
+		variant.SKU,
+		string(options),
+		variant.Price,
+		variant.StockQuantity,
+		variant.Status,
+		time.Now(),
+		time.Now(),
+	).Scan(&newID)
+	if err != nil {
+		return 0, variantError(err)
+	}
+
+	if err = rollUpVariants(ctx, tx, variant.ProductID); err != nil {
+		return 0, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return newID, nil
+}
+
+// UpdateProductVariant saves a variant of variant.ProductID and rolls the
+// product's stock and status up again. It returns sql.ErrNoRows when the
+// product has no such variant.
+func (p *DBRepo) UpdateProductVariant(variant *schema.ProductVariant) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	options, err := json.Marshal(variant.Options)
+	if err != nil {
+		return err
+	}
+
+	tx, err := p.SqlConn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt := `update product_variants set
+		sku = $1,
+		options = $2,
+		price = $3,
+		stock_quantity = $4,
+		status = $5,
+		updated_at = $6
+		where id = $7 and product_id = $8
+	`
+
+	result, err := tx.ExecContext(ctx, stmt,
+		variant.SKU,
+		string(options),
+		variant.Price,
+		variant.StockQuantity,
+		variant.Status,
+		time.Now(),
+		variant.ID,
+		variant.ProductID,
+	)
+	if err != nil {
+		return variantError(err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return sql.ErrNoRows
+	}
+
+	if err = rollUpVariants(ctx, tx, variant.ProductID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// DeleteProductVariant deletes a variant of productID and rolls the product's
+// stock and status up again. It returns sql.ErrNoRows when the product has no
+// such variant.
+func (p *DBRepo) DeleteProductVariant(productID, variantID int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	tx, err := p.SqlConn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, `delete from product_variants where id = $1 and product_id = $2`, variantID, productID)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return sql.ErrNoRows
+	}
+
+	if err = rollUpVariants(ctx, tx, productID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// loadProductVariants fills in the variants of products with a single query.
+func (p *DBRepo) loadProductVariants(ctx context.Context, products []*schema.Product) error {
+	if len(products) == 0 {
+		return nil
+	}
+	byID := make(map[int]*schema.Product, len(products))
+	ids := make([]int, 0, len(products))
+	for _, product := range products {
+		product.Variants = []schema.ProductVariant{}
+		byID[product.ID] = product
+		ids = append(ids, product.ID)
+	}
+
+	query := `select id, product_id, sku, options, price, stock_quantity, status
+		from product_variants
+		where product_id = any($1)
+		order by id`
+
+	rows, err := p.SqlConn.QueryContext(ctx, query, ids)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var variant schema.ProductVariant
+		var options []byte
+		var price sql.NullString
+		err := rows.Scan(
+			&variant.ID,
+			&variant.ProductID,
+			&variant.SKU,
+			&options,
+			&price,
+			&variant.StockQuantity,
+			&variant.Status,
+		)
+		if err != nil {
+			return err
+		}
+		if err := json.Unmarshal(options, &variant.Options); err != nil {
+			return fmt.Errorf("parsing options: %w", err)
+		}
+		if price.Valid {
+			parsed, err := strconv.ParseFloat(price.String, 64)
+			if err != nil {
+				return fmt.Errorf("parsing price: %w", err)
+			}
+			variant.Price = &parsed
+		}
+		product := byID[variant.ProductID]
+		product.Variants = append(product.Variants, variant)
+	}
+	return rows.Err()
+}
+
+// rollUpVariants sets the stock of a product with variants to that of its
+// variants for sale, and its status to in stock if any of them has stock.
+// Draft products and products without variants are left alone.
+func rollUpVariants(ctx context.Context, tx *sql.Tx, productID int) error {
+	stmt := `update products set
+		stock_quantity = (
+			select coalesce(sum(stock_quantity), 0) from product_variants
+			where product_id = $1 and status = 'in_stock'),
+		status = case when exists (
+			select 1 from product_variants
+			where product_id = $1 and status = 'in_stock' and stock_quantity > 0)
+			then 'in_stock' else 'out_of_stock' end
+		where id = $1 and status <> 'draft'
+		and exists (select 1 from product_variants where product_id = $1)`
+
+	_, err := tx.ExecContext(ctx, stmt, productID)
+	return err
+}
+
+// variantError maps the unique violations of product_variants to errors the
+// caller can tell apart.
+func variantError(err error) error {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+		switch pgErr.ConstraintName {
+		case "product_variants_sku_key":
+			return databases.ErrDuplicateSKU
+		case "product_variants_product_id_options_key":
+			return databases.ErrDuplicateVariant
+		}
+	}
+	return err
+}
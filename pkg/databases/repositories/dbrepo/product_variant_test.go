@@ -0,0 +1,111 @@
+package dbrepo
+
+import (
+	"database/sql"
+	"testing"
+
+	databases "github.com/MinhNHHH/online-store/pkg/databases/repositories"
+	"github.com/MinhNHHH/online-store/pkg/databases/schema"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProductVariants(t *testing.T) {
+	productID, err := testRepo.InsertProduct(&schema.Product{
+		Name:          "Variant Tee",
+		Description:   "Comes in sizes and colors",
+		Price:         20,
+		StockQuantity: 0,
+		Status:        schema.ProductStatusOutOfStock,
+		Options:       []string{"size", "color"},
+	})
+	assert.NoError(t, err)
+
+	price := 25.5
+	small := &schema.ProductVariant{
+		ProductID:     productID,
+		SKU:           "VTEE-S-RED",
+		Options:       map[string]string{"size": "S", "color": "red"},
+		StockQuantity: 3,
+		Status:        schema.ProductStatusInStock,
+	}
+	small.ID, err = testRepo.InsertProductVariant(small)
+	assert.NoError(t, err)
+
+	large := &schema.ProductVariant{
+		ProductID:     productID,
+		SKU:           "VTEE-L-RED",
+		Options:       map[string]string{"size": "L", "color": "red"},
+		Price:         &price,
+		StockQuantity: 4,
+		Status:        schema.ProductStatusInStock,
+	}
+	large.ID, err = testRepo.InsertProductVariant(large)
+	assert.NoError(t, err)
+
+	// SKUs are unique across the catalog, options within a product.
+	_, err = testRepo.InsertProductVariant(&schema.ProductVariant{
+		ProductID: productID,
+		SKU:       "VTEE-S-RED",
+		Options:   map[string]string{"size": "M", "color": "red"},
+		Status:    schema.ProductStatusInStock,
+	})
+	assert.ErrorIs(t, err, databases.ErrDuplicateSKU)
+	_, err = testRepo.InsertProductVariant(&schema.ProductVariant{
+		ProductID: productID,
+		SKU:       "VTEE-S-RED-2",
+		Options:   map[string]string{"color": "red", "size": "S"},
+		Status:    schema.ProductStatusInStock,
+	})
+	assert.ErrorIs(t, err, databases.ErrDuplicateVariant)
+
+	product, err := testRepo.GetProduct(productID)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"size", "color"}, product.Options)
+	assert.Len(t, product.Variants, 2)
+	assert.Nil(t, product.Variants[0].Price)
+	assert.Equal(t, &price, product.Variants[1].Price)
+	// The product's stock and status are rolled up from its variants.
+	assert.Equal(t, 7, product.StockQuantity)
+	assert.Equal(t, schema.ProductStatusInStock, product.Status)
+
+	// Variants that are not for sale do not count.
+	large.Status = schema.ProductStatusDraft
+	assert.NoError(t, testRepo.UpdateProductVariant(large))
+	small.StockQuantity = 0
+	assert.NoError(t, testRepo.UpdateProductVariant(small))
+	product, err = testRepo.GetProduct(productID)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, product.StockQuantity)
+	assert.Equal(t, schema.ProductStatusOutOfStock, product.Status)
+
+	// An update of the product keeps the roll-up and, without options, the
+	// options.
+	product.Options = nil
+	product.StockQuantity = 100
+	product.Status = schema.ProductStatusInStock
+	assert.NoError(t, testRepo.UpdateProduct(product))
+	product, err = testRepo.GetProduct(productID)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"size", "color"}, product.Options)
+	assert.Equal(t, 0, product.StockQuantity)
+	assert.Equal(t, schema.ProductStatusOutOfStock, product.Status)
+
+	// Listings include the variants.
+	products, _, err := testRepo.AllProducts(schema.ProductFilter{Name: "Variant Tee"}, schema.Page{Number: 1, Size: 10})
+	assert.NoError(t, err)
+	assert.Len(t, products, 1)
+	assert.Len(t, products[0].Variants, 2)
+
+	// A variant belongs to one product.
+	assert.ErrorIs(t, testRepo.DeleteProductVariant(productID+1, small.ID), sql.ErrNoRows)
+	assert.NoError(t, testRepo.DeleteProductVariant(productID, small.ID))
+	assert.ErrorIs(t, testRepo.DeleteProductVariant(productID, small.ID), sql.ErrNoRows)
+	product, err = testRepo.GetProduct(productID)
+	assert.NoError(t, err)
+	assert.Len(t, product.Variants, 1)
+}
+
+func TestGetProductNotFound(t *testing.T) {
+	_, err := testRepo.GetProduct(999999)
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+}
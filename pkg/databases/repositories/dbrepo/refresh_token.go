@@ -0,0 +1,126 @@
+package dbrepo
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	databases "github.com/MinhNHHH/online-store/pkg/databases/repositories"
+	"github.com/MinhNHHH/online-store/pkg/databases/schema"
+)
+
+func (p *DBRepo) InsertRefreshToken(token *schema.RefreshToken) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	stmt := `insert into refresh_tokens (jti, user_id, family_id, mfa, expires_at, created_at)
+		values ($1, $2, $3, $4, $5, $6)`
+
+	_, err := p.SqlConn.ExecContext(ctx, stmt,
+		token.JTI,
+		token.UserID,
+		token.FamilyID,
+		token.MFA,
+		token.ExpiresAt,
+		time.Now(),
+	)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (p *DBRepo) GetRefreshToken(jti string) (*schema.RefreshToken, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `select jti, user_id, family_id, replaced_by, mfa, expires_at, revoked_at, created_at
+		from refresh_tokens
+		where jti = $1`
+
+	var token schema.RefreshToken
+	var replacedBy sql.NullString
+	var revokedAt sql.NullTime
+	err := p.SqlConn.QueryRowContext(ctx, query, jti).Scan(
+		&token.JTI,
+		&token.UserID,
+		&token.FamilyID,
+		&replacedBy,
+		&token.MFA,
+		&token.ExpiresAt,
+		&revokedAt,
+		&token.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	token.ReplacedBy = replacedBy.String
+	if revokedAt.Valid {
+		token.RevokedAt = &revokedAt.Time
+	}
+	return &token, nil
+}
+
+// RotateRefreshToken marks oldJTI as replaced by next and stores next in the
+// same transaction. If oldJTI was already rotated or revoked it returns
+// databases.ErrRefreshTokenRotated and stores nothing.
+func (p *DBRepo) RotateRefreshToken(oldJTI string, next *schema.RefreshToken) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	tx, err := p.SqlConn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt := `update refresh_tokens set replaced_by = $1
+		where jti = $2 and replaced_by is null and revoked_at is null`
+
+	result, err := tx.ExecContext(ctx, stmt, next.JTI, oldJTI)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return databases.ErrRefreshTokenRotated
+	}
+
+	stmt = `insert into refresh_tokens (jti, user_id, family_id, mfa, expires_at, created_at)
+		values ($1, $2, $3, $4, $5, $6)`
+
+	_, err = tx.ExecContext(ctx, stmt, next.JTI, next.UserID, next.FamilyID, next.MFA, next.ExpiresAt, time.Now())
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (p *DBRepo) RevokeRefreshTokenFamily(familyID string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	stmt := `update refresh_tokens set revoked_at = $1 where family_id = $2 and revoked_at is null`
+	_, err := p.SqlConn.ExecContext(ctx, stmt, time.Now(), familyID)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (p *DBRepo) RevokeUserRefreshTokens(userID int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	stmt := `update refresh_tokens set revoked_at = $1 where user_id = $2 and revoked_at is null`
+	_, err := p.SqlConn.ExecContext(ctx, stmt, time.Now(), userID)
+	if err != nil {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+package dbrepo
+
+import (
+	"testing"
+	"time"
+
+	databases "github.com/MinhNHHH/online-store/pkg/databases/repositories"
+	"github.com/MinhNHHH/online-store/pkg/databases/schema"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRotateRefreshToken(t *testing.T) {
+	userID, err := testRepo.InsertUser(schema.User{
+		Name:     "Refresh User",
+		Email:    "refresh@example.com",
+		Password: "secret",
+	})
+	assert.NoError(t, err)
+
+	first := &schema.RefreshToken{
+		JTI:       "first",
+		UserID:    userID,
+		FamilyID:  "first",
+		ExpiresAt: time.Now().Add(time.Hour),
+	}
+	assert.NoError(t, testRepo.InsertRefreshToken(first))
+
+	second := &schema.RefreshToken{
+		JTI:       "second",
+		UserID:    userID,
+		FamilyID:  "first",
+		ExpiresAt: time.Now().Add(time.Hour),
+	}
+	assert.NoError(t, testRepo.RotateRefreshToken(first.JTI, second))
+
+	stored, err := testRepo.GetRefreshToken(first.JTI)
+	assert.NoError(t, err)
+	assert.Equal(t, "second", stored.ReplacedBy)
+
+	replay := &schema.RefreshToken{
+		JTI:       "replay",
+		UserID:    userID,
+		FamilyID:  "first",
+		ExpiresAt: time.Now().Add(time.Hour),
+	}
+	err = testRepo.RotateRefreshToken(first.JTI, replay)
+	assert.ErrorIs(t, err, databases.ErrRefreshTokenRotated)
+
+	_, err = testRepo.GetRefreshToken(replay.JTI)
+	assert.Error(t, err)
+
+	assert.NoError(t, testRepo.RevokeRefreshTokenFamily("first"))
+	stored, err = testRepo.GetRefreshToken(second.JTI)
+	assert.NoError(t, err)
+	assert.NotNil(t, stored.RevokedAt)
+}
@@ -7,32 +7,122 @@ import (
 	"github.com/MinhNHHH/online-store/pkg/databases/schema"
 )
 
-func (p *DBRepo) ReviewsByProductID(productID int) ([]*schema.Review, error) {
+// reviewsOrder lists the newest reviews first.
+var reviewsOrder = keyset{name: "newest:desc", key: "r.created_at", keyType: "timestamp", id: "r.id", desc: true}
+
+// ReviewsByProductID returns a page of the reviews of a product, newest first.
+func (p *DBRepo) ReviewsByProductID(productID int, page schema.Page) ([]*schema.Review, *schema.PageInfo, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
 	defer cancel()
 
-	query := `select r.id, p.name, u.name, r.rating, r.comment 
+	// Only numbered pages are counted
+	var total int
+	if page.Number > 0 {
+		err := p.SqlConn.QueryRowContext(ctx, `select count(*) from reviews where product_id = $1`, productID).Scan(&total)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	after, tail, pageArgs, err := reviewsOrder.page(page, 2)
+	if err != nil {
+		return nil, nil, err
+	}
+	// reviews of erased users have no author
+	query := `select r.id, p.name, coalesce(u.name, 'Deleted user'), r.rating, coalesce(r.comment, ''), ` + reviewsOrder.column() + `
 		from reviews r
 		inner join products p on r.product_id = p.id
-		inner join users u on r.user_id = u.id
-		where r.product_id = $1`
+		left join users u on r.user_id = u.id
+		where r.product_id = $1` + after + tail
 
-	rows, err := p.SqlConn.QueryContext(ctx, query, productID)
+	rows, err := p.SqlConn.QueryContext(ctx, query, append([]interface{}{productID}, pageArgs...)...)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	reviews := []*schema.Review{}
+	var cursors []schema.Cursor
+	for rows.Next() {
+		var review schema.Review
+		var key string
+		err := rows.Scan(&review.ID, &review.ProductName, &review.UserName, &review.Rating, &review.Comment, &key)
+		if err != nil {
+			return nil, nil, err
+		}
+		reviews = append(reviews, &review)
+		cursors = append(cursors, reviewsOrder.cursor(key, review.ID))
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	reviews, info := keysetPage(page, reviews, cursors)
+	info.Total = total
+	return reviews, info, nil
+}
+
+// ReviewsByUserID returns every review written by the user, newest first.
+func (p *DBRepo) ReviewsByUserID(userID int) ([]*schema.Review, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `select r.id, r.product_id, p.name, r.user_id, r.rating, coalesce(r.comment, ''), r.created_at, r.updated_at
+		from reviews r
+		inner join products p on r.product_id = p.id
+		where r.user_id = $1
+		order by r.created_at desc, r.id desc`
+
+	rows, err := p.SqlConn.QueryContext(ctx, query, userID)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var reviews []*schema.Review
+	reviews := []*schema.Review{}
 	for rows.Next() {
 		var review schema.Review
-		err := rows.Scan(&review.ID, &review.ProductName, &review.UserName, &review.Rating, &review.Comment)
+		err := rows.Scan(
+			&review.ID,
+			&review.ProductID,
+			&review.ProductName,
+			&review.UserID,
+			&review.Rating,
+			&review.Comment,
+			&review.CreatedAt,
+			&review.UpdatedAt,
+		)
 		if err != nil {
 			return nil, err
 		}
 		reviews = append(reviews, &review)
 	}
-	return reviews, nil
+	return reviews, rows.Err()
+}
+
+// GetReview returns a review. The user ID of reviews of erased users is zero.
+func (p *DBRepo) GetReview(id int) (*schema.Review, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `select r.id, r.product_id, coalesce(r.user_id, 0), r.rating, coalesce(r.comment, ''), r.created_at, r.updated_at
+		from reviews r
+		where r.id = $1`
+
+	var review schema.Review
+	err := p.SqlConn.QueryRowContext(ctx, query, id).Scan(
+		&review.ID,
+		&review.ProductID,
+		&review.UserID,
+		&review.Rating,
+		&review.Comment,
+		&review.CreatedAt,
+		&review.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &review, nil
 }
 
 func (p *DBRepo) InsertReview(review *schema.Review) (int, error) {
@@ -1,6 +1,7 @@
 package dbrepo
 
 import (
+	"database/sql"
 	"testing"
 
 	"github.com/MinhNHHH/online-store/pkg/databases/schema"
@@ -21,7 +22,7 @@ func TestReviewsByProductID(t *testing.T) {
 		Price:         100,
 		StockQuantity: 10,
 		Status:        "in_stock",
-		CategoryID:    categoryID,
+		Categories:    []schema.CategoryRef{{ID: categoryID}},
 	})
 	assert.NoError(t, err)
 	assert.Greater(t, productID, 0)
@@ -57,7 +58,7 @@ func TestReviewsByProductID(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			reviews, err := testRepo.ReviewsByProductID(tt.productID)
+			reviews, _, err := testRepo.ReviewsByProductID(tt.productID, schema.Page{Size: 10})
 			assert.NoError(t, err)
 			assert.Equal(t, tt.wantCount, len(reviews))
 
@@ -151,6 +152,21 @@ func TestInsertReview(t *testing.T) {
 	}
 }
 
+func TestGetReview(t *testing.T) {
+	id, err := testRepo.InsertReview(&schema.Review{ProductID: 1, UserID: 1, Rating: 4, Comment: "Test review to get"})
+	assert.NoError(t, err)
+
+	review, err := testRepo.GetReview(id)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, review.ProductID)
+	assert.Equal(t, 1, review.UserID)
+	assert.Equal(t, 4, review.Rating)
+	assert.Equal(t, "Test review to get", review.Comment)
+
+	_, err = testRepo.GetReview(999999)
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+}
+
 func TestDeleteReview(t *testing.T) {
 	// First insert a test review
 	review := &schema.Review{
@@ -194,3 +210,31 @@ func TestDeleteReview(t *testing.T) {
 		})
 	}
 }
+
+func TestReviewsByUserID(t *testing.T) {
+	userID, err := testRepo.InsertUser(schema.User{Name: "Reviewer", Email: "reviewer@example.com", Password: "secret"})
+	assert.NoError(t, err)
+	categoryID, err := testRepo.InsertCategory(&schema.Category{Name: "Reviewed Category"})
+	assert.NoError(t, err)
+	productID, err := testRepo.InsertProduct(&schema.Product{
+		Name:          "Reviewed Product",
+		Price:         10,
+		StockQuantity: 1,
+		Status:        "in_stock",
+		Categories:    []schema.CategoryRef{{ID: categoryID}},
+	})
+	assert.NoError(t, err)
+
+	_, err = testRepo.InsertReview(&schema.Review{ProductID: productID, UserID: userID, Rating: 3, Comment: "Fine"})
+	assert.NoError(t, err)
+
+	reviews, err := testRepo.ReviewsByUserID(userID)
+	assert.NoError(t, err)
+	assert.Len(t, reviews, 1)
+	assert.Equal(t, "Reviewed Product", reviews[0].ProductName)
+	assert.Equal(t, "Fine", reviews[0].Comment)
+
+	reviews, err = testRepo.ReviewsByUserID(999999)
+	assert.NoError(t, err)
+	assert.Empty(t, reviews)
+}
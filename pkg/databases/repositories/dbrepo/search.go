@@ -0,0 +1,47 @@
+package dbrepo
+
+import (
+	"fmt"
+	"html"
+	"strings"
+	"unicode"
+)
+
+// Search matches are delimited by these control characters in ts_headline
+// output, which is HTML escaped before they become <mark> tags.
+const (
+	matchStart = "\x02"
+	matchStop  = "\x03"
+)
+
+const headlineOptions = `StartSel="` + matchStart + `", StopSel="` + matchStop + `", MinWords=10, MaxWords=30, MaxFragments=2`
+
+// searchTerms splits what a user typed into words. Everything but letters and
+// digits is dropped, which also keeps tsquery syntax out.
+func searchTerms(q string) []string {
+	return strings.FieldsFunc(q, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// searchQuery returns a tsquery expression matching every word as a prefix,
+// either stemmed or as written, so "red runn" finds "Red Running Shoes" and
+// "shoes" finds "Shoe Rack". nextArg numbers the parameters, which are
+// returned in order.
+func searchQuery(words []string, nextArg int) (string, []interface{}) {
+	parts := make([]string, 0, len(words))
+	args := make([]interface{}, 0, len(words))
+	for i, word := range words {
+		parts = append(parts, fmt.Sprintf("(to_tsquery('english', $%[1]d) || to_tsquery('simple', $%[1]d))", nextArg+i))
+		args = append(args, word+":*")
+	}
+	return strings.Join(parts, " && "), args
+}
+
+// markSnippet HTML escapes a ts_headline snippet and wraps its matches in
+// <mark> tags.
+func markSnippet(snippet string) string {
+	snippet = html.EscapeString(snippet)
+	snippet = strings.ReplaceAll(snippet, matchStart, "<mark>")
+	return strings.ReplaceAll(snippet, matchStop, "</mark>")
+}
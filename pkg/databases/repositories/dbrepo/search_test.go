@@ -0,0 +1,85 @@
+package dbrepo
+
+import (
+	"testing"
+
+	"github.com/MinhNHHH/online-store/pkg/databases/schema"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSearchTerms(t *testing.T) {
+	assert.Equal(t, []string{"red", "sho"}, searchTerms("  red sho"))
+	assert.Equal(t, []string{"t", "shirt", "café"}, searchTerms("t-shirt & café!"))
+	assert.Empty(t, searchTerms("':* | !"))
+}
+
+func TestSearchQuery(t *testing.T) {
+	query, args := searchQuery([]string{"red", "sho"}, 3)
+	assert.Equal(t, "(to_tsquery('english', $3) || to_tsquery('simple', $3)) && (to_tsquery('english', $4) || to_tsquery('simple', $4))", query)
+	assert.Equal(t, []interface{}{"red:*", "sho:*"}, args)
+}
+
+func TestMarkSnippet(t *testing.T) {
+	assert.Equal(t, "&lt;b&gt;<mark>Red</mark>&lt;/b&gt; shoes", markSnippet("<b>\x02Red\x03</b> shoes"))
+}
+
+func TestProductSearch(t *testing.T) {
+	categoryID, err := testRepo.InsertCategory(&schema.Category{Name: "Footwear"})
+	assert.NoError(t, err)
+
+	shoesID, err := testRepo.InsertProduct(&schema.Product{
+		Name:          "Red Running Shoes",
+		Description:   "Light <b>trainers</b> for long runs",
+		Price:         80,
+		StockQuantity: 3,
+		Status:        schema.ProductStatusInStock,
+		Categories:    []schema.CategoryRef{{ID: categoryID}},
+	})
+	assert.NoError(t, err)
+	shirtID, err := testRepo.InsertProduct(&schema.Product{
+		Name:          "Plain Shirt",
+		Description:   "Cotton shirt in red and blue",
+		Price:         20,
+		StockQuantity: 3,
+		Status:        schema.ProductStatusInStock,
+	})
+	assert.NoError(t, err)
+
+	// A match in the name ranks above one in the description.
+	products, info, err := testRepo.AllProducts(schema.ProductFilter{Query: "red"}, schema.Page{Number: 1, Size: 10})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, info.Total)
+	assert.Equal(t, shoesID, products[0].ID)
+	assert.Equal(t, shirtID, products[1].ID)
+	assert.Contains(t, products[0].Snippet, "<mark>Red</mark>")
+	assert.Contains(t, products[0].Snippet, "&lt;b&gt;")
+
+	// Words match as prefixes, as written or stemmed.
+	products, _, err = testRepo.AllProducts(schema.ProductFilter{Query: "runn shoe"}, schema.Page{Number: 1, Size: 10})
+	assert.NoError(t, err)
+	assert.Len(t, products, 1)
+	assert.Equal(t, shoesID, products[0].ID)
+	products, _, err = testRepo.AllProducts(schema.ProductFilter{Query: "runs"}, schema.Page{Number: 1, Size: 10})
+	assert.NoError(t, err)
+	assert.Len(t, products, 1)
+	assert.Equal(t, shoesID, products[0].ID)
+
+	// Category names are searched, and kept up to date.
+	products, _, err = testRepo.AllProducts(schema.ProductFilter{Query: "footwear"}, schema.Page{Number: 1, Size: 10})
+	assert.NoError(t, err)
+	assert.Len(t, products, 1)
+	assert.NoError(t, testRepo.UpdateCategory(&schema.Category{ID: categoryID, Name: "Sneakers"}))
+	products, _, err = testRepo.AllProducts(schema.ProductFilter{Query: "sneakers"}, schema.Page{Number: 1, Size: 10})
+	assert.NoError(t, err)
+	assert.Len(t, products, 1)
+
+	products, _, err = testRepo.AllProducts(schema.ProductFilter{Query: "cotton"}, schema.Page{Number: 1, Size: 10})
+	assert.NoError(t, err)
+	assert.Len(t, products, 1)
+	assert.Equal(t, shirtID, products[0].ID)
+
+	// Without a query there is no snippet.
+	products, _, err = testRepo.AllProducts(schema.ProductFilter{Name: "Plain Shirt"}, schema.Page{Number: 1, Size: 10})
+	assert.NoError(t, err)
+	assert.Empty(t, products[0].Snippet)
+}
@@ -0,0 +1,106 @@
+package dbrepo
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"time"
+)
+
+// SessionStore keeps scs sessions in the sessions table. It implements
+// scs.Store.
+type SessionStore struct {
+	SqlConn     *sql.DB
+	stopCleanup chan struct{}
+}
+
+// NewSessionStore returns a store that deletes expired sessions every
+// cleanupInterval. A zero interval turns the cleanup off.
+func NewSessionStore(db *sql.DB, cleanupInterval time.Duration) *SessionStore {
+	s := &SessionStore{SqlConn: db}
+	if cleanupInterval > 0 {
+		s.stopCleanup = make(chan struct{})
+		go s.cleanup(cleanupInterval)
+	}
+	return s
+}
+
+// Find returns the data of the unexpired session with token.
+func (s *SessionStore) Find(token string) ([]byte, bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `select data from sessions where token = $1 and expiry > $2`
+
+	var data []byte
+	err := s.SqlConn.QueryRowContext(ctx, query, token, time.Now()).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return data, true, nil
+}
+
+// Commit adds or replaces the session with token.
+func (s *SessionStore) Commit(token string, data []byte, expiry time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	stmt := `insert into sessions (token, data, expiry) values ($1, $2, $3)
+		on conflict (token) do update set data = excluded.data, expiry = excluded.expiry`
+
+	_, err := s.SqlConn.ExecContext(ctx, stmt, token, data, expiry)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (s *SessionStore) Delete(token string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	stmt := `delete from sessions where token = $1`
+	_, err := s.SqlConn.ExecContext(ctx, stmt, token)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (s *SessionStore) DeleteExpired() error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	stmt := `delete from sessions where expiry < $1`
+	_, err := s.SqlConn.ExecContext(ctx, stmt, time.Now())
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (s *SessionStore) cleanup(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := s.DeleteExpired(); err != nil {
+				log.Println("Error deleting expired sessions:", err)
+			}
+		case <-s.stopCleanup:
+			return
+		}
+	}
+}
+
+// StopCleanup stops the goroutine deleting expired sessions.
+func (s *SessionStore) StopCleanup() {
+	if s.stopCleanup != nil {
+		close(s.stopCleanup)
+	}
+}
@@ -0,0 +1,38 @@
+package dbrepo
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSessionStore(t *testing.T) {
+	store := NewSessionStore(testRepo.SqlConn, 0)
+
+	_, found, err := store.Find("session-token")
+	assert.NoError(t, err)
+	assert.False(t, found)
+
+	assert.NoError(t, store.Commit("session-token", []byte("first"), time.Now().Add(time.Hour)))
+	assert.NoError(t, store.Commit("session-token", []byte("second"), time.Now().Add(time.Hour)))
+	data, found, err := store.Find("session-token")
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, []byte("second"), data)
+
+	assert.NoError(t, store.Commit("expired-token", []byte("old"), time.Now().Add(-time.Minute)))
+	_, found, err = store.Find("expired-token")
+	assert.NoError(t, err)
+	assert.False(t, found)
+
+	assert.NoError(t, store.DeleteExpired())
+	var count int
+	assert.NoError(t, testRepo.SqlConn.QueryRow(`select count(*) from sessions`).Scan(&count))
+	assert.Equal(t, 1, count)
+
+	assert.NoError(t, store.Delete("session-token"))
+	_, found, err = store.Find("session-token")
+	assert.NoError(t, err)
+	assert.False(t, found)
+}
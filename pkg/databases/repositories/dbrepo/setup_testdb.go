@@ -2,19 +2,30 @@ package dbrepo
 
 import (
 	"database/sql"
-	"errors"
+	"fmt"
+	"slices"
+	"strings"
 	"time"
 
+	databases "github.com/MinhNHHH/online-store/pkg/databases/repositories"
 	"github.com/MinhNHHH/online-store/pkg/databases/schema"
 )
 
 type TestDBRepo struct{}
 
+// TestTOTPSecret is the two-factor secret of the user "twofactor@example.com".
+const TestTOTPSecret = "JBSWY3DPEHPK3PXP"
+
+// TestAdminID is the ID of "staff@example.com", the only admin.
+const TestAdminID = 8
+
 func (p *TestDBRepo) SQLConnection() *sql.DB {
 	return nil
 }
 
-func (p *TestDBRepo) AllCategories(name string, page, pageSize int) ([]*schema.Category, int, error) {
+// AllCategories lists two categories. Numbered pages count them; the first
+// page by cursor has a next page and later ones a previous page.
+func (p *TestDBRepo) AllCategories(name string, page schema.Page) ([]*schema.Category, *schema.PageInfo, error) {
 	var categories []*schema.Category
 	mocks := []*schema.Category{
 		{
@@ -34,15 +45,55 @@ func (p *TestDBRepo) AllCategories(name string, page, pageSize int) ([]*schema.C
 	}
 	categories = append(categories, mocks...)
 
-	return categories, 0, nil
+	info := &schema.PageInfo{}
+	cursor := &schema.Cursor{Sort: "newest:desc", Key: "2025-06-01 10:00:00", ID: 2}
+	switch {
+	case page.Number > 0:
+		info.Total = len(categories)
+	case page.Cursor == nil:
+		info.Next = cursor
+	default:
+		info.Prev = &schema.Cursor{Sort: "newest:desc", Key: "2025-06-01 11:00:00", ID: 1, Before: true}
+	}
+	return categories, info, nil
 }
 
+// GetCategory knows category 1, "shirts", under category 2, "clothing".
+func (p *TestDBRepo) GetCategory(id int) (*schema.Category, error) {
+	switch id {
+	case 1:
+		parentID := 2
+		return &schema.Category{ID: 1, Slug: "shirts", Name: "Shirts", ParentID: &parentID}, nil
+	case 2:
+		return &schema.Category{ID: 2, Slug: "clothing", Name: "Clothing"}, nil
+	}
+	return nil, sql.ErrNoRows
+}
+
+// GetCategoryBySlug knows category 1 as "shirts", and before as "tops".
+func (p *TestDBRepo) GetCategoryBySlug(slug string) (*schema.Category, error) {
+	if slug == "shirts" || slug == "tops" {
+		return p.GetCategory(1)
+	}
+	return nil, sql.ErrNoRows
+}
+
+// InsertCategory puts categories under the categories GetCategory knows.
 func (p *TestDBRepo) InsertCategory(category *schema.Category) (int, error) {
+	if category.ParentID != nil {
+		if _, err := p.GetCategory(*category.ParentID); err != nil {
+			return 0, databases.ErrParentCategoryNotFound
+		}
+	}
 	category.ID = 1
 	return category.ID, nil
 }
 
+// UpdateCategory refuses the slug "taken".
 func (p *TestDBRepo) UpdateCategory(category *schema.Category) error {
+	if category.Slug == "taken" {
+		return databases.ErrSlugTaken
+	}
 	return nil
 }
 
@@ -50,15 +101,49 @@ func (p *TestDBRepo) DeleteCategory(id int) error {
 	return nil
 }
 
-func (p *TestDBRepo) AllProducts(name, categoryName, status string, page, pageSize int) ([]*schema.Product, int, error) {
-	return nil, 0, nil
+// CategoryTree has category 1 under category 2.
+func (p *TestDBRepo) CategoryTree() ([]*schema.Category, error) {
+	clothing, _ := p.GetCategory(2)
+	shirts, _ := p.GetCategory(1)
+	clothing.Children = []*schema.Category{shirts}
+	return []*schema.Category{clothing}, nil
+}
+
+// MoveCategory moves the categories GetCategory knows, and refuses to move
+// category 2 under category 1, which is under it.
+func (p *TestDBRepo) MoveCategory(id int, parentID *int) error {
+	if _, err := p.GetCategory(id); err != nil {
+		return err
+	}
+	if parentID == nil {
+		return nil
+	}
+	if _, err := p.GetCategory(*parentID); err != nil {
+		return databases.ErrParentCategoryNotFound
+	}
+	if *parentID == id || id == 2 {
+		return databases.ErrCategoryCycle
+	}
+	return nil
+}
+
+func (p *TestDBRepo) AllProducts(filter schema.ProductFilter, page schema.Page) ([]*schema.Product, *schema.PageInfo, error) {
+	return nil, &schema.PageInfo{}, nil
+}
+
+func (p *TestDBRepo) ProductFacets(filter schema.ProductFilter) (*schema.ProductFacets, error) {
+	return &schema.ProductFacets{}, nil
 }
 
 func (p *TestDBRepo) InsertProduct(product *schema.Product) (int, error) {
 	return 0, nil
 }
 
+// UpdateProduct refuses the slug "taken".
 func (p *TestDBRepo) UpdateProduct(product *schema.Product) error {
+	if product.Slug == "taken" {
+		return databases.ErrSlugTaken
+	}
 	return nil
 }
 
@@ -66,6 +151,112 @@ func (p *TestDBRepo) DeleteProduct(id int) error {
 	return nil
 }
 
+// GetProduct knows product 1, a T-shirt sold in sizes, with the variant 1
+// "TEE-S" and the images 1 and 2.
+func (p *TestDBRepo) GetProduct(id int) (*schema.Product, error) {
+	if id == 1 {
+		return &schema.Product{
+			ID:            1,
+			Slug:          "t-shirt",
+			Name:          "T-shirt",
+			Price:         20,
+			StockQuantity: 5,
+			Status:        schema.ProductStatusInStock,
+			Categories:    []schema.CategoryRef{},
+			Breadcrumbs:   [][]schema.CategoryRef{},
+			Options:       []string{"size"},
+			Variants: []schema.ProductVariant{
+				{ID: 1, ProductID: 1, SKU: "TEE-S", Options: map[string]string{"size": "S"}, StockQuantity: 5, Status: schema.ProductStatusInStock},
+			},
+			Images: []schema.ProductImage{testProductImage(1), testProductImage(2)},
+		}, nil
+	}
+	return nil, sql.ErrNoRows
+}
+
+// GetProductBySlug knows product 1 as "t-shirt", and before as "tee".
+func (p *TestDBRepo) GetProductBySlug(slug string) (*schema.Product, error) {
+	if slug == "t-shirt" || slug == "tee" {
+		return p.GetProduct(1)
+	}
+	return nil, sql.ErrNoRows
+}
+
+// RatingHistogram has two five star reviews of product 1.
+func (p *TestDBRepo) RatingHistogram(productID int) (map[int]int, error) {
+	histogram := map[int]int{1: 0, 2: 0, 3: 0, 4: 0, 5: 0}
+	if productID == 1 {
+		histogram[5] = 2
+	}
+	return histogram, nil
+}
+
+// InsertProductVariant refuses the SKU "TEE-S", which variant 1 has.
+func (p *TestDBRepo) InsertProductVariant(variant *schema.ProductVariant) (int, error) {
+	if variant.SKU == "TEE-S" {
+		return 0, databases.ErrDuplicateSKU
+	}
+	return 2, nil
+}
+
+func (p *TestDBRepo) UpdateProductVariant(variant *schema.ProductVariant) error {
+	if variant.ProductID != 1 || variant.ID != 1 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func (p *TestDBRepo) DeleteProductVariant(productID, variantID int) error {
+	if productID != 1 || variantID != 1 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func testProductImage(id int) schema.ProductImage {
+	key := fmt.Sprintf("products/1/image%d", id)
+	return schema.ProductImage{
+		ID:            id,
+		ProductID:     1,
+		Key:           key + ".jpg",
+		ContentType:   "image/jpeg",
+		Width:         640,
+		Height:        480,
+		Size:          1024,
+		Position:      id - 1,
+		ThumbnailKeys: map[string]string{"160": key + "_160.jpg"},
+	}
+}
+
+func (p *TestDBRepo) InsertProductImage(image *schema.ProductImage) (int, error) {
+	image.Position = 2
+	return 3, nil
+}
+
+func (p *TestDBRepo) SetProductImageAltText(productID, imageID int, altText string) error {
+	if productID != 1 || (imageID != 1 && imageID != 2) {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// ReorderProductImages accepts either order of the images 1 and 2 of product
+// 1.
+func (p *TestDBRepo) ReorderProductImages(productID int, imageIDs []int) error {
+	if productID != 1 || !(slices.Equal(imageIDs, []int{1, 2}) || slices.Equal(imageIDs, []int{2, 1})) {
+		return databases.ErrImageOrder
+	}
+	return nil
+}
+
+func (p *TestDBRepo) DeleteProductImage(productID, imageID int) (*schema.ProductImage, error) {
+	if productID != 1 || (imageID != 1 && imageID != 2) {
+		return nil, sql.ErrNoRows
+	}
+	image := testProductImage(imageID)
+	return &image, nil
+}
+
 func (p *TestDBRepo) DeleteReview(id int) error {
 	return nil
 }
@@ -78,41 +269,167 @@ func (p *TestDBRepo) RemoveFromWishlist(userID, productID int) error {
 	return nil
 }
 
+// GetWishlist has one product on the wishlist of user 1 and none on the others.
 func (p *TestDBRepo) GetWishlist(userID int) ([]*schema.Product, error) {
-	return nil, nil
+	if userID == 1 {
+		return []*schema.Product{{ID: 1, Name: "test"}}, nil
+	}
+	return []*schema.Product{}, nil
 }
 
-func (p *TestDBRepo) AllUsers() ([]*schema.User, error) {
-	return nil, nil
+func (p *TestDBRepo) AllUsers(search string, page, pageSize int) ([]*schema.User, int, error) {
+	users := []*schema.User{}
+	for _, email := range []string{"admin@example.com", "unverified@example.com", "twofactor@example.com", "deactivated@example.com"} {
+		user, _ := p.GetUserByEmail(email)
+		if strings.Contains(user.Name, search) || strings.Contains(user.Email, search) {
+			users = append(users, user)
+		}
+	}
+	total := len(users)
+	start := min((page-1)*pageSize, total)
+	return users[start:min(start+pageSize, total)], total, nil
 }
 
 func (p *TestDBRepo) GetUser(id int) (*schema.User, error) {
-	return nil, nil
+	switch id {
+	case 1:
+		return p.GetUserByEmail("admin@example.com")
+	case 2:
+		return p.GetUserByEmail("unverified@example.com")
+	case 3:
+		return p.GetUserByEmail("twofactor@example.com")
+	case 6:
+		return p.GetUserByEmail("deactivated@example.com")
+	case 7:
+		return p.GetUserByEmail("leaving@example.com")
+	case TestAdminID:
+		return p.GetUserByEmail("staff@example.com")
+	}
+	return nil, sql.ErrNoRows
 }
 
 func (p *TestDBRepo) GetUserByEmail(email string) (*schema.User, error) {
-	if email == "admin@example.com" {
+	verifiedAt := time.Now()
+	switch email {
+	case "admin@example.com":
+		user := schema.User{
+			ID:              1,
+			Name:            "Admin",
+			Email:           "admin@example.com",
+			Password:        "$2a$14$ajq8Q7fbtFRQvXpdCq7Jcuy.Rx1h/L4J60Otx.gyNLbAYctGMJ9tK",
+			EmailVerifiedAt: &verifiedAt,
+			CreatedAt:       time.Now(),
+			UpdatedAt:       time.Now(),
+		}
+		return &user, nil
+	case "unverified@example.com":
 		user := schema.User{
-			ID:        1,
-			Name:      "Admin",
-			Email:     "admin@example.com",
+			ID:        2,
+			Name:      "Unverified",
+			Email:     "unverified@example.com",
 			Password:  "$2a$14$ajq8Q7fbtFRQvXpdCq7Jcuy.Rx1h/L4J60Otx.gyNLbAYctGMJ9tK",
 			CreatedAt: time.Now(),
 			UpdatedAt: time.Now(),
 		}
 		return &user, nil
+	case "twofactor@example.com":
+		user := schema.User{
+			ID:              3,
+			Name:            "Two Factor",
+			Email:           "twofactor@example.com",
+			Password:        "$2a$14$ajq8Q7fbtFRQvXpdCq7Jcuy.Rx1h/L4J60Otx.gyNLbAYctGMJ9tK",
+			EmailVerifiedAt: &verifiedAt,
+			TOTPSecret:      TestTOTPSecret,
+			TOTPEnabledAt:   &verifiedAt,
+			CreatedAt:       time.Now(),
+			UpdatedAt:       time.Now(),
+		}
+		return &user, nil
+	case "deactivated@example.com":
+		user := schema.User{
+			ID:              6,
+			Name:            "Deactivated",
+			Email:           "deactivated@example.com",
+			Password:        "$2a$14$ajq8Q7fbtFRQvXpdCq7Jcuy.Rx1h/L4J60Otx.gyNLbAYctGMJ9tK",
+			EmailVerifiedAt: &verifiedAt,
+			DeactivatedAt:   &verifiedAt,
+			CreatedAt:       time.Now(),
+			UpdatedAt:       time.Now(),
+		}
+		return &user, nil
+	case "leaving@example.com":
+		deletionAt := time.Now().Add(time.Hour * 24)
+		user := schema.User{
+			ID:                  7,
+			Name:                "Leaving",
+			Email:               "leaving@example.com",
+			Password:            "$2a$14$ajq8Q7fbtFRQvXpdCq7Jcuy.Rx1h/L4J60Otx.gyNLbAYctGMJ9tK",
+			EmailVerifiedAt:     &verifiedAt,
+			DeletionScheduledAt: &deletionAt,
+			DeletionMode:        schema.DeletionModeAnonymize,
+			CreatedAt:           time.Now(),
+			UpdatedAt:           time.Now(),
+		}
+		return &user, nil
+	case "staff@example.com":
+		user := schema.User{
+			ID:              TestAdminID,
+			Name:            "Staff",
+			Email:           "staff@example.com",
+			Password:        "$2a$14$ajq8Q7fbtFRQvXpdCq7Jcuy.Rx1h/L4J60Otx.gyNLbAYctGMJ9tK",
+			IsAdmin:         true,
+			EmailVerifiedAt: &verifiedAt,
+			CreatedAt:       time.Now(),
+			UpdatedAt:       time.Now(),
+		}
+		return &user, nil
 	}
-	return nil, errors.New("not found")
+	return nil, sql.ErrNoRows
 }
 
 func (p *TestDBRepo) UpdateUser(user schema.User) error {
 	return nil
 }
 
+func (p *TestDBRepo) SetUserAdmin(id int, isAdmin bool) error {
+	_, err := p.GetUser(id)
+	return err
+}
+
+func (p *TestDBRepo) SetUserDeactivated(id int, deactivated bool) error {
+	_, err := p.GetUser(id)
+	return err
+}
+
+func (p *TestDBRepo) RevokeUserSessions(id int) (int, error) {
+	user, err := p.GetUser(id)
+	if err != nil {
+		return 0, err
+	}
+	return user.SessionGeneration + 1, nil
+}
+
 func (p *TestDBRepo) DeleteUser(id int) error {
 	return nil
 }
 
+func (p *TestDBRepo) ScheduleUserDeletion(id int, mode string, at time.Time) error {
+	_, err := p.GetUser(id)
+	return err
+}
+
+// CancelUserDeletion only finds a scheduled deletion for user 7.
+func (p *TestDBRepo) CancelUserDeletion(id int) error {
+	if id == 7 {
+		return nil
+	}
+	return sql.ErrNoRows
+}
+
+func (p *TestDBRepo) PurgeDueUsers(now time.Time) ([]int, error) {
+	return []int{}, nil
+}
+
 func (p *TestDBRepo) InsertUser(user schema.User) (int, error) {
 	return 0, nil
 }
@@ -121,10 +438,254 @@ func (p *TestDBRepo) ResetPassword(id int, password string) error {
 	return nil
 }
 
-func (p *TestDBRepo) ReviewsByProductID(productID int) ([]*schema.Review, error) {
-	return nil, nil
+func (p *TestDBRepo) ReviewsByProductID(productID int, page schema.Page) ([]*schema.Review, *schema.PageInfo, error) {
+	return []*schema.Review{}, &schema.PageInfo{}, nil
+}
+
+// ReviewsByUserID has one review by user 1 and none by the others.
+func (p *TestDBRepo) ReviewsByUserID(userID int) ([]*schema.Review, error) {
+	if userID == 1 {
+		return []*schema.Review{{ID: 1, ProductID: 1, ProductName: "test", UserID: 1, Rating: 5, Comment: "great"}}, nil
+	}
+	return []*schema.Review{}, nil
+}
+
+// GetReview knows review 1 of product 1 by user 1, and review 2 of product 1
+// by an erased user.
+func (p *TestDBRepo) GetReview(id int) (*schema.Review, error) {
+	switch id {
+	case 1:
+		return &schema.Review{ID: 1, ProductID: 1, UserID: 1, Rating: 5, Comment: "great"}, nil
+	case 2:
+		return &schema.Review{ID: 2, ProductID: 1, Rating: 1, Comment: "bad"}, nil
+	}
+	return nil, sql.ErrNoRows
 }
 
 func (p *TestDBRepo) InsertReview(review *schema.Review) (int, error) {
 	return 0, nil
 }
+
+func (p *TestDBRepo) InsertRefreshToken(token *schema.RefreshToken) error {
+	return nil
+}
+
+// GetRefreshToken knows three tokens: "valid-jti", which can still be used,
+// "rotated-jti", which has already been exchanged, and "deactivated-jti" of
+// the deactivated user 6.
+func (p *TestDBRepo) GetRefreshToken(jti string) (*schema.RefreshToken, error) {
+	switch jti {
+	case "valid-jti":
+		return &schema.RefreshToken{
+			JTI:       jti,
+			UserID:    1,
+			FamilyID:  "family",
+			ExpiresAt: time.Now().Add(time.Hour),
+		}, nil
+	case "deactivated-jti":
+		return &schema.RefreshToken{
+			JTI:       jti,
+			UserID:    6,
+			FamilyID:  "deactivated-family",
+			ExpiresAt: time.Now().Add(time.Hour),
+		}, nil
+	case "rotated-jti":
+		return &schema.RefreshToken{
+			JTI:        jti,
+			UserID:     1,
+			FamilyID:   "family",
+			ReplacedBy: "valid-jti",
+			ExpiresAt:  time.Now().Add(time.Hour),
+		}, nil
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (p *TestDBRepo) RotateRefreshToken(oldJTI string, next *schema.RefreshToken) error {
+	return nil
+}
+
+func (p *TestDBRepo) RevokeRefreshTokenFamily(familyID string) error {
+	return nil
+}
+
+func (p *TestDBRepo) RevokeUserRefreshTokens(userID int) error {
+	return nil
+}
+
+func (p *TestDBRepo) InsertPasswordResetToken(userID int, tokenHash string, expiresAt time.Time) error {
+	return nil
+}
+
+// ResetPasswordWithToken accepts only the SHA-256 of "valid-reset-token".
+func (p *TestDBRepo) ResetPasswordWithToken(tokenHash, password string) (int, error) {
+	if tokenHash == "79902197833df66c53a7e9a88601f58cb91f4ec72bd113b8b5d686e6ca1dc3bc" {
+		return 1, nil
+	}
+	return 0, sql.ErrNoRows
+}
+
+func (p *TestDBRepo) InsertEmailVerificationToken(userID int, email, tokenHash string, expiresAt time.Time) error {
+	return nil
+}
+
+// VerifyEmailWithToken accepts only the SHA-256 of "valid-verify-token".
+func (p *TestDBRepo) VerifyEmailWithToken(tokenHash string) (int, error) {
+	if tokenHash == "3416ed12aace363b89b5602eb658b850704ef45b1152780958c02e829448d4c6" {
+		return 1, nil
+	}
+	return 0, sql.ErrNoRows
+}
+
+// GetLoginThrottle reports "locked@example.com" as locked for ten minutes and
+// "slow@example.com" as having just failed five times in a row.
+func (p *TestDBRepo) GetLoginThrottle(scope, key string) (*schema.LoginThrottle, error) {
+	now := time.Now()
+	switch {
+	case scope == "account" && key == "locked@example.com":
+		lockedUntil := now.Add(10 * time.Minute)
+		return &schema.LoginThrottle{Scope: scope, Key: key, Failures: 10, LastFailureAt: &now, LockedUntil: &lockedUntil}, nil
+	case scope == "account" && key == "slow@example.com":
+		return &schema.LoginThrottle{Scope: scope, Key: key, Failures: 5, LastFailureAt: &now}, nil
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (p *TestDBRepo) RecordLoginFailure(scope, key string, window time.Duration) (*schema.LoginThrottle, error) {
+	now := time.Now()
+	return &schema.LoginThrottle{Scope: scope, Key: key, Failures: 1, LastFailureAt: &now}, nil
+}
+
+func (p *TestDBRepo) LockLogin(lockout *schema.Lockout) error {
+	return nil
+}
+
+func (p *TestDBRepo) ForgiveLoginFailure(scope, key string) error {
+	return nil
+}
+
+func (p *TestDBRepo) ClearLoginFailures(scope, key string) error {
+	return nil
+}
+
+func (p *TestDBRepo) AllLockouts(activeOnly bool) ([]*schema.Lockout, error) {
+	return []*schema.Lockout{}, nil
+}
+
+func (p *TestDBRepo) UnlockLogin(scope, key string, adminID int) error {
+	return nil
+}
+
+func (p *TestDBRepo) SetTOTPSecret(userID int, secret string) error {
+	return nil
+}
+
+func (p *TestDBRepo) EnableTOTP(userID int, recoveryCodeHashes []string) error {
+	return nil
+}
+
+func (p *TestDBRepo) DisableTOTP(userID int) error {
+	return nil
+}
+
+func (p *TestDBRepo) UseTOTPStep(userID int, step int64) error {
+	return nil
+}
+
+// UseRecoveryCode accepts only the recovery code "aaaaa-bbbbb".
+func (p *TestDBRepo) UseRecoveryCode(userID int, codeHash string) error {
+	if codeHash == "ed74b5c9ceaa577420d8ec549a9e55c9480ea02f6718c4095687f67e4ab220d4" {
+		return nil
+	}
+	return sql.ErrNoRows
+}
+
+func (p *TestDBRepo) GetUserByIdentity(provider, subject string) (*schema.User, error) {
+	if provider == "mock" {
+		switch subject {
+		case "admin-subject":
+			return p.GetUser(1)
+		case "twofactor-subject":
+			return p.GetUser(3)
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (p *TestDBRepo) InsertUserIdentity(identity *schema.UserIdentity) error {
+	return nil
+}
+
+func (p *TestDBRepo) InsertUserWithIdentity(user schema.User, identity *schema.UserIdentity) (int, error) {
+	return 4, nil
+}
+
+func (p *TestDBRepo) InsertAPIKey(key *schema.APIKey, keyHash string) (int, error) {
+	return 1, nil
+}
+
+func (p *TestDBRepo) AllAPIKeys() ([]*schema.APIKey, error) {
+	return []*schema.APIKey{}, nil
+}
+
+// UseAPIKey knows the SHA-256 of two keys: "so_products-key", which can read
+// and write products, and "so_categories-read-key".
+func (p *TestDBRepo) UseAPIKey(keyHash string) (*schema.APIKey, error) {
+	switch keyHash {
+	case "34bada63f641edd8d6e2e2c284dcd12468e81edca08d0ed5a758c921df212181":
+		return &schema.APIKey{ID: 1, Name: "ERP sync", Scopes: []string{"products:read", "products:write"}}, nil
+	case "84f3590ec7ce362bdb954ba0051c5d3b9869159b0b8341969bd32bc75a6169a3":
+		return &schema.APIKey{ID: 2, Name: "Scanner", Scopes: []string{"categories:read"}}, nil
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (p *TestDBRepo) RevokeAPIKey(id int) error {
+	if id == 1 {
+		return nil
+	}
+	return sql.ErrNoRows
+}
+
+func (p *TestDBRepo) InsertAuthEvent(event *schema.AuthEvent) error {
+	event.ID = 1
+	event.CreatedAt = time.Now()
+	return nil
+}
+
+// testAuthEvents filters three events by type and outcome: a failed and a
+// successful login of user 1 and an admin promoting user 2.
+func testAuthEvents(filter schema.AuthEventFilter) []*schema.AuthEvent {
+	admin, user := 1, 2
+	mocks := []*schema.AuthEvent{
+		{ID: 3, Type: "user_promoted", Outcome: "success", UserID: &user, ActorID: &admin, IP: "192.0.2.1", CreatedAt: time.Now()},
+		{ID: 2, Type: "login", Outcome: "success", UserID: &admin, Email: "admin@example.com", IP: "192.0.2.1", UserAgent: "test, \"quoted\"", CreatedAt: time.Now()},
+		{ID: 1, Type: "login", Outcome: "failure", Reason: "wrong_password", UserID: &admin, Email: "admin@example.com", IP: "192.0.2.2", CreatedAt: time.Now()},
+	}
+
+	events := []*schema.AuthEvent{}
+	for _, event := range mocks {
+		if (filter.Type == "" || event.Type == filter.Type) && (filter.Outcome == "" || event.Outcome == filter.Outcome) {
+			events = append(events, event)
+		}
+	}
+	return events
+}
+
+func (p *TestDBRepo) AllAuthEvents(filter schema.AuthEventFilter, page, pageSize int) ([]*schema.AuthEvent, int, error) {
+	events := testAuthEvents(filter)
+	total := len(events)
+	start := min((page-1)*pageSize, total)
+	end := min(start+pageSize, total)
+	return events[start:end], total, nil
+}
+
+func (p *TestDBRepo) AuthEventsBefore(filter schema.AuthEventFilter, beforeID, limit int) ([]*schema.AuthEvent, error) {
+	events := []*schema.AuthEvent{}
+	for _, event := range testAuthEvents(filter) {
+		if (beforeID == 0 || event.ID < beforeID) && len(events) < limit {
+			events = append(events, event)
+		}
+	}
+	return events, nil
+}
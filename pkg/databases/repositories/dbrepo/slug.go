@@ -0,0 +1,116 @@
+package dbrepo
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	databases "github.com/MinhNHHH/online-store/pkg/databases/repositories"
+	"github.com/MinhNHHH/online-store/pkg/slug"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// slugAttempts is how many slugs insert tries before giving up.
+const slugAttempts = 5
+
+// slugs is where the slugs of one kind of row are kept: the current ones in
+// table, and old ones, which still lead to their row, in redirects, whose
+// column ref points at it. A slug is only ever in one of those places.
+type slugs struct {
+	table     string
+	redirects string
+	ref       string
+}
+
+var (
+	productSlugs  = slugs{table: "products", redirects: "product_slug_redirects", ref: "product_id"}
+	categorySlugs = slugs{table: "categories", redirects: "category_slug_redirects", ref: "category_id"}
+)
+
+// lookup is the condition matching the row with the given slug, current or
+// old, in the parameter arg of a query on table aliased as alias.
+func (s slugs) lookup(alias string, arg int) string {
+	return fmt.Sprintf("(%[1]s.slug = $%[2]d or %[1]s.id = (select %[3]s from %[4]s where slug = $%[2]d))", alias, arg, s.ref, s.redirects)
+}
+
+// taken reports whether a row other than id has slug, now or before.
+func (s slugs) taken(ctx context.Context, tx *sql.Tx, slug string, id int) (bool, error) {
+	var taken bool
+	err := tx.QueryRowContext(ctx, `select exists (select 1 from `+s.table+` where slug = $1 and id <> $2)
+		or exists (select 1 from `+s.redirects+` where slug = $1 and `+s.ref+` <> $2)`, slug, id).Scan(&taken)
+	return taken, err
+}
+
+// unique returns base, or else base numbered from 2, whichever is not taken.
+func (s slugs) unique(ctx context.Context, tx *sql.Tx, base string, id int) (string, error) {
+	candidate := base
+	for n := 2; ; n++ {
+		taken, err := s.taken(ctx, tx, candidate, id)
+		if err != nil || !taken {
+			return candidate, err
+		}
+		suffix := "-" + strconv.Itoa(n)
+		candidate = strings.TrimSuffix(base[:min(len(base), slug.MaxLength-len(suffix))], "-") + suffix
+	}
+}
+
+// conflict reports whether err is the unique constraint on the current slugs
+// refusing a slug another transaction took first.
+func (s slugs) conflict(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "23505" && pgErr.ConstraintName == s.table+"_slug_key"
+}
+
+// insert runs insert, which adds a row, with a slug made from base that is
+// not taken. Checking and inserting are two statements, so a concurrent
+// insert can take the slug in between; the row is then tried again, after
+// rolling back to before the failed insert, with the next free slug.
+func (s slugs) insert(ctx context.Context, tx *sql.Tx, base string, insert func(slug string) error) error {
+	for attempt := 1; ; attempt++ {
+		candidate, err := s.unique(ctx, tx, base, 0)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, `savepoint slug_insert`); err != nil {
+			return err
+		}
+		err = insert(candidate)
+		if !s.conflict(err) || attempt == slugAttempts {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, `rollback to savepoint slug_insert`); err != nil {
+			return err
+		}
+	}
+}
+
+// change gives row id a new slug, and keeps the one it had as a redirect. A
+// row can take back an old slug of its own, but not one of another row.
+func (s slugs) change(ctx context.Context, tx *sql.Tx, id int, slug string) error {
+	taken, err := s.taken(ctx, tx, slug, id)
+	if err != nil {
+		return err
+	}
+	if taken {
+		return databases.ErrSlugTaken
+	}
+
+	stmt := `insert into ` + s.redirects + ` (slug, ` + s.ref + `)
+		select slug, id from ` + s.table + ` where id = $1 and slug <> $2
+		on conflict (slug) do nothing`
+	if _, err := tx.ExecContext(ctx, stmt, id, slug); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `delete from `+s.redirects+` where slug = $1`, slug); err != nil {
+		return err
+	}
+	err = execAffectingRow(ctx, tx, `update `+s.table+` set slug = $1 where id = $2`, slug, id)
+	if s.conflict(err) {
+		// another row took the slug since it was checked
+		return databases.ErrSlugTaken
+	}
+	return err
+}
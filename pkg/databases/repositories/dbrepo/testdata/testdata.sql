@@ -6,10 +6,15 @@ DROP INDEX IF EXISTS idx_products_created_at;
 
 DROP INDEX IF EXISTS idx_categories_name;
 DROP INDEX IF EXISTS idx_categories_created_at;
+DROP INDEX IF EXISTS idx_categories_parent_id;
 
 DROP INDEX IF EXISTS idx_product_categories_product_id;
 DROP INDEX IF EXISTS idx_product_categories_category_id;
 
+DROP TABLE IF EXISTS category_slug_redirects;
+DROP TABLE IF EXISTS product_slug_redirects;
+DROP TABLE IF EXISTS product_images;
+DROP TABLE IF EXISTS product_variants;
 DROP TABLE IF EXISTS wishlist;
 DROP TABLE IF EXISTS reviews;
 DROP TABLE IF EXISTS product_categories;
@@ -30,6 +35,17 @@ CREATE TABLE users (
     updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
 );
 
+ALTER TABLE users ADD COLUMN is_admin BOOLEAN DEFAULT FALSE;
+ALTER TABLE users ADD COLUMN email_verified_at TIMESTAMP;
+ALTER TABLE users ADD COLUMN totp_secret VARCHAR(64);
+ALTER TABLE users ADD COLUMN totp_enabled_at TIMESTAMP;
+ALTER TABLE users ADD COLUMN totp_last_step BIGINT;
+ALTER TABLE users ADD COLUMN deactivated_at TIMESTAMP;
+ALTER TABLE users ADD COLUMN deletion_scheduled_at TIMESTAMP;
+ALTER TABLE users ADD COLUMN deletion_mode VARCHAR(16) CHECK (deletion_mode IN ('delete', 'anonymize'));
+
+CREATE INDEX idx_users_deletion_scheduled_at ON users(deletion_scheduled_at);
+
 CREATE TABLE products (
 	id SERIAL PRIMARY KEY,
 	name VARCHAR(255) NOT NULL,
@@ -59,7 +75,7 @@ CREATE TABLE product_categories (
 CREATE TABLE reviews (
 	id SERIAL PRIMARY KEY,
 	product_id INT NOT NULL,
-	user_id INT NOT NULL,
+	user_id INT,
 	rating INT NOT NULL CHECK (
 		rating >= 1
 		AND rating <= 5
@@ -68,7 +84,7 @@ CREATE TABLE reviews (
 	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
 	updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
 	FOREIGN KEY (product_id) REFERENCES products(id) ON DELETE CASCADE,
-	FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+	FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE SET NULL
 );
 
 CREATE TABLE wishlist (
@@ -100,3 +116,304 @@ INSERT INTO users (name, email, password) VALUES
 ('Alice Nguyen', 'alice@example.com', 'hashed_pwd_1'),
 ('Bob Tran', 'bob@example.com', 'hashed_pwd_2'),
 ('Carol Pham', 'carol@example.com', 'hashed_pwd_3');
+
+CREATE TABLE refresh_tokens (
+	jti VARCHAR(64) PRIMARY KEY,
+	user_id INT NOT NULL,
+	family_id VARCHAR(64) NOT NULL,
+	replaced_by VARCHAR(64),
+	mfa BOOLEAN NOT NULL DEFAULT FALSE,
+	expires_at TIMESTAMP NOT NULL,
+	revoked_at TIMESTAMP,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_refresh_tokens_user_id ON refresh_tokens(user_id);
+CREATE INDEX idx_refresh_tokens_family_id ON refresh_tokens(family_id);
+
+CREATE TABLE password_reset_tokens (
+	id SERIAL PRIMARY KEY,
+	user_id INT NOT NULL,
+	token_hash VARCHAR(64) UNIQUE NOT NULL,
+	expires_at TIMESTAMP NOT NULL,
+	used_at TIMESTAMP,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_password_reset_tokens_user_id ON password_reset_tokens(user_id);
+
+CREATE TABLE email_verification_tokens (
+	id SERIAL PRIMARY KEY,
+	user_id INT NOT NULL,
+	email VARCHAR(255) NOT NULL,
+	token_hash VARCHAR(64) UNIQUE NOT NULL,
+	expires_at TIMESTAMP NOT NULL,
+	used_at TIMESTAMP,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_email_verification_tokens_user_id ON email_verification_tokens(user_id);
+
+CREATE TABLE login_throttles (
+	scope VARCHAR(16) NOT NULL CHECK (scope IN ('account', 'ip')),
+	key VARCHAR(255) NOT NULL,
+	failures INT NOT NULL DEFAULT 0,
+	last_failure_at TIMESTAMP,
+	locked_until TIMESTAMP,
+	PRIMARY KEY (scope, key)
+);
+
+CREATE TABLE login_lockouts (
+	id SERIAL PRIMARY KEY,
+	scope VARCHAR(16) NOT NULL,
+	key VARCHAR(255) NOT NULL,
+	user_id INT,
+	ip VARCHAR(64),
+	failures INT NOT NULL,
+	locked_until TIMESTAMP NOT NULL,
+	unlocked_at TIMESTAMP,
+	unlocked_by INT,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+	FOREIGN KEY (unlocked_by) REFERENCES users(id) ON DELETE SET NULL
+);
+
+CREATE INDEX idx_login_lockouts_scope_key ON login_lockouts(scope, key);
+CREATE INDEX idx_login_lockouts_created_at ON login_lockouts(created_at);
+
+CREATE TABLE recovery_codes (
+	id SERIAL PRIMARY KEY,
+	user_id INT NOT NULL,
+	code_hash VARCHAR(64) NOT NULL,
+	used_at TIMESTAMP,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_recovery_codes_user_id ON recovery_codes(user_id);
+
+CREATE TABLE user_identities (
+	id SERIAL PRIMARY KEY,
+	user_id INT NOT NULL,
+	provider VARCHAR(64) NOT NULL,
+	subject VARCHAR(255) NOT NULL,
+	email VARCHAR(255),
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+	UNIQUE (provider, subject)
+);
+
+CREATE INDEX idx_user_identities_user_id ON user_identities(user_id);
+
+CREATE TABLE api_keys (
+	id SERIAL PRIMARY KEY,
+	name VARCHAR(255) NOT NULL,
+	prefix VARCHAR(16) NOT NULL,
+	key_hash VARCHAR(64) NOT NULL UNIQUE,
+	scopes TEXT NOT NULL,
+	created_by INT,
+	last_used_at TIMESTAMP,
+	expires_at TIMESTAMP,
+	revoked_at TIMESTAMP,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY (created_by) REFERENCES users(id) ON DELETE SET NULL
+);
+
+CREATE TABLE sessions (
+	token TEXT PRIMARY KEY,
+	data BYTEA NOT NULL,
+	expiry TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX idx_sessions_expiry ON sessions(expiry);
+
+-- auth_events has no foreign keys: events outlive the users they name.
+CREATE TABLE auth_events (
+	id SERIAL PRIMARY KEY,
+	event_type VARCHAR(64) NOT NULL,
+	outcome VARCHAR(16) NOT NULL CHECK (outcome IN ('success', 'failure')),
+	reason VARCHAR(255),
+	user_id INT,
+	actor_id INT,
+	email VARCHAR(255),
+	ip VARCHAR(64),
+	user_agent TEXT,
+	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_auth_events_created_at ON auth_events(created_at);
+CREATE INDEX idx_auth_events_user_id ON auth_events(user_id, created_at);
+CREATE INDEX idx_auth_events_event_type ON auth_events(event_type, created_at);
+
+CREATE FUNCTION auth_events_append_only() RETURNS TRIGGER AS $$
+BEGIN
+	RAISE EXCEPTION 'auth_events is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER auth_events_no_update_or_delete
+	BEFORE UPDATE OR DELETE ON auth_events
+	FOR EACH ROW EXECUTE FUNCTION auth_events_append_only();
+
+CREATE TRIGGER auth_events_no_truncate
+	BEFORE TRUNCATE ON auth_events
+	FOR EACH STATEMENT EXECUTE FUNCTION auth_events_append_only();
+
+-- the option axes a product's variants are defined by, e.g. ["size", "color"]
+ALTER TABLE products ADD COLUMN options JSONB NOT NULL DEFAULT '[]';
+
+CREATE TABLE product_variants (
+	id SERIAL PRIMARY KEY,
+	product_id INT NOT NULL,
+	sku VARCHAR(64) NOT NULL UNIQUE CHECK (sku <> ''),
+	options JSONB NOT NULL DEFAULT '{}',
+	-- NULL uses the product's price
+	price DECIMAL(10, 2) CHECK (price >= 0),
+	stock_quantity INT NOT NULL DEFAULT 0 CHECK (stock_quantity >= 0),
+	status VARCHAR(50) NOT NULL DEFAULT 'in_stock' CHECK (status IN ('in_stock', 'out_of_stock', 'draft')),
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY (product_id) REFERENCES products(id) ON DELETE CASCADE,
+	UNIQUE (product_id, options)
+);
+
+CREATE INDEX idx_product_variants_product_id ON product_variants(product_id);
+
+CREATE TABLE product_images (
+	id SERIAL PRIMARY KEY,
+	product_id INT NOT NULL,
+	storage_key VARCHAR(255) NOT NULL,
+	content_type VARCHAR(64) NOT NULL,
+	width INT NOT NULL,
+	height INT NOT NULL,
+	size_bytes BIGINT NOT NULL,
+	alt_text VARCHAR(255) NOT NULL DEFAULT '',
+	position INT NOT NULL DEFAULT 0,
+	-- storage keys of the thumbnails, by the length of their longest side
+	thumbnails JSONB NOT NULL DEFAULT '{}',
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY (product_id) REFERENCES products(id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_product_images_product_id ON product_images(product_id, position);
+
+-- search_vector covers the name (weight A), description (B) and category names
+-- (C) of a product. Category names live in other tables, so triggers keep it
+-- up to date rather than a generated column. Words are kept both stemmed and
+-- as written, so that prefixes of whole words match too.
+ALTER TABLE products ADD COLUMN search_vector TSVECTOR;
+
+CREATE FUNCTION search_vector_of(document TEXT, weight "char") RETURNS TSVECTOR AS $$
+	SELECT setweight(to_tsvector('english', coalesce(document, ''))
+		|| to_tsvector('simple', coalesce(document, '')), weight);
+$$ LANGUAGE sql IMMUTABLE;
+
+CREATE FUNCTION product_search_vector(product_id INT, name TEXT, description TEXT) RETURNS TSVECTOR AS $$
+	SELECT search_vector_of(name, 'A')
+		|| search_vector_of(description, 'B')
+		|| search_vector_of((
+			SELECT string_agg(c.name, ' ')
+			FROM product_categories pc
+			INNER JOIN categories c ON pc.category_id = c.id
+			WHERE pc.product_id = product_search_vector.product_id
+		), 'C');
+$$ LANGUAGE sql STABLE;
+
+CREATE FUNCTION products_search_vector_refresh() RETURNS TRIGGER AS $$
+BEGIN
+	NEW.search_vector := product_search_vector(NEW.id, NEW.name, NEW.description);
+	RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER products_search_vector_refresh
+	BEFORE INSERT OR UPDATE OF name, description ON products
+	FOR EACH ROW EXECUTE FUNCTION products_search_vector_refresh();
+
+CREATE FUNCTION product_categories_search_vector_refresh() RETURNS TRIGGER AS $$
+BEGIN
+	IF TG_OP IN ('INSERT', 'UPDATE') THEN
+		UPDATE products SET search_vector = product_search_vector(id, name, description)
+		WHERE id = NEW.product_id;
+	END IF;
+	IF TG_OP IN ('UPDATE', 'DELETE') THEN
+		UPDATE products SET search_vector = product_search_vector(id, name, description)
+		WHERE id = OLD.product_id;
+	END IF;
+	RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER product_categories_search_vector_refresh
+	AFTER INSERT OR UPDATE OR DELETE ON product_categories
+	FOR EACH ROW EXECUTE FUNCTION product_categories_search_vector_refresh();
+
+CREATE FUNCTION categories_search_vector_refresh() RETURNS TRIGGER AS $$
+BEGIN
+	UPDATE products SET search_vector = product_search_vector(id, name, description)
+	WHERE id IN (SELECT product_id FROM product_categories WHERE category_id = NEW.id);
+	RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER categories_search_vector_refresh
+	AFTER UPDATE OF name ON categories
+	FOR EACH ROW EXECUTE FUNCTION categories_search_vector_refresh();
+
+UPDATE products SET search_vector = product_search_vector(id, name, description);
+
+CREATE INDEX idx_products_search_vector ON products USING GIN (search_vector);
+
+CREATE INDEX idx_reviews_product_id ON reviews(product_id, rating);
+
+ALTER TABLE products ADD COLUMN slug VARCHAR(80) NOT NULL;
+ALTER TABLE products ADD CONSTRAINT products_slug_key UNIQUE (slug);
+
+ALTER TABLE categories ADD COLUMN slug VARCHAR(80) NOT NULL;
+ALTER TABLE categories ADD CONSTRAINT categories_slug_key UNIQUE (slug);
+
+CREATE TABLE product_slug_redirects (
+	slug VARCHAR(80) PRIMARY KEY,
+	product_id INT NOT NULL,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY (product_id) REFERENCES products(id) ON DELETE CASCADE
+);
+
+CREATE TABLE category_slug_redirects (
+	slug VARCHAR(80) PRIMARY KEY,
+	category_id INT NOT NULL,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY (category_id) REFERENCES categories(id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_product_slug_redirects_product_id ON product_slug_redirects(product_id);
+CREATE INDEX idx_category_slug_redirects_category_id ON category_slug_redirects(category_id);
+
+ALTER TABLE categories ADD COLUMN parent_id INT;
+ALTER TABLE categories ADD CONSTRAINT categories_parent_id_fkey
+	FOREIGN KEY (parent_id) REFERENCES categories(id) ON DELETE SET NULL;
+ALTER TABLE categories ADD CONSTRAINT categories_parent_id_check CHECK (parent_id <> id);
+
+CREATE INDEX idx_categories_parent_id ON categories(parent_id);
+
+ALTER TABLE login_throttles DROP CONSTRAINT IF EXISTS login_throttles_scope_check;
+ALTER TABLE login_throttles ADD CONSTRAINT login_throttles_scope_check CHECK (scope IN ('account', 'ip', 'challenge'));
+
+ALTER TABLE users ADD COLUMN session_generation INT NOT NULL DEFAULT 0;
+
+-- Purging a user blanks the email, IP and user agent of their events; any
+-- other change to an event is still refused.
+CREATE OR REPLACE FUNCTION auth_events_append_only() RETURNS TRIGGER AS $$
+BEGIN
+	IF TG_OP = 'UPDATE'
+		AND NEW.email IS NULL AND NEW.ip IS NULL AND NEW.user_agent IS NULL
+		AND (NEW.id, NEW.event_type, NEW.outcome, NEW.reason, NEW.user_id, NEW.actor_id, NEW.created_at)
+			IS NOT DISTINCT FROM (OLD.id, OLD.event_type, OLD.outcome, OLD.reason, OLD.user_id, OLD.actor_id, OLD.created_at) THEN
+		RETURN NEW;
+	END IF;
+	RAISE EXCEPTION 'auth_events is append-only';
+END;
+$$ LANGUAGE plpgsql;
@@ -0,0 +1,128 @@
+package dbrepo
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	databases "github.com/MinhNHHH/online-store/pkg/databases/repositories"
+)
+
+// SetTOTPSecret starts enrollment with a new secret. Two-factor stays off
+// until EnableTOTP is called.
+func (p *DBRepo) SetTOTPSecret(userID int, secret string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	stmt := `update users set totp_secret = $1, totp_enabled_at = null, totp_last_step = null, updated_at = $2
+		where id = $3`
+	_, err := p.SqlConn.ExecContext(ctx, stmt, secret, time.Now(), userID)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// EnableTOTP turns two-factor on and replaces the user's recovery codes.
+func (p *DBRepo) EnableTOTP(userID int, recoveryCodeHashes []string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	tx, err := p.SqlConn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	stmt := `update users set totp_enabled_at = $1, updated_at = $1 where id = $2 and totp_secret is not null`
+	_, err = tx.ExecContext(ctx, stmt, now, userID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `delete from recovery_codes where user_id = $1`, userID)
+	if err != nil {
+		return err
+	}
+
+	stmt = `insert into recovery_codes (user_id, code_hash, created_at) values ($1, $2, $3)`
+	for _, hash := range recoveryCodeHashes {
+		_, err = tx.ExecContext(ctx, stmt, userID, hash, now)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (p *DBRepo) DisableTOTP(userID int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	tx, err := p.SqlConn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt := `update users set totp_secret = null, totp_enabled_at = null, totp_last_step = null, updated_at = $1
+		where id = $2`
+	_, err = tx.ExecContext(ctx, stmt, time.Now(), userID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `delete from recovery_codes where user_id = $1`, userID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// UseTOTPStep records step as the last accepted time step of the user. It
+// returns databases.ErrTOTPCodeUsed if step is not newer than the last one,
+// so a code cannot be replayed within its validity window.
+func (p *DBRepo) UseTOTPStep(userID int, step int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	stmt := `update users set totp_last_step = $1
+		where id = $2 and (totp_last_step is null or totp_last_step < $1)`
+	result, err := p.SqlConn.ExecContext(ctx, stmt, step, userID)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return databases.ErrTOTPCodeUsed
+	}
+	return nil
+}
+
+// UseRecoveryCode spends the unused recovery code matching codeHash. It
+// returns sql.ErrNoRows when there is none.
+func (p *DBRepo) UseRecoveryCode(userID int, codeHash string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	stmt := `update recovery_codes set used_at = $1
+		where user_id = $2 and code_hash = $3 and used_at is null`
+	result, err := p.SqlConn.ExecContext(ctx, stmt, time.Now(), userID, codeHash)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
@@ -0,0 +1,46 @@
+package dbrepo
+
+import (
+	"database/sql"
+	"testing"
+
+	databases "github.com/MinhNHHH/online-store/pkg/databases/repositories"
+	"github.com/MinhNHHH/online-store/pkg/databases/schema"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTwoFactor(t *testing.T) {
+	userID, err := testRepo.InsertUser(schema.User{
+		Name:     "Two Factor User",
+		Email:    "2fa@example.com",
+		Password: "secret",
+	})
+	assert.NoError(t, err)
+
+	assert.NoError(t, testRepo.SetTOTPSecret(userID, "JBSWY3DPEHPK3PXP"))
+	user, err := testRepo.GetUser(userID)
+	assert.NoError(t, err)
+	assert.Equal(t, "JBSWY3DPEHPK3PXP", user.TOTPSecret)
+	assert.Nil(t, user.TOTPEnabledAt)
+
+	assert.NoError(t, testRepo.EnableTOTP(userID, []string{"code-1", "code-2"}))
+	user, err = testRepo.GetUser(userID)
+	assert.NoError(t, err)
+	assert.NotNil(t, user.TOTPEnabledAt)
+
+	assert.NoError(t, testRepo.UseTOTPStep(userID, 100))
+	assert.ErrorIs(t, testRepo.UseTOTPStep(userID, 100), databases.ErrTOTPCodeUsed)
+	assert.ErrorIs(t, testRepo.UseTOTPStep(userID, 99), databases.ErrTOTPCodeUsed)
+	assert.NoError(t, testRepo.UseTOTPStep(userID, 101))
+
+	assert.NoError(t, testRepo.UseRecoveryCode(userID, "code-1"))
+	assert.ErrorIs(t, testRepo.UseRecoveryCode(userID, "code-1"), sql.ErrNoRows)
+	assert.ErrorIs(t, testRepo.UseRecoveryCode(userID, "unknown"), sql.ErrNoRows)
+
+	assert.NoError(t, testRepo.DisableTOTP(userID))
+	user, err = testRepo.GetUser(userID)
+	assert.NoError(t, err)
+	assert.Empty(t, user.TOTPSecret)
+	assert.Nil(t, user.TOTPEnabledAt)
+	assert.ErrorIs(t, testRepo.UseRecoveryCode(userID, "code-2"), sql.ErrNoRows)
+}
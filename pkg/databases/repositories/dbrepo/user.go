@@ -2,131 +2,161 @@ package dbrepo
 
 import (
 	"context"
+	"database/sql"
 	"fmt"
-	"log"
 	"time"
 
 	"github.com/MinhNHHH/online-store/pkg/databases/schema"
 	"golang.org/x/crypto/bcrypt"
 )
 
-func (p *DBRepo) AllUsers() ([]*schema.User, error) {
+// AllUsers returns a page of users, newest first, together with the number of
+// users matching search. A non-empty search matches part of the name or email.
+func (p *DBRepo) AllUsers(search string, page, pageSize int) ([]*schema.User, int, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
 	defer cancel()
 
-	query := `select id, email, first_name, last_name, password, is_admin, created_at, updated_at
-	from users order by last_name`
+	countQuery := `select count(*) from users u where 1=1`
+	query := `select ` + userColumns + ` from users u where 1=1`
 
-	rows, err := p.SqlConn.QueryContext(ctx, query)
+	args := []interface{}{}
+	argCount := 1
+
+	if search != "" {
+		filter := fmt.Sprintf(" and (u.name ILIKE $%d or u.email ILIKE $%d)", argCount, argCount)
+		query += filter
+		countQuery += filter
+		args = append(args, "%"+search+"%")
+		argCount++
+	}
+
+	var total int
+	err := p.SqlConn.QueryRowContext(ctx, countQuery, args...).Scan(&total)
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
-	defer rows.Close()
 
-	var users []*schema.User
+	offset := (page - 1) * pageSize
+	query += fmt.Sprintf(" order by u.created_at desc, u.id desc LIMIT $%d OFFSET $%d", argCount, argCount+1)
+	args = append(args, pageSize, offset)
+
+	rows, err := p.SqlConn.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
 
+	users := []*schema.User{}
 	for rows.Next() {
-		var user schema.User
-		err := rows.Scan(
-			&user.ID,
-			&user.Email,
-			&user.Name,
-			&user.Password,
-			&user.IsAdmin,
-			&user.CreatedAt,
-			&user.UpdatedAt,
-		)
+		user, err := scanUser(rows)
 		if err != nil {
-			log.Println("Error scanning", err)
-			return nil, err
+			return nil, 0, err
 		}
-
-		users = append(users, &user)
+		users = append(users, user)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
 	}
 
-	return users, nil
+	return users, total, nil
 }
 
-func (p *DBRepo) GetUser(id int) (*schema.User, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
-	defer cancel()
+// userColumns is the column list scanned by scanUser.
+const userColumns = `u.id, u.email, u.name, u.password, u.is_admin, u.email_verified_at,
+	u.totp_secret, u.totp_enabled_at, u.deactivated_at, u.deletion_scheduled_at, u.deletion_mode,
+	u.session_generation, u.created_at, u.updated_at`
 
-	query := `
-		select 
-			u.id, u.email, u.name, u.password, u.is_admin, u.created_at, u.updated_at
-		from 
-			users u
-		where
-		    u.id = $1`
+type rowScanner interface {
+	Scan(dest ...any) error
+}
 
+func scanUser(row rowScanner) (*schema.User, error) {
 	var user schema.User
-	row := p.SqlConn.QueryRowContext(ctx, query, id)
-
+	var emailVerifiedAt, totpEnabledAt, deactivatedAt, deletionScheduledAt sql.NullTime
+	var totpSecret, deletionMode sql.NullString
 	err := row.Scan(
 		&user.ID,
 		&user.Email,
 		&user.Name,
 		&user.Password,
 		&user.IsAdmin,
+		&emailVerifiedAt,
+		&totpSecret,
+		&totpEnabledAt,
+		&deactivatedAt,
+		&deletionScheduledAt,
+		&deletionMode,
+		&user.SessionGeneration,
 		&user.CreatedAt,
 		&user.UpdatedAt,
 	)
-
 	if err != nil {
 		return nil, err
 	}
-
+	if emailVerifiedAt.Valid {
+		user.EmailVerifiedAt = &emailVerifiedAt.Time
+	}
+	user.TOTPSecret = totpSecret.String
+	if totpEnabledAt.Valid {
+		user.TOTPEnabledAt = &totpEnabledAt.Time
+	}
+	if deactivatedAt.Valid {
+		user.DeactivatedAt = &deactivatedAt.Time
+	}
+	if deletionScheduledAt.Valid {
+		user.DeletionScheduledAt = &deletionScheduledAt.Time
+	}
+	user.DeletionMode = deletionMode.String
 	return &user, nil
 }
 
-func (p *DBRepo) GetUserByEmail(email string) (*schema.User, error) {
+func (p *DBRepo) GetUser(id int) (*schema.User, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
 	defer cancel()
 
 	query := `
 		select 
-			u.id, u.email, u.name, u.password, u.is_admin, u.created_at, u.updated_at
+			` + userColumns + `
 		from 
 			users u
 		where
-		    u.email = $1`
+		    u.id = $1`
 
-	var user schema.User
-	row := p.SqlConn.QueryRowContext(ctx, query, email)
+	return scanUser(p.SqlConn.QueryRowContext(ctx, query, id))
+}
 
-	err := row.Scan(
-		&user.ID,
-		&user.Email,
-		&user.Name,
-		&user.Password,
-		&user.IsAdmin,
-		&user.CreatedAt,
-		&user.UpdatedAt,
-	)
+func (p *DBRepo) GetUserByEmail(email string) (*schema.User, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
 
-	if err != nil {
-		return nil, err
-	}
+	query := `
+		select 
+			` + userColumns + `
+		from 
+			users u
+		where
+		    u.email = $1`
 
-	return &user, nil
+	return scanUser(p.SqlConn.QueryRowContext(ctx, query, email))
 }
 
+// UpdateUser saves the email and name of u. A changed email address is no
+// longer verified. Admin rights are only changed by SetUserAdmin.
 func (p *DBRepo) UpdateUser(u schema.User) error {
 	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
 	defer cancel()
 
 	stmt := `update users set
+		email_verified_at = case when email = $1 then email_verified_at end,
 		email = $1,
 		name = $2,
-		is_admin = $4,
-		updated_at = $5
-		where id = $6
+		updated_at = $3
+		where id = $4
 	`
 
 	_, err := p.SqlConn.ExecContext(ctx, stmt,
 		u.Email,
 		u.Name,
-		u.IsAdmin,
 		time.Now(),
 		u.ID,
 	)
@@ -138,18 +168,156 @@ func (p *DBRepo) UpdateUser(u schema.User) error {
 	return nil
 }
 
+// SetUserAdmin grants or takes away admin rights. It returns sql.ErrNoRows
+// when there is no such user.
+func (p *DBRepo) SetUserAdmin(id int, isAdmin bool) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	stmt := `update users set is_admin = $1, updated_at = $2 where id = $3`
+	return execAffectingRow(ctx, p.SqlConn, stmt, isAdmin, time.Now(), id)
+}
+
+// SetUserDeactivated deactivates or reactivates an account. Deactivating an
+// account that already is keeps the original time. It returns sql.ErrNoRows
+// when there is no such user.
+func (p *DBRepo) SetUserDeactivated(id int, deactivated bool) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	now := time.Now()
+	stmt := `update users set deactivated_at = null, updated_at = $1 where id = $2`
+	if deactivated {
+		stmt = `update users set deactivated_at = coalesce(deactivated_at, $1), updated_at = $1 where id = $2`
+	}
+	return execAffectingRow(ctx, p.SqlConn, stmt, now, id)
+}
+
+// RevokeUserSessions ends every cookie session of the user by moving it to a
+// new session generation, which it returns.
+func (p *DBRepo) RevokeUserSessions(id int) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	stmt := `update users set session_generation = session_generation + 1 where id = $1 returning session_generation`
+	var generation int
+	err := p.SqlConn.QueryRowContext(ctx, stmt, id).Scan(&generation)
+	return generation, err
+}
+
+// execAffectingRow runs stmt and returns sql.ErrNoRows when it changed no
+// rows.
+func execAffectingRow(ctx context.Context, db interface {
+	ExecContext(context.Context, string, ...any) (sql.Result, error)
+}, stmt string, args ...any) error {
+	result, err := db.ExecContext(ctx, stmt, args...)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// DeleteUser deletes the user right away, together with their reviews.
 func (p *DBRepo) DeleteUser(id int) error {
 	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
 	defer cancel()
 
-	stmt := `delete from users where id = $1`
+	tx, err := p.SqlConn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
 
-	_, err := p.SqlConn.ExecContext(ctx, stmt, id)
+	// reviews outlive their author unless deleted first
+	_, err = tx.ExecContext(ctx, `delete from reviews where user_id = $1`, id)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `delete from users where id = $1`, id)
 	if err != nil {
 		return err
 	}
 
-	return nil
+	return tx.Commit()
+}
+
+// ScheduleUserDeletion marks the user to be purged at the given time, with
+// mode deciding whether their reviews are deleted or anonymized.
+func (p *DBRepo) ScheduleUserDeletion(id int, mode string, at time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	stmt := `update users set deletion_scheduled_at = $1, deletion_mode = $2, updated_at = $3 where id = $4`
+	return execAffectingRow(ctx, p.SqlConn, stmt, at, mode, time.Now(), id)
+}
+
+func (p *DBRepo) CancelUserDeletion(id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	stmt := `update users set deletion_scheduled_at = null, deletion_mode = null, updated_at = $1
+		where id = $2 and deletion_scheduled_at is not null`
+	return execAffectingRow(ctx, p.SqlConn, stmt, time.Now(), id)
+}
+
+// PurgeDueUsers deletes every user whose deletion was scheduled at or before
+// now and returns their IDs. Their reviews are deleted or, in anonymize mode,
+// kept without an author. Their auth events are kept for the audit log, with
+// the email, IP and user agent blanked.
+func (p *DBRepo) PurgeDueUsers(now time.Time) ([]int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	tx, err := p.SqlConn.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	stmt := `delete from reviews where user_id in (
+			select id from users where deletion_scheduled_at <= $1 and deletion_mode = $2
+		)`
+	_, err = tx.ExecContext(ctx, stmt, now, schema.DeletionModeDelete)
+	if err != nil {
+		return nil, err
+	}
+
+	stmt = `update auth_events set email = null, ip = null, user_agent = null
+		where user_id in (select id from users where deletion_scheduled_at <= $1)
+			or lower(email) in (select lower(email) from users where deletion_scheduled_at <= $1)`
+	_, err = tx.ExecContext(ctx, stmt, now)
+	if err != nil {
+		return nil, err
+	}
+
+	// deleting the user sets user_id of the remaining reviews to null
+	rows, err := tx.QueryContext(ctx, `delete from users where deletion_scheduled_at <= $1 returning id`, now)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := []int{}
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	return ids, tx.Commit()
 }
 
 func (p *DBRepo) InsertUser(user schema.User) (int, error) {
@@ -162,14 +330,13 @@ func (p *DBRepo) InsertUser(user schema.User) (int, error) {
 	}
 
 	var newID int
-	stmt := `insert into users (email, name, password, is_admin, created_at, updated_at)
-		values ($1, $2, $3, $4, $5, $6) returning id`
+	stmt := `insert into users (email, name, password, created_at, updated_at)
+		values ($1, $2, $3, $4, $5) returning id`
 
 	rows, err := p.SqlConn.QueryContext(ctx, stmt,
 		user.Email,
 		user.Name,
 		hashedPassword,
-		user.IsAdmin,
 		time.Now(),
 		time.Now(),
 	)
@@ -0,0 +1,93 @@
+package dbrepo
+
+import (
+	"context"
+	"time"
+
+	"github.com/MinhNHHH/online-store/pkg/databases/schema"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// GetUserByIdentity returns the user linked to subject at provider.
+func (p *DBRepo) GetUserByIdentity(provider, subject string) (*schema.User, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `
+		select
+			` + userColumns + `
+		from
+			users u
+			join user_identities i on i.user_id = u.id
+		where
+			i.provider = $1 and i.subject = $2`
+
+	return scanUser(p.SqlConn.QueryRowContext(ctx, query, provider, subject))
+}
+
+func (p *DBRepo) InsertUserIdentity(identity *schema.UserIdentity) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	stmt := `insert into user_identities (user_id, provider, subject, email, created_at)
+		values ($1, $2, $3, $4, $5)`
+
+	_, err := p.SqlConn.ExecContext(ctx, stmt,
+		identity.UserID,
+		identity.Provider,
+		identity.Subject,
+		identity.Email,
+		time.Now(),
+	)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// InsertUserWithIdentity creates user and links identity to it in one
+// transaction, so a failed link leaves no account behind.
+func (p *DBRepo) InsertUserWithIdentity(user schema.User, identity *schema.UserIdentity) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(user.Password), 12)
+	if err != nil {
+		return 0, err
+	}
+
+	tx, err := p.SqlConn.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var newID int
+	stmt := `insert into users (email, name, password, email_verified_at, created_at, updated_at)
+		values ($1, $2, $3, $4, $5, $6) returning id`
+
+	err = tx.QueryRowContext(ctx, stmt,
+		user.Email,
+		user.Name,
+		hashedPassword,
+		user.EmailVerifiedAt,
+		time.Now(),
+		time.Now(),
+	).Scan(&newID)
+	if err != nil {
+		return 0, err
+	}
+
+	stmt = `insert into user_identities (user_id, provider, subject, email, created_at)
+		values ($1, $2, $3, $4, $5)`
+
+	_, err = tx.ExecContext(ctx, stmt, newID, identity.Provider, identity.Subject, identity.Email, time.Now())
+	if err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return newID, nil
+}
@@ -0,0 +1,73 @@
+package dbrepo
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/MinhNHHH/online-store/pkg/databases/schema"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUserIdentities(t *testing.T) {
+	userID, err := testRepo.InsertUser(schema.User{
+		Name:     "Linked User",
+		Email:    "linked@example.com",
+		Password: "secret",
+	})
+	assert.NoError(t, err)
+
+	_, err = testRepo.GetUserByIdentity("google", "linked-subject")
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+
+	assert.NoError(t, testRepo.InsertUserIdentity(&schema.UserIdentity{
+		UserID:   userID,
+		Provider: "google",
+		Subject:  "linked-subject",
+		Email:    "linked@example.com",
+	}))
+	user, err := testRepo.GetUserByIdentity("google", "linked-subject")
+	assert.NoError(t, err)
+	assert.Equal(t, userID, user.ID)
+
+	// the same subject at another provider is another identity
+	_, err = testRepo.GetUserByIdentity("github", "linked-subject")
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+
+	// a subject can only be linked once
+	assert.Error(t, testRepo.InsertUserIdentity(&schema.UserIdentity{
+		UserID:   userID,
+		Provider: "google",
+		Subject:  "linked-subject",
+	}))
+
+	verifiedAt := time.Now()
+	newID, err := testRepo.InsertUserWithIdentity(schema.User{
+		Name:            "Social User",
+		Email:           "social@example.com",
+		Password:        "random",
+		EmailVerifiedAt: &verifiedAt,
+	}, &schema.UserIdentity{
+		Provider: "google",
+		Subject:  "social-subject",
+		Email:    "social@example.com",
+	})
+	assert.NoError(t, err)
+	user, err = testRepo.GetUserByIdentity("google", "social-subject")
+	assert.NoError(t, err)
+	assert.Equal(t, newID, user.ID)
+	assert.NotNil(t, user.EmailVerifiedAt)
+
+	// a failed link does not leave the user behind
+	_, err = testRepo.InsertUserWithIdentity(schema.User{
+		Name:     "Duplicate",
+		Email:    "duplicate@example.com",
+		Password: "random",
+	}, &schema.UserIdentity{
+		Provider: "google",
+		Subject:  "social-subject",
+	})
+	assert.Error(t, err)
+	_, err = testRepo.GetUserByEmail("duplicate@example.com")
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+}
@@ -0,0 +1,209 @@
+package dbrepo
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/MinhNHHH/online-store/pkg/databases/schema"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUpdateUser(t *testing.T) {
+	userID, err := testRepo.InsertUser(schema.User{
+		Name:     "Update User",
+		Email:    "update@example.com",
+		Password: "secret",
+	})
+	assert.NoError(t, err)
+
+	assert.NoError(t, testRepo.InsertEmailVerificationToken(userID, "update@example.com", "update-hash", time.Now().Add(time.Hour)))
+	_, err = testRepo.VerifyEmailWithToken("update-hash")
+	assert.NoError(t, err)
+
+	user, err := testRepo.GetUser(userID)
+	assert.NoError(t, err)
+	user.Name = "Renamed User"
+	assert.NoError(t, testRepo.UpdateUser(*user))
+
+	user, err = testRepo.GetUser(userID)
+	assert.NoError(t, err)
+	assert.Equal(t, "Renamed User", user.Name)
+	assert.NotNil(t, user.EmailVerifiedAt, "keeping the email keeps it verified")
+
+	user.Email = "updated@example.com"
+	assert.NoError(t, testRepo.UpdateUser(*user))
+
+	user, err = testRepo.GetUser(userID)
+	assert.NoError(t, err)
+	assert.Equal(t, "updated@example.com", user.Email)
+	assert.Nil(t, user.EmailVerifiedAt, "a new email has to be verified again")
+	assert.False(t, user.IsAdmin)
+
+	// a copy read before a change of admin rights does not undo it
+	stale := *user
+	assert.NoError(t, testRepo.SetUserAdmin(userID, true))
+	stale.IsAdmin = false
+	assert.NoError(t, testRepo.UpdateUser(stale))
+	user, err = testRepo.GetUser(userID)
+	assert.NoError(t, err)
+	assert.True(t, user.IsAdmin)
+}
+
+func TestDeleteUser(t *testing.T) {
+	userID, err := testRepo.InsertUser(schema.User{
+		Name:     "Delete User",
+		Email:    "delete@example.com",
+		Password: "secret",
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, testRepo.AddToWishlist(userID, 1))
+
+	assert.NoError(t, testRepo.DeleteUser(userID))
+
+	_, err = testRepo.GetUser(userID)
+	assert.Error(t, err)
+}
+
+func TestAllUsers(t *testing.T) {
+	users, total, err := testRepo.AllUsers("example.com", 1, 2)
+	assert.NoError(t, err)
+	assert.GreaterOrEqual(t, total, 3)
+	assert.Len(t, users, 2)
+
+	users, total, err = testRepo.AllUsers("Bob", 1, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, total)
+	assert.Equal(t, "bob@example.com", users[0].Email)
+}
+
+func TestSetUserAdminAndDeactivated(t *testing.T) {
+	userID, err := testRepo.InsertUser(schema.User{
+		Name:     "Managed User",
+		Email:    "managed@example.com",
+		Password: "secret",
+	})
+	assert.NoError(t, err)
+
+	assert.NoError(t, testRepo.SetUserAdmin(userID, true))
+	assert.NoError(t, testRepo.SetUserDeactivated(userID, true))
+
+	user, err := testRepo.GetUser(userID)
+	assert.NoError(t, err)
+	assert.True(t, user.IsAdmin)
+	assert.NotNil(t, user.DeactivatedAt)
+
+	assert.NoError(t, testRepo.SetUserDeactivated(userID, false))
+	user, err = testRepo.GetUser(userID)
+	assert.NoError(t, err)
+	assert.Nil(t, user.DeactivatedAt)
+
+	assert.ErrorIs(t, testRepo.SetUserAdmin(999999, true), sql.ErrNoRows)
+	assert.ErrorIs(t, testRepo.SetUserDeactivated(999999, true), sql.ErrNoRows)
+}
+
+func TestScheduleAndPurgeUserDeletion(t *testing.T) {
+	categoryID, err := testRepo.InsertCategory(&schema.Category{Name: "Erasure Category"})
+	assert.NoError(t, err)
+	productID, err := testRepo.InsertProduct(&schema.Product{
+		Name:          "Erasure Product",
+		Price:         10,
+		StockQuantity: 1,
+		Status:        "in_stock",
+		Categories:    []schema.CategoryRef{{ID: categoryID}},
+	})
+	assert.NoError(t, err)
+
+	var userIDs []int
+	for _, email := range []string{"erase-delete@example.com", "erase-anonymize@example.com", "erase-later@example.com"} {
+		userID, err := testRepo.InsertUser(schema.User{Name: "Erased", Email: email, Password: "secret"})
+		assert.NoError(t, err)
+		_, err = testRepo.InsertReview(&schema.Review{ProductID: productID, UserID: userID, Rating: 4, Comment: email})
+		assert.NoError(t, err)
+		userIDs = append(userIDs, userID)
+		assert.NoError(t, testRepo.InsertAuthEvent(&schema.AuthEvent{Type: "login", Outcome: "success", UserID: &userID, Email: email, IP: "192.0.2.7", UserAgent: "curl/8.0"}))
+	}
+	// failed logins name the account by email only
+	assert.NoError(t, testRepo.InsertAuthEvent(&schema.AuthEvent{Type: "login", Outcome: "failure", Email: "Erase-Delete@example.com", IP: "192.0.2.7"}))
+
+	now := time.Now()
+	assert.NoError(t, testRepo.ScheduleUserDeletion(userIDs[0], schema.DeletionModeDelete, now.Add(-time.Minute)))
+	assert.NoError(t, testRepo.ScheduleUserDeletion(userIDs[1], schema.DeletionModeAnonymize, now.Add(-time.Minute)))
+	assert.NoError(t, testRepo.ScheduleUserDeletion(userIDs[2], schema.DeletionModeDelete, now.Add(time.Hour)))
+	assert.ErrorIs(t, testRepo.ScheduleUserDeletion(999999, schema.DeletionModeDelete, now), sql.ErrNoRows)
+
+	user, err := testRepo.GetUser(userIDs[1])
+	assert.NoError(t, err)
+	assert.Equal(t, schema.DeletionModeAnonymize, user.DeletionMode)
+	assert.NotNil(t, user.DeletionScheduledAt)
+
+	purged, err := testRepo.PurgeDueUsers(now)
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, userIDs[:2], purged)
+
+	reviews, _, err := testRepo.ReviewsByProductID(productID, schema.Page{Size: 10})
+	assert.NoError(t, err)
+	assert.Len(t, reviews, 2, "the anonymized review and the one of the user not yet due are kept")
+
+	// the auth events of purged users are kept without personal data
+	for i, userID := range userIDs {
+		events, total, err := testRepo.AllAuthEvents(schema.AuthEventFilter{UserID: userID}, 1, 10)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, total)
+		if i < 2 {
+			assert.Empty(t, events[0].Email)
+			assert.Empty(t, events[0].IP)
+			assert.Empty(t, events[0].UserAgent)
+		} else {
+			assert.Equal(t, "erase-later@example.com", events[0].Email)
+		}
+	}
+	_, total, err := testRepo.AllAuthEvents(schema.AuthEventFilter{Email: "erase-delete@example.com"}, 1, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, total)
+
+	// logging in during the grace period cancels the deletion
+	assert.NoError(t, testRepo.CancelUserDeletion(userIDs[2]))
+	assert.ErrorIs(t, testRepo.CancelUserDeletion(userIDs[2]), sql.ErrNoRows)
+	user, err = testRepo.GetUser(userIDs[2])
+	assert.NoError(t, err)
+	assert.Nil(t, user.DeletionScheduledAt)
+}
+
+func TestInsertUserIsNotAdmin(t *testing.T) {
+	userID, err := testRepo.InsertUser(schema.User{
+		Name:     "Would Be Admin",
+		Email:    "would-be-admin@example.com",
+		Password: "secret",
+		IsAdmin:  true,
+	})
+	assert.NoError(t, err)
+
+	user, err := testRepo.GetUser(userID)
+	assert.NoError(t, err)
+	assert.False(t, user.IsAdmin, "admins are made with SetUserAdmin")
+}
+
+func TestRevokeUserSessions(t *testing.T) {
+	userID, err := testRepo.InsertUser(schema.User{
+		Name:     "Session User",
+		Email:    "session-user@example.com",
+		Password: "secret",
+	})
+	assert.NoError(t, err)
+
+	user, err := testRepo.GetUser(userID)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, user.SessionGeneration)
+
+	generation, err := testRepo.RevokeUserSessions(userID)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, generation)
+
+	user, err = testRepo.GetUser(userID)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, user.SessionGeneration)
+
+	_, err = testRepo.RevokeUserSessions(999999)
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+}
@@ -34,11 +34,10 @@ func (p *DBRepo) GetWishlist(userID int) ([]*schema.Product, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
 	defer cancel()
 
-	query := `select p.id, p.name, p.price, p.stock_quantity, p.status, c.name from products p
-		inner join product_categories pc on p.id = pc.product_id
-		inner join categories c on pc.category_id = c.id
+	query := `select p.id, p.slug, p.name, p.price, p.stock_quantity, p.status from products p
 		inner join wishlist w on p.id = w.product_id
-		where w.user_id = $1`
+		where w.user_id = $1
+		order by w.added_at desc`
 
 	rows, err := p.SqlConn.QueryContext(ctx, query, userID)
 	if err != nil {
@@ -46,14 +45,27 @@ func (p *DBRepo) GetWishlist(userID int) ([]*schema.Product, error) {
 	}
 	defer rows.Close()
 
-	var products []*schema.Product
+	products := []*schema.Product{}
 	for rows.Next() {
 		var product schema.Product
-		err := rows.Scan(&product.ID, &product.Name, &product.Price, &product.StockQuantity, &product.Status, &product.CategoryName)
+		err := rows.Scan(&product.ID, &product.Slug, &product.Name, &product.Price, &product.StockQuantity, &product.Status)
 		if err != nil {
 			return nil, err
 		}
 		products = append(products, &product)
 	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if err := p.loadProductCategories(ctx, products); err != nil {
+		return nil, err
+	}
+	if err := p.loadProductVariants(ctx, products); err != nil {
+		return nil, err
+	}
+	if err := p.loadProductImages(ctx, products); err != nil {
+		return nil, err
+	}
 	return products, nil
 }
@@ -0,0 +1,39 @@
+package databases
+
+import "errors"
+
+// ErrRefreshTokenRotated is returned when a refresh token has already been
+// exchanged for a new one, which means it is being replayed.
+var ErrRefreshTokenRotated = errors.New("refresh token already rotated")
+
+// ErrTOTPCodeUsed is returned when a one-time code for a time step at or
+// before the last accepted one is presented again.
+var ErrTOTPCodeUsed = errors.New("totp code already used")
+
+// ErrDuplicateSKU is returned when a variant is saved with a SKU another
+// variant already has.
+var ErrDuplicateSKU = errors.New("sku already in use")
+
+// ErrDuplicateVariant is returned when a variant is saved with the same
+// options as another variant of its product.
+var ErrDuplicateVariant = errors.New("variant with these options already exists")
+
+// ErrImageOrder is returned when a new order of a product's images does not
+// list each of them exactly once.
+var ErrImageOrder = errors.New("image order must list each image of the product once")
+
+// ErrInvalidCursor is returned when a page cursor was taken in another order
+// than the list is sorted in, or does not hold a position in it.
+var ErrInvalidCursor = errors.New("invalid page cursor")
+
+// ErrSlugTaken is returned when a slug is set that another product or
+// category has, or had.
+var ErrSlugTaken = errors.New("slug already in use")
+
+// ErrParentCategoryNotFound is returned when a category is put under a
+// category that does not exist.
+var ErrParentCategoryNotFound = errors.New("parent category not found")
+
+// ErrCategoryCycle is returned when a category is moved under itself or one
+// of its descendants.
+var ErrCategoryCycle = errors.New("category cannot be moved under itself or its descendants")
@@ -1,6 +1,9 @@
 package schema
 
-import "time"
+import (
+	"encoding/json"
+	"time"
+)
 
 type User struct {
 	ID        int       `json:"id,omitempty"`
@@ -10,25 +13,199 @@ type User struct {
 	IsAdmin   bool      `json:"is_admin,omitempty"`
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
+
+	EmailVerifiedAt *time.Time `json:"email_verified_at,omitempty"`
+	// TOTPSecret is set once enrollment starts; two-factor authentication is
+	// only on after TOTPEnabledAt is set by confirming a first code.
+	TOTPSecret    string     `json:"-"`
+	TOTPEnabledAt *time.Time `json:"totp_enabled_at,omitempty"`
+	// DeactivatedAt is set while an admin has deactivated the account.
+	DeactivatedAt *time.Time `json:"deactivated_at,omitempty"`
+	// DeletionScheduledAt is when an account whose owner asked for it to be
+	// deleted will be purged. DeletionMode says what happens to its reviews.
+	DeletionScheduledAt *time.Time `json:"deletion_scheduled_at,omitempty"`
+	DeletionMode        string     `json:"deletion_mode,omitempty"`
+	// SessionGeneration goes up whenever every cookie session of the user
+	// is ended; sessions started under an older generation are refused.
+	SessionGeneration int `json:"-"`
 }
 
+// Deletion modes: the reviews of a purged account are either deleted with it
+// or kept without an author.
+const (
+	DeletionModeDelete    = "delete"
+	DeletionModeAnonymize = "anonymize"
+)
+
 type Product struct {
-	ID            int     `json:"id"`
+	ID int `json:"id"`
+	// Slug names the product in URLs. It is made from the name when the
+	// product is created, and kept when the name changes. Updates with a slug
+	// change it; the old one keeps leading to the product.
+	Slug          string  `json:"slug"`
 	Name          string  `json:"name"`
 	Description   string  `json:"description"`
 	Price         float64 `json:"price"`
 	StockQuantity int     `json:"stock_quantity"`
 	Status        string  `json:"status,omitempty"`
-	CategoryID    int     `json:"category_id,omitempty"`
-	CategoryName  string  `json:"category_name,omitempty"`
+	// Categories are written as a list of IDs and read with their names.
+	Categories []CategoryRef `json:"categories"`
+	// Breadcrumbs are read-only: for each of the categories, the trail from
+	// the top of the tree down to it.
+	Breadcrumbs [][]CategoryRef `json:"breadcrumbs"`
+	// Options are the axes variants are defined by, such as size and color.
+	Options []string `json:"options"`
+	// Variants are read-only here. A product with variants has its stock and
+	// status rolled up from theirs.
+	Variants []ProductVariant `json:"variants"`
+	// Images are in display order.
+	Images []ProductImage `json:"images"`
+	// AverageRating is nil until the product has reviews.
+	AverageRating *float64 `json:"average_rating"`
+	ReviewCount   int      `json:"review_count"`
+	// RatingHistogram counts reviews by their rating, from 1 to 5 stars. Only
+	// product details have it.
+	RatingHistogram map[int]int `json:"rating_histogram,omitempty"`
+	CreatedAt       time.Time   `json:"created_at"`
+	UpdatedAt       time.Time   `json:"updated_at"`
+	// Snippet is only set by searches: the best matching part of the name and
+	// description, HTML escaped, with the matches in <mark> tags.
+	Snippet string `json:"snippet,omitempty"`
+}
+
+// ProductFilter selects products. Zero fields match everything.
+type ProductFilter struct {
+	// Name and CategoryName match any part of a name.
+	Name         string
+	CategoryName string
+	Status       string
+	// Query searches the words, or word prefixes, of names, descriptions and
+	// category names.
+	Query string
+	// CategoryIDs and CategorySlugs match products in any of the categories.
+	CategoryIDs   []int
+	CategorySlugs []string
+	MinPrice      *float64
+	MaxPrice      *float64
+	// MinRating is the lowest average rating; products without reviews have
+	// none.
+	MinRating *float64
+	// InStock only matches products in stock with stock left.
+	InStock bool
+	// Sort is one of the ProductSort constants; the zero value sorts by
+	// relevance when there is a Query, by newest otherwise.
+	Sort       string
+	Descending bool
+}
+
+// Orders products can be sorted in.
+const (
+	ProductSortRelevance = "relevance"
+	ProductSortNewest    = "newest"
+	ProductSortPrice     = "price"
+	ProductSortName      = "name"
+	ProductSortRating    = "rating"
+)
+
+// ProductFacets count the products matching a filter in each category, in
+// each status and in each price range.
+type ProductFacets struct {
+	Categories []CategoryFacet `json:"categories"`
+	Statuses   []StatusFacet   `json:"statuses"`
+	Prices     []PriceFacet    `json:"prices"`
+}
+
+type CategoryFacet struct {
+	ID    int    `json:"id"`
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+type StatusFacet struct {
+	Status string `json:"status"`
+	Count  int    `json:"count"`
+}
+
+// PriceFacet counts prices from Min up to, but not including, Max. The last
+// range has no Max.
+type PriceFacet struct {
+	Min   float64  `json:"min"`
+	Max   *float64 `json:"max"`
+	Count int      `json:"count"`
+}
+
+// Product and variant statuses.
+const (
+	ProductStatusInStock    = "in_stock"
+	ProductStatusOutOfStock = "out_of_stock"
+	ProductStatusDraft      = "draft"
+)
+
+// ProductVariant is one combination of a product's options, sold under its
+// own SKU.
+type ProductVariant struct {
+	ID        int    `json:"id"`
+	ProductID int    `json:"product_id"`
+	SKU       string `json:"sku"`
+	// Options holds a value for each of the product's options.
+	Options map[string]string `json:"options"`
+	// Price overrides the product's price when set.
+	Price         *float64 `json:"price"`
+	StockQuantity int      `json:"stock_quantity"`
+	Status        string   `json:"status"`
+}
+
+// ProductImage is a picture of a product. Its files are kept in storage under
+// Key and ThumbnailKeys, and URL and Thumbnails are filled in from those when
+// it is sent.
+type ProductImage struct {
+	ID          int    `json:"id"`
+	ProductID   int    `json:"product_id"`
+	Key         string `json:"-"`
+	URL         string `json:"url"`
+	ContentType string `json:"content_type"`
+	Width       int    `json:"width"`
+	Height      int    `json:"height"`
+	Size        int64  `json:"size"`
+	AltText     string `json:"alt_text"`
+	Position    int    `json:"position"`
+	// ThumbnailKeys and Thumbnails are by the length of the thumbnail's
+	// longest side, such as "160".
+	ThumbnailKeys map[string]string `json:"-"`
+	Thumbnails    map[string]string `json:"thumbnails"`
+}
+
+// CategoryRef is a category as listed on a product.
+type CategoryRef struct {
+	ID   int    `json:"id"`
+	Slug string `json:"slug,omitempty"`
+	Name string `json:"name,omitempty"`
+}
+
+// UnmarshalJSON accepts a bare category ID as well as an object, so products
+// can be written with "categories": [1, 2].
+func (c *CategoryRef) UnmarshalJSON(data []byte) error {
+	if err := json.Unmarshal(data, &c.ID); err == nil {
+		return nil
+	}
+	type categoryRef CategoryRef
+	return json.Unmarshal(data, (*categoryRef)(c))
 }
 
 type Category struct {
-	ID          int       `json:"id,omitempty"`
-	Name        string    `json:"name"`
-	Description string    `json:"description"`
-	CreatedAt   time.Time `json:"created_at,omitempty"`
-	UpdatedAt   time.Time `json:"updated_at,omitempty"`
+	ID int `json:"id,omitempty"`
+	// Slug names the category in URLs. It is made from the name when the
+	// category is created, and kept when the name changes.
+	Slug        string `json:"slug"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	// ParentID is the category this one is nested in, nil at the top of the
+	// tree. It is set on create; later the category is moved.
+	ParentID *int `json:"parent_id"`
+	// Children are only filled in on the category tree.
+	Children  []*Category `json:"children,omitempty"`
+	CreatedAt time.Time   `json:"created_at,omitempty"`
+	UpdatedAt time.Time   `json:"updated_at,omitempty"`
 }
 
 type ProductCategory struct {
@@ -47,3 +224,121 @@ type Review struct {
 	CreatedAt   time.Time `json:"created_at"`
 	UpdatedAt   time.Time `json:"updated_at"`
 }
+
+type RefreshToken struct {
+	JTI        string     `json:"jti"`
+	UserID     int        `json:"user_id"`
+	FamilyID   string     `json:"family_id"`
+	ReplacedBy string     `json:"replaced_by,omitempty"`
+	MFA        bool       `json:"mfa"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// LoginThrottle counts recent failed logins for one account (keyed by email)
+// or one client IP.
+type LoginThrottle struct {
+	Scope         string     `json:"scope"`
+	Key           string     `json:"key"`
+	Failures      int        `json:"failures"`
+	LastFailureAt *time.Time `json:"last_failure_at,omitempty"`
+	LockedUntil   *time.Time `json:"locked_until,omitempty"`
+}
+
+type Lockout struct {
+	ID          int        `json:"id"`
+	Scope       string     `json:"scope"`
+	Key         string     `json:"key"`
+	UserID      *int       `json:"user_id,omitempty"`
+	IP          string     `json:"ip,omitempty"`
+	Failures    int        `json:"failures"`
+	LockedUntil time.Time  `json:"locked_until"`
+	UnlockedAt  *time.Time `json:"unlocked_at,omitempty"`
+	UnlockedBy  *int       `json:"unlocked_by,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+}
+
+// AuthEvent is an entry in the security audit log: a login, a token refresh,
+// an account change or an admin action. UserID is the account concerned and
+// ActorID whoever acted on it, when known.
+type AuthEvent struct {
+	ID        int       `json:"id"`
+	Type      string    `json:"type"`
+	Outcome   string    `json:"outcome"`
+	Reason    string    `json:"reason,omitempty"`
+	UserID    *int      `json:"user_id,omitempty"`
+	ActorID   *int      `json:"actor_id,omitempty"`
+	Email     string    `json:"email,omitempty"`
+	IP        string    `json:"ip,omitempty"`
+	UserAgent string    `json:"user_agent,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// AuthEventFilter selects audit log entries. Zero fields match everything.
+type AuthEventFilter struct {
+	Type    string
+	Outcome string
+	UserID  int
+	Email   string
+	IP      string
+	Since   *time.Time
+	Until   *time.Time
+}
+
+// UserIdentity links a user to their account at an OpenID Connect provider.
+type UserIdentity struct {
+	ID        int       `json:"id"`
+	UserID    int       `json:"user_id"`
+	Provider  string    `json:"provider"`
+	Subject   string    `json:"subject"`
+	Email     string    `json:"email"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// APIKey lets a machine client call the API without logging in. Only a hash
+// of the key is stored; Prefix is its start, kept so admins can tell keys apart.
+type APIKey struct {
+	ID         int        `json:"id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Scopes     []string   `json:"scopes"`
+	CreatedBy  *int       `json:"created_by,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// Page selects part of a sorted list. Lists are paged by cursor: Size rows
+// from the start, or after (or before) the row Cursor points at, without
+// counting the list. A Number asks for that page by offset instead, and has
+// the list counted, for tables that show page numbers.
+type Page struct {
+	Size   int
+	Number int
+	Cursor *Cursor
+}
+
+// Cursor points at a row of a sorted list by the values it is sorted by, so
+// paging from it is not thrown off by rows added or removed in between.
+type Cursor struct {
+	// Sort names the order the cursor was taken in; a cursor only works in
+	// that order.
+	Sort string `json:"s"`
+	// Key is the value of the row's sort column, as text.
+	Key string `json:"k"`
+	ID  int    `json:"i"`
+	// Before asks for the rows before this one rather than after it.
+	Before bool `json:"b,omitempty"`
+}
+
+// PageInfo tells where a page is in its list.
+type PageInfo struct {
+	// Total is the length of the list, only counted for numbered pages.
+	Total int
+	// Next and Prev point at the pages after and before this one, when there
+	// are any.
+	Next *Cursor
+	Prev *Cursor
+}
@@ -0,0 +1,57 @@
+package mailer
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"time"
+)
+
+var unsafeFileChars = regexp.MustCompile(`[^a-zA-Z0-9@._-]+`)
+
+// OutboxMailer keeps every message it is given instead of delivering it. When
+// Dir is set each message is also written there as an .eml file, which is
+// handy during local development.
+type OutboxMailer struct {
+	Dir  string
+	From string
+
+	mu   sync.Mutex
+	sent []Message
+}
+
+func NewOutboxMailer(dir, from string) *OutboxMailer {
+	return &OutboxMailer{Dir: dir, From: from}
+}
+
+func (m *OutboxMailer) Send(msg Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.sent = append(m.sent, msg)
+	if m.Dir == "" {
+		return nil
+	}
+
+	if err := os.MkdirAll(m.Dir, 0755); err != nil {
+		return fmt.Errorf("failed to create outbox directory: %w", err)
+	}
+	to := ""
+	if len(msg.To) > 0 {
+		to = unsafeFileChars.ReplaceAllString(msg.To[0], "_")
+	}
+	name := fmt.Sprintf("%s-%d-%s.eml", time.Now().Format("20060102150405"), len(m.sent), to)
+	return os.WriteFile(filepath.Join(m.Dir, name), format(m.From, msg), 0644)
+}
+
+// Sent returns a copy of the messages sent so far.
+func (m *OutboxMailer) Sent() []Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	sent := make([]Message, len(m.sent))
+	copy(sent, m.sent)
+	return sent
+}
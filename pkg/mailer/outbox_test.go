@@ -0,0 +1,34 @@
+package mailer
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestOutboxMailer_Send(t *testing.T) {
+	dir := t.TempDir()
+	m := NewOutboxMailer(dir, "no-reply@example.com")
+
+	err := m.Send(Message{To: []string{"alice@example.com"}, Subject: "Hello", Body: "Hi Alice"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(m.Sent()) != 1 {
+		t.Fatalf("expected 1 sent message, got %d", len(m.Sent()))
+	}
+
+	files, _ := filepath.Glob(filepath.Join(dir, "*.eml"))
+	if len(files) != 1 {
+		t.Fatalf("expected 1 .eml file, got %d", len(files))
+	}
+
+	content, _ := os.ReadFile(files[0])
+	for _, want := range []string{"From: no-reply@example.com", "To: alice@example.com", "Subject: Hello", "Hi Alice"} {
+		if !strings.Contains(string(content), want) {
+			t.Errorf("expected %q in message, got:\n%s", want, content)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package passwords
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+)
+
+// hashLen is the length of a SHA-1 hash in hex, and recordLen that of a line
+// in a breached list.
+const (
+	hashLen   = sha1.Size * 2
+	recordLen = hashLen + 1
+)
+
+// BreachedList is a file of SHA-1 hashes of breached passwords: upper case
+// hex, sorted, one per line. Every line has the same length, so a lookup is a
+// binary search reading one line per step rather than loading the file.
+type BreachedList struct {
+	file  *os.File
+	count int64
+}
+
+// OpenBreachedList opens a list written by WriteBreachedList.
+func OpenBreachedList(path string) (*BreachedList, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	if info.Size()%recordLen != 0 {
+		file.Close()
+		return nil, fmt.Errorf("%s is not a breached password list: its size is not a multiple of %d", path, recordLen)
+	}
+	return &BreachedList{file: file, count: info.Size() / recordLen}, nil
+}
+
+// Len is the number of hashes in the list.
+func (l *BreachedList) Len() int64 {
+	return l.count
+}
+
+// Contains reports whether password is in the list. It is safe to call from
+// several goroutines.
+func (l *BreachedList) Contains(password string) (bool, error) {
+	target := []byte(Hash(password))
+	record := make([]byte, hashLen)
+
+	lo, hi := int64(0), l.count
+	for lo < hi {
+		mid := lo + (hi-lo)/2
+		if _, err := l.file.ReadAt(record, mid*recordLen); err != nil {
+			return false, err
+		}
+		switch bytes.Compare(record, target) {
+		case 0:
+			return true, nil
+		case -1:
+			lo = mid + 1
+		default:
+			hi = mid
+		}
+	}
+	return false, nil
+}
+
+func (l *BreachedList) Close() error {
+	return l.file.Close()
+}
+
+// Hash is how passwords are stored in a breached list: the upper case hex
+// SHA-1, as in the Have I Been Pwned downloads.
+func Hash(password string) string {
+	sum := sha1.Sum([]byte(password))
+	return strings.ToUpper(hex.EncodeToString(sum[:]))
+}
+
+// WriteBreachedList writes hashes, as made by Hash, in the format
+// OpenBreachedList reads. They are sorted and duplicates are dropped.
+func WriteBreachedList(w io.Writer, hashes []string) error {
+	sorted := make([]string, 0, len(hashes))
+	for _, hash := range hashes {
+		hash = strings.ToUpper(hash)
+		if len(hash) != hashLen {
+			return fmt.Errorf("%q is not a SHA-1 hash", hash)
+		}
+		if _, err := hex.DecodeString(hash); err != nil {
+			return fmt.Errorf("%q is not a SHA-1 hash", hash)
+		}
+		sorted = append(sorted, hash)
+	}
+	slices.Sort(sorted)
+
+	for _, hash := range slices.Compact(sorted) {
+		if _, err := io.WriteString(w, hash+"\n"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
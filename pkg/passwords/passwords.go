@@ -0,0 +1,80 @@
+// Package passwords checks new passwords against a policy: a minimum length,
+// a maximum length in bytes and a list of breached passwords.
+package passwords
+
+import (
+	"fmt"
+	"unicode/utf8"
+)
+
+const (
+	// DefaultMinLength is used when a policy asks for no minimum.
+	DefaultMinLength = 8
+	// MaxBcryptBytes is the longest password bcrypt can hash in full. Longer
+	// ones would be truncated, so they are never allowed.
+	MaxBcryptBytes = 72
+)
+
+// Codes of the ways a password can break the policy.
+const (
+	CodeTooShort = "too_short"
+	CodeTooLong  = "too_long"
+	CodeBreached = "breached"
+)
+
+// Violation is one way a password breaks the policy.
+type Violation struct {
+	Code    string
+	Message string
+}
+
+type Policy struct {
+	// MinLength counts characters, not bytes.
+	MinLength int
+	MaxBytes  int
+	// Breached is optional; without it no password counts as breached.
+	Breached *BreachedList
+}
+
+// NewPolicy returns a policy with minLength and maxBytes brought into range:
+// at least one character, and no more bytes than bcrypt can hash.
+func NewPolicy(minLength, maxBytes int, breached *BreachedList) *Policy {
+	if minLength < 1 {
+		minLength = DefaultMinLength
+	}
+	if maxBytes < 1 || maxBytes > MaxBcryptBytes {
+		maxBytes = MaxBcryptBytes
+	}
+	return &Policy{MinLength: minLength, MaxBytes: maxBytes, Breached: breached}
+}
+
+// Check returns every way password breaks the policy. It only fails when the
+// breached list cannot be read.
+func (p *Policy) Check(password string) ([]Violation, error) {
+	var violations []Violation
+	if utf8.RuneCountInString(password) < p.MinLength {
+		violations = append(violations, Violation{
+			Code:    CodeTooShort,
+			Message: fmt.Sprintf("must be at least %d characters long", p.MinLength),
+		})
+	}
+	if len(password) > p.MaxBytes {
+		violations = append(violations, Violation{
+			Code:    CodeTooLong,
+			Message: fmt.Sprintf("must be at most %d bytes long", p.MaxBytes),
+		})
+	}
+	if p.Breached != nil && password != "" {
+		breached, err := p.Breached.Contains(password)
+		if err != nil {
+			return nil, err
+		}
+		if breached {
+			violations = append(violations, Violation{
+				Code:    CodeBreached,
+				Message: "appears in a list of breached or common passwords",
+			})
+		}
+	}
+	return violations, nil
+}
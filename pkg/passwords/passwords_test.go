@@ -0,0 +1,116 @@
+package passwords
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeTestList(t *testing.T, passwords ...string) *BreachedList {
+	t.Helper()
+	var hashes []string
+	for _, password := range passwords {
+		hashes = append(hashes, Hash(password))
+	}
+	path := filepath.Join(t.TempDir(), "breached.txt")
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteBreachedList(file, hashes); err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+
+	list, err := OpenBreachedList(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { list.Close() })
+	return list
+}
+
+func TestBreachedList(t *testing.T) {
+	list := writeTestList(t, "password", "letmein", "123456", "password", "correct horse")
+	if list.Len() != 4 {
+		t.Errorf("expected 4 hashes but got %d", list.Len())
+	}
+
+	var tests = []struct {
+		password string
+		expected bool
+	}{
+		{"password", true},
+		{"letmein", true},
+		{"123456", true},
+		{"correct horse", true},
+		{"Password", false},
+		{"battery staple", false},
+		{"", false},
+	}
+
+	for _, e := range tests {
+		found, err := list.Contains(e.password)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if found != e.expected {
+			t.Errorf("%q: expected %v but got %v", e.password, e.expected, found)
+		}
+	}
+}
+
+func TestOpenBreachedListRejectsOtherFiles(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "passwords.txt")
+	os.WriteFile(path, []byte("password\nletmein\n"), 0o600)
+	if _, err := OpenBreachedList(path); err == nil {
+		t.Error("expected an error for a plain password list")
+	}
+}
+
+func TestWriteBreachedListRejectsNonHashes(t *testing.T) {
+	var sb strings.Builder
+	if err := WriteBreachedList(&sb, []string{"password"}); err == nil {
+		t.Error("expected an error for a password that is not hashed")
+	}
+}
+
+func TestPolicyCheck(t *testing.T) {
+	policy := NewPolicy(10, 20, writeTestList(t, "password123"))
+
+	var tests = []struct {
+		name     string
+		password string
+		expected []string
+	}{
+		{"valid", "a long passphrase", nil},
+		{"empty", "", []string{CodeTooShort}},
+		{"too short", "short", []string{CodeTooShort}},
+		{"counts characters", "pässwörtçhën", nil},
+		{"too long", strings.Repeat("x", 21), []string{CodeTooLong}},
+		{"too long in bytes", strings.Repeat("ü", 11), []string{CodeTooLong}},
+		{"breached", "password123", []string{CodeBreached}},
+	}
+
+	for _, e := range tests {
+		violations, err := policy.Check(e.password)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", e.name, err)
+		}
+		var codes []string
+		for _, v := range violations {
+			codes = append(codes, v.Code)
+		}
+		if strings.Join(codes, ",") != strings.Join(e.expected, ",") {
+			t.Errorf("%s: expected %v but got %v", e.name, e.expected, codes)
+		}
+	}
+}
+
+func TestNewPolicyCapsMaxBytes(t *testing.T) {
+	policy := NewPolicy(0, 1000, nil)
+	if policy.MinLength != DefaultMinLength || policy.MaxBytes != MaxBcryptBytes {
+		t.Errorf("expected %d and %d but got %+v", DefaultMinLength, MaxBcryptBytes, policy)
+	}
+}
@@ -0,0 +1,73 @@
+// Package slug makes readable, URL-safe names like "red-wool-scarf" out of
+// titles.
+package slug
+
+import (
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// MaxLength bounds the length of a slug, which is cut at a hyphen where it
+// can be.
+const MaxLength = 80
+
+// letters spells the letters that do not come apart into a base letter and
+// accents.
+var letters = map[rune]string{
+	'đ': "d", 'ð': "d", 'ß': "ss", 'æ': "ae", 'œ': "oe", 'ø': "o",
+	'ł': "l", 'þ': "th", 'ı': "i",
+}
+
+// Make turns name into a slug: lower-case ASCII letters and digits, with
+// single hyphens between words. Accents are dropped, so "Áo sơ mi đỏ" becomes
+// "ao-so-mi-do"; other scripts are dropped altogether. A slug made of digits
+// alone could be taken for an ID, so prefix is put in front of those, and is
+// the slug when nothing is left of name.
+func Make(name, prefix string) string {
+	var b strings.Builder
+	hyphen := false
+	write := func(s string) {
+		if hyphen && b.Len() > 0 {
+			b.WriteByte('-')
+		}
+		hyphen = false
+		b.WriteString(s)
+	}
+	for _, r := range norm.NFKD.String(strings.ToLower(name)) {
+		switch {
+		case r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)):
+			write(string(r))
+		case letters[r] != "":
+			write(letters[r])
+		case unicode.Is(unicode.Mn, r):
+			// accents of the letter before
+		default:
+			hyphen = true
+		}
+	}
+	slug := cut(b.String(), MaxLength)
+
+	if strings.Trim(slug, "0123456789") == "" {
+		slug = strings.TrimSuffix(prefix+"-"+slug, "-")
+	}
+	return cut(slug, MaxLength)
+}
+
+func cut(slug string, length int) string {
+	if len(slug) <= length {
+		return slug
+	}
+	slug = slug[:length]
+	if i := strings.LastIndexByte(slug, '-'); i > length/2 {
+		slug = slug[:i]
+	}
+	return strings.TrimSuffix(slug, "-")
+}
+
+// Valid reports whether s is a slug as Make makes them, and so can be set by
+// hand.
+func Valid(s string) bool {
+	return s != "" && Make(s, "") == s
+}
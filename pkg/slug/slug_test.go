@@ -0,0 +1,49 @@
+package slug
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMake(t *testing.T) {
+	var tests = []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{"words", "Red Wool Scarf", "red-wool-scarf"},
+		{"punctuation", "  T-Shirt (XL) -- 100% cotton! ", "t-shirt-xl-100-cotton"},
+		{"accents", "Crème Brûlée", "creme-brulee"},
+		{"vietnamese", "Áo sơ mi đỏ", "ao-so-mi-do"},
+		{"letters without accents", "Straße Smørrebrød", "strasse-smorrebrod"},
+		{"other scripts", "Кружка mug 杯子", "mug"},
+		{"digits only", "2024", "product-2024"},
+		{"nothing left", "!!!", "product"},
+	}
+
+	for _, e := range tests {
+		if got := Make(e.input, "product"); got != e.expected {
+			t.Errorf("%s: expected %q but got %q", e.name, e.expected, got)
+		}
+	}
+}
+
+func TestMakeLong(t *testing.T) {
+	got := Make(strings.Repeat("scarf ", 30), "product")
+	if len(got) > MaxLength || strings.HasSuffix(got, "-") || strings.HasSuffix(got, "-scar") {
+		t.Errorf("expected a slug cut between words of at most %d characters but got %q", MaxLength, got)
+	}
+}
+
+func TestValid(t *testing.T) {
+	for _, s := range []string{"red-wool-scarf", "t-shirt-2", "2024-edition"} {
+		if !Valid(s) {
+			t.Errorf("expected %q to be valid", s)
+		}
+	}
+	for _, s := range []string{"", "Red-Wool", "red--wool", "-red", "red wool", "2024", "crème", strings.Repeat("a", MaxLength+1)} {
+		if Valid(s) {
+			t.Errorf("expected %q to be invalid", s)
+		}
+	}
+}
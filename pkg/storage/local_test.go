@@ -0,0 +1,63 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLocalStorage(t *testing.T) {
+	dir := t.TempDir()
+	s := NewLocalStorage(dir, "/media/")
+	ctx := context.Background()
+
+	if err := s.Put(ctx, "products/1/a.txt", strings.NewReader("hello"), "text/plain"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	content, err := os.ReadFile(filepath.Join(dir, "products", "1", "a.txt"))
+	if err != nil || string(content) != "hello" {
+		t.Fatalf("expected the file to be written, got %q, %v", content, err)
+	}
+	if url := s.URL("products/1/a.txt"); url != "/media/products/1/a.txt" {
+		t.Errorf("unexpected url %s", url)
+	}
+
+	req := httptest.NewRequest("GET", "/products/1/a.txt", nil)
+	rr := httptest.NewRecorder()
+	s.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK || rr.Body.String() != "hello" {
+		t.Errorf("expected the file to be served, got %d %q", rr.Code, rr.Body.String())
+	}
+
+	req = httptest.NewRequest("GET", "/products/1/", nil)
+	rr = httptest.NewRecorder()
+	s.ServeHTTP(rr, req)
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("expected directories not to be listed, got %d", rr.Code)
+	}
+
+	if err := s.Delete(ctx, "products/1/a.txt"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "products", "1", "a.txt")); !os.IsNotExist(err) {
+		t.Errorf("expected the file to be deleted, got %v", err)
+	}
+	if err := s.Delete(ctx, "products/1/a.txt"); err != nil {
+		t.Errorf("expected deleting a missing file to succeed, got %v", err)
+	}
+}
+
+func TestLocalStorage_InvalidKeys(t *testing.T) {
+	s := NewLocalStorage(t.TempDir(), "/media")
+	for _, key := range []string{"", "../escape.txt", "/etc/passwd", "a/../../b"} {
+		err := s.Put(context.Background(), key, strings.NewReader("x"), "text/plain")
+		if !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("%q: expected ErrInvalidKey, got %v", key, err)
+		}
+	}
+}
@@ -0,0 +1,23 @@
+// Package storage keeps uploaded files such as product images.
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+)
+
+// ErrInvalidKey is returned for keys that are not relative, slash separated
+// paths inside the storage.
+var ErrInvalidKey = errors.New("invalid storage key")
+
+// Storage keeps files under slash separated keys such as
+// "products/1/3f2a9c.jpg".
+type Storage interface {
+	// Put stores the content of r under key, replacing any file there.
+	Put(ctx context.Context, key string, r io.Reader, contentType string) error
+	// Delete removes the file under key. A missing file is not an error.
+	Delete(ctx context.Context, key string) error
+	// URL is where clients can fetch the file under key.
+	URL(key string) string
+}
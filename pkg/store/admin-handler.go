@@ -0,0 +1,402 @@
+package store
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/MinhNHHH/online-store/pkg/databases/schema"
+	"github.com/go-chi/chi"
+)
+
+func (app *OnlineStore) GetLockouts(w http.ResponseWriter, r *http.Request) {
+	activeOnly := r.URL.Query().Get("active") == "true"
+
+	lockouts, err := app.DB.AllLockouts(activeOnly)
+	if err != nil {
+		log.Printf("Error getting lockouts: %v", err)
+		app.SendError(w, http.StatusInternalServerError, errors.New("could not list lockouts"))
+		return
+	}
+
+	response := struct {
+		Lockouts []*schema.Lockout `json:"lockouts"`
+	}{
+		Lockouts: lockouts,
+	}
+	app.SendResponse(w, http.StatusOK, response)
+}
+
+// UnlockUser lifts a login lock on the account and resets its failures.
+func (app *OnlineStore) UnlockUser(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		log.Printf("Error parsing user ID: %v", err)
+		app.SendError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	adminID, err := app.authenticatedUserID(w, r)
+	if err != nil {
+		app.SendError(w, http.StatusUnauthorized, errors.New("unauthorized"))
+		return
+	}
+
+	user, err := app.DB.GetUser(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		app.SendError(w, http.StatusNotFound, errors.New("user not found"))
+		return
+	}
+	if err != nil {
+		log.Printf("Error getting user: %v", err)
+		app.SendError(w, http.StatusInternalServerError, errors.New("could not unlock user"))
+		return
+	}
+
+	err = app.DB.UnlockLogin(throttleAccount, accountThrottleKey(user.Email), adminID)
+	if err != nil {
+		log.Printf("Error unlocking user: %v", err)
+		app.SendError(w, http.StatusInternalServerError, errors.New("could not unlock user"))
+		return
+	}
+	app.recordAuthEvent(r, schema.AuthEvent{Type: eventUserUnlocked, Outcome: outcomeSuccess, UserID: &user.ID, Email: user.Email})
+	app.SendResponse(w, http.StatusOK, nil)
+}
+
+// GetUsers lists a page of users. search matches part of the name or email.
+func (app *OnlineStore) GetUsers(w http.ResponseWriter, r *http.Request) {
+	search := r.URL.Query().Get("search")
+	page, err := strconv.Atoi(r.URL.Query().Get("page"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+	pageSize, err := strconv.Atoi(r.URL.Query().Get("page_size"))
+	if err != nil || pageSize < 1 || pageSize > 100 {
+		pageSize = 20
+	}
+
+	users, total, err := app.DB.AllUsers(search, page, pageSize)
+	if err != nil {
+		log.Printf("Error getting users: %v", err)
+		app.SendError(w, http.StatusInternalServerError, errors.New("could not list users"))
+		return
+	}
+
+	profiles := make([]UserProfile, 0, len(users))
+	for _, user := range users {
+		profiles = append(profiles, profileOf(user))
+	}
+	response := struct {
+		Users      []UserProfile `json:"users"`
+		TotalCount int           `json:"total_count"`
+		Page       int           `json:"page"`
+		PageSize   int           `json:"page_size"`
+		TotalPages int           `json:"total_pages"`
+	}{
+		Users:      profiles,
+		TotalCount: total,
+		Page:       page,
+		PageSize:   pageSize,
+		TotalPages: (total + pageSize - 1) / pageSize,
+	}
+	app.SendResponse(w, http.StatusOK, response)
+}
+
+func (app *OnlineStore) PromoteUser(w http.ResponseWriter, r *http.Request) {
+	app.setUserAdmin(w, r, true)
+}
+
+func (app *OnlineStore) DemoteUser(w http.ResponseWriter, r *http.Request) {
+	app.setUserAdmin(w, r, false)
+}
+
+func (app *OnlineStore) setUserAdmin(w http.ResponseWriter, r *http.Request, isAdmin bool) {
+	var selfErr error
+	if !isAdmin {
+		selfErr = errors.New("admins cannot demote themselves")
+	}
+	id, ok := app.targetUserID(w, r, selfErr)
+	if !ok {
+		return
+	}
+
+	err := app.DB.SetUserAdmin(id, isAdmin)
+	if errors.Is(err, sql.ErrNoRows) {
+		app.SendError(w, http.StatusNotFound, errors.New("user not found"))
+		return
+	}
+	if err != nil {
+		log.Printf("Error setting admin flag: %v", err)
+		app.SendError(w, http.StatusInternalServerError, errors.New("could not update user"))
+		return
+	}
+	// requests are authorized by the stored flag, but tokens carry the admin
+	// claim for clients to read, so make the user log in again
+	if err := app.DB.RevokeUserRefreshTokens(id); err != nil {
+		log.Printf("Error revoking refresh tokens: %v", err)
+	}
+	eventType := eventUserDemoted
+	if isAdmin {
+		eventType = eventUserPromoted
+	}
+	app.recordAuthEvent(r, schema.AuthEvent{Type: eventType, Outcome: outcomeSuccess, UserID: &id})
+	app.SendResponse(w, http.StatusOK, nil)
+}
+
+// DeactivateUser stops the account from logging in, refreshing tokens or
+// using the access tokens it has, and ends its sessions.
+func (app *OnlineStore) DeactivateUser(w http.ResponseWriter, r *http.Request) {
+	app.setUserDeactivated(w, r, true)
+}
+
+func (app *OnlineStore) ReactivateUser(w http.ResponseWriter, r *http.Request) {
+	app.setUserDeactivated(w, r, false)
+}
+
+func (app *OnlineStore) setUserDeactivated(w http.ResponseWriter, r *http.Request, deactivated bool) {
+	var selfErr error
+	if deactivated {
+		selfErr = errors.New("admins cannot deactivate themselves")
+	}
+	id, ok := app.targetUserID(w, r, selfErr)
+	if !ok {
+		return
+	}
+
+	err := app.DB.SetUserDeactivated(id, deactivated)
+	if errors.Is(err, sql.ErrNoRows) {
+		app.SendError(w, http.StatusNotFound, errors.New("user not found"))
+		return
+	}
+	if err != nil {
+		log.Printf("Error deactivating user: %v", err)
+		app.SendError(w, http.StatusInternalServerError, errors.New("could not update user"))
+		return
+	}
+	eventType := eventUserReactivated
+	if deactivated {
+		eventType = eventUserDeactivated
+		if err := app.DB.RevokeUserRefreshTokens(id); err != nil {
+			log.Printf("Error revoking refresh tokens: %v", err)
+		}
+	}
+	app.recordAuthEvent(r, schema.AuthEvent{Type: eventType, Outcome: outcomeSuccess, UserID: &id})
+	app.SendResponse(w, http.StatusOK, nil)
+}
+
+// ForcePasswordReset replaces the password of the account with a random one,
+// logs it out everywhere and mails the user a link to choose a new password.
+func (app *OnlineStore) ForcePasswordReset(w http.ResponseWriter, r *http.Request) {
+	id, ok := app.targetUserID(w, r, nil)
+	if !ok {
+		return
+	}
+
+	user, err := app.DB.GetUser(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		app.SendError(w, http.StatusNotFound, errors.New("user not found"))
+		return
+	}
+	if err != nil {
+		log.Printf("Error getting user: %v", err)
+		app.SendError(w, http.StatusInternalServerError, errors.New("could not reset password"))
+		return
+	}
+
+	password, err := randomToken(32)
+	if err != nil {
+		log.Printf("Error generating password: %v", err)
+		app.SendError(w, http.StatusInternalServerError, errors.New("could not reset password"))
+		return
+	}
+	if err := app.DB.ResetPassword(user.ID, password); err != nil {
+		log.Printf("Error resetting password: %v", err)
+		app.SendError(w, http.StatusInternalServerError, errors.New("could not reset password"))
+		return
+	}
+	if err := app.DB.RevokeUserRefreshTokens(user.ID); err != nil {
+		log.Printf("Error revoking refresh tokens: %v", err)
+	}
+	if err := app.revokeSessions(r, user.ID); err != nil {
+		log.Printf("Error revoking sessions: %v", err)
+	}
+	app.recordAuthEvent(r, schema.AuthEvent{Type: eventAdminPasswordReset, Outcome: outcomeSuccess, UserID: &user.ID, Email: user.Email})
+	if err := app.startPasswordReset(user); err != nil {
+		log.Printf("Error starting password reset: %v", err)
+		app.SendError(w, http.StatusInternalServerError, errors.New("could not send reset link"))
+		return
+	}
+	app.SendResponse(w, http.StatusAccepted, nil)
+}
+
+// targetUserID reads the user ID from the URL. When selfErr is set, admins
+// naming their own account are refused with it, so they cannot lock
+// themselves out.
+func (app *OnlineStore) targetUserID(w http.ResponseWriter, r *http.Request, selfErr error) (int, bool) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		log.Printf("Error parsing user ID: %v", err)
+		app.SendError(w, http.StatusBadRequest, err)
+		return 0, false
+	}
+	if selfErr != nil {
+		adminID, err := app.authenticatedUserID(w, r)
+		if err != nil {
+			app.SendError(w, http.StatusUnauthorized, errors.New("unauthorized"))
+			return 0, false
+		}
+		if id == adminID {
+			app.SendError(w, http.StatusBadRequest, selfErr)
+			return 0, false
+		}
+	}
+	return id, true
+}
+
+// auditExportPageSize is how many events a CSV export reads at a time.
+const auditExportPageSize = 500
+
+// GetAuthEvents lists a page of the audit log, newest first. It filters by
+// type, outcome, user_id, email, ip and the RFC 3339 times since and until.
+// With format=csv every matching event is sent as a CSV file instead.
+func (app *OnlineStore) GetAuthEvents(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	filter := schema.AuthEventFilter{
+		Type:    query.Get("type"),
+		Outcome: query.Get("outcome"),
+		Email:   query.Get("email"),
+		IP:      query.Get("ip"),
+	}
+	if userID := query.Get("user_id"); userID != "" {
+		id, err := strconv.Atoi(userID)
+		if err != nil {
+			app.SendError(w, http.StatusBadRequest, errors.New("invalid user_id"))
+			return
+		}
+		filter.UserID = id
+	}
+	for _, param := range []struct {
+		name string
+		dest **time.Time
+	}{
+		{"since", &filter.Since},
+		{"until", &filter.Until},
+	} {
+		value := query.Get(param.name)
+		if value == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			app.SendError(w, http.StatusBadRequest, fmt.Errorf("invalid %s: use RFC 3339, e.g. 2025-06-01T00:00:00Z", param.name))
+			return
+		}
+		*param.dest = &t
+	}
+
+	if query.Get("format") == "csv" {
+		app.exportAuthEvents(w, filter)
+		return
+	}
+
+	page, err := strconv.Atoi(query.Get("page"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+	pageSize, err := strconv.Atoi(query.Get("page_size"))
+	if err != nil || pageSize < 1 || pageSize > 100 {
+		pageSize = 20
+	}
+
+	events, total, err := app.DB.AllAuthEvents(filter, page, pageSize)
+	if err != nil {
+		log.Printf("Error getting auth events: %v", err)
+		app.SendError(w, http.StatusInternalServerError, errors.New("could not list audit events"))
+		return
+	}
+
+	response := struct {
+		Events     []*schema.AuthEvent `json:"events"`
+		TotalCount int                 `json:"total_count"`
+		Page       int                 `json:"page"`
+		PageSize   int                 `json:"page_size"`
+		TotalPages int                 `json:"total_pages"`
+	}{
+		Events:     events,
+		TotalCount: total,
+		Page:       page,
+		PageSize:   pageSize,
+		TotalPages: (total + pageSize - 1) / pageSize,
+	}
+	app.SendResponse(w, http.StatusOK, response)
+}
+
+// exportAuthEvents writes every event matching filter as CSV, newest first,
+// reading them a page at a time. Pages continue below the last ID read, so
+// events logged during the export neither shift nor repeat rows.
+func (app *OnlineStore) exportAuthEvents(w http.ResponseWriter, filter schema.AuthEventFilter) {
+	events, err := app.DB.AuthEventsBefore(filter, 0, auditExportPageSize)
+	if err != nil {
+		log.Printf("Error getting auth events: %v", err)
+		app.SendError(w, http.StatusInternalServerError, errors.New("could not export audit events"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", `attachment; filename="audit.csv"`)
+	w.WriteHeader(http.StatusOK)
+
+	out := csv.NewWriter(w)
+	out.Write([]string{"id", "created_at", "type", "outcome", "reason", "user_id", "actor_id", "email", "ip", "user_agent"})
+	for {
+		for _, event := range events {
+			out.Write([]string{
+				strconv.Itoa(event.ID),
+				event.CreatedAt.UTC().Format(time.RFC3339),
+				event.Type,
+				event.Outcome,
+				event.Reason,
+				optionalID(event.UserID),
+				optionalID(event.ActorID),
+				csvSafe(event.Email),
+				event.IP,
+				csvSafe(event.UserAgent),
+			})
+		}
+		if len(events) < auditExportPageSize {
+			break
+		}
+		// the status is already sent, so a failure can only cut the file short
+		events, err = app.DB.AuthEventsBefore(filter, events[len(events)-1].ID, auditExportPageSize)
+		if err != nil {
+			log.Printf("Error getting auth events: %v", err)
+			break
+		}
+	}
+	out.Flush()
+	if err := out.Error(); err != nil {
+		log.Printf("Error writing audit export: %v", err)
+	}
+}
+
+func optionalID(id *int) string {
+	if id == nil {
+		return ""
+	}
+	return strconv.Itoa(*id)
+}
+
+// csvSafe keeps values the client chose, such as the user agent, from being
+// run as formulas when the export is opened in a spreadsheet.
+func csvSafe(value string) string {
+	if value != "" && strings.ContainsRune("=+-@\t\r", rune(value[0])) {
+		return "'" + value
+	}
+	return value
+}
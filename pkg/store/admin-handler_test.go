@@ -0,0 +1,264 @@
+package store
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/MinhNHHH/online-store/pkg/databases/repositories/dbrepo"
+	"github.com/MinhNHHH/online-store/pkg/databases/schema"
+	"github.com/go-chi/chi"
+)
+
+func Test_app_GetLockouts(t *testing.T) {
+	for _, query := range []string{"", "?active=true"} {
+		req, _ := http.NewRequest("GET", "/api/v1/admin/lockouts"+query, nil)
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(app.GetLockouts)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusOK {
+			t.Errorf("%q: expected status 200 but got %d", query, rr.Code)
+		}
+	}
+}
+
+func Test_app_UnlockUser(t *testing.T) {
+	adminTokens, _, _ := app.generateTokenPair(&schema.User{ID: dbrepo.TestAdminID, Name: "Staff", IsAdmin: true}, "", false)
+
+	var tests = []struct {
+		name               string
+		userID             string
+		expectedStatusCode int
+	}{
+		{"existing user", "1", http.StatusOK},
+		{"unknown user", "999", http.StatusNotFound},
+		{"invalid id", "abc", http.StatusBadRequest},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("POST", "/api/v1/admin/users/"+e.userID+"/unlock", nil)
+		req.Header.Set("Authorization", "Bearer "+adminTokens.Token)
+		rr := httptest.NewRecorder()
+
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("id", e.userID)
+		req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+
+		handler := http.HandlerFunc(app.UnlockUser)
+		handler.ServeHTTP(rr, req)
+
+		if e.expectedStatusCode != rr.Code {
+			t.Errorf("%s: returned wrong status code; expected %d but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+	}
+}
+
+func Test_app_GetUsers(t *testing.T) {
+	var tests = []struct {
+		name          string
+		query         string
+		expectedUsers int
+		expectedTotal int
+	}{
+		{"all users", "", 4, 4},
+		{"search", "?search=twofactor", 1, 1},
+		{"second page", "?page=2&page_size=3", 1, 4},
+		{"invalid paging", "?page=-1&page_size=abc", 4, 4},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("GET", "/api/v1/admin/users"+e.query, nil)
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(app.GetUsers).ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusOK {
+			t.Errorf("%s: expected status 200 but got %d", e.name, rr.Code)
+			continue
+		}
+		var response struct {
+			Users      []UserProfile `json:"users"`
+			TotalCount int           `json:"total_count"`
+		}
+		_ = json.NewDecoder(rr.Body).Decode(&response)
+		if len(response.Users) != e.expectedUsers || response.TotalCount != e.expectedTotal {
+			t.Errorf("%s: expected %d of %d users but got %d of %d", e.name, e.expectedUsers, e.expectedTotal, len(response.Users), response.TotalCount)
+		}
+		if strings.Contains(rr.Body.String(), "password") {
+			t.Errorf("%s: response leaks passwords", e.name)
+		}
+	}
+}
+
+func Test_app_AdminUserActions(t *testing.T) {
+	routes := app.Routes()
+	adminTokens, _, _ := app.generateTokenPair(&schema.User{ID: dbrepo.TestAdminID, Name: "Staff", IsAdmin: true}, "", false)
+	userTokens, _, _ := app.generateTokenPair(&schema.User{ID: 2, Name: "User"}, "", false)
+	// user 1 was an admin when the token was issued, but is no more
+	demotedTokens, _, _ := app.generateTokenPair(&schema.User{ID: 1, Name: "Admin", IsAdmin: true}, "", false)
+
+	var tests = []struct {
+		name               string
+		url                string
+		token              string
+		expectedStatusCode int
+	}{
+		{"promote", "/api/v1/admin/users/2/promote", adminTokens.Token, http.StatusOK},
+		{"demote", "/api/v1/admin/users/3/demote", adminTokens.Token, http.StatusOK},
+		{"demote self", "/api/v1/admin/users/8/demote", adminTokens.Token, http.StatusBadRequest},
+		{"deactivate", "/api/v1/admin/users/2/deactivate", adminTokens.Token, http.StatusOK},
+		{"deactivate self", "/api/v1/admin/users/8/deactivate", adminTokens.Token, http.StatusBadRequest},
+		{"deactivate unknown user", "/api/v1/admin/users/999/deactivate", adminTokens.Token, http.StatusNotFound},
+		{"reactivate", "/api/v1/admin/users/6/reactivate", adminTokens.Token, http.StatusOK},
+		{"reset password", "/api/v1/admin/users/2/reset-password", adminTokens.Token, http.StatusAccepted},
+		{"reset password of unknown user", "/api/v1/admin/users/999/reset-password", adminTokens.Token, http.StatusNotFound},
+		{"invalid id", "/api/v1/admin/users/abc/promote", adminTokens.Token, http.StatusBadRequest},
+		{"non admin", "/api/v1/admin/users/2/promote", userTokens.Token, http.StatusForbidden},
+		{"demoted admin", "/api/v1/admin/users/2/promote", demotedTokens.Token, http.StatusForbidden},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("POST", e.url, nil)
+		req.Header.Set("Authorization", "Bearer "+e.token)
+		rr := httptest.NewRecorder()
+		routes.ServeHTTP(rr, req)
+
+		if e.expectedStatusCode != rr.Code {
+			t.Errorf("%s: returned wrong status code; expected %d but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+	}
+}
+
+func Test_app_GetAuthEvents(t *testing.T) {
+	routes := app.Routes()
+	adminTokens, _, _ := app.generateTokenPair(&schema.User{ID: dbrepo.TestAdminID, Name: "Staff", IsAdmin: true}, "", false)
+	userTokens, _, _ := app.generateTokenPair(&schema.User{ID: 2, Name: "User"}, "", false)
+
+	var tests = []struct {
+		name               string
+		query              string
+		token              string
+		expectedStatusCode int
+		expectedEvents     int
+	}{
+		{"all events", "", adminTokens.Token, http.StatusOK, 3},
+		{"by type", "?type=login", adminTokens.Token, http.StatusOK, 2},
+		{"by type and outcome", "?type=login&outcome=failure", adminTokens.Token, http.StatusOK, 1},
+		{"paged", "?page=2&page_size=2", adminTokens.Token, http.StatusOK, 1},
+		{"time range", "?since=2025-06-01T00:00:00Z&until=2030-01-01T00:00:00Z", adminTokens.Token, http.StatusOK, 3},
+		{"invalid since", "?since=yesterday", adminTokens.Token, http.StatusBadRequest, 0},
+		{"invalid user id", "?user_id=abc", adminTokens.Token, http.StatusBadRequest, 0},
+		{"not an admin", "", userTokens.Token, http.StatusForbidden, 0},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("GET", "/api/v1/admin/audit"+e.query, nil)
+		req.Header.Set("Authorization", "Bearer "+e.token)
+		rr := httptest.NewRecorder()
+		routes.ServeHTTP(rr, req)
+
+		if e.expectedStatusCode != rr.Code {
+			t.Errorf("%s: returned wrong status code; expected %d but got %d", e.name, e.expectedStatusCode, rr.Code)
+			continue
+		}
+		if rr.Code != http.StatusOK {
+			continue
+		}
+		var response struct {
+			Events []*schema.AuthEvent `json:"events"`
+		}
+		_ = json.NewDecoder(rr.Body).Decode(&response)
+		if len(response.Events) != e.expectedEvents {
+			t.Errorf("%s: expected %d events but got %d", e.name, e.expectedEvents, len(response.Events))
+		}
+	}
+}
+
+func Test_app_GetAuthEventsCSV(t *testing.T) {
+	adminTokens, _, _ := app.generateTokenPair(&schema.User{ID: dbrepo.TestAdminID, Name: "Staff", IsAdmin: true}, "", false)
+
+	req, _ := http.NewRequest("GET", "/api/v1/admin/audit?format=csv&type=login", nil)
+	req.Header.Set("Authorization", "Bearer "+adminTokens.Token)
+	rr := httptest.NewRecorder()
+	app.Routes().ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK || rr.Header().Get("Content-Type") != "text/csv" {
+		t.Fatalf("expected a CSV file but got %d %s", rr.Code, rr.Header().Get("Content-Type"))
+	}
+	records, err := csv.NewReader(rr.Body).ReadAll()
+	if err != nil {
+		t.Fatalf("invalid CSV: %v", err)
+	}
+	if len(records) != 3 || records[0][0] != "id" {
+		t.Fatalf("expected a header and two events, got %v", records)
+	}
+	if records[1][9] != `test, "quoted"` || records[2][4] != "wrong_password" {
+		t.Errorf("unexpected rows %v", records[1:])
+	}
+}
+
+// auditExportRecorder holds numbered login events and logs a new one every
+// time a batch is read, as a busy server would during an export.
+type auditExportRecorder struct {
+	dbrepo.TestDBRepo
+	events []*schema.AuthEvent
+}
+
+func (p *auditExportRecorder) AuthEventsBefore(filter schema.AuthEventFilter, beforeID, limit int) ([]*schema.AuthEvent, error) {
+	batch := []*schema.AuthEvent{}
+	for i := len(p.events) - 1; i >= 0 && len(batch) < limit; i-- {
+		if beforeID == 0 || p.events[i].ID < beforeID {
+			batch = append(batch, p.events[i])
+		}
+	}
+	p.events = append(p.events, &schema.AuthEvent{ID: len(p.events) + 1, Type: "login", Outcome: "success"})
+	return batch, nil
+}
+
+func Test_app_GetAuthEventsCSVPages(t *testing.T) {
+	total := auditExportPageSize*2 + 1
+	recorder := &auditExportRecorder{}
+	for id := 1; id <= total; id++ {
+		recorder.events = append(recorder.events, &schema.AuthEvent{ID: id, Type: "login", Outcome: "success"})
+	}
+	testApp := app
+	testApp.DB = recorder
+	adminTokens, _, _ := app.generateTokenPair(&schema.User{ID: dbrepo.TestAdminID, Name: "Staff", IsAdmin: true}, "", false)
+
+	req, _ := http.NewRequest("GET", "/api/v1/admin/audit?format=csv", nil)
+	req.Header.Set("Authorization", "Bearer "+adminTokens.Token)
+	rr := httptest.NewRecorder()
+	testApp.Routes().ServeHTTP(rr, req)
+
+	records, err := csv.NewReader(rr.Body).ReadAll()
+	if err != nil {
+		t.Fatalf("invalid CSV: %v", err)
+	}
+	if len(records) != total+1 {
+		t.Fatalf("expected a header and %d events, got %d rows", total, len(records))
+	}
+	for i, record := range records[1:] {
+		if expected := strconv.Itoa(total - i); record[0] != expected {
+			t.Fatalf("row %d: expected event %s but got %s", i+1, expected, record[0])
+		}
+	}
+}
+
+func Test_csvSafe(t *testing.T) {
+	for value, expected := range map[string]string{
+		"Mozilla/5.0":       "Mozilla/5.0",
+		"=HYPERLINK(\"x\")": "'=HYPERLINK(\"x\")",
+		"+1":                "'+1",
+		"@SUM(A1)":          "'@SUM(A1)",
+		"":                  "",
+	} {
+		if got := csvSafe(value); got != expected {
+			t.Errorf("%q: expected %q but got %q", value, expected, got)
+		}
+	}
+}
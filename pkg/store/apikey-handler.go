@@ -0,0 +1,170 @@
+package store
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/MinhNHHH/online-store/pkg/databases/schema"
+	"github.com/go-chi/chi"
+)
+
+// Scopes API keys can be given. Routes open to API keys name one of them with
+// apiKeyScope.
+const (
+	scopeProductsRead    = "products:read"
+	scopeProductsWrite   = "products:write"
+	scopeCategoriesRead  = "categories:read"
+	scopeCategoriesWrite = "categories:write"
+	scopeReviewsRead     = "reviews:read"
+)
+
+var apiKeyScopes = []string{
+	scopeProductsRead,
+	scopeProductsWrite,
+	scopeCategoriesRead,
+	scopeCategoriesWrite,
+	scopeReviewsRead,
+}
+
+// apiKeyPrefix starts every API key, which tells them apart from access
+// tokens in the Authorization header.
+const apiKeyPrefix = "so_"
+
+// presentedAPIKey returns the API key sent in the X-API-Key header or as a
+// bearer token, if any.
+func presentedAPIKey(r *http.Request) string {
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		return key
+	}
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if ok && strings.HasPrefix(token, apiKeyPrefix) {
+		return token
+	}
+	return ""
+}
+
+// generateAPIKey returns a new key together with the prefix shown to admins.
+func generateAPIKey() (string, string, error) {
+	raw, err := randomToken(24)
+	if err != nil {
+		return "", "", err
+	}
+	key := apiKeyPrefix + raw
+	return key, key[:len(apiKeyPrefix)+8], nil
+}
+
+func (app *OnlineStore) GetAPIKeys(w http.ResponseWriter, r *http.Request) {
+	keys, err := app.DB.AllAPIKeys()
+	if err != nil {
+		log.Printf("Error getting API keys: %v", err)
+		app.SendError(w, http.StatusInternalServerError, errors.New("could not list API keys"))
+		return
+	}
+
+	response := struct {
+		APIKeys []*schema.APIKey `json:"api_keys"`
+	}{
+		APIKeys: keys,
+	}
+	app.SendResponse(w, http.StatusOK, response)
+}
+
+// CreateAPIKey creates a key with the requested scopes. The key itself is only
+// ever part of this response.
+func (app *OnlineStore) CreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Name      string     `json:"name"`
+		Scopes    []string   `json:"scopes"`
+		ExpiresAt *time.Time `json:"expires_at"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		log.Printf("Error decoding request: %v", err)
+		app.SendError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if strings.TrimSpace(request.Name) == "" {
+		app.SendError(w, http.StatusBadRequest, errors.New("name is required"))
+		return
+	}
+	if len(request.Scopes) == 0 {
+		app.SendError(w, http.StatusBadRequest, errors.New("at least one scope is required"))
+		return
+	}
+	for _, scope := range request.Scopes {
+		if !slices.Contains(apiKeyScopes, scope) {
+			app.SendError(w, http.StatusBadRequest, fmt.Errorf("unknown scope %q", scope))
+			return
+		}
+	}
+	if request.ExpiresAt != nil && request.ExpiresAt.Before(time.Now()) {
+		app.SendError(w, http.StatusBadRequest, errors.New("expires_at must be in the future"))
+		return
+	}
+
+	adminID, err := app.authenticatedUserID(w, r)
+	if err != nil {
+		app.SendError(w, http.StatusUnauthorized, errors.New("unauthorized"))
+		return
+	}
+
+	raw, prefix, err := generateAPIKey()
+	if err != nil {
+		log.Printf("Error generating API key: %v", err)
+		app.SendError(w, http.StatusInternalServerError, errors.New("could not create API key"))
+		return
+	}
+
+	key := &schema.APIKey{
+		Name:      strings.TrimSpace(request.Name),
+		Prefix:    prefix,
+		Scopes:    slices.Compact(slices.Sorted(slices.Values(request.Scopes))),
+		CreatedBy: &adminID,
+		ExpiresAt: request.ExpiresAt,
+		CreatedAt: time.Now(),
+	}
+	key.ID, err = app.DB.InsertAPIKey(key, hashToken(raw))
+	if err != nil {
+		log.Printf("Error inserting API key: %v", err)
+		app.SendError(w, http.StatusInternalServerError, errors.New("could not create API key"))
+		return
+	}
+
+	response := struct {
+		APIKey *schema.APIKey `json:"api_key"`
+		Key    string         `json:"key"`
+	}{
+		APIKey: key,
+		Key:    raw,
+	}
+	app.SendResponse(w, http.StatusCreated, response)
+}
+
+func (app *OnlineStore) RevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		log.Printf("Error parsing API key ID: %v", err)
+		app.SendError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	err = app.DB.RevokeAPIKey(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		app.SendError(w, http.StatusNotFound, errors.New("API key not found"))
+		return
+	}
+	if err != nil {
+		log.Printf("Error revoking API key: %v", err)
+		app.SendError(w, http.StatusInternalServerError, errors.New("could not revoke API key"))
+		return
+	}
+	app.SendResponse(w, http.StatusOK, nil)
+}
@@ -0,0 +1,148 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/MinhNHHH/online-store/pkg/databases/repositories/dbrepo"
+	"github.com/MinhNHHH/online-store/pkg/databases/schema"
+	"github.com/go-chi/chi"
+)
+
+func Test_app_RoutesAPIKeyScopes(t *testing.T) {
+	routes := app.Routes()
+
+	var tests = []struct {
+		name               string
+		method             string
+		url                string
+		body               string
+		header             string
+		key                string
+		expectedStatusCode int
+	}{
+		{"read products with header", http.MethodGet, "/api/v1/products/", "", "X-API-Key", "so_products-key", http.StatusOK},
+		{"read products with bearer", http.MethodGet, "/api/v1/products/", "", "Authorization", "Bearer so_products-key", http.StatusOK},
+		{"write products", http.MethodPost, "/api/v1/products/", `{"name":"x"}`, "X-API-Key", "so_products-key", http.StatusCreated},
+		{"delete product", http.MethodDelete, "/api/v1/products/1", "", "X-API-Key", "so_products-key", http.StatusOK},
+		{"read categories", http.MethodGet, "/api/v1/categories/", "", "X-API-Key", "so_categories-read-key", http.StatusOK},
+		{"read categories without scope", http.MethodGet, "/api/v1/categories/", "", "X-API-Key", "so_products-key", http.StatusForbidden},
+		{"write categories with read scope", http.MethodPost, "/api/v1/categories/", `{"name":"x"}`, "X-API-Key", "so_categories-read-key", http.StatusForbidden},
+		{"write products with other scope", http.MethodPost, "/api/v1/products/", `{"name":"x"}`, "X-API-Key", "so_categories-read-key", http.StatusForbidden},
+		{"route without scope", http.MethodGet, "/api/v1/admin/lockouts", "", "X-API-Key", "so_products-key", http.StatusForbidden},
+		{"user route", http.MethodPost, "/api/v1/reviews/1", `{}`, "X-API-Key", "so_products-key", http.StatusForbidden},
+		{"unknown key", http.MethodGet, "/api/v1/products/", "", "X-API-Key", "so_unknown", http.StatusUnauthorized},
+		{"unknown bearer key", http.MethodGet, "/api/v1/products/", "", "Authorization", "Bearer so_unknown", http.StatusUnauthorized},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest(e.method, e.url, strings.NewReader(e.body))
+		req.Header.Set(e.header, e.key)
+		rr := httptest.NewRecorder()
+		routes.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: expected status %d but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+	}
+}
+
+func Test_app_CreateAPIKey(t *testing.T) {
+	adminTokens, _, _ := app.generateTokenPair(&schema.User{ID: dbrepo.TestAdminID, Name: "Staff", IsAdmin: true}, "", false)
+	past := time.Now().Add(-time.Hour).Format(time.RFC3339)
+
+	var tests = []struct {
+		name               string
+		requestBody        string
+		expectedStatusCode int
+	}{
+		{"valid", `{"name":"ERP sync", "scopes":["products:write", "products:read", "products:read"]}`, http.StatusCreated},
+		{"with expiry", `{"name":"Scanner", "scopes":["categories:read"], "expires_at":"2099-01-01T00:00:00Z"}`, http.StatusCreated},
+		{"expired", `{"name":"Scanner", "scopes":["categories:read"], "expires_at":"` + past + `"}`, http.StatusBadRequest},
+		{"no name", `{"scopes":["products:read"]}`, http.StatusBadRequest},
+		{"no scopes", `{"name":"ERP sync"}`, http.StatusBadRequest},
+		{"unknown scope", `{"name":"ERP sync", "scopes":["users:write"]}`, http.StatusBadRequest},
+		{"not json", `i am not JSON`, http.StatusBadRequest},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("POST", "/api/v1/admin/api-keys", strings.NewReader(e.requestBody))
+		req.Header.Set("Authorization", "Bearer "+adminTokens.Token)
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(app.CreateAPIKey).ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: returned wrong status code; expected %d but got %d", e.name, e.expectedStatusCode, rr.Code)
+			continue
+		}
+		if rr.Code != http.StatusCreated {
+			continue
+		}
+
+		var response struct {
+			APIKey schema.APIKey `json:"api_key"`
+			Key    string        `json:"key"`
+		}
+		_ = json.NewDecoder(rr.Body).Decode(&response)
+		if !strings.HasPrefix(response.Key, response.APIKey.Prefix) || len(response.Key) <= len(response.APIKey.Prefix) {
+			t.Errorf("%s: expected key %q to start with prefix %q", e.name, response.Key, response.APIKey.Prefix)
+		}
+		if presentedAPIKey(&http.Request{Header: http.Header{"Authorization": {"Bearer " + response.Key}}}) == "" {
+			t.Errorf("%s: key %q is not recognised as a bearer API key", e.name, response.Key)
+		}
+	}
+
+	// scopes are stored sorted and without duplicates
+	req, _ := http.NewRequest("POST", "/api/v1/admin/api-keys", strings.NewReader(tests[0].requestBody))
+	req.Header.Set("Authorization", "Bearer "+adminTokens.Token)
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(app.CreateAPIKey).ServeHTTP(rr, req)
+	if !strings.Contains(rr.Body.String(), `"scopes":["products:read","products:write"]`) {
+		t.Errorf("expected normalised scopes, got %s", rr.Body)
+	}
+}
+
+func Test_app_RevokeAPIKey(t *testing.T) {
+	var tests = []struct {
+		name               string
+		keyID              string
+		expectedStatusCode int
+	}{
+		{"existing key", "1", http.StatusOK},
+		{"unknown key", "999", http.StatusNotFound},
+		{"invalid id", "abc", http.StatusBadRequest},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("DELETE", "/api/v1/admin/api-keys/"+e.keyID, nil)
+		rr := httptest.NewRecorder()
+
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("id", e.keyID)
+		req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+
+		http.HandlerFunc(app.RevokeAPIKey).ServeHTTP(rr, req)
+
+		if e.expectedStatusCode != rr.Code {
+			t.Errorf("%s: returned wrong status code; expected %d but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+	}
+}
+
+func Test_app_GetAPIKeys(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/api/v1/admin/api-keys", nil)
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(app.GetAPIKeys).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("expected status 200 but got %d", rr.Code)
+	}
+	if !strings.Contains(rr.Body.String(), `"api_keys":[]`) {
+		t.Errorf("expected an empty list, got %s", rr.Body)
+	}
+}
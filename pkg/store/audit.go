@@ -0,0 +1,68 @@
+package store
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/MinhNHHH/online-store/pkg/databases/schema"
+)
+
+// Types of audit log events.
+const (
+	eventLogin                    = "login"
+	eventTokenRefresh             = "token_refresh"
+	eventLogout                   = "logout"
+	eventLogoutAll                = "logout_all"
+	eventRegister                 = "register"
+	eventPasswordResetRequest     = "password_reset_requested"
+	eventPasswordReset            = "password_reset"
+	eventPasswordChange           = "password_change"
+	eventEmailChange              = "email_change"
+	eventAccountDeletionRequested = "account_deletion_requested"
+	eventAccountDeletionCancelled = "account_deletion_cancelled"
+	eventAccountDeleted           = "account_deleted"
+	eventTwoFactorEnabled         = "two_factor_enabled"
+	eventTwoFactorDisabled        = "two_factor_disabled"
+	eventUserPromoted             = "user_promoted"
+	eventUserDemoted              = "user_demoted"
+	eventUserDeactivated          = "user_deactivated"
+	eventUserReactivated          = "user_reactivated"
+	eventUserUnlocked             = "user_unlocked"
+	eventAdminPasswordReset       = "admin_password_reset"
+)
+
+const (
+	outcomeSuccess = "success"
+	outcomeFailure = "failure"
+)
+
+// Reasons recorded with failed events.
+const (
+	reasonUnknownEmail    = "unknown_email"
+	reasonWrongPassword   = "wrong_password"
+	reasonThrottled       = "throttled"
+	reasonDeactivated     = "deactivated"
+	reasonEmailUnverified = "email_unverified"
+	reasonInvalidCode     = "invalid_code"
+	reasonInvalidToken    = "invalid_token"
+	reasonTokenRevoked    = "token_revoked"
+	reasonTokenReused     = "token_reused"
+	reasonUnknownUser     = "unknown_user"
+)
+
+// recordAuthEvent adds event to the audit log with the client IP and user
+// agent of r. Unless set, the actor is the user signed in to make r. A failure
+// to record is logged but does not fail the request.
+func (app *OnlineStore) recordAuthEvent(r *http.Request, event schema.AuthEvent) {
+	event.IP = clientIP(r)
+	event.UserAgent = r.UserAgent()
+	if event.ActorID == nil {
+		if p, ok := principalFromContext(r.Context()); ok && p.UserID != 0 {
+			actorID := p.UserID
+			event.ActorID = &actorID
+		}
+	}
+	if err := app.DB.InsertAuthEvent(&event); err != nil {
+		log.Printf("Error recording %s event: %v", event.Type, err)
+	}
+}
@@ -0,0 +1,92 @@
+package store
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/MinhNHHH/online-store/pkg/databases/repositories/dbrepo"
+	"github.com/MinhNHHH/online-store/pkg/databases/schema"
+)
+
+// auditRecorder keeps the events written to the audit log.
+type auditRecorder struct {
+	dbrepo.TestDBRepo
+	mu     sync.Mutex
+	events []schema.AuthEvent
+}
+
+func (a *auditRecorder) InsertAuthEvent(event *schema.AuthEvent) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.events = append(a.events, *event)
+	return nil
+}
+
+func Test_app_recordAuthEvent(t *testing.T) {
+	adminTokens, _, _ := app.generateTokenPair(&schema.User{ID: dbrepo.TestAdminID, Name: "Staff", IsAdmin: true}, "", false)
+
+	var theTests = []struct {
+		name            string
+		url             string
+		body            string
+		token           string
+		expectedType    string
+		expectedOutcome string
+		expectedReason  string
+		expectedUserID  int
+		expectedActorID int
+	}{
+		{"unknown email", "/api/v1/auth", `{"email":"nobody@example.com", "password":"secret"}`, "", eventLogin, outcomeFailure, reasonUnknownEmail, 0, 0},
+		{"wrong password", "/api/v1/auth", `{"email":"admin@example.com", "password":"wrong"}`, "", eventLogin, outcomeFailure, reasonWrongPassword, 1, 0},
+		{"deactivated", "/api/v1/auth", `{"email":"deactivated@example.com", "password":"secret"}`, "", eventLogin, outcomeFailure, reasonDeactivated, 6, 0},
+		{"refresh with bad token", "/api/v1/refresh-token", "refresh_token=bad", "", eventTokenRefresh, outcomeFailure, reasonInvalidToken, 0, 0},
+		{"reset with bad token", "/api/v1/auth/reset-password", `{"token":"used-reset-token", "password":"new-secret"}`, "", eventPasswordReset, outcomeFailure, reasonInvalidToken, 0, 0},
+		{"promote", "/api/v1/admin/users/2/promote", "", adminTokens.Token, eventUserPromoted, outcomeSuccess, "", 2, dbrepo.TestAdminID},
+	}
+
+	for _, e := range theTests {
+		recorder := &auditRecorder{}
+		testApp := app
+		testApp.DB = recorder
+
+		req, _ := http.NewRequest("POST", e.url, strings.NewReader(e.body))
+		if strings.HasPrefix(e.body, "refresh_token") {
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		}
+		if e.token != "" {
+			req.Header.Set("Authorization", "Bearer "+e.token)
+		}
+		req.Header.Set("User-Agent", "audit-test")
+		req.RemoteAddr = "192.0.2.1:1234"
+		rr := httptest.NewRecorder()
+		testApp.Routes().ServeHTTP(rr, req)
+
+		if len(recorder.events) != 1 {
+			t.Errorf("%s: expected one event but got %d", e.name, len(recorder.events))
+			continue
+		}
+		event := recorder.events[0]
+		if event.Type != e.expectedType || event.Outcome != e.expectedOutcome || event.Reason != e.expectedReason {
+			t.Errorf("%s: expected %s %s %q but got %s %s %q", e.name, e.expectedType, e.expectedOutcome, e.expectedReason, event.Type, event.Outcome, event.Reason)
+		}
+		if event.IP != "192.0.2.1" || event.UserAgent != "audit-test" {
+			t.Errorf("%s: expected the client IP and user agent, got %q and %q", e.name, event.IP, event.UserAgent)
+		}
+		if optionalID(event.UserID) != optionalID(idOrNil(e.expectedUserID)) {
+			t.Errorf("%s: expected user %d but got %s", e.name, e.expectedUserID, optionalID(event.UserID))
+		}
+		if optionalID(event.ActorID) != optionalID(idOrNil(e.expectedActorID)) {
+			t.Errorf("%s: expected actor %d but got %s", e.name, e.expectedActorID, optionalID(event.ActorID))
+		}
+	}
+}
+
+func idOrNil(id int) *int {
+	if id == 0 {
+		return nil
+	}
+	return &id
+}
@@ -1,15 +1,15 @@
 package store
 
 import (
+	"database/sql"
 	"encoding/json"
 	"errors"
 	"log"
 	"net/http"
-	"strconv"
 	"time"
 
+	databases "github.com/MinhNHHH/online-store/pkg/databases/repositories"
 	"github.com/MinhNHHH/online-store/pkg/databases/schema"
-	"github.com/golang-jwt/jwt/v4"
 	"golang.org/x/crypto/bcrypt"
 )
 
@@ -19,6 +19,16 @@ type Credentials struct {
 }
 
 func (app *OnlineStore) authenticate(w http.ResponseWriter, r *http.Request) {
+	user := app.checkCredentials(w, r)
+	if user == nil {
+		return
+	}
+	app.completeLogin(w, r, user, false)
+}
+
+// checkCredentials reads an email and password from the request and returns
+// their user. When they are wrong, it answers the request and returns nil.
+func (app *OnlineStore) checkCredentials(w http.ResponseWriter, r *http.Request) *schema.User {
 	var creds Credentials
 
 	// read a json payload
@@ -26,26 +36,118 @@ func (app *OnlineStore) authenticate(w http.ResponseWriter, r *http.Request) {
 	if err != nil {
 		log.Println("Error decoding JSON:", err)
 		app.SendResponse(w, http.StatusBadRequest, err.Error())
-		return
+		return nil
+	}
+
+	// refuse throttled accounts and IPs before spending time on bcrypt
+	ip := clientIP(r)
+	if wait := app.loginRetryAfter(creds.UserName, ip); wait > 0 {
+		app.recordAuthEvent(r, schema.AuthEvent{Type: eventLogin, Outcome: outcomeFailure, Reason: reasonThrottled, Email: creds.UserName})
+		app.sendTooManyAttempts(w, wait)
+		return nil
 	}
 
 	// lock up the user by email address
 	user, err := app.DB.GetUserByEmail(creds.UserName)
-	if err != nil {
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
 		log.Println("Error getting user by email:", err)
-		app.SendResponse(w, http.StatusUnauthorized, errors.New("unauthorized"))
-		return
+		app.SendError(w, http.StatusInternalServerError, errors.New("could not log in"))
+		return nil
+	}
+	var userID *int
+	hash := dummyPasswordHash
+	if user != nil {
+		userID = &user.ID
+		hash = user.Password
 	}
 
-	// check password
-	err = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(creds.Password))
+	if wait := app.countLoginAttempt(creds.UserName, ip, userID); wait > 0 {
+		app.recordAuthEvent(r, schema.AuthEvent{Type: eventLogin, Outcome: outcomeFailure, Reason: reasonThrottled, UserID: userID, Email: creds.UserName})
+		app.sendTooManyAttempts(w, wait)
+		return nil
+	}
+
+	// check password, against a stand-in hash for unknown emails so that
+	// they take as long as wrong passwords
+	err = bcrypt.CompareHashAndPassword([]byte(hash), []byte(creds.Password))
+	if user == nil {
+		app.lockFailedLogin(creds.UserName, ip, nil)
+		app.recordAuthEvent(r, schema.AuthEvent{Type: eventLogin, Outcome: outcomeFailure, Reason: reasonUnknownEmail, Email: creds.UserName})
+		app.SendResponse(w, http.StatusUnauthorized, errors.New("unauthorized"))
+		return nil
+	}
 	if err != nil {
 		log.Println("Error comparing password:", err)
+		app.lockFailedLogin(creds.UserName, ip, userID)
+		app.recordAuthEvent(r, schema.AuthEvent{Type: eventLogin, Outcome: outcomeFailure, Reason: reasonWrongPassword, UserID: userID, Email: creds.UserName})
 		app.SendResponse(w, http.StatusUnauthorized, errors.New("unauthorized"))
+		return nil
+	}
+	// the account's failures are only cleared by finishLogin, once a second
+	// factor, if any, was right too
+	app.forgiveLoginAttempt(creds.UserName, ip)
+	return user
+}
+
+// dummyPasswordHash is compared with the password of logins for unknown
+// emails. Its cost is that of stored passwords.
+const dummyPasswordHash = "$2a$12$OIFHQAtTS3sACVEkDrwqFuP7KV30ugRmHj9mlsq0FU4BWzD0fstcq"
+
+// completeLogin answers a login once the user has proven who they are: with a
+// token pair or a cookie session, or with a challenge when two-factor
+// authentication is on.
+func (app *OnlineStore) completeLogin(w http.ResponseWriter, r *http.Request, user *schema.User, session bool) {
+	if user.DeactivatedAt != nil {
+		app.recordAuthEvent(r, schema.AuthEvent{Type: eventLogin, Outcome: outcomeFailure, Reason: reasonDeactivated, UserID: &user.ID, Email: user.Email})
+		app.SendError(w, http.StatusForbidden, errAccountDeactivated)
+		return
+	}
+	if user.EmailVerifiedAt == nil && app.Cfgs.EMAIL_VERIFICATION == emailVerificationRequired {
+		app.recordAuthEvent(r, schema.AuthEvent{Type: eventLogin, Outcome: outcomeFailure, Reason: reasonEmailUnverified, UserID: &user.ID, Email: user.Email})
+		app.SendError(w, http.StatusForbidden, errors.New("email address not verified"))
+		return
+	}
+	// with two-factor on, the password only earns a challenge for the code
+	if user.TOTPEnabledAt != nil {
+		challenge, err := app.generateChallengeToken(user, session)
+		if err != nil {
+			log.Println("Error generating challenge token:", err)
+			app.SendResponse(w, http.StatusUnauthorized, errors.New("unauthorized"))
+			return
+		}
+		app.SendResponse(w, http.StatusOK, TwoFactorChallenge{
+			TwoFactorRequired: true,
+			ChallengeToken:    challenge,
+		})
 		return
 	}
+
+	app.finishLogin(w, r, user, false, session)
+}
+
+// finishLogin hands out a token pair, or starts a cookie session when session
+// is set. mfa records whether a second factor was used.
+func (app *OnlineStore) finishLogin(w http.ResponseWriter, r *http.Request, user *schema.User, mfa, session bool) {
+	// the account may have been deactivated while a second factor was asked for
+	if user.DeactivatedAt != nil {
+		app.recordAuthEvent(r, schema.AuthEvent{Type: eventLogin, Outcome: outcomeFailure, Reason: reasonDeactivated, UserID: &user.ID, Email: user.Email})
+		app.SendError(w, http.StatusForbidden, errAccountDeactivated)
+		return
+	}
+	if err := app.DB.ClearLoginFailures(throttleAccount, accountThrottleKey(user.Email)); err != nil {
+		log.Println("Error clearing login failures:", err)
+	}
+	app.recordAuthEvent(r, schema.AuthEvent{Type: eventLogin, Outcome: outcomeSuccess, UserID: &user.ID, Email: user.Email})
+	if user.DeletionScheduledAt != nil {
+		app.cancelUserDeletion(r, user)
+	}
+	if session {
+		app.startSession(w, r, user, mfa)
+		return
+	}
+
 	// generate tokens
-	tokenPairs, err := app.generateTokenPair(user)
+	tokenPairs, err := app.issueTokenPair(user, mfa)
 	if err != nil {
 		log.Println("Error generating token pair:", err)
 		app.SendResponse(w, http.StatusUnauthorized, errors.New("unauthorized"))
@@ -55,6 +157,41 @@ func (app *OnlineStore) authenticate(w http.ResponseWriter, r *http.Request) {
 	app.SendResponse(w, http.StatusOK, tokenPairs)
 }
 
+var errAccountDeactivated = errors.New("account deactivated")
+
+var errDeletionScheduled = errors.New("account scheduled for deletion")
+
+const refreshTokenCookie = "__Host-refresh_token"
+
+// refreshTokenFromRequest reads the refresh token from the form, falling back
+// to the cookie set by refresh.
+func refreshTokenFromRequest(r *http.Request) string {
+	if token := r.Form.Get("refresh_token"); token != "" {
+		return token
+	}
+	if cookie, err := r.Cookie(refreshTokenCookie); err == nil {
+		return cookie.Value
+	}
+	return ""
+}
+
+func (app *OnlineStore) setRefreshTokenCookie(w http.ResponseWriter, value string, maxAge time.Duration) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     refreshTokenCookie,
+		Path:     "/",
+		Value:    value,
+		Expires:  time.Now().Add(maxAge),
+		MaxAge:   int(maxAge.Seconds()),
+		SameSite: http.SameSiteStrictMode,
+		Domain:   "localhost",
+		HttpOnly: true,
+		Secure:   true,
+	})
+}
+
+// refresh exchanges a refresh token for a new token pair. Every refresh token
+// can be used once; presenting one that was already rotated revokes its whole
+// family, since either the client or an attacker holds a stolen copy.
 func (app *OnlineStore) refresh(w http.ResponseWriter, r *http.Request) {
 	err := r.ParseForm()
 	if err != nil {
@@ -63,74 +200,162 @@ func (app *OnlineStore) refresh(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	refreshToken := r.Form.Get("refresh_token")
-	claims := &Claims{}
-	_, err = jwt.ParseWithClaims(refreshToken, claims, func(t *jwt.Token) (interface{}, error) {
-		return []byte(app.Cfgs.JWT_SECRET), nil
-	})
-
+	claims, err := app.parseRefreshToken(refreshTokenFromRequest(r))
 	if err != nil {
 		log.Println("Error parsing refresh token:", err)
-		app.SendResponse(w, http.StatusBadRequest, err)
+		app.recordAuthEvent(r, schema.AuthEvent{Type: eventTokenRefresh, Outcome: outcomeFailure, Reason: reasonInvalidToken})
+		app.SendError(w, http.StatusBadRequest, err)
 		return
 	}
 
-	if time.Unix(claims.ExpiresAt.Unix(), 0).Sub(time.Now()) > 30*time.Second {
-		log.Println("Refresh token does not need renewed yet")
-		app.SendResponse(w, http.StatusTooEarly, errors.New("refresh toekn does not need renewed yet"))
+	stored, err := app.DB.GetRefreshToken(claims.ID)
+	if err != nil {
+		log.Println("Error getting refresh token:", err)
+		app.recordAuthEvent(r, schema.AuthEvent{Type: eventTokenRefresh, Outcome: outcomeFailure, Reason: reasonInvalidToken})
+		app.SendError(w, http.StatusUnauthorized, errors.New("unknown refresh token"))
 		return
 	}
 
-	// get the user id from the claims
-	userID, err := strconv.Atoi(claims.Subject)
-	if err != nil {
-		log.Println("Error getting user id from claims:", err)
-		app.SendResponse(w, http.StatusBadRequest, errors.New("unknow user"))
+	if stored.RevokedAt != nil {
+		app.recordAuthEvent(r, schema.AuthEvent{Type: eventTokenRefresh, Outcome: outcomeFailure, Reason: reasonTokenRevoked, UserID: &stored.UserID})
+		app.SendError(w, http.StatusUnauthorized, errors.New("refresh token revoked"))
+		return
+	}
+
+	if stored.ReplacedBy != "" {
+		app.revokeReusedFamily(w, r, stored)
 		return
 	}
 
-	user, err := app.DB.GetUser(userID)
+	user, err := app.DB.GetUser(stored.UserID)
 	if err != nil {
 		log.Println("Error getting user:", err)
-		app.SendResponse(w, http.StatusBadRequest, err)
+		app.recordAuthEvent(r, schema.AuthEvent{Type: eventTokenRefresh, Outcome: outcomeFailure, Reason: reasonUnknownUser, UserID: &stored.UserID})
+		app.SendError(w, http.StatusBadRequest, errors.New("unknown user"))
+		return
+	}
+	if user.DeactivatedAt != nil {
+		app.recordAuthEvent(r, schema.AuthEvent{Type: eventTokenRefresh, Outcome: outcomeFailure, Reason: reasonDeactivated, UserID: &user.ID})
+		app.SendError(w, http.StatusForbidden, errAccountDeactivated)
 		return
 	}
 
-	tokenPairs, err := app.generateTokenPair(user)
+	tokenPairs, next, err := app.generateTokenPair(user, stored.FamilyID, stored.MFA)
 	if err != nil {
 		log.Println("Error generating token pair:", err)
-		app.SendResponse(w, http.StatusBadRequest, err)
+		app.SendError(w, http.StatusBadRequest, err)
 		return
 	}
 
-	http.SetCookie(w, &http.Cookie{
-		Name:     "__Host-refresh_token",
-		Path:     "/",
-		Value:    tokenPairs.RefreshToken,
-		Expires:  time.Now().Add(refreshTokenExpiry),
-		MaxAge:   int(refreshTokenExpiry.Seconds()),
-		SameSite: http.SameSiteStrictMode,
-		Domain:   "localhost",
-		HttpOnly: true,
-		Secure:   true,
-	})
+	err = app.DB.RotateRefreshToken(stored.JTI, next)
+	if errors.Is(err, databases.ErrRefreshTokenRotated) {
+		app.revokeReusedFamily(w, r, stored)
+		return
+	}
+	if err != nil {
+		log.Println("Error rotating refresh token:", err)
+		app.SendError(w, http.StatusInternalServerError, errors.New("could not rotate refresh token"))
+		return
+	}
 
+	app.setRefreshTokenCookie(w, tokenPairs.RefreshToken, refreshTokenExpiry)
+
+	app.recordAuthEvent(r, schema.AuthEvent{Type: eventTokenRefresh, Outcome: outcomeSuccess, UserID: &user.ID})
 	app.SendResponse(w, http.StatusOK, tokenPairs)
 }
 
+func (app *OnlineStore) revokeReusedFamily(w http.ResponseWriter, r *http.Request, stored *schema.RefreshToken) {
+	log.Printf("Refresh token reuse detected for user %d, revoking family %s", stored.UserID, stored.FamilyID)
+	app.recordAuthEvent(r, schema.AuthEvent{Type: eventTokenRefresh, Outcome: outcomeFailure, Reason: reasonTokenReused, UserID: &stored.UserID})
+	if err := app.DB.RevokeRefreshTokenFamily(stored.FamilyID); err != nil {
+		log.Println("Error revoking refresh token family:", err)
+	}
+	app.SendError(w, http.StatusUnauthorized, errors.New("refresh token reuse detected"))
+}
+
+// logout revokes the family of the presented refresh token, ending that one
+// session.
+func (app *OnlineStore) logout(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		log.Println("Error parsing form:", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	claims, err := app.parseRefreshToken(refreshTokenFromRequest(r))
+	if err != nil {
+		log.Println("Error parsing refresh token:", err)
+		app.SendError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	stored, err := app.DB.GetRefreshToken(claims.ID)
+	if err != nil {
+		log.Println("Error getting refresh token:", err)
+		app.SendError(w, http.StatusUnauthorized, errors.New("unknown refresh token"))
+		return
+	}
+
+	if err := app.DB.RevokeRefreshTokenFamily(stored.FamilyID); err != nil {
+		log.Println("Error revoking refresh token family:", err)
+		app.SendError(w, http.StatusInternalServerError, errors.New("could not log out"))
+		return
+	}
+
+	app.recordAuthEvent(r, schema.AuthEvent{Type: eventLogout, Outcome: outcomeSuccess, UserID: &stored.UserID})
+	app.setRefreshTokenCookie(w, "", -time.Second)
+	app.SendResponse(w, http.StatusOK, nil)
+}
+
+// logoutAll revokes every refresh token of the user owning the access token.
+func (app *OnlineStore) logoutAll(w http.ResponseWriter, r *http.Request) {
+	userID, err := app.authenticatedUserID(w, r)
+	if err != nil {
+		app.SendError(w, http.StatusUnauthorized, errors.New("unauthorized"))
+		return
+	}
+
+	if err := app.DB.RevokeUserRefreshTokens(userID); err != nil {
+		log.Println("Error revoking refresh tokens:", err)
+		app.SendError(w, http.StatusInternalServerError, errors.New("could not log out"))
+		return
+	}
+
+	app.recordAuthEvent(r, schema.AuthEvent{Type: eventLogoutAll, Outcome: outcomeSuccess, UserID: &userID})
+	app.setRefreshTokenCookie(w, "", -time.Second)
+	app.SendResponse(w, http.StatusOK, nil)
+}
+
+// CreateUser registers a user. Only the name, email and password are taken
+// from the request: new users are never admins, they are promoted by one.
 func (app *OnlineStore) CreateUser(w http.ResponseWriter, r *http.Request) {
-	var user schema.User
-	err := json.NewDecoder(r.Body).Decode(&user)
+	var request struct {
+		Name     string `json:"name"`
+		Email    string `json:"email"`
+		Password string `json:"password"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&request)
 	if err != nil {
 		log.Println("Error decoding JSON:", err)
 		app.SendResponse(w, http.StatusBadRequest, err)
 		return
 	}
+	user := schema.User{Name: request.Name, Email: request.Email, Password: request.Password}
+	if !app.checkPassword(w, "password", user.Password) {
+		return
+	}
 	userID, err := app.DB.InsertUser(user)
 	if err != nil {
 		log.Println("Error inserting user:", err)
 		app.SendResponse(w, http.StatusBadRequest, err)
 		return
 	}
+	app.recordAuthEvent(r, schema.AuthEvent{Type: eventRegister, Outcome: outcomeSuccess, UserID: &userID, Email: user.Email})
+
+	err = app.startEmailVerification(userID, user.Name, user.Email)
+	if err != nil {
+		log.Println("Error starting email verification:", err)
+	}
 	app.SendResponse(w, http.StatusOK, userID)
 }
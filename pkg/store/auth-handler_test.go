@@ -4,8 +4,14 @@ import (
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"strings"
 	"testing"
+	"time"
+
+	"github.com/MinhNHHH/online-store/pkg/databases/repositories/dbrepo"
+	"github.com/MinhNHHH/online-store/pkg/databases/schema"
+	"github.com/golang-jwt/jwt/v4"
 )
 
 func Test_app_authenticate(t *testing.T) {
@@ -20,6 +26,7 @@ func Test_app_authenticate(t *testing.T) {
 		{"empty json", `{}`, http.StatusUnauthorized},
 		{"empty email", `{"email": ""}`, http.StatusUnauthorized},
 		{"empty password", `{"email": "admin@example.com"}`, http.StatusUnauthorized},
+		{"deactivated user", `{"email":"deactivated@example.com", "password":"secret"}`, http.StatusForbidden},
 	}
 
 	for _, e := range theTests {
@@ -34,3 +41,123 @@ func Test_app_authenticate(t *testing.T) {
 		}
 	}
 }
+
+func signedRefreshToken(t *testing.T, jti string) string {
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"sub": "1",
+		"jti": jti,
+		"fam": "family",
+		"exp": time.Now().Add(time.Hour).Unix(),
+	})
+	signed, err := token.SignedString([]byte(app.Cfgs.JWT_SECRET))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+func Test_app_refresh(t *testing.T) {
+	var theTests = []struct {
+		name               string
+		refreshToken       string
+		expectedStatusCode int
+	}{
+		{"valid token", signedRefreshToken(t, "valid-jti"), http.StatusOK},
+		{"rotated token", signedRefreshToken(t, "rotated-jti"), http.StatusUnauthorized},
+		{"unknown token", signedRefreshToken(t, "unknown-jti"), http.StatusUnauthorized},
+		{"garbage token", "not-a-token", http.StatusBadRequest},
+		{"deactivated user", signedRefreshToken(t, "deactivated-jti"), http.StatusForbidden},
+	}
+
+	for _, e := range theTests {
+		form := url.Values{"refresh_token": {e.refreshToken}}
+		req, _ := http.NewRequest("POST", "/api/v1/refresh-token", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(app.refresh)
+		handler.ServeHTTP(rr, req)
+		if e.expectedStatusCode != rr.Code {
+			t.Errorf("%s: returned wrong status code; expected %d but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+	}
+}
+
+func Test_app_logout(t *testing.T) {
+	var theTests = []struct {
+		name               string
+		refreshToken       string
+		expectedStatusCode int
+	}{
+		{"valid token", signedRefreshToken(t, "valid-jti"), http.StatusOK},
+		{"unknown token", signedRefreshToken(t, "unknown-jti"), http.StatusUnauthorized},
+		{"missing token", "", http.StatusBadRequest},
+	}
+
+	for _, e := range theTests {
+		form := url.Values{"refresh_token": {e.refreshToken}}
+		req, _ := http.NewRequest("POST", "/api/v1/auth/logout", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(app.logout)
+		handler.ServeHTTP(rr, req)
+		if e.expectedStatusCode != rr.Code {
+			t.Errorf("%s: returned wrong status code; expected %d but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+	}
+}
+
+func Test_app_logoutAll(t *testing.T) {
+	tokens, _, _ := app.generateTokenPair(&schema.User{ID: 1, Name: "Admin"}, "", false)
+
+	var theTests = []struct {
+		name               string
+		token              string
+		expectedStatusCode int
+	}{
+		{"valid token", "Bearer " + tokens.Token, http.StatusOK},
+		{"no token", "", http.StatusUnauthorized},
+	}
+
+	for _, e := range theTests {
+		req, _ := http.NewRequest("POST", "/api/v1/auth/logout-all", nil)
+		if e.token != "" {
+			req.Header.Set("Authorization", e.token)
+		}
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(app.logoutAll)
+		handler.ServeHTTP(rr, req)
+		if e.expectedStatusCode != rr.Code {
+			t.Errorf("%s: returned wrong status code; expected %d but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+	}
+}
+
+// userRecorder keeps the last user inserted.
+type userRecorder struct {
+	dbrepo.TestDBRepo
+	user schema.User
+}
+
+func (u *userRecorder) InsertUser(user schema.User) (int, error) {
+	u.user = user
+	return 5, nil
+}
+
+func Test_app_CreateUserIsNotAdmin(t *testing.T) {
+	recorder := &userRecorder{}
+	testApp := app
+	testApp.DB = recorder
+
+	body := `{"name": "Mallory", "email": "mallory@example.com", "password": "correct horse battery", "is_admin": true}`
+	req, _ := http.NewRequest("POST", "/api/v1/auth/register", strings.NewReader(body))
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(testApp.CreateUser)
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("returned wrong status code; expected %d but got %d: %s", http.StatusOK, rr.Code, rr.Body)
+	}
+	if recorder.user.Email != "mallory@example.com" || recorder.user.IsAdmin {
+		t.Errorf("expected a non-admin user mallory@example.com but got %+v", recorder.user)
+	}
+}
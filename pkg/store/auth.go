@@ -1,6 +1,8 @@
 package store
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"net/http"
@@ -19,8 +21,22 @@ type TokenPairs struct {
 	RefreshToken string `json:"refresh_token"`
 }
 
+type RefreshClaims struct {
+	Family string `json:"fam"`
+	jwt.RegisteredClaims
+}
+
 type Claims struct {
-	UserName string `json:"name"`
+	UserName      string `json:"name"`
+	Admin         bool   `json:"admin"`
+	EmailVerified bool   `json:"email_verified"`
+	// MFA is true when the session was started with a second factor.
+	MFA bool `json:"mfa"`
+	// Type is empty for access tokens and names the purpose of any other
+	// token signed with the same key, such as a two-factor challenge.
+	Type string `json:"typ,omitempty"`
+	// Session is set on two-factor challenges of cookie session logins.
+	Session bool `json:"ses,omitempty"`
 	jwt.RegisteredClaims
 }
 
@@ -46,12 +62,7 @@ func (app *OnlineStore) getTokenFromHeaderandVerify(w http.ResponseWriter, r *ht
 
 	claims := &Claims{}
 
-	_, err := jwt.ParseWithClaims(token, claims, func(t *jwt.Token) (interface{}, error) {
-		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", t.Header["alg"])
-		}
-		return []byte(app.Cfgs.JWT_SECRET), nil
-	})
+	_, err := jwt.ParseWithClaims(token, claims, app.keys().Keyfunc)
 
 	if err != nil {
 		if strings.HasPrefix(err.Error(), "token is expired by") {
@@ -64,45 +75,113 @@ func (app *OnlineStore) getTokenFromHeaderandVerify(w http.ResponseWriter, r *ht
 		return "", nil, errors.New("incorrect issuer")
 	}
 
+	if claims.Type != "" {
+		return "", nil, errors.New("not an access token")
+	}
+
 	return token, claims, nil
 }
 
-func (app *OnlineStore) generateTokenPair(user *schema.User) (TokenPairs, error) {
-	token := jwt.New(jwt.SigningMethodHS256)
+// authenticatedUserID returns the ID of the user making the request. API keys
+// do not belong to a user.
+func (app *OnlineStore) authenticatedUserID(w http.ResponseWriter, r *http.Request) (int, error) {
+	p, err := app.requestPrincipal(w, r)
+	if err != nil {
+		return 0, err
+	}
+	if p.UserID == 0 {
+		return 0, errors.New("not a user")
+	}
+	return p.UserID, nil
+}
 
-	claims := token.Claims.(jwt.MapClaims)
+// generateTokenPair signs an access token and a refresh token for user. The
+// refresh token joins family, or starts a new family when family is empty. mfa
+// records whether the session was started with a second factor. The returned
+// record must be stored before the refresh token is handed out.
+func (app *OnlineStore) generateTokenPair(user *schema.User, family string, mfa bool) (TokenPairs, *schema.RefreshToken, error) {
+	claims := jwt.MapClaims{}
 	claims["name"] = user.Name
 	claims["sub"] = fmt.Sprint(user.ID)
 	claims["aud"] = app.Cfgs.DOMAIN
 	claims["iss"] = app.Cfgs.DOMAIN
-	if user.IsAdmin {
-		claims["admin"] = true
-	} else {
-		claims["admin"] = false
-	}
+	claims["admin"] = user.IsAdmin
+	claims["email_verified"] = user.EmailVerifiedAt != nil
+	claims["mfa"] = mfa
 
 	claims["exp"] = time.Now().Add(jwtTokenExpiry).Unix()
 
-	signedAccessToken, err := token.SignedString([]byte(app.Cfgs.JWT_SECRET))
+	signedAccessToken, err := app.keys().Sign(claims)
 	if err != nil {
-		return TokenPairs{}, err
+		return TokenPairs{}, nil, err
+	}
+
+	jti, err := randomToken(16)
+	if err != nil {
+		return TokenPairs{}, nil, err
+	}
+	if family == "" {
+		family = jti
+	}
+	record := &schema.RefreshToken{
+		JTI:       jti,
+		UserID:    user.ID,
+		FamilyID:  family,
+		MFA:       mfa,
+		ExpiresAt: time.Now().Add(refreshTokenExpiry),
 	}
 
-	refreshToken := jwt.New(jwt.SigningMethodHS256)
-	refreshTokenClaims := refreshToken.Claims.(jwt.MapClaims)
+	refreshTokenClaims := jwt.MapClaims{}
 
 	refreshTokenClaims["sub"] = fmt.Sprint(user.ID)
+	refreshTokenClaims["jti"] = record.JTI
+	refreshTokenClaims["fam"] = record.FamilyID
 
-	refreshTokenClaims["exp"] = time.Now().Add(refreshTokenExpiry).Unix()
+	refreshTokenClaims["exp"] = record.ExpiresAt.Unix()
 
-	signedRefreshToken, err := refreshToken.SignedString([]byte(app.Cfgs.JWT_SECRET))
+	signedRefreshToken, err := app.keys().Sign(refreshTokenClaims)
 	if err != nil {
-		return TokenPairs{}, nil
+		return TokenPairs{}, nil, err
 	}
 
 	var tokenPairs = TokenPairs{
 		Token:        signedAccessToken,
 		RefreshToken: signedRefreshToken,
 	}
+	return tokenPairs, record, nil
+}
+
+// issueTokenPair starts a new refresh token family for user, as on login.
+func (app *OnlineStore) issueTokenPair(user *schema.User, mfa bool) (TokenPairs, error) {
+	tokenPairs, record, err := app.generateTokenPair(user, "", mfa)
+	if err != nil {
+		return TokenPairs{}, err
+	}
+	if err := app.DB.InsertRefreshToken(record); err != nil {
+		return TokenPairs{}, err
+	}
 	return tokenPairs, nil
 }
+
+// parseRefreshToken verifies the signature and expiry of a refresh token.
+// Whether it is still usable is decided by the stored record.
+func (app *OnlineStore) parseRefreshToken(refreshToken string) (*RefreshClaims, error) {
+	claims := &RefreshClaims{}
+	_, err := jwt.ParseWithClaims(refreshToken, claims, app.keys().Keyfunc)
+	if err != nil {
+		return nil, err
+	}
+	if claims.ID == "" {
+		return nil, errors.New("refresh token has no jti")
+	}
+	return claims, nil
+}
+
+// randomToken returns n random bytes encoded as hex.
+func randomToken(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -1,27 +1,30 @@
 package store
 
 import (
+	"database/sql"
 	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
-	"strconv"
 
+	databases "github.com/MinhNHHH/online-store/pkg/databases/repositories"
 	"github.com/MinhNHHH/online-store/pkg/databases/schema"
-	"github.com/go-chi/chi"
+	"github.com/MinhNHHH/online-store/pkg/slug"
 )
 
 func (app *OnlineStore) GetCategories(w http.ResponseWriter, r *http.Request) {
 	categoryName := r.URL.Query().Get("category_name")
-	page, err := strconv.Atoi(r.URL.Query().Get("page"))
-	if err != nil {
-		page = 1
-	}
-	pageSize, err := strconv.Atoi(r.URL.Query().Get("page_size"))
-	if err != nil {
-		pageSize = 10
+	page, fields := readPage(r.URL.Query())
+	if len(fields) > 0 {
+		app.SendValidationError(w, fields)
+		return
 	}
 
-	categories, total, err := app.DB.AllCategories(categoryName, page, pageSize)
+	categories, info, err := app.DB.AllCategories(categoryName, page)
+	if errors.Is(err, databases.ErrInvalidCursor) {
+		app.SendValidationError(w, []FieldError{invalidCursorField})
+		return
+	}
 	if err != nil {
 		log.Printf("Error getting categories: %v", err)
 		app.SendResponse(w, http.StatusBadRequest, err)
@@ -30,20 +33,43 @@ func (app *OnlineStore) GetCategories(w http.ResponseWriter, r *http.Request) {
 
 	response := struct {
 		Categories []*schema.Category `json:"categories"`
-		TotalCount int                `json:"total_count"`
-		Page       int                `json:"page"`
-		PageSize   int                `json:"page_size"`
-		TotalPages int                `json:"total_pages"`
+		listPage
 	}{
 		Categories: categories,
-		TotalCount: total,
-		Page:       page,
-		PageSize:   pageSize,
-		TotalPages: (total + 9) / 10,
+		listPage:   pageLinks(w, r, page, info),
 	}
 	app.SendResponse(w, http.StatusOK, response)
 }
 
+var unknownParentField = FieldError{Field: "parent_id", Code: "unknown", Message: "is not a category"}
+
+// GetCategoryTree sends every category, with the categories under it nested
+// in children.
+func (app *OnlineStore) GetCategoryTree(w http.ResponseWriter, r *http.Request) {
+	categories, err := app.DB.CategoryTree()
+	if err != nil {
+		log.Printf("Error getting category tree: %v", err)
+		app.SendError(w, http.StatusInternalServerError, errors.New("could not get categories"))
+		return
+	}
+
+	response := struct {
+		Categories []*schema.Category `json:"categories"`
+	}{
+		Categories: categories,
+	}
+	app.SendResponse(w, http.StatusOK, response)
+}
+
+// GetCategory sends one category, named by its ID or its slug.
+func (app *OnlineStore) GetCategory(w http.ResponseWriter, r *http.Request) {
+	category, ok := app.categoryFromURL(w, r)
+	if !ok {
+		return
+	}
+	app.SendResponse(w, http.StatusOK, category)
+}
+
 func (app *OnlineStore) CreateCategory(w http.ResponseWriter, r *http.Request) {
 	var category schema.Category
 	err := json.NewDecoder(r.Body).Decode(&category)
@@ -54,6 +80,10 @@ func (app *OnlineStore) CreateCategory(w http.ResponseWriter, r *http.Request) {
 	}
 
 	id, err := app.DB.InsertCategory(&category)
+	if errors.Is(err, databases.ErrParentCategoryNotFound) {
+		app.SendValidationError(w, []FieldError{unknownParentField})
+		return
+	}
 	if err != nil {
 		log.Printf("Error inserting category: %v", err)
 		app.SendResponse(w, http.StatusBadRequest, err)
@@ -68,7 +98,13 @@ func (app *OnlineStore) CreateCategory(w http.ResponseWriter, r *http.Request) {
 	app.SendResponse(w, http.StatusCreated, response)
 }
 
+// UpdateCategory updates the category named by the URL. A slug in the body
+// renames the category; its old slug keeps leading to it.
 func (app *OnlineStore) UpdateCategory(w http.ResponseWriter, r *http.Request) {
+	id, ok := app.categoryIDFromURL(w, r)
+	if !ok {
+		return
+	}
 	var category schema.Category
 	err := json.NewDecoder(r.Body).Decode(&category)
 	if err != nil {
@@ -76,8 +112,17 @@ func (app *OnlineStore) UpdateCategory(w http.ResponseWriter, r *http.Request) {
 		app.SendResponse(w, http.StatusBadRequest, err)
 		return
 	}
+	category.ID = id
+	if category.Slug != "" && !slug.Valid(category.Slug) {
+		app.SendValidationError(w, []FieldError{invalidSlugField})
+		return
+	}
 
 	err = app.DB.UpdateCategory(&category)
+	if errors.Is(err, databases.ErrSlugTaken) {
+		app.SendError(w, http.StatusConflict, err)
+		return
+	}
 	if err != nil {
 		log.Printf("Error updating category: %v", err)
 		app.SendResponse(w, http.StatusBadRequest, err)
@@ -87,14 +132,12 @@ func (app *OnlineStore) UpdateCategory(w http.ResponseWriter, r *http.Request) {
 }
 
 func (app *OnlineStore) DeleteCategory(w http.ResponseWriter, r *http.Request) {
-	id, err := strconv.Atoi(chi.URLParam(r, "id"))
-	if err != nil {
-		log.Printf("Error parsing category ID: %v", err)
-		app.SendResponse(w, http.StatusBadRequest, err)
+	id, ok := app.categoryIDFromURL(w, r)
+	if !ok {
 		return
 	}
 
-	err = app.DB.DeleteCategory(id)
+	err := app.DB.DeleteCategory(id)
 	if err != nil {
 		log.Printf("Error deleting category: %v", err)
 		app.SendResponse(w, http.StatusBadRequest, err)
@@ -102,3 +145,35 @@ func (app *OnlineStore) DeleteCategory(w http.ResponseWriter, r *http.Request) {
 	}
 	app.SendResponse(w, http.StatusOK, nil)
 }
+
+// MoveCategory puts the category named by the URL, with everything under it,
+// under parent_id, or at the top of the tree when parent_id is null.
+func (app *OnlineStore) MoveCategory(w http.ResponseWriter, r *http.Request) {
+	id, ok := app.categoryIDFromURL(w, r)
+	if !ok {
+		return
+	}
+	var request struct {
+		ParentID *int `json:"parent_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		log.Printf("Error decoding request: %v", err)
+		app.SendError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	err := app.DB.MoveCategory(id, request.ParentID)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		app.SendError(w, http.StatusNotFound, errors.New("category not found"))
+	case errors.Is(err, databases.ErrParentCategoryNotFound):
+		app.SendValidationError(w, []FieldError{unknownParentField})
+	case errors.Is(err, databases.ErrCategoryCycle):
+		app.SendValidationError(w, []FieldError{{Field: "parent_id", Code: "invalid", Message: "cannot be the category or one under it"}})
+	case err != nil:
+		log.Printf("Error moving category: %v", err)
+		app.SendError(w, http.StatusInternalServerError, errors.New("could not move category"))
+	default:
+		app.SendResponse(w, http.StatusOK, nil)
+	}
+}
@@ -9,6 +9,7 @@ import (
 	"net/http/httptest"
 	"testing"
 
+	"github.com/MinhNHHH/online-store/pkg/databases/schema"
 	"github.com/go-chi/chi"
 )
 
@@ -33,6 +34,16 @@ func Test_app_GetCategories(t *testing.T) {
 			queryParams:        "?page=1&page_size=5",
 			expectedStatusCode: http.StatusOK,
 		},
+		{
+			name:               "page size too large",
+			queryParams:        "?page_size=500",
+			expectedStatusCode: http.StatusBadRequest,
+		},
+		{
+			name:               "cursor not from a list",
+			queryParams:        "?cursor=abc",
+			expectedStatusCode: http.StatusBadRequest,
+		},
 	}
 
 	for _, e := range tests {
@@ -58,6 +69,16 @@ func Test_app_CreateCategory(t *testing.T) {
 			requestBody:        `{"name": "Electronics", "description": "Electronic devices and accessories"}`,
 			expectedStatusCode: http.StatusCreated,
 		},
+		{
+			name:               "under a parent",
+			requestBody:        `{"name": "Men", "parent_id": 2}`,
+			expectedStatusCode: http.StatusCreated,
+		},
+		{
+			name:               "unknown parent",
+			requestBody:        `{"name": "Men", "parent_id": 99}`,
+			expectedStatusCode: http.StatusBadRequest,
+		},
 	}
 
 	for _, e := range tests {
@@ -100,6 +121,30 @@ func Test_app_UpdateCategory(t *testing.T) {
 			requestBody:        `{"id": 1, "name": "Updated Electronics", "description": "Updated description"}`,
 			expectedStatusCode: http.StatusOK,
 		},
+		{
+			name:               "by old slug",
+			categoryID:         "tops",
+			requestBody:        `{"name": "Shirts", "slug": "shirts"}`,
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			name:               "invalid slug",
+			categoryID:         "1",
+			requestBody:        `{"name": "Shirts", "slug": "Shirts & Tops"}`,
+			expectedStatusCode: http.StatusBadRequest,
+		},
+		{
+			name:               "slug taken",
+			categoryID:         "1",
+			requestBody:        `{"name": "Shirts", "slug": "taken"}`,
+			expectedStatusCode: http.StatusConflict,
+		},
+		{
+			name:               "unknown slug",
+			categoryID:         "shoes",
+			requestBody:        `{"name": "Shoes"}`,
+			expectedStatusCode: http.StatusNotFound,
+		},
 	}
 
 	for _, e := range tests {
@@ -132,6 +177,11 @@ func Test_app_DeleteCategory(t *testing.T) {
 			categoryID:         "1",
 			expectedStatusCode: http.StatusOK,
 		},
+		{
+			name:               "delete by slug",
+			categoryID:         "shirts",
+			expectedStatusCode: http.StatusOK,
+		},
 	}
 
 	for _, e := range tests {
@@ -151,3 +201,89 @@ func Test_app_DeleteCategory(t *testing.T) {
 		}
 	}
 }
+
+func Test_app_GetCategory(t *testing.T) {
+	var tests = []struct {
+		name               string
+		categoryID         string
+		expectedStatusCode int
+		expectedLocation   string
+	}{
+		{"by id", "1", http.StatusOK, ""},
+		{"by slug", "shirts", http.StatusOK, ""},
+		{"old slug", "tops", http.StatusMovedPermanently, "/api/v1/categories/shirts?page=2"},
+		{"missing id", "3", http.StatusNotFound, ""},
+		{"missing slug", "shoes", http.StatusNotFound, ""},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("GET", "/api/v1/categories/"+e.categoryID+"?page=2", nil)
+		rr := httptest.NewRecorder()
+
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("id", e.categoryID)
+		req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+		handler := http.HandlerFunc(app.GetCategory)
+		handler.ServeHTTP(rr, req)
+
+		if e.expectedStatusCode != rr.Code {
+			t.Errorf("%s: returned wrong status code; expected %d but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+		if location := rr.Header().Get("Location"); location != e.expectedLocation {
+			t.Errorf("%s: expected location %q but got %q", e.name, e.expectedLocation, location)
+		}
+	}
+}
+
+func Test_app_GetCategoryTree(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/api/v1/categories/tree", nil)
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(app.GetCategoryTree)
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("returned wrong status code; expected %d but got %d", http.StatusOK, rr.Code)
+	}
+	var response struct {
+		Categories []*schema.Category `json:"categories"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+		t.Fatal(err)
+	}
+	if len(response.Categories) != 1 || len(response.Categories[0].Children) != 1 || response.Categories[0].Children[0].Slug != "shirts" {
+		t.Errorf("expected shirts under clothing but got %+v", response.Categories)
+	}
+}
+
+func Test_app_MoveCategory(t *testing.T) {
+	var tests = []struct {
+		name               string
+		categoryID         string
+		requestBody        string
+		expectedStatusCode int
+	}{
+		{"under another", "1", `{"parent_id": 2}`, http.StatusOK},
+		{"by slug", "shirts", `{"parent_id": 2}`, http.StatusOK},
+		{"to the top", "1", `{"parent_id": null}`, http.StatusOK},
+		{"under itself", "1", `{"parent_id": 1}`, http.StatusBadRequest},
+		{"under a descendant", "2", `{"parent_id": 1}`, http.StatusBadRequest},
+		{"unknown parent", "1", `{"parent_id": 99}`, http.StatusBadRequest},
+		{"unknown category", "99", `{"parent_id": 2}`, http.StatusNotFound},
+		{"bad body", "1", `{"parent_id": "clothing"}`, http.StatusBadRequest},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("PUT", "/api/v1/categories/"+e.categoryID+"/parent", bytes.NewBufferString(e.requestBody))
+		rr := httptest.NewRecorder()
+
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("id", e.categoryID)
+		req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+		handler := http.HandlerFunc(app.MoveCategory)
+		handler.ServeHTTP(rr, req)
+
+		if e.expectedStatusCode != rr.Code {
+			t.Errorf("%s: returned wrong status code; expected %d but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+	}
+}
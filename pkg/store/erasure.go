@@ -0,0 +1,142 @@
+package store
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/MinhNHHH/online-store/pkg/databases/schema"
+	"github.com/MinhNHHH/online-store/pkg/mailer"
+)
+
+// ExportCurrentUser sends the signed in user a zip archive of everything
+// stored about them: their profile, reviews, wishlist and security events.
+func (app *OnlineStore) ExportCurrentUser(w http.ResponseWriter, r *http.Request) {
+	user := app.currentUser(w, r)
+	if user == nil {
+		return
+	}
+
+	reviews, err := app.DB.ReviewsByUserID(user.ID)
+	if err != nil {
+		log.Printf("Error getting reviews: %v", err)
+		app.SendError(w, http.StatusInternalServerError, errors.New("could not export account"))
+		return
+	}
+	wishlist, err := app.DB.GetWishlist(user.ID)
+	if err != nil {
+		log.Printf("Error getting wishlist: %v", err)
+		app.SendError(w, http.StatusInternalServerError, errors.New("could not export account"))
+		return
+	}
+	app.withImageURLs(wishlist...)
+	events, err := app.userAuthEvents(user.ID)
+	if err != nil {
+		log.Printf("Error getting auth events: %v", err)
+		app.SendError(w, http.StatusInternalServerError, errors.New("could not export account"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", `attachment; filename="account-export.zip"`)
+	w.WriteHeader(http.StatusOK)
+
+	archive := zip.NewWriter(w)
+	for _, file := range []struct {
+		name string
+		data interface{}
+	}{
+		{"profile.json", profileOf(user)},
+		{"reviews.json", reviews},
+		{"wishlist.json", wishlist},
+		{"security-events.json", events},
+	} {
+		f, err := archive.Create(file.name)
+		if err != nil {
+			log.Printf("Error writing account export: %v", err)
+			return
+		}
+		encoder := json.NewEncoder(f)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(file.data); err != nil {
+			log.Printf("Error writing account export: %v", err)
+			return
+		}
+	}
+	if err := archive.Close(); err != nil {
+		log.Printf("Error writing account export: %v", err)
+	}
+}
+
+// userAuthEvents reads every audit log event about the user.
+func (app *OnlineStore) userAuthEvents(userID int) ([]*schema.AuthEvent, error) {
+	var events []*schema.AuthEvent
+	for page := 1; ; page++ {
+		found, total, err := app.DB.AllAuthEvents(schema.AuthEventFilter{UserID: userID}, page, auditExportPageSize)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, found...)
+		if page*auditExportPageSize >= total {
+			return events, nil
+		}
+	}
+}
+
+// cancelUserDeletion restores an account waiting to be purged, as its owner
+// has logged in again.
+func (app *OnlineStore) cancelUserDeletion(r *http.Request, user *schema.User) {
+	if err := app.DB.CancelUserDeletion(user.ID); err != nil {
+		log.Printf("Error cancelling user deletion: %v", err)
+		return
+	}
+	user.DeletionScheduledAt = nil
+	user.DeletionMode = ""
+	app.recordAuthEvent(r, schema.AuthEvent{Type: eventAccountDeletionCancelled, Outcome: outcomeSuccess, UserID: &user.ID, Email: user.Email})
+}
+
+func (app *OnlineStore) sendDeletionScheduledEmail(user *schema.User, deletionAt time.Time) {
+	msg := mailer.Message{
+		To:      []string{user.Email},
+		Subject: "Your account will be deleted",
+		Body: fmt.Sprintf("Hi %s,\n\nAs you asked, your account will be deleted on %s. Until then you can keep it by logging in again.\n\nIf you did not ask for this, log in and change your password.\n",
+			user.Name, deletionAt.UTC().Format("2 January 2006 15:04 MST")),
+	}
+	if err := app.Mailer.Send(msg); err != nil {
+		log.Printf("Error sending deletion email: %v", err)
+	}
+}
+
+// PurgeDeletedUsers deletes the accounts whose grace period is over.
+func (app *OnlineStore) PurgeDeletedUsers() {
+	ids, err := app.DB.PurgeDueUsers(time.Now())
+	if err != nil {
+		log.Printf("Error purging deleted users: %v", err)
+		return
+	}
+	for _, id := range ids {
+		userID := id
+		event := schema.AuthEvent{Type: eventAccountDeleted, Outcome: outcomeSuccess, UserID: &userID}
+		if err := app.DB.InsertAuthEvent(&event); err != nil {
+			log.Printf("Error recording %s event: %v", event.Type, err)
+		}
+	}
+	if len(ids) > 0 {
+		log.Printf("Purged %d deleted users", len(ids))
+	}
+}
+
+// PurgeDeletedUsersEvery runs PurgeDeletedUsers now and then at every
+// interval. It does not return.
+func (app *OnlineStore) PurgeDeletedUsersEvery(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		app.PurgeDeletedUsers()
+		<-ticker.C
+	}
+}
@@ -0,0 +1,132 @@
+package store
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/MinhNHHH/online-store/pkg/databases/schema"
+)
+
+func Test_app_ExportCurrentUser(t *testing.T) {
+	userTokens, _, _ := app.generateTokenPair(&schema.User{ID: 1, Name: "Admin"}, "", false)
+
+	req, _ := http.NewRequest("GET", "/api/v1/users/me/export", nil)
+	req.Header.Set("Authorization", "Bearer "+userTokens.Token)
+	rr := httptest.NewRecorder()
+	app.Routes().ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK || rr.Header().Get("Content-Type") != "application/zip" {
+		t.Fatalf("expected a zip archive but got %d %s", rr.Code, rr.Header().Get("Content-Type"))
+	}
+	archive, err := zip.NewReader(bytes.NewReader(rr.Body.Bytes()), int64(rr.Body.Len()))
+	if err != nil {
+		t.Fatalf("invalid archive: %v", err)
+	}
+
+	contents := map[string][]byte{}
+	for _, f := range archive.File {
+		rc, _ := f.Open()
+		var buf bytes.Buffer
+		buf.ReadFrom(rc)
+		rc.Close()
+		contents[f.Name] = buf.Bytes()
+	}
+	for _, name := range []string{"profile.json", "reviews.json", "wishlist.json", "security-events.json"} {
+		if _, ok := contents[name]; !ok {
+			t.Errorf("expected %s in the archive", name)
+		}
+	}
+
+	var profile UserProfile
+	_ = json.Unmarshal(contents["profile.json"], &profile)
+	if profile.Email != "admin@example.com" {
+		t.Errorf("expected the profile of admin@example.com, got %+v", profile)
+	}
+	if strings.Contains(string(contents["profile.json"]), "$2a$") {
+		t.Error("the export leaks the password hash")
+	}
+	var reviews []*schema.Review
+	_ = json.Unmarshal(contents["reviews.json"], &reviews)
+	if len(reviews) != 1 {
+		t.Errorf("expected one review but got %d", len(reviews))
+	}
+
+	req, _ = http.NewRequest("GET", "/api/v1/users/me/export", nil)
+	rr = httptest.NewRecorder()
+	app.Routes().ServeHTTP(rr, req)
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("without token: expected status 401 but got %d", rr.Code)
+	}
+}
+
+func Test_app_DeleteCurrentUserSchedulesDeletion(t *testing.T) {
+	testApp := app
+	testApp.Cfgs.ACCOUNT_DELETION_GRACE = time.Hour * 24 * 30
+	userTokens, _, _ := testApp.generateTokenPair(&schema.User{ID: 1, Name: "Admin"}, "", false)
+
+	req, _ := http.NewRequest("DELETE", "/api/v1/users/me", strings.NewReader(`{"password":"secret", "mode":"anonymize"}`))
+	req.Header.Set("Authorization", "Bearer "+userTokens.Token)
+	rr := httptest.NewRecorder()
+	testApp.Routes().ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200 but got %d", rr.Code)
+	}
+	var profile UserProfile
+	_ = json.NewDecoder(rr.Body).Decode(&profile)
+	if profile.DeletionMode != schema.DeletionModeAnonymize || profile.DeletionScheduledAt == nil ||
+		profile.DeletionScheduledAt.Before(time.Now().Add(time.Hour*24*29)) {
+		t.Errorf("expected an anonymizing deletion in 30 days, got %+v", profile)
+	}
+}
+
+func Test_app_loginCancelsDeletion(t *testing.T) {
+	recorder := &auditRecorder{}
+	testApp := app
+	testApp.DB = recorder
+
+	req, _ := http.NewRequest("POST", "/api/v1/auth", strings.NewReader(`{"email":"leaving@example.com", "password":"secret"}`))
+	rr := httptest.NewRecorder()
+	testApp.Routes().ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200 but got %d", rr.Code)
+	}
+	for _, event := range recorder.events {
+		if event.Type == eventAccountDeletionCancelled && *event.UserID == 7 {
+			return
+		}
+	}
+	t.Errorf("expected the deletion to be cancelled, got events %+v", recorder.events)
+}
+
+// purgeRecorder purges users 4 and 5.
+type purgeRecorder struct {
+	auditRecorder
+}
+
+func (p *purgeRecorder) PurgeDueUsers(now time.Time) ([]int, error) {
+	return []int{4, 5}, nil
+}
+
+func Test_app_PurgeDeletedUsers(t *testing.T) {
+	recorder := &purgeRecorder{}
+	testApp := app
+	testApp.DB = recorder
+	testApp.PurgeDeletedUsers()
+
+	if len(recorder.events) != 2 {
+		t.Fatalf("expected two events but got %d", len(recorder.events))
+	}
+	for i, event := range recorder.events {
+		if event.Type != eventAccountDeleted || *event.UserID != i+4 {
+			t.Errorf("unexpected event %+v", event)
+		}
+	}
+}
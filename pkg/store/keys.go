@@ -0,0 +1,273 @@
+package store
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/MinhNHHH/online-store/pkg/cfgs"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// JWK is a public key in the JSON Web Key format (RFC 7517).
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	// RSA
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+	// Ed25519
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+type verificationKey struct {
+	method jwt.SigningMethod
+	key    interface{}
+}
+
+// KeySet holds the key tokens are signed with and every key tokens are still
+// accepted from. With HS256 both are the shared secret; with RS256 or EdDSA
+// the public halves of old keys can stay in the set while a rotation is under
+// way, and are published by the JWKS endpoint.
+type KeySet struct {
+	method     jwt.SigningMethod
+	signingKey interface{}
+	kid        string
+	verify     map[string]verificationKey
+	public     []JWK
+}
+
+// NewHMACKeySet signs and verifies with a shared HS256 secret.
+func NewHMACKeySet(secret string) *KeySet {
+	return &KeySet{
+		method:     jwt.SigningMethodHS256,
+		signingKey: []byte(secret),
+		verify: map[string]verificationKey{
+			"": {method: jwt.SigningMethodHS256, key: []byte(secret)},
+		},
+		public: []JWK{},
+	}
+}
+
+// NewKeySet signs with privateKey, an *rsa.PrivateKey or ed25519.PrivateKey,
+// and additionally accepts tokens signed by the private keys of publicKeys.
+func NewKeySet(privateKey crypto.Signer, publicKeys ...crypto.PublicKey) (*KeySet, error) {
+	k := &KeySet{
+		signingKey: privateKey,
+		verify:     map[string]verificationKey{},
+		public:     []JWK{},
+	}
+
+	kid, method, err := k.addPublicKey(privateKey.Public())
+	if err != nil {
+		return nil, err
+	}
+	k.kid = kid
+	k.method = method
+
+	for _, publicKey := range publicKeys {
+		if _, _, err := k.addPublicKey(publicKey); err != nil {
+			return nil, err
+		}
+	}
+	return k, nil
+}
+
+func (k *KeySet) addPublicKey(publicKey crypto.PublicKey) (string, jwt.SigningMethod, error) {
+	var jwk JWK
+	var method jwt.SigningMethod
+	switch key := publicKey.(type) {
+	case *rsa.PublicKey:
+		method = jwt.SigningMethodRS256
+		jwk = JWK{
+			Kty: "RSA",
+			N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}
+	case ed25519.PublicKey:
+		method = jwt.SigningMethodEdDSA
+		jwk = JWK{
+			Kty: "OKP",
+			Crv: "Ed25519",
+			X:   base64.RawURLEncoding.EncodeToString(key),
+		}
+	default:
+		return "", nil, fmt.Errorf("unsupported key type %T", publicKey)
+	}
+
+	jwk.Kid = thumbprint(jwk)
+	jwk.Use = "sig"
+	jwk.Alg = method.Alg()
+	if _, ok := k.verify[jwk.Kid]; !ok {
+		k.verify[jwk.Kid] = verificationKey{method: method, key: publicKey}
+		k.public = append(k.public, jwk)
+	}
+	return jwk.Kid, method, nil
+}
+
+// thumbprint is the RFC 7638 JWK thumbprint, used as the key ID.
+func thumbprint(jwk JWK) string {
+	var canonical string
+	switch jwk.Kty {
+	case "RSA":
+		canonical = fmt.Sprintf(`{"e":%q,"kty":"RSA","n":%q}`, jwk.E, jwk.N)
+	case "OKP":
+		canonical = fmt.Sprintf(`{"crv":%q,"kty":"OKP","x":%q}`, jwk.Crv, jwk.X)
+	}
+	sum := sha256.Sum256([]byte(canonical))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// Sign signs claims with the current key, naming it in the kid header.
+func (k *KeySet) Sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(k.method, claims)
+	if k.kid != "" {
+		token.Header["kid"] = k.kid
+	}
+	return token.SignedString(k.signingKey)
+}
+
+// Keyfunc picks the verification key for a token by its kid header and makes
+// sure the token uses that key's algorithm.
+func (k *KeySet) Keyfunc(t *jwt.Token) (interface{}, error) {
+	kid, _ := t.Header["kid"].(string)
+	vk, ok := k.verify[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+	if t.Method.Alg() != vk.method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method: %v", t.Header["alg"])
+	}
+	return vk.key, nil
+}
+
+// JWKS returns the public keys tokens are verified with. It is empty for
+// HS256, whose secret must never be published.
+func (k *KeySet) JWKS() []JWK {
+	return k.public
+}
+
+// LoadKeySet builds the key set described by the JWT_* settings.
+func LoadKeySet(c cfgs.Configs) (*KeySet, error) {
+	switch c.JWT_ALGORITHM {
+	case "", jwt.SigningMethodHS256.Alg():
+		return NewHMACKeySet(c.JWT_SECRET), nil
+	case jwt.SigningMethodRS256.Alg(), jwt.SigningMethodEdDSA.Alg():
+	default:
+		return nil, fmt.Errorf("unsupported JWT algorithm %q", c.JWT_ALGORITHM)
+	}
+
+	if c.JWT_PRIVATE_KEY_FILE == "" {
+		return nil, fmt.Errorf("JWT algorithm %s needs a private key file", c.JWT_ALGORITHM)
+	}
+	privateKey, err := readPrivateKey(c.JWT_PRIVATE_KEY_FILE)
+	if err != nil {
+		return nil, err
+	}
+
+	var publicKeys []crypto.PublicKey
+	for _, path := range strings.Split(c.JWT_PUBLIC_KEY_FILES, ",") {
+		path = strings.TrimSpace(path)
+		if path == "" {
+			continue
+		}
+		publicKey, err := readPublicKey(path)
+		if err != nil {
+			return nil, err
+		}
+		publicKeys = append(publicKeys, publicKey)
+	}
+
+	keys, err := NewKeySet(privateKey, publicKeys...)
+	if err != nil {
+		return nil, err
+	}
+	if keys.method.Alg() != c.JWT_ALGORITHM {
+		return nil, fmt.Errorf("private key is for %s, not %s", keys.method.Alg(), c.JWT_ALGORITHM)
+	}
+	return keys, nil
+}
+
+func readPEM(path string) (*pem.Block, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s: no PEM data found", path)
+	}
+	return block, nil
+}
+
+func readPrivateKey(path string) (crypto.Signer, error) {
+	block, err := readPEM(path)
+	if err != nil {
+		return nil, err
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("%s: unsupported private key type %T", path, key)
+	}
+	return signer, nil
+}
+
+// readPublicKey reads a public key, or the public half of a private key.
+func readPublicKey(path string) (crypto.PublicKey, error) {
+	block, err := readPEM(path)
+	if err != nil {
+		return nil, err
+	}
+	if strings.Contains(block.Type, "PRIVATE KEY") {
+		signer, err := readPrivateKey(path)
+		if err != nil {
+			return nil, err
+		}
+		return signer.Public(), nil
+	}
+	if key, err := x509.ParsePKCS1PublicKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return key, nil
+}
+
+// keys returns the configured key set, falling back to HS256 with JWT_SECRET
+// when none was loaded.
+func (app *OnlineStore) keys() *KeySet {
+	if app.Keys != nil {
+		return app.Keys
+	}
+	return NewHMACKeySet(app.Cfgs.JWT_SECRET)
+}
+
+func (app *OnlineStore) jwks(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	app.SendResponse(w, http.StatusOK, struct {
+		Keys []JWK `json:"keys"`
+	}{
+		Keys: app.keys().JWKS(),
+	})
+}
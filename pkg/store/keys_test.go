@@ -0,0 +1,280 @@
+package store
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/MinhNHHH/online-store/pkg/cfgs"
+	"github.com/MinhNHHH/online-store/pkg/databases/schema"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+func newRSAKey(t *testing.T) *rsa.PrivateKey {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func newEd25519Key(t *testing.T) ed25519.PrivateKey {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func writePEM(t *testing.T, blockType string, der []byte) string {
+	path := filepath.Join(t.TempDir(), "key.pem")
+	data := pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der})
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func testClaims() jwt.MapClaims {
+	return jwt.MapClaims{"sub": "1", "exp": time.Now().Add(time.Minute).Unix()}
+}
+
+func Test_KeySet_SignAndVerify(t *testing.T) {
+	hmacKeys := NewHMACKeySet("secret")
+	rsaKeys, err := NewKeySet(newRSAKey(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	edKeys, err := NewKeySet(newEd25519Key(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var theTests = []struct {
+		name        string
+		keys        *KeySet
+		expectedAlg string
+	}{
+		{"hmac", hmacKeys, "HS256"},
+		{"rsa", rsaKeys, "RS256"},
+		{"ed25519", edKeys, "EdDSA"},
+	}
+
+	for _, e := range theTests {
+		signed, err := e.keys.Sign(testClaims())
+		if err != nil {
+			t.Errorf("%s: unexpected error signing: %v", e.name, err)
+			continue
+		}
+		token, err := jwt.Parse(signed, e.keys.Keyfunc)
+		if err != nil {
+			t.Errorf("%s: unexpected error verifying: %v", e.name, err)
+			continue
+		}
+		if token.Method.Alg() != e.expectedAlg {
+			t.Errorf("%s: expected alg %s but got %s", e.name, e.expectedAlg, token.Method.Alg())
+		}
+	}
+}
+
+func Test_KeySet_Rotation(t *testing.T) {
+	oldKey := newRSAKey(t)
+	oldKeys, err := NewKeySet(oldKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldToken, err := oldKeys.Sign(testClaims())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the new key signs, the old one is only kept for verification
+	newKeys, err := NewKeySet(newEd25519Key(t), oldKey.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := jwt.Parse(oldToken, newKeys.Keyfunc); err != nil {
+		t.Errorf("token signed by the previous key was rejected: %v", err)
+	}
+	if len(newKeys.JWKS()) != 2 {
+		t.Errorf("expected 2 published keys but got %d", len(newKeys.JWKS()))
+	}
+
+	newToken, err := newKeys.Sign(testClaims())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := jwt.Parse(newToken, oldKeys.Keyfunc); err == nil {
+		t.Error("expected token with an unknown kid to be rejected")
+	}
+}
+
+func Test_KeySet_RejectsAlgorithmMismatch(t *testing.T) {
+	rsaKey := newRSAKey(t)
+	keys, err := NewKeySet(rsaKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// an HS256 token naming the RSA key, signed with its public modulus
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, testClaims())
+	token.Header["kid"] = keys.kid
+	signed, err := token.SignedString(rsaKey.N.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := jwt.Parse(signed, keys.Keyfunc); err == nil {
+		t.Error("expected token with a mismatched alg to be rejected")
+	}
+
+	// a token without kid is only accepted by the HS256 key set
+	signed, err = NewHMACKeySet("secret").Sign(testClaims())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := jwt.Parse(signed, keys.Keyfunc); err == nil {
+		t.Error("expected token without kid to be rejected")
+	}
+}
+
+func Test_LoadKeySet(t *testing.T) {
+	rsaKey := newRSAKey(t)
+	edKey := newEd25519Key(t)
+
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(edKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edPath := writePEM(t, "PRIVATE KEY", pkcs8)
+	rsaPath := writePEM(t, "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(rsaKey))
+	pkix, err := x509.MarshalPKIXPublicKey(rsaKey.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaPublicPath := writePEM(t, "PUBLIC KEY", pkix)
+
+	var theTests = []struct {
+		name         string
+		cfgs         cfgs.Configs
+		expectError  bool
+		expectedKeys int
+	}{
+		{"hmac default", cfgs.Configs{JWT_SECRET: "secret"}, false, 0},
+		{"hmac", cfgs.Configs{JWT_ALGORITHM: "HS256", JWT_SECRET: "secret"}, false, 0},
+		{"rsa", cfgs.Configs{JWT_ALGORITHM: "RS256", JWT_PRIVATE_KEY_FILE: rsaPath}, false, 1},
+		{"eddsa with previous rsa key", cfgs.Configs{JWT_ALGORITHM: "EdDSA", JWT_PRIVATE_KEY_FILE: edPath, JWT_PUBLIC_KEY_FILES: rsaPublicPath}, false, 2},
+		{"private key as public key", cfgs.Configs{JWT_ALGORITHM: "EdDSA", JWT_PRIVATE_KEY_FILE: edPath, JWT_PUBLIC_KEY_FILES: rsaPath + ", " + edPath}, false, 2},
+		{"unknown algorithm", cfgs.Configs{JWT_ALGORITHM: "none"}, true, 0},
+		{"missing private key", cfgs.Configs{JWT_ALGORITHM: "RS256"}, true, 0},
+		{"key for other algorithm", cfgs.Configs{JWT_ALGORITHM: "RS256", JWT_PRIVATE_KEY_FILE: edPath}, true, 0},
+		{"unreadable file", cfgs.Configs{JWT_ALGORITHM: "RS256", JWT_PRIVATE_KEY_FILE: filepath.Join(t.TempDir(), "missing.pem")}, true, 0},
+	}
+
+	for _, e := range theTests {
+		keys, err := LoadKeySet(e.cfgs)
+		if e.expectError {
+			if err == nil {
+				t.Errorf("%s: expected error but got none", e.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", e.name, err)
+			continue
+		}
+		if len(keys.JWKS()) != e.expectedKeys {
+			t.Errorf("%s: expected %d published keys but got %d", e.name, e.expectedKeys, len(keys.JWKS()))
+		}
+	}
+}
+
+func Test_app_AsymmetricTokens(t *testing.T) {
+	var signers = []crypto.Signer{newRSAKey(t), newEd25519Key(t)}
+
+	for _, signer := range signers {
+		keys, err := NewKeySet(signer)
+		if err != nil {
+			t.Fatal(err)
+		}
+		testApp := app
+		testApp.Keys = keys
+
+		tokens, _, err := testApp.generateTokenPair(&schema.User{ID: 1, Name: "Admin", Email: "admin@example.com"}, "", false)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		req, _ := http.NewRequest("GET", "/", nil)
+		req.Header.Set("Authorization", "Bearer "+tokens.Token)
+		rr := httptest.NewRecorder()
+		if _, _, err := testApp.getTokenFromHeaderandVerify(rr, req); err != nil {
+			t.Errorf("%s: access token was rejected: %v", keys.method.Alg(), err)
+		}
+		if _, err := testApp.parseRefreshToken(tokens.RefreshToken); err != nil {
+			t.Errorf("%s: refresh token was rejected: %v", keys.method.Alg(), err)
+		}
+
+		// tokens signed with the shared secret are no longer accepted
+		rr = httptest.NewRecorder()
+		tokens, _, _ = app.generateTokenPair(&schema.User{ID: 1, Name: "Admin", Email: "admin@example.com"}, "", false)
+		req.Header.Set("Authorization", "Bearer "+tokens.Token)
+		if _, _, err := testApp.getTokenFromHeaderandVerify(rr, req); err == nil {
+			t.Errorf("%s: expected HS256 token to be rejected", keys.method.Alg())
+		}
+	}
+}
+
+func Test_app_jwks(t *testing.T) {
+	keys, err := NewKeySet(newEd25519Key(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var theTests = []struct {
+		name         string
+		keys         *KeySet
+		expectedKeys int
+	}{
+		{"hmac", nil, 0},
+		{"ed25519", keys, 1},
+	}
+
+	for _, e := range theTests {
+		testApp := app
+		testApp.Keys = e.keys
+
+		req, _ := http.NewRequest("GET", "/.well-known/jwks.json", nil)
+		rr := httptest.NewRecorder()
+		testApp.Routes().ServeHTTP(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Errorf("%s: returned wrong status code; expected %d but got %d", e.name, http.StatusOK, rr.Code)
+			continue
+		}
+
+		var body struct {
+			Keys []JWK `json:"keys"`
+		}
+		if err := json.NewDecoder(rr.Body).Decode(&body); err != nil {
+			t.Errorf("%s: invalid json: %v", e.name, err)
+			continue
+		}
+		if len(body.Keys) != e.expectedKeys {
+			t.Errorf("%s: expected %d keys but got %d", e.name, e.expectedKeys, len(body.Keys))
+		}
+		for _, k := range body.Keys {
+			if k.Kid == "" || k.Alg != "EdDSA" || k.Crv != "Ed25519" || k.X == "" {
+				t.Errorf("%s: incomplete key %+v", e.name, k)
+			}
+		}
+	}
+}
@@ -1,14 +1,23 @@
 package store
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
 	"net/http"
+	"slices"
 )
 
+type contextKey string
+
+const apiKeyScopeContextKey contextKey = "api_key_scope"
+
 func (app *OnlineStore) enableCORS(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-API-Key, "+csrfHeader)
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
 
 		if r.Method == http.MethodOptions {
 			w.WriteHeader(http.StatusOK)
@@ -19,13 +28,106 @@ func (app *OnlineStore) enableCORS(next http.Handler) http.Handler {
 	})
 }
 
+// authRequired lets through requests with a valid access token or cookie
+// session, or with an API key carrying the scope the route was opened to with
+// apiKeyScope. It puts the Principal making the request into the context.
 func (app *OnlineStore) authRequired(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		_, _, err := app.getTokenFromHeaderandVerify(w, r)
+		if raw := presentedAPIKey(r); raw != "" {
+			key, err := app.DB.UseAPIKey(hashToken(raw))
+			if err != nil {
+				log.Println("Error checking API key:", err)
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			scope, _ := r.Context().Value(apiKeyScopeContextKey).(string)
+			if scope == "" {
+				app.SendError(w, http.StatusForbidden, errors.New("forbidden: route not available to API keys"))
+				return
+			}
+			if !slices.Contains(key.Scopes, scope) {
+				app.SendError(w, http.StatusForbidden, fmt.Errorf("forbidden: API key lacks the %s scope", scope))
+				return
+			}
+			p := &Principal{APIKeyID: key.ID, Scopes: key.Scopes}
+			next.ServeHTTP(w, r.WithContext(withPrincipal(r.Context(), p)))
+			return
+		}
+
+		claims, err := app.requestClaims(w, r)
 		if err != nil {
 			w.WriteHeader(http.StatusUnauthorized)
 			return
 		}
+		p, err := principalFromClaims(claims)
+		if err != nil {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		// browsers send the session cookie along with forged requests, but
+		// cannot read the CSRF token to add it
+		if r.Header.Get("Authorization") == "" && app.Session != nil && !app.sessionCSRFValid(r) {
+			app.SendError(w, http.StatusForbidden, errors.New("forbidden: missing or invalid CSRF token"))
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(withPrincipal(r.Context(), p)))
+	})
+}
+
+// apiKeyScope opens the routes it is mounted on to API keys carrying scope. It
+// must run before authRequired, which refuses API keys on every other route.
+func (app *OnlineStore) apiKeyScope(scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), apiKeyScopeContextKey, scope)))
+		})
+	}
+}
+
+// adminRequired only lets requests through when the principal is an admin,
+// and with ADMIN_REQUIRE_2FA on, signed in with a second factor. It must be
+// mounted on routes that also use authRequired.
+func (app *OnlineStore) adminRequired(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p, err := app.requestPrincipal(w, r)
+		if err != nil {
+			app.SendError(w, http.StatusUnauthorized, errors.New("unauthorized"))
+			return
+		}
+		// authRequired already held API keys to the route's scope
+		if p.APIKeyID != 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if !p.Admin {
+			app.SendError(w, http.StatusForbidden, errors.New("forbidden: admin privileges required"))
+			return
+		}
+		if app.Cfgs.ADMIN_REQUIRE_2FA && !p.MFA {
+			app.SendError(w, http.StatusForbidden, errors.New("forbidden: admin accounts must sign in with two-factor authentication"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// verifiedEmailRequired blocks unverified accounts when email verification is
+// in limited mode. In the other modes it lets every request through.
+func (app *OnlineStore) verifiedEmailRequired(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if app.Cfgs.EMAIL_VERIFICATION != emailVerificationLimited {
+			next.ServeHTTP(w, r)
+			return
+		}
+		p, err := app.requestPrincipal(w, r)
+		if err != nil {
+			app.SendError(w, http.StatusUnauthorized, errors.New("unauthorized"))
+			return
+		}
+		if !p.EmailVerified {
+			app.SendError(w, http.StatusForbidden, errors.New("forbidden: email address not verified"))
+			return
+		}
 		next.ServeHTTP(w, r)
 	})
 }
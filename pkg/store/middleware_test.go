@@ -4,8 +4,10 @@ import (
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 
+	"github.com/MinhNHHH/online-store/pkg/databases/repositories/dbrepo"
 	"github.com/MinhNHHH/online-store/pkg/databases/schema"
 )
 
@@ -38,11 +40,22 @@ func Test_app_EnableCORS(t *testing.T) {
 			t.Errorf("%s: expected no header, but got one", e.name)
 		}
 	}
+
+	// a preflight allows the headers that authenticate requests
+	req := httptest.NewRequest(http.MethodOptions, "http://testing", nil)
+	rr := httptest.NewRecorder()
+	app.enableCORS(nextHandler).ServeHTTP(rr, req)
+	allowed := rr.Header().Get("Access-Control-Allow-Headers")
+	for _, header := range []string{"Content-Type", "Authorization", "X-API-Key", "X-CSRF-Token"} {
+		if !strings.Contains(allowed, header) {
+			t.Errorf("preflight: expected %s in allowed headers %q", header, allowed)
+		}
+	}
 }
 
 func Test_app_authRequired(t *testing.T) {
 	nextHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
-	app := OnlineStore{}
+	app := OnlineStore{DB: &dbrepo.TestDBRepo{}}
 
 	testUser := schema.User{
 		ID:       1,
@@ -52,7 +65,10 @@ func Test_app_authRequired(t *testing.T) {
 		IsAdmin:  true,
 	}
 
-	tokens, _ := app.generateTokenPair(&testUser)
+	tokens, _, _ := app.generateTokenPair(&testUser, "", false)
+	deactivatedTokens, _, _ := app.generateTokenPair(&schema.User{ID: 6, Name: "Deactivated"}, "", false)
+	deletedTokens, _, _ := app.generateTokenPair(&schema.User{ID: 999, Name: "Deleted"}, "", false)
+	leavingTokens, _, _ := app.generateTokenPair(&schema.User{ID: 7, Name: "Leaving"}, "", false)
 	var tests = []struct {
 		name             string
 		token            string
@@ -60,6 +76,9 @@ func Test_app_authRequired(t *testing.T) {
 		setHeader        bool
 	}{
 		{name: "valid token", token: fmt.Sprintf("Bearer %s", tokens.Token), expectAuthorized: true, setHeader: true},
+		{name: "token of deactivated user", token: fmt.Sprintf("Bearer %s", deactivatedTokens.Token), expectAuthorized: false, setHeader: true},
+		{name: "token of deleted user", token: fmt.Sprintf("Bearer %s", deletedTokens.Token), expectAuthorized: false, setHeader: true},
+		{name: "token of user scheduled for deletion", token: fmt.Sprintf("Bearer %s", leavingTokens.Token), expectAuthorized: false, setHeader: true},
 		{name: "no token", token: "", expectAuthorized: false, setHeader: false},
 		{name: "invalid token", token: fmt.Sprintf("Bearer %s1", tokens.Token), expectAuthorized: false, setHeader: true},
 	}
@@ -82,3 +101,118 @@ func Test_app_authRequired(t *testing.T) {
 		}
 	}
 }
+
+func Test_app_adminRequired(t *testing.T) {
+	nextHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	adminTokens, _, _ := app.generateTokenPair(&schema.User{ID: dbrepo.TestAdminID, Name: "Staff", IsAdmin: true}, "", false)
+	userTokens, _, _ := app.generateTokenPair(&schema.User{ID: 2, Name: "User", IsAdmin: false}, "", false)
+
+	var tests = []struct {
+		name               string
+		token              string
+		expectedStatusCode int
+	}{
+		{name: "admin token", token: fmt.Sprintf("Bearer %s", adminTokens.Token), expectedStatusCode: http.StatusOK},
+		{name: "non admin token", token: fmt.Sprintf("Bearer %s", userTokens.Token), expectedStatusCode: http.StatusForbidden},
+		{name: "no token", token: "", expectedStatusCode: http.StatusUnauthorized},
+	}
+	for _, e := range tests {
+		req, _ := http.NewRequest("POST", "/", nil)
+		if e.token != "" {
+			req.Header.Set("Authorization", e.token)
+		}
+
+		rr := httptest.NewRecorder()
+		handlerToTest := app.adminRequired(nextHandler)
+		handlerToTest.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: expected status %d but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+
+		if rr.Code == http.StatusForbidden && !strings.Contains(rr.Body.String(), "admin privileges required") {
+			t.Errorf("%s: expected a descriptive error body, got %q", e.name, rr.Body.String())
+		}
+	}
+}
+
+func Test_app_RoutesAdminOnlyWrites(t *testing.T) {
+	routes := app.Routes()
+
+	userTokens, _, _ := app.generateTokenPair(&schema.User{ID: 2, Name: "User", IsAdmin: false}, "", false)
+	adminTokens, _, _ := app.generateTokenPair(&schema.User{ID: dbrepo.TestAdminID, Name: "Staff", IsAdmin: true}, "", false)
+
+	var tests = []struct {
+		name               string
+		method             string
+		url                string
+		body               string
+		token              string
+		expectedStatusCode int
+	}{
+		{"user lists products", http.MethodGet, "/api/v1/products/", "", userTokens.Token, http.StatusOK},
+		{"user lists categories", http.MethodGet, "/api/v1/categories/", "", userTokens.Token, http.StatusOK},
+		{"user creates product", http.MethodPost, "/api/v1/products/", `{"name":"x"}`, userTokens.Token, http.StatusForbidden},
+		{"user updates product", http.MethodPut, "/api/v1/products/1", `{"name":"x"}`, userTokens.Token, http.StatusForbidden},
+		{"user deletes product", http.MethodDelete, "/api/v1/products/1", "", userTokens.Token, http.StatusForbidden},
+		{"user creates category", http.MethodPost, "/api/v1/categories/", `{"name":"x"}`, userTokens.Token, http.StatusForbidden},
+		{"user updates category", http.MethodPut, "/api/v1/categories/1", `{"name":"x"}`, userTokens.Token, http.StatusForbidden},
+		{"user deletes category", http.MethodDelete, "/api/v1/categories/1", "", userTokens.Token, http.StatusForbidden},
+		{"admin creates category", http.MethodPost, "/api/v1/categories/", `{"name":"x"}`, adminTokens.Token, http.StatusCreated},
+		{"admin deletes product", http.MethodDelete, "/api/v1/products/1", "", adminTokens.Token, http.StatusOK},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest(e.method, e.url, strings.NewReader(e.body))
+		req.Header.Set("Authorization", "Bearer "+e.token)
+		rr := httptest.NewRecorder()
+		routes.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: expected status %d but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+	}
+}
+
+func Test_app_authRequiredSetsPrincipal(t *testing.T) {
+	adminTokens, _, _ := app.generateTokenPair(&schema.User{ID: dbrepo.TestAdminID, Name: "Staff", IsAdmin: true}, "", true)
+	// the admin and email verified claims follow the user, not the token
+	userTokens, _, _ := app.generateTokenPair(&schema.User{ID: 2, Name: "User", IsAdmin: true}, "", true)
+
+	var tests = []struct {
+		name     string
+		header   string
+		value    string
+		scope    string
+		expected Principal
+	}{
+		{"access token", "Authorization", "Bearer " + adminTokens.Token, "", Principal{UserID: dbrepo.TestAdminID, Admin: true, EmailVerified: true, MFA: true}},
+		{"access token of demoted admin", "Authorization", "Bearer " + userTokens.Token, "", Principal{UserID: 2, MFA: true}},
+		{"API key", "X-API-Key", "so_products-key", scopeProductsRead, Principal{APIKeyID: 1, Scopes: []string{scopeProductsRead, scopeProductsWrite}}},
+	}
+
+	for _, e := range tests {
+		var got *Principal
+		nextHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			got, _ = principalFromContext(r.Context())
+		})
+
+		req, _ := http.NewRequest("GET", "/", nil)
+		req.Header.Set(e.header, e.value)
+		rr := httptest.NewRecorder()
+		handlerToTest := app.authRequired(nextHandler)
+		if e.scope != "" {
+			handlerToTest = app.apiKeyScope(e.scope)(handlerToTest)
+		}
+		handlerToTest.ServeHTTP(rr, req)
+
+		if got == nil {
+			t.Errorf("%s: no principal in the context", e.name)
+			continue
+		}
+		if fmt.Sprint(*got) != fmt.Sprint(e.expected) {
+			t.Errorf("%s: expected principal %+v but got %+v", e.name, e.expected, *got)
+		}
+	}
+}
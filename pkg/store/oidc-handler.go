@@ -0,0 +1,327 @@
+package store
+
+import (
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/MinhNHHH/online-store/pkg/cfgs"
+	"github.com/MinhNHHH/online-store/pkg/databases/schema"
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/go-chi/chi"
+	"github.com/golang-jwt/jwt/v4"
+	"golang.org/x/oauth2"
+)
+
+const oidcStateType = "oidc_state"
+
+const oidcStateCookie = "__Host-oidc_state"
+
+var oidcStateExpiry = time.Minute * 10
+
+var (
+	errIdentityNoEmail  = errors.New("the provider did not share an email address")
+	errIdentityConflict = errors.New("an account with this email address already exists; sign in with your password first")
+)
+
+// OIDCProvider is an OpenID Connect provider users can sign in with. Its
+// discovery document is fetched on first use, so a provider being down does
+// not keep the store from starting.
+type OIDCProvider struct {
+	cfgs.OIDCProvider
+
+	mu       sync.Mutex
+	provider *oidc.Provider
+}
+
+// NewOIDCProviders indexes providers by name.
+func NewOIDCProviders(providers []cfgs.OIDCProvider) map[string]*OIDCProvider {
+	byName := make(map[string]*OIDCProvider, len(providers))
+	for _, p := range providers {
+		byName[p.Name] = &OIDCProvider{OIDCProvider: p}
+	}
+	return byName
+}
+
+var oidcHTTPClient = &http.Client{Timeout: time.Second * 10}
+
+func (p *OIDCProvider) discover() (*oidc.Provider, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.provider == nil {
+		// the provider keeps the context to fetch signing keys later on, so
+		// it must not be one that gets cancelled
+		ctx := oidc.ClientContext(context.Background(), oidcHTTPClient)
+		provider, err := oidc.NewProvider(ctx, p.Issuer)
+		if err != nil {
+			return nil, err
+		}
+		p.provider = provider
+	}
+	return p.provider, nil
+}
+
+func (app *OnlineStore) oauth2Config(p *OIDCProvider, provider *oidc.Provider) *oauth2.Config {
+	return &oauth2.Config{
+		ClientID:     p.ClientID,
+		ClientSecret: p.ClientSecret,
+		Endpoint:     provider.Endpoint(),
+		RedirectURL:  fmt.Sprintf("%s/api/v1/auth/oidc/%s/callback", app.Cfgs.APP_URL, p.Name),
+		Scopes:       []string{oidc.ScopeOpenID, "email", "profile"},
+	}
+}
+
+// oidcStateClaims is kept in a cookie between oidcLogin and oidcCallback. It
+// ties the callback to the browser that started the login and carries the
+// PKCE verifier and nonce the provider's answer is checked against.
+type oidcStateClaims struct {
+	Provider string `json:"provider"`
+	State    string `json:"state"`
+	Nonce    string `json:"nonce"`
+	Verifier string `json:"verifier"`
+	Type     string `json:"typ"`
+	jwt.RegisteredClaims
+}
+
+func (app *OnlineStore) setOIDCStateCookie(w http.ResponseWriter, value string, maxAge time.Duration) {
+	http.SetCookie(w, &http.Cookie{
+		Name:    oidcStateCookie,
+		Path:    "/",
+		Value:   value,
+		Expires: time.Now().Add(maxAge),
+		MaxAge:  int(maxAge.Seconds()),
+		// the provider redirects back with a cross-site navigation, which
+		// strict cookies are not sent with
+		SameSite: http.SameSiteLaxMode,
+		HttpOnly: true,
+		Secure:   true,
+	})
+}
+
+// oidcProviders lists the names of the providers users can sign in with.
+func (app *OnlineStore) oidcProviders(w http.ResponseWriter, r *http.Request) {
+	names := []string{}
+	for name := range app.OIDC {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	app.SendResponse(w, http.StatusOK, names)
+}
+
+// oidcLogin sends the browser to the provider's login page.
+func (app *OnlineStore) oidcLogin(w http.ResponseWriter, r *http.Request) {
+	p, ok := app.OIDC[chi.URLParam(r, "provider")]
+	if !ok {
+		app.SendError(w, http.StatusNotFound, errors.New("unknown provider"))
+		return
+	}
+	provider, err := p.discover()
+	if err != nil {
+		log.Println("Error discovering OIDC provider:", err)
+		app.SendError(w, http.StatusBadGateway, errors.New("provider unavailable"))
+		return
+	}
+
+	state, err := randomToken(16)
+	if err != nil {
+		log.Println("Error generating state:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	nonce, err := randomToken(16)
+	if err != nil {
+		log.Println("Error generating nonce:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	verifier := oauth2.GenerateVerifier()
+
+	cookie, err := app.keys().Sign(oidcStateClaims{
+		Provider: p.Name,
+		State:    state,
+		Nonce:    nonce,
+		Verifier: verifier,
+		Type:     oidcStateType,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Audience:  jwt.ClaimStrings{app.Cfgs.DOMAIN},
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(oidcStateExpiry)),
+		},
+	})
+	if err != nil {
+		log.Println("Error signing OIDC state:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	app.setOIDCStateCookie(w, cookie, oidcStateExpiry)
+
+	authURL := app.oauth2Config(p, provider).AuthCodeURL(state, oidc.Nonce(nonce), oauth2.S256ChallengeOption(verifier))
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// oidcCallback finishes a login the provider sent back, answering like
+// authenticate does.
+func (app *OnlineStore) oidcCallback(w http.ResponseWriter, r *http.Request) {
+	p, ok := app.OIDC[chi.URLParam(r, "provider")]
+	if !ok {
+		app.SendError(w, http.StatusNotFound, errors.New("unknown provider"))
+		return
+	}
+
+	cookie, err := r.Cookie(oidcStateCookie)
+	if err != nil {
+		app.SendError(w, http.StatusBadRequest, errors.New("login state missing or expired"))
+		return
+	}
+	// the state is only good for one attempt
+	app.setOIDCStateCookie(w, "", -time.Second)
+
+	claims := &oidcStateClaims{}
+	_, err = jwt.ParseWithClaims(cookie.Value, claims, app.keys().Keyfunc)
+	if err != nil || claims.Type != oidcStateType || claims.Provider != p.Name ||
+		subtle.ConstantTimeCompare([]byte(claims.State), []byte(r.URL.Query().Get("state"))) != 1 {
+		app.SendError(w, http.StatusBadRequest, errors.New("invalid login state"))
+		return
+	}
+
+	if e := r.URL.Query().Get("error"); e != "" {
+		app.SendError(w, http.StatusUnauthorized, fmt.Errorf("provider refused the login: %s", e))
+		return
+	}
+
+	provider, err := p.discover()
+	if err != nil {
+		log.Println("Error discovering OIDC provider:", err)
+		app.SendError(w, http.StatusBadGateway, errors.New("provider unavailable"))
+		return
+	}
+
+	ctx := oidc.ClientContext(r.Context(), oidcHTTPClient)
+	token, err := app.oauth2Config(p, provider).Exchange(ctx, r.URL.Query().Get("code"), oauth2.VerifierOption(claims.Verifier))
+	if err != nil {
+		log.Println("Error exchanging authorization code:", err)
+		app.SendResponse(w, http.StatusUnauthorized, errors.New("unauthorized"))
+		return
+	}
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		log.Println("Error exchanging authorization code: no id_token in response")
+		app.SendResponse(w, http.StatusUnauthorized, errors.New("unauthorized"))
+		return
+	}
+	idToken, err := provider.Verifier(&oidc.Config{ClientID: p.ClientID}).Verify(ctx, rawIDToken)
+	if err != nil {
+		log.Println("Error verifying id token:", err)
+		app.SendResponse(w, http.StatusUnauthorized, errors.New("unauthorized"))
+		return
+	}
+	if subtle.ConstantTimeCompare([]byte(idToken.Nonce), []byte(claims.Nonce)) != 1 {
+		log.Println("Error verifying id token: nonce mismatch")
+		app.SendResponse(w, http.StatusUnauthorized, errors.New("unauthorized"))
+		return
+	}
+
+	user, err := app.userForIdentity(p.Name, idToken)
+	switch {
+	case errors.Is(err, errIdentityNoEmail):
+		app.SendError(w, http.StatusUnauthorized, err)
+		return
+	case errors.Is(err, errIdentityConflict):
+		app.SendError(w, http.StatusConflict, err)
+		return
+	case err != nil:
+		log.Println("Error linking identity:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	app.completeLogin(w, r, user, false)
+}
+
+type identityClaims struct {
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
+	Name          string `json:"name"`
+}
+
+// userForIdentity returns the user linked to the identity in idToken. Unknown
+// identities are linked to the account with the same email address, as long as
+// both the provider and we have verified that address, or else get a new
+// account.
+func (app *OnlineStore) userForIdentity(provider string, idToken *oidc.IDToken) (*schema.User, error) {
+	user, err := app.DB.GetUserByIdentity(provider, idToken.Subject)
+	if err == nil {
+		return user, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+
+	var claims identityClaims
+	if err := idToken.Claims(&claims); err != nil {
+		return nil, err
+	}
+	if claims.Email == "" {
+		return nil, errIdentityNoEmail
+	}
+	identity := &schema.UserIdentity{
+		Provider: provider,
+		Subject:  idToken.Subject,
+		Email:    claims.Email,
+	}
+
+	user, err = app.DB.GetUserByEmail(claims.Email)
+	switch {
+	case err == nil:
+		// an unverified account may have been registered by someone else
+		// to take over the real owner's social login
+		if !claims.EmailVerified || user.EmailVerifiedAt == nil {
+			return nil, errIdentityConflict
+		}
+		identity.UserID = user.ID
+		if err := app.DB.InsertUserIdentity(identity); err != nil {
+			return nil, err
+		}
+		return user, nil
+	case !errors.Is(err, sql.ErrNoRows):
+		return nil, err
+	}
+
+	// new users can set a password later through forgot-password
+	password, err := randomToken(32)
+	if err != nil {
+		return nil, err
+	}
+	user = &schema.User{
+		Email:     claims.Email,
+		Name:      claims.Name,
+		Password:  password,
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	}
+	if user.Name == "" {
+		user.Name = claims.Email
+	}
+	if claims.EmailVerified {
+		now := time.Now()
+		user.EmailVerifiedAt = &now
+	}
+
+	user.ID, err = app.DB.InsertUserWithIdentity(*user, identity)
+	if err != nil {
+		return nil, err
+	}
+	if user.EmailVerifiedAt == nil {
+		if err := app.startEmailVerification(user.ID, user.Name, user.Email); err != nil {
+			log.Println("Error starting email verification:", err)
+		}
+	}
+	return user, nil
+}
@@ -0,0 +1,183 @@
+package store
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/MinhNHHH/online-store/pkg/cfgs"
+	"github.com/MinhNHHH/online-store/pkg/oidctest"
+)
+
+func newOIDCTestApp(provider *oidctest.Provider) *OnlineStore {
+	testApp := app
+	testApp.OIDC = NewOIDCProviders([]cfgs.OIDCProvider{{
+		Name:         "mock",
+		Issuer:       provider.URL,
+		ClientID:     provider.ClientID,
+		ClientSecret: provider.ClientSecret,
+	}})
+	return &testApp
+}
+
+// startOIDCLogin starts a login at the store, lets the provider approve it and
+// returns the callback request the browser is sent back with.
+func startOIDCLogin(t *testing.T, handler http.Handler) *http.Request {
+	req, _ := http.NewRequest("GET", "/api/v1/auth/oidc/mock", nil)
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusFound {
+		t.Fatalf("expected redirect to the provider but got %d: %s", rr.Code, rr.Body)
+	}
+
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	resp, err := client.Get(rr.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusFound {
+		t.Fatalf("expected redirect back from the provider but got %d", resp.StatusCode)
+	}
+
+	callback, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if callback.Path != "/api/v1/auth/oidc/mock/callback" {
+		t.Fatalf("unexpected callback %s", callback)
+	}
+	req, _ = http.NewRequest("GET", callback.RequestURI(), nil)
+	for _, cookie := range rr.Result().Cookies() {
+		req.AddCookie(cookie)
+	}
+	return req
+}
+
+func Test_app_oidcLogin(t *testing.T) {
+	provider := oidctest.NewProvider()
+	defer provider.Close()
+	handler := newOIDCTestApp(provider).Routes()
+
+	var theTests = []struct {
+		name               string
+		identity           oidctest.Identity
+		expectedStatusCode int
+		expectedField      string
+	}{
+		{"linked identity", oidctest.Identity{Subject: "admin-subject", Email: "someone@example.com"}, http.StatusOK, "access_token"},
+		{"new user", oidctest.Identity{Subject: "new-subject", Email: "new@example.com", EmailVerified: true, Name: "New"}, http.StatusOK, "access_token"},
+		{"new user with unverified email", oidctest.Identity{Subject: "new-subject", Email: "new@example.com"}, http.StatusOK, "access_token"},
+		{"link verified account", oidctest.Identity{Subject: "other-subject", Email: "admin@example.com", EmailVerified: true}, http.StatusOK, "access_token"},
+		{"email not verified by provider", oidctest.Identity{Subject: "other-subject", Email: "admin@example.com"}, http.StatusConflict, "error"},
+		{"local account not verified", oidctest.Identity{Subject: "other-subject", Email: "unverified@example.com", EmailVerified: true}, http.StatusConflict, "error"},
+		{"no email", oidctest.Identity{Subject: "other-subject"}, http.StatusUnauthorized, "error"},
+		{"two-factor user", oidctest.Identity{Subject: "twofactor-subject"}, http.StatusOK, "two_factor_required"},
+	}
+
+	for _, e := range theTests {
+		provider.SignIn(e.identity)
+		req := startOIDCLogin(t, handler)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: returned wrong status code; expected %d but got %d", e.name, e.expectedStatusCode, rr.Code)
+			continue
+		}
+		var response map[string]interface{}
+		_ = json.NewDecoder(rr.Body).Decode(&response)
+		if _, ok := response[e.expectedField]; !ok {
+			t.Errorf("%s: expected %s in response, got %v", e.name, e.expectedField, response)
+		}
+	}
+}
+
+func Test_app_oidcCallbackRejects(t *testing.T) {
+	provider := oidctest.NewProvider()
+	defer provider.Close()
+	handler := newOIDCTestApp(provider).Routes()
+	provider.SignIn(oidctest.Identity{Subject: "admin-subject"})
+
+	var theTests = []struct {
+		name               string
+		tamper             func(req *http.Request)
+		expectedStatusCode int
+	}{
+		{"no state cookie", func(req *http.Request) { req.Header.Del("Cookie") }, http.StatusBadRequest},
+		{"forged state cookie", func(req *http.Request) {
+			req.Header.Del("Cookie")
+			req.AddCookie(&http.Cookie{Name: oidcStateCookie, Value: expiredToken})
+		}, http.StatusBadRequest},
+		{"wrong state", func(req *http.Request) { setQuery(req, "state", "other") }, http.StatusBadRequest},
+		{"wrong code", func(req *http.Request) { setQuery(req, "code", "other") }, http.StatusUnauthorized},
+		{"provider error", func(req *http.Request) { setQuery(req, "error", "access_denied") }, http.StatusUnauthorized},
+		{"unknown provider", func(req *http.Request) {
+			req.URL.Path = strings.Replace(req.URL.Path, "/mock/", "/other/", 1)
+		}, http.StatusNotFound},
+	}
+
+	for _, e := range theTests {
+		req := startOIDCLogin(t, handler)
+		e.tamper(req)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: returned wrong status code; expected %d but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+	}
+
+	// authorization codes can only be exchanged once
+	req := startOIDCLogin(t, handler)
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200 but got %d", rr.Code)
+	}
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("replayed callback: expected status 401 but got %d", rr.Code)
+	}
+}
+
+func setQuery(req *http.Request, key, value string) {
+	q := req.URL.Query()
+	q.Set(key, value)
+	req.URL.RawQuery = q.Encode()
+}
+
+func Test_app_oidcProviders(t *testing.T) {
+	provider := oidctest.NewProvider()
+	defer provider.Close()
+
+	var theTests = []struct {
+		name         string
+		app          *OnlineStore
+		expectedBody string
+	}{
+		{"none", &app, "[]"},
+		{"mock", newOIDCTestApp(provider), `["mock"]`},
+	}
+
+	for _, e := range theTests {
+		req, _ := http.NewRequest("GET", "/api/v1/auth/oidc", nil)
+		rr := httptest.NewRecorder()
+		e.app.Routes().ServeHTTP(rr, req)
+		if strings.TrimSpace(rr.Body.String()) != e.expectedBody {
+			t.Errorf("%s: expected %s but got %s", e.name, e.expectedBody, rr.Body)
+		}
+	}
+
+	req, _ := http.NewRequest("GET", "/api/v1/auth/oidc/other", nil)
+	rr := httptest.NewRecorder()
+	newOIDCTestApp(provider).Routes().ServeHTTP(rr, req)
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("unknown provider: expected status 404 but got %d", rr.Code)
+	}
+}
@@ -0,0 +1,136 @@
+package store
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/MinhNHHH/online-store/pkg/databases/schema"
+)
+
+// Sizes of a page of a list.
+const (
+	defaultPageSize = 10
+	maxPageSize     = 100
+)
+
+var errInvalidCursor = errors.New("invalid cursor")
+
+var invalidCursorField = FieldError{Field: "cursor", Code: "invalid", Message: "is not a cursor of this list"}
+
+// listPage is the paging part of a list response. Lists paged by cursor point
+// at the pages around them; numbered pages carry the counts of the list.
+type listPage struct {
+	PageSize   int    `json:"page_size"`
+	NextCursor string `json:"next_cursor,omitempty"`
+	PrevCursor string `json:"prev_cursor,omitempty"`
+	Page       int    `json:"page,omitempty"`
+	TotalCount *int   `json:"total_count,omitempty"`
+	TotalPages *int   `json:"total_pages,omitempty"`
+}
+
+// readPage reads which page of a list is asked for. Lists are paged by
+// cursor, from the first page, unless page asks for a page number.
+func readPage(query url.Values) (schema.Page, []FieldError) {
+	page := schema.Page{Size: defaultPageSize}
+	var fields []FieldError
+
+	if value := query.Get("page_size"); value != "" {
+		size, err := strconv.Atoi(value)
+		if err != nil || size < 1 || size > maxPageSize {
+			fields = append(fields, FieldError{Field: "page_size", Code: "invalid", Message: fmt.Sprintf("must be between 1 and %d", maxPageSize)})
+		}
+		page.Size = size
+	}
+	if value := query.Get("page"); value != "" {
+		number, err := strconv.Atoi(value)
+		if err != nil || number < 1 {
+			fields = append(fields, FieldError{Field: "page", Code: "invalid", Message: "must be a positive number"})
+		}
+		page.Number = number
+	}
+	if value := query.Get("cursor"); value != "" {
+		cursor, err := decodeCursor(value)
+		switch {
+		case err != nil:
+			fields = append(fields, invalidCursorField)
+		case page.Number > 0:
+			fields = append(fields, FieldError{Field: "cursor", Code: "invalid", Message: "cannot be combined with page"})
+		}
+		page.Cursor = cursor
+	}
+	return page, fields
+}
+
+// encodeCursor makes a cursor opaque to clients, which only pass it back.
+func encodeCursor(cursor *schema.Cursor) string {
+	if cursor == nil {
+		return ""
+	}
+	data, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(value string) (*schema.Cursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, errInvalidCursor
+	}
+	var cursor schema.Cursor
+	if err := json.Unmarshal(data, &cursor); err != nil || cursor.Sort == "" {
+		return nil, errInvalidCursor
+	}
+	return &cursor, nil
+}
+
+// pageLinks sets an RFC 8288 Link header pointing at the pages around the one
+// sent, and returns the paging part of the response.
+func pageLinks(w http.ResponseWriter, r *http.Request, page schema.Page, info *schema.PageInfo) listPage {
+	response := listPage{PageSize: page.Size}
+	var links []string
+	link := func(rel string, set func(url.Values)) {
+		query := r.URL.Query()
+		query.Del("page")
+		query.Del("cursor")
+		set(query)
+		links = append(links, fmt.Sprintf(`<%s?%s>; rel="%s"`, r.URL.Path, query.Encode(), rel))
+	}
+
+	if page.Number > 0 {
+		totalPages := (info.Total + page.Size - 1) / page.Size
+		response.Page = page.Number
+		response.TotalCount = &info.Total
+		response.TotalPages = &totalPages
+		numbered := func(number int) func(url.Values) {
+			return func(query url.Values) { query.Set("page", strconv.Itoa(number)) }
+		}
+		link("first", numbered(1))
+		if page.Number > 1 {
+			link("prev", numbered(min(page.Number-1, max(totalPages, 1))))
+		}
+		if page.Number < totalPages {
+			link("next", numbered(page.Number+1))
+		}
+		link("last", numbered(max(totalPages, 1)))
+	} else {
+		response.NextCursor = encodeCursor(info.Next)
+		response.PrevCursor = encodeCursor(info.Prev)
+		if info.Prev != nil {
+			link("first", func(url.Values) {})
+			link("prev", func(query url.Values) { query.Set("cursor", response.PrevCursor) })
+		}
+		if info.Next != nil {
+			link("next", func(query url.Values) { query.Set("cursor", response.NextCursor) })
+		}
+	}
+
+	if len(links) > 0 {
+		w.Header().Set("Link", strings.Join(links, ", "))
+	}
+	return response
+}
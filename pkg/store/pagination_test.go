@@ -0,0 +1,89 @@
+package store
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/MinhNHHH/online-store/pkg/databases/schema"
+)
+
+func Test_readPage(t *testing.T) {
+	cursor := &schema.Cursor{Sort: "price:asc", Key: "19.99", ID: 4, Before: true}
+	var tests = []struct {
+		name           string
+		query          string
+		expectedPage   schema.Page
+		expectedFields int
+	}{
+		{"first page by cursor", "", schema.Page{Size: defaultPageSize}, 0},
+		{"numbered page", "page=3&page_size=20", schema.Page{Size: 20, Number: 3}, 0},
+		{"cursor", "cursor=" + encodeCursor(cursor), schema.Page{Size: defaultPageSize, Cursor: cursor}, 0},
+		{"page size out of range", "page_size=0", schema.Page{}, 1},
+		{"bad page", "page=first", schema.Page{}, 1},
+		{"bad cursor", "cursor=not-a-cursor", schema.Page{}, 1},
+		{"cursor and page", "page=2&cursor=" + encodeCursor(cursor), schema.Page{}, 1},
+	}
+
+	for _, e := range tests {
+		query, _ := url.ParseQuery(e.query)
+		page, fields := readPage(query)
+		if len(fields) != e.expectedFields {
+			t.Errorf("%s: expected %d field errors but got %v", e.name, e.expectedFields, fields)
+		}
+		if e.expectedFields == 0 && !reflect.DeepEqual(page, e.expectedPage) {
+			t.Errorf("%s: expected page %+v but got %+v", e.name, e.expectedPage, page)
+		}
+	}
+}
+
+func Test_app_GetCategoriesLinks(t *testing.T) {
+	// The first page by cursor has a next page.
+	req, _ := http.NewRequest("GET", "/api/v1/categories?category_name=test&page_size=2", nil)
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(app.GetCategories).ServeHTTP(rr, req)
+
+	var body struct {
+		NextCursor string `json:"next_cursor"`
+		PrevCursor string `json:"prev_cursor"`
+		TotalCount *int   `json:"total_count"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if body.NextCursor == "" || body.PrevCursor != "" || body.TotalCount != nil {
+		t.Errorf("expected only a next cursor but got %+v", body)
+	}
+	expected := `</api/v1/categories?category_name=test&cursor=` + body.NextCursor + `&page_size=2>; rel="next"`
+	if link := rr.Header().Get("Link"); link != expected {
+		t.Errorf("expected link %q but got %q", expected, link)
+	}
+
+	// Following it leads back.
+	req, _ = http.NewRequest("GET", "/api/v1/categories?page_size=2&cursor="+body.NextCursor, nil)
+	rr = httptest.NewRecorder()
+	http.HandlerFunc(app.GetCategories).ServeHTTP(rr, req)
+	if link := rr.Header().Get("Link"); !strings.Contains(link, `rel="first"`) || !strings.Contains(link, `rel="prev"`) {
+		t.Errorf("expected links to the first and previous pages but got %q", link)
+	}
+
+	// Numbered pages are counted and link by number.
+	req, _ = http.NewRequest("GET", "/api/v1/categories?page=1&page_size=1", nil)
+	rr = httptest.NewRecorder()
+	http.HandlerFunc(app.GetCategories).ServeHTTP(rr, req)
+	body.TotalCount = nil
+	if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if body.TotalCount == nil || *body.TotalCount != 2 {
+		t.Errorf("expected a total count of 2 but got %v", body.TotalCount)
+	}
+	expected = `</api/v1/categories?page=1&page_size=1>; rel="first", </api/v1/categories?page=2&page_size=1>; rel="next", </api/v1/categories?page=2&page_size=1>; rel="last"`
+	if link := rr.Header().Get("Link"); link != expected {
+		t.Errorf("expected link %q but got %q", expected, link)
+	}
+}
@@ -0,0 +1,132 @@
+package store
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/MinhNHHH/online-store/pkg/databases/schema"
+	"github.com/MinhNHHH/online-store/pkg/mailer"
+)
+
+var passwordResetExpiry = time.Minute * 30
+
+// hashToken is how single-use tokens are stored. They carry enough entropy
+// that a plain SHA-256 is sufficient.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// forgotPassword emails a reset link when the address belongs to a user. The
+// response is the same either way so it cannot be used to probe for accounts.
+func (app *OnlineStore) forgotPassword(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Email string `json:"email"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		log.Printf("Error decoding request: %v", err)
+		app.SendError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	response := struct {
+		Message string `json:"message"`
+	}{
+		Message: "if the email is registered, a reset link has been sent",
+	}
+
+	user, err := app.DB.GetUserByEmail(request.Email)
+	if err != nil {
+		app.recordAuthEvent(r, schema.AuthEvent{Type: eventPasswordResetRequest, Outcome: outcomeFailure, Reason: reasonUnknownEmail, Email: request.Email})
+		app.SendResponse(w, http.StatusAccepted, response)
+		return
+	}
+
+	// the token is stored in the background as well, so that known emails
+	// answer as fast as unknown ones
+	go func() {
+		if err := app.startPasswordReset(user); err != nil {
+			log.Printf("Error starting password reset: %v", err)
+		}
+	}()
+	app.recordAuthEvent(r, schema.AuthEvent{Type: eventPasswordResetRequest, Outcome: outcomeSuccess, UserID: &user.ID, Email: user.Email})
+	app.SendResponse(w, http.StatusAccepted, response)
+}
+
+// startPasswordReset stores a new reset token for user and mails it to them.
+// Delivery happens in the background.
+func (app *OnlineStore) startPasswordReset(user *schema.User) error {
+	token, err := randomToken(32)
+	if err != nil {
+		return err
+	}
+
+	err = app.DB.InsertPasswordResetToken(user.ID, hashToken(token), time.Now().Add(passwordResetExpiry))
+	if err != nil {
+		return err
+	}
+
+	go app.sendPasswordResetEmail(user, token)
+	return nil
+}
+
+func (app *OnlineStore) sendPasswordResetEmail(user *schema.User, token string) {
+	link := fmt.Sprintf("%s/reset-password?token=%s", app.Cfgs.APP_URL, url.QueryEscape(token))
+	msg := mailer.Message{
+		To:      []string{user.Email},
+		Subject: "Reset your password",
+		Body: fmt.Sprintf("Hi %s,\n\nUse the link below to choose a new password. It expires in %d minutes and can only be used once.\n\n%s\n\nIf you did not ask for this, you can ignore this email.\n",
+			user.Name, int(passwordResetExpiry.Minutes()), link),
+	}
+	if err := app.Mailer.Send(msg); err != nil {
+		log.Printf("Error sending password reset email: %v", err)
+	}
+}
+
+func (app *OnlineStore) resetPassword(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		log.Printf("Error decoding request: %v", err)
+		app.SendError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if !app.checkPassword(w, "password", request.Password) {
+		return
+	}
+
+	userID, err := app.DB.ResetPasswordWithToken(hashToken(request.Token), request.Password)
+	if errors.Is(err, sql.ErrNoRows) {
+		app.recordAuthEvent(r, schema.AuthEvent{Type: eventPasswordReset, Outcome: outcomeFailure, Reason: reasonInvalidToken})
+		app.SendError(w, http.StatusBadRequest, errors.New("invalid or expired reset token"))
+		return
+	}
+	if err != nil {
+		log.Printf("Error resetting password: %v", err)
+		app.SendError(w, http.StatusInternalServerError, errors.New("could not reset password"))
+		return
+	}
+
+	// A reset usually means the old password is compromised, so end every
+	// session that was started with it.
+	if err := app.DB.RevokeUserRefreshTokens(userID); err != nil {
+		log.Printf("Error revoking refresh tokens: %v", err)
+	}
+	if err := app.revokeSessions(r, userID); err != nil {
+		log.Printf("Error revoking sessions: %v", err)
+	}
+
+	app.recordAuthEvent(r, schema.AuthEvent{Type: eventPasswordReset, Outcome: outcomeSuccess, UserID: &userID})
+	app.SendResponse(w, http.StatusOK, nil)
+}
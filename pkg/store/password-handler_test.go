@@ -0,0 +1,80 @@
+package store
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/MinhNHHH/online-store/pkg/databases/schema"
+)
+
+func Test_app_forgotPassword(t *testing.T) {
+	var theTests = []struct {
+		name               string
+		requestBody        string
+		expectedStatusCode int
+	}{
+		{"registered email", `{"email":"admin@example.com"}`, http.StatusAccepted},
+		{"unknown email", `{"email":"nobody@example.com"}`, http.StatusAccepted},
+		{"not json", `i am not JSON`, http.StatusBadRequest},
+	}
+
+	var bodies []string
+	for _, e := range theTests {
+		req, _ := http.NewRequest("POST", "/api/v1/auth/forgot-password", strings.NewReader(e.requestBody))
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(app.forgotPassword)
+		handler.ServeHTTP(rr, req)
+		if e.expectedStatusCode != rr.Code {
+			t.Errorf("%s: returned wrong status code; expected %d but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+		if rr.Code == http.StatusAccepted {
+			bodies = append(bodies, rr.Body.String())
+		}
+	}
+
+	if len(bodies) == 2 && bodies[0] != bodies[1] {
+		t.Errorf("responses for registered and unknown emails differ: %q vs %q", bodies[0], bodies[1])
+	}
+}
+
+func Test_app_sendPasswordResetEmail(t *testing.T) {
+	app.sendPasswordResetEmail(&schema.User{Name: "Admin", Email: "admin@example.com"}, "abc123")
+
+	found := false
+	for _, msg := range outbox.Sent() {
+		if strings.Contains(msg.Body, "http://example.com/reset-password?token=abc123") {
+			found = true
+			if msg.To[0] != "admin@example.com" {
+				t.Errorf("expected message to admin@example.com, got %v", msg.To)
+			}
+		}
+	}
+	if !found {
+		t.Error("expected a message containing the reset link")
+	}
+}
+
+func Test_app_resetPassword(t *testing.T) {
+	var theTests = []struct {
+		name               string
+		requestBody        string
+		expectedStatusCode int
+	}{
+		{"valid token", `{"token":"valid-reset-token", "password":"new-secret"}`, http.StatusOK},
+		{"invalid token", `{"token":"used-reset-token", "password":"new-secret"}`, http.StatusBadRequest},
+		{"empty password", `{"token":"valid-reset-token", "password":""}`, http.StatusBadRequest},
+		{"not json", `i am not JSON`, http.StatusBadRequest},
+	}
+
+	for _, e := range theTests {
+		req, _ := http.NewRequest("POST", "/api/v1/auth/reset-password", strings.NewReader(e.requestBody))
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(app.resetPassword)
+		handler.ServeHTTP(rr, req)
+		if e.expectedStatusCode != rr.Code {
+			t.Errorf("%s: returned wrong status code; expected %d but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+	}
+}
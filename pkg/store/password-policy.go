@@ -0,0 +1,55 @@
+package store
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/MinhNHHH/online-store/pkg/cfgs"
+	"github.com/MinhNHHH/online-store/pkg/passwords"
+)
+
+// LoadPasswordPolicy builds the policy new passwords must meet, opening the
+// breached password list unless PASSWORD_BREACHED_LIST is empty.
+func LoadPasswordPolicy(c cfgs.Configs) (*passwords.Policy, error) {
+	var breached *passwords.BreachedList
+	if c.PASSWORD_BREACHED_LIST != "" {
+		var err error
+		breached, err = passwords.OpenBreachedList(c.PASSWORD_BREACHED_LIST)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return passwords.NewPolicy(c.PASSWORD_MIN_LENGTH, c.PASSWORD_MAX_BYTES, breached), nil
+}
+
+// passwordPolicy returns app.Passwords, or a policy without a breached list
+// when none was loaded.
+func (app *OnlineStore) passwordPolicy() *passwords.Policy {
+	if app.Passwords != nil {
+		return app.Passwords
+	}
+	return passwords.NewPolicy(app.Cfgs.PASSWORD_MIN_LENGTH, app.Cfgs.PASSWORD_MAX_BYTES, nil)
+}
+
+// checkPassword checks a new password sent in field against the policy. When
+// it breaks the policy, it writes the violations as a validation error and
+// returns false.
+func (app *OnlineStore) checkPassword(w http.ResponseWriter, field, password string) bool {
+	violations, err := app.passwordPolicy().Check(password)
+	if err != nil {
+		log.Printf("Error checking password: %v", err)
+		app.SendError(w, http.StatusInternalServerError, errors.New("could not check password"))
+		return false
+	}
+	if len(violations) == 0 {
+		return true
+	}
+
+	fields := make([]FieldError, 0, len(violations))
+	for _, v := range violations {
+		fields = append(fields, FieldError{Field: field, Code: v.Code, Message: v.Message})
+	}
+	app.SendValidationError(w, fields)
+	return false
+}
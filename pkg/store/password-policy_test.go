@@ -0,0 +1,72 @@
+package store
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/MinhNHHH/online-store/pkg/passwords"
+)
+
+func Test_LoadPasswordPolicy(t *testing.T) {
+	testApp := app
+	testApp.Cfgs.PASSWORD_BREACHED_LIST = "../../data/breached-passwords.txt"
+	policy, err := LoadPasswordPolicy(testApp.Cfgs)
+	if err != nil {
+		t.Fatalf("could not load the bundled list: %v", err)
+	}
+	if found, _ := policy.Breached.Contains("password123"); !found {
+		t.Error("expected password123 in the bundled list")
+	}
+
+	testApp.Cfgs.PASSWORD_BREACHED_LIST = "no-such-file.txt"
+	if _, err := LoadPasswordPolicy(testApp.Cfgs); err == nil {
+		t.Error("expected an error for a missing list")
+	}
+}
+
+func Test_app_passwordPolicyErrors(t *testing.T) {
+	testApp := app
+	testApp.Cfgs.PASSWORD_BREACHED_LIST = "../../data/breached-passwords.txt"
+	testApp.Passwords, _ = LoadPasswordPolicy(testApp.Cfgs)
+	routes := testApp.Routes()
+
+	var theTests = []struct {
+		name          string
+		url           string
+		body          string
+		expectedField string
+		expectedCodes []string
+	}{
+		{"register with short password", "/api/v1/auth/register", `{"name":"New", "email":"new@example.com", "password":"short"}`, "password", []string{passwords.CodeTooShort}},
+		{"register with breached password", "/api/v1/auth/register", `{"name":"New", "email":"new@example.com", "password":"password123"}`, "password", []string{passwords.CodeBreached}},
+		{"register with long password", "/api/v1/auth/register", `{"name":"New", "email":"new@example.com", "password":"` + strings.Repeat("x", 73) + `"}`, "password", []string{passwords.CodeTooLong}},
+		{"reset to breached password", "/api/v1/auth/reset-password", `{"token":"valid-reset-token", "password":"iloveyou"}`, "password", []string{passwords.CodeBreached}},
+		{"reset to empty password", "/api/v1/auth/reset-password", `{"token":"valid-reset-token", "password":""}`, "password", []string{passwords.CodeTooShort}},
+	}
+
+	for _, e := range theTests {
+		req, _ := http.NewRequest("POST", e.url, strings.NewReader(e.body))
+		rr := httptest.NewRecorder()
+		routes.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("%s: returned wrong status code; expected %d but got %d", e.name, http.StatusBadRequest, rr.Code)
+			continue
+		}
+		var response ValidationErrorResponse
+		_ = json.NewDecoder(rr.Body).Decode(&response)
+		var codes []string
+		for _, f := range response.Fields {
+			if f.Field != e.expectedField || f.Message == "" {
+				t.Errorf("%s: unexpected field error %+v", e.name, f)
+			}
+			codes = append(codes, f.Code)
+		}
+		if strings.Join(codes, ",") != strings.Join(e.expectedCodes, ",") {
+			t.Errorf("%s: expected %v but got %v", e.name, e.expectedCodes, codes)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+)
+
+const principalContextKey contextKey = "principal"
+
+// Principal is who a request is made by, as established by authRequired: a
+// user signed in with an access token or cookie session, or a machine client
+// with an API key.
+type Principal struct {
+	// UserID is zero for API keys.
+	UserID        int
+	Admin         bool
+	EmailVerified bool
+	MFA           bool
+	// APIKeyID and Scopes are only set for API keys.
+	APIKeyID int
+	Scopes   []string
+}
+
+var errActForOtherUser = errors.New("forbidden: cannot act for another user")
+
+func withPrincipal(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, principalContextKey, p)
+}
+
+// principalFromContext returns the principal authRequired stored in ctx.
+func principalFromContext(ctx context.Context) (*Principal, bool) {
+	p, ok := ctx.Value(principalContextKey).(*Principal)
+	return p, ok
+}
+
+func principalFromClaims(claims *Claims) (*Principal, error) {
+	userID, err := strconv.Atoi(claims.Subject)
+	if err != nil {
+		return nil, err
+	}
+	return &Principal{
+		UserID:        userID,
+		Admin:         claims.Admin,
+		EmailVerified: claims.EmailVerified,
+		MFA:           claims.MFA,
+	}, nil
+}
+
+// requestPrincipal returns the principal stored by authRequired, or works it
+// out from the access token or cookie session when the handler runs without
+// it.
+func (app *OnlineStore) requestPrincipal(w http.ResponseWriter, r *http.Request) (*Principal, error) {
+	if p, ok := principalFromContext(r.Context()); ok {
+		return p, nil
+	}
+	claims, err := app.requestClaims(w, r)
+	if err != nil {
+		return nil, err
+	}
+	return principalFromClaims(claims)
+}
+
+// actingUserID returns the user a request by p acts for. That is p itself
+// unless an admin names another user in requested; zero names nobody.
+func (app *OnlineStore) actingUserID(p *Principal, requested int) (int, error) {
+	if p.UserID == 0 {
+		return 0, errActForOtherUser
+	}
+	if requested == 0 || requested == p.UserID {
+		return p.UserID, nil
+	}
+	if !app.actsAsAdmin(p) {
+		return 0, errActForOtherUser
+	}
+	return requested, nil
+}
+
+// actsAsAdmin reports whether p may act on the data of other users: p is an
+// admin, signed in with a second factor when ADMIN_REQUIRE_2FA is set.
+func (app *OnlineStore) actsAsAdmin(p *Principal) bool {
+	return p.Admin && (!app.Cfgs.ADMIN_REQUIRE_2FA || p.MFA)
+}
@@ -1,50 +1,163 @@
 package store
 
 import (
+	"database/sql"
 	"encoding/json"
+	"errors"
 	"log"
+	"math"
 	"net/http"
+	"net/url"
+	"slices"
 	"strconv"
+	"strings"
 
+	databases "github.com/MinhNHHH/online-store/pkg/databases/repositories"
 	"github.com/MinhNHHH/online-store/pkg/databases/schema"
-	"github.com/go-chi/chi"
+	"github.com/MinhNHHH/online-store/pkg/slug"
 )
 
 func (app *OnlineStore) GetProducts(w http.ResponseWriter, r *http.Request) {
-	productName := r.URL.Query().Get("product_name")
-	categoryName := r.URL.Query().Get("category_name")
-	status := r.URL.Query().Get("status")
-	page, err := strconv.Atoi(r.URL.Query().Get("page"))
-	if err != nil {
-		page = 1
-	}
-	pageSize, err := strconv.Atoi(r.URL.Query().Get("page_size"))
-	if err != nil {
-		pageSize = 10
+	filter, fields := productFilter(r.URL.Query())
+	page, pageFields := readPage(r.URL.Query())
+	if fields = append(fields, pageFields...); len(fields) > 0 {
+		app.SendValidationError(w, fields)
+		return
 	}
 
-	products, total, err := app.DB.AllProducts(productName, categoryName, status, page, pageSize)
+	products, info, err := app.DB.AllProducts(filter, page)
+	if errors.Is(err, databases.ErrInvalidCursor) {
+		app.SendValidationError(w, []FieldError{invalidCursorField})
+		return
+	}
 	if err != nil {
 		log.Printf("Error getting products: %v", err)
 		app.SendResponse(w, http.StatusBadRequest, err)
 		return
 	}
+	facets, err := app.DB.ProductFacets(filter)
+	if err != nil {
+		log.Printf("Error getting product facets: %v", err)
+		app.SendError(w, http.StatusInternalServerError, errors.New("could not get products"))
+		return
+	}
+	app.withImageURLs(products...)
 	response := struct {
-		Products   []*schema.Product `json:"products"`
-		TotalCount int               `json:"total_count"`
-		Page       int               `json:"page"`
-		PageSize   int               `json:"page_size"`
-		TotalPages int               `json:"total_pages"`
+		Products []*schema.Product     `json:"products"`
+		Facets   *schema.ProductFacets `json:"facets"`
+		listPage
 	}{
-		Products:   products,
-		TotalCount: total,
-		Page:       page,
-		PageSize:   pageSize,
-		TotalPages: (total + 9) / 10,
+		Products: products,
+		Facets:   facets,
+		listPage: pageLinks(w, r, page, info),
 	}
 	app.SendResponse(w, http.StatusOK, response)
 }
 
+// productFilter reads the filter and order of a product list from its query
+// string. Without a sort, searches are sorted by relevance and everything else
+// by newest; order defaults to descending, but ascending for price and name.
+func productFilter(query url.Values) (schema.ProductFilter, []FieldError) {
+	filter := schema.ProductFilter{
+		Name:         query.Get("product_name"),
+		CategoryName: query.Get("category_name"),
+		Status:       query.Get("status"),
+		Query:        query.Get("q"),
+		Sort:         query.Get("sort"),
+	}
+	var fields []FieldError
+
+	if refs := query.Get("category_ids"); refs != "" {
+		for _, ref := range strings.Split(refs, ",") {
+			ref = strings.TrimSpace(ref)
+			if categoryID, err := strconv.Atoi(ref); err == nil && categoryID > 0 {
+				filter.CategoryIDs = append(filter.CategoryIDs, categoryID)
+				continue
+			}
+			if !slug.Valid(ref) {
+				fields = append(fields, FieldError{Field: "category_ids", Code: "invalid", Message: "must be a comma separated list of category IDs or slugs"})
+				break
+			}
+			filter.CategorySlugs = append(filter.CategorySlugs, ref)
+		}
+	}
+
+	number := func(field string) *float64 {
+		value := query.Get(field)
+		if value == "" {
+			return nil
+		}
+		n, err := strconv.ParseFloat(value, 64)
+		if err != nil || math.IsNaN(n) || math.IsInf(n, 0) || n < 0 {
+			fields = append(fields, FieldError{Field: field, Code: "invalid", Message: "must be a number, not negative"})
+			return nil
+		}
+		return &n
+	}
+	filter.MinPrice = number("min_price")
+	filter.MaxPrice = number("max_price")
+	if filter.MinPrice != nil && filter.MaxPrice != nil && *filter.MinPrice > *filter.MaxPrice {
+		fields = append(fields, FieldError{Field: "max_price", Code: "invalid", Message: "must not be less than min_price"})
+	}
+	filter.MinRating = number("min_rating")
+	if filter.MinRating != nil && (*filter.MinRating < 1 || *filter.MinRating > 5) {
+		fields = append(fields, FieldError{Field: "min_rating", Code: "invalid", Message: "must be between 1 and 5"})
+	}
+
+	if value := query.Get("in_stock"); value != "" {
+		inStock, err := strconv.ParseBool(value)
+		if err != nil {
+			fields = append(fields, FieldError{Field: "in_stock", Code: "invalid", Message: "must be true or false"})
+		}
+		filter.InStock = inStock
+	}
+
+	switch filter.Sort {
+	case "":
+		filter.Sort = schema.ProductSortNewest
+		if strings.TrimSpace(filter.Query) != "" {
+			filter.Sort = schema.ProductSortRelevance
+		}
+	case schema.ProductSortRelevance:
+		if strings.TrimSpace(filter.Query) == "" {
+			fields = append(fields, FieldError{Field: "sort", Code: "invalid", Message: "relevance needs a search query"})
+		}
+	case schema.ProductSortNewest, schema.ProductSortPrice, schema.ProductSortName, schema.ProductSortRating:
+	default:
+		fields = append(fields, FieldError{Field: "sort", Code: "invalid", Message: "must be relevance, newest, price, name or rating"})
+	}
+
+	switch query.Get("order") {
+	case "":
+		filter.Descending = filter.Sort != schema.ProductSortPrice && filter.Sort != schema.ProductSortName
+	case "asc":
+	case "desc":
+		filter.Descending = true
+	default:
+		fields = append(fields, FieldError{Field: "order", Code: "invalid", Message: "must be asc or desc"})
+	}
+
+	return filter, fields
+}
+
+// GetProduct sends one product, named by its ID or its slug, with a summary
+// of its reviews.
+func (app *OnlineStore) GetProduct(w http.ResponseWriter, r *http.Request) {
+	product, ok := app.productFromURL(w, r)
+	if !ok {
+		return
+	}
+	var err error
+	product.RatingHistogram, err = app.DB.RatingHistogram(product.ID)
+	if err != nil {
+		log.Printf("Error getting rating histogram: %v", err)
+		app.SendError(w, http.StatusInternalServerError, errors.New("could not get product"))
+		return
+	}
+	app.withImageURLs(product)
+	app.SendResponse(w, http.StatusOK, product)
+}
+
 func (app *OnlineStore) CreateProduct(w http.ResponseWriter, r *http.Request) {
 	var product schema.Product
 	err := json.NewDecoder(r.Body).Decode(&product)
@@ -53,6 +166,10 @@ func (app *OnlineStore) CreateProduct(w http.ResponseWriter, r *http.Request) {
 		app.SendResponse(w, http.StatusBadRequest, err)
 		return
 	}
+	if fields, ok := normalizeOptions(product.Options); !ok {
+		app.SendValidationError(w, fields)
+		return
+	}
 	id, err := app.DB.InsertProduct(&product)
 	if err != nil {
 		log.Printf("Error inserting product: %v", err)
@@ -67,7 +184,13 @@ func (app *OnlineStore) CreateProduct(w http.ResponseWriter, r *http.Request) {
 	app.SendResponse(w, http.StatusCreated, response)
 }
 
+// UpdateProduct updates the product named by the URL. A slug in the body
+// renames the product; its old slug keeps leading to it.
 func (app *OnlineStore) UpdateProduct(w http.ResponseWriter, r *http.Request) {
+	id, ok := app.productIDFromParam(w, r, "id")
+	if !ok {
+		return
+	}
 	var product schema.Product
 	err := json.NewDecoder(r.Body).Decode(&product)
 	if err != nil {
@@ -75,7 +198,33 @@ func (app *OnlineStore) UpdateProduct(w http.ResponseWriter, r *http.Request) {
 		app.SendResponse(w, http.StatusBadRequest, err)
 		return
 	}
+	product.ID = id
+	if product.Slug != "" && !slug.Valid(product.Slug) {
+		app.SendValidationError(w, []FieldError{invalidSlugField})
+		return
+	}
+	if product.Options != nil {
+		if fields, ok := normalizeOptions(product.Options); !ok {
+			app.SendValidationError(w, fields)
+			return
+		}
+		// Variants are defined by the options, so they cannot change under them.
+		current, err := app.DB.GetProduct(product.ID)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			log.Printf("Error getting product: %v", err)
+			app.SendError(w, http.StatusInternalServerError, errors.New("could not update product"))
+			return
+		}
+		if err == nil && len(current.Variants) > 0 && !slices.Equal(current.Options, product.Options) {
+			app.SendError(w, http.StatusConflict, errors.New("options cannot change while the product has variants"))
+			return
+		}
+	}
 	err = app.DB.UpdateProduct(&product)
+	if errors.Is(err, databases.ErrSlugTaken) {
+		app.SendError(w, http.StatusConflict, err)
+		return
+	}
 	if err != nil {
 		log.Printf("Error updating product: %v", err)
 		app.SendResponse(w, http.StatusBadRequest, err)
@@ -85,17 +234,21 @@ func (app *OnlineStore) UpdateProduct(w http.ResponseWriter, r *http.Request) {
 }
 
 func (app *OnlineStore) DeleteProduct(w http.ResponseWriter, r *http.Request) {
-	id, err := strconv.Atoi(chi.URLParam(r, "id"))
-	if err != nil {
-		log.Printf("Error parsing product ID: %v", err)
-		app.SendResponse(w, http.StatusBadRequest, err)
+	id, ok := app.productIDFromParam(w, r, "id")
+	if !ok {
 		return
 	}
-	err = app.DB.DeleteProduct(id)
+	// The images go with the product, so their files are removed after it.
+	var images []schema.ProductImage
+	if product, err := app.DB.GetProduct(id); err == nil {
+		images = product.Images
+	}
+	err := app.DB.DeleteProduct(id)
 	if err != nil {
 		log.Printf("Error deleting product: %v", err)
 		app.SendResponse(w, http.StatusInternalServerError, err)
 		return
 	}
+	app.deleteImageFiles(images...)
 	app.SendResponse(w, http.StatusOK, nil)
 }
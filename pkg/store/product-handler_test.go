@@ -0,0 +1,253 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/MinhNHHH/online-store/pkg/databases/repositories/dbrepo"
+	"github.com/MinhNHHH/online-store/pkg/databases/schema"
+	"github.com/go-chi/chi"
+)
+
+// productRecorder keeps the last product written and the last filter used.
+type productRecorder struct {
+	dbrepo.TestDBRepo
+	product *schema.Product
+	filter  schema.ProductFilter
+}
+
+func (p *productRecorder) AllProducts(filter schema.ProductFilter, page schema.Page) ([]*schema.Product, *schema.PageInfo, error) {
+	p.filter = filter
+	return []*schema.Product{}, &schema.PageInfo{}, nil
+}
+
+func (p *productRecorder) InsertProduct(product *schema.Product) (int, error) {
+	p.product = product
+	return 1, nil
+}
+
+func (p *productRecorder) UpdateProduct(product *schema.Product) error {
+	p.product = product
+	return p.TestDBRepo.UpdateProduct(product)
+}
+
+func Test_app_CreateProductCategories(t *testing.T) {
+	var tests = []struct {
+		name               string
+		requestBody        string
+		expectedStatusCode int
+		expectedCategories []schema.CategoryRef
+	}{
+		{
+			name:               "category ids",
+			requestBody:        `{"name": "Phone", "price": 10, "categories": [1, 2]}`,
+			expectedStatusCode: http.StatusCreated,
+			expectedCategories: []schema.CategoryRef{{ID: 1}, {ID: 2}},
+		},
+		{
+			name:               "categories as read back",
+			requestBody:        `{"name": "Phone", "price": 10, "categories": [{"id": 3, "name": "Phones"}]}`,
+			expectedStatusCode: http.StatusCreated,
+			expectedCategories: []schema.CategoryRef{{ID: 3, Name: "Phones"}},
+		},
+		{
+			name:               "no categories",
+			requestBody:        `{"name": "Phone", "price": 10}`,
+			expectedStatusCode: http.StatusCreated,
+		},
+		{
+			name:               "invalid category",
+			requestBody:        `{"name": "Phone", "price": 10, "categories": ["phones"]}`,
+			expectedStatusCode: http.StatusBadRequest,
+		},
+	}
+
+	for _, e := range tests {
+		recorder := &productRecorder{}
+		testApp := app
+		testApp.DB = recorder
+
+		req, _ := http.NewRequest("POST", "/products", bytes.NewBufferString(e.requestBody))
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(testApp.CreateProduct)
+		handler.ServeHTTP(rr, req)
+
+		if e.expectedStatusCode != rr.Code {
+			t.Errorf("%s: returned wrong status code; expected %d but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+		if rr.Code == http.StatusCreated && !reflect.DeepEqual(recorder.product.Categories, e.expectedCategories) {
+			t.Errorf("%s: expected categories %v but got %v", e.name, e.expectedCategories, recorder.product.Categories)
+		}
+	}
+}
+
+func Test_app_UpdateProductKeepsCategories(t *testing.T) {
+	recorder := &productRecorder{}
+	testApp := app
+	testApp.DB = recorder
+
+	req, _ := http.NewRequest("PUT", "/products/1", bytes.NewBufferString(`{"name": "Phone"}`))
+	rctx := chi.NewRouteContext()
+	rctx.URLParams.Add("id", "1")
+	req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(testApp.UpdateProduct)
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("returned wrong status code; expected %d but got %d", http.StatusOK, rr.Code)
+	}
+	// nil tells UpdateProduct to leave the categories alone.
+	if recorder.product.Categories != nil {
+		t.Errorf("expected no categories but got %v", recorder.product.Categories)
+	}
+}
+
+func Test_app_GetProductsFilter(t *testing.T) {
+	recorder := &productRecorder{}
+	testApp := app
+	testApp.DB = recorder
+
+	req, _ := http.NewRequest("GET", "/products?q=red+shoes&category_name=Footwear&status=in_stock&product_name=run", nil)
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(testApp.GetProducts)
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("returned wrong status code; expected %d but got %d", http.StatusOK, rr.Code)
+	}
+	expected := schema.ProductFilter{Name: "run", CategoryName: "Footwear", Status: "in_stock", Query: "red shoes", Sort: schema.ProductSortRelevance, Descending: true}
+	if !reflect.DeepEqual(recorder.filter, expected) {
+		t.Errorf("expected filter %+v but got %+v", expected, recorder.filter)
+	}
+}
+
+func Test_app_GetProductsFacetedFilter(t *testing.T) {
+	one, five, four := 1.0, 5.0, 4.0
+	var tests = []struct {
+		name           string
+		query          string
+		expectedStatus int
+		expectedFilter schema.ProductFilter
+	}{
+		{"default sort", "", http.StatusOK, schema.ProductFilter{Sort: schema.ProductSortNewest, Descending: true}},
+		{"price ascending by default", "sort=price", http.StatusOK, schema.ProductFilter{Sort: schema.ProductSortPrice}},
+		{"name descending", "sort=name&order=desc", http.StatusOK, schema.ProductFilter{Sort: schema.ProductSortName, Descending: true}},
+		{"rating ascending", "sort=rating&order=asc", http.StatusOK, schema.ProductFilter{Sort: schema.ProductSortRating}},
+		{"all filters", "category_ids=2,%203&min_price=1&max_price=5&min_rating=4&in_stock=true", http.StatusOK,
+			schema.ProductFilter{CategoryIDs: []int{2, 3}, MinPrice: &one, MaxPrice: &five, MinRating: &four, InStock: true, Sort: schema.ProductSortNewest, Descending: true}},
+		{"category slugs", "category_ids=2,shoes", http.StatusOK,
+			schema.ProductFilter{CategoryIDs: []int{2}, CategorySlugs: []string{"shoes"}, Sort: schema.ProductSortNewest, Descending: true}},
+		{"bad category ids", "category_ids=2,Shoes!", http.StatusBadRequest, schema.ProductFilter{}},
+		{"negative price", "min_price=-1", http.StatusBadRequest, schema.ProductFilter{}},
+		{"price range reversed", "min_price=5&max_price=1", http.StatusBadRequest, schema.ProductFilter{}},
+		{"rating out of range", "min_rating=6", http.StatusBadRequest, schema.ProductFilter{}},
+		{"bad in_stock", "in_stock=maybe", http.StatusBadRequest, schema.ProductFilter{}},
+		{"unknown sort", "sort=popularity", http.StatusBadRequest, schema.ProductFilter{}},
+		{"relevance without query", "sort=relevance", http.StatusBadRequest, schema.ProductFilter{}},
+		{"unknown order", "sort=price&order=up", http.StatusBadRequest, schema.ProductFilter{}},
+	}
+
+	for _, e := range tests {
+		recorder := &productRecorder{}
+		testApp := app
+		testApp.DB = recorder
+
+		req, _ := http.NewRequest("GET", "/products?"+e.query, nil)
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(testApp.GetProducts)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatus {
+			t.Errorf("%s: returned wrong status code; expected %d but got %d", e.name, e.expectedStatus, rr.Code)
+		}
+		if !reflect.DeepEqual(recorder.filter, e.expectedFilter) {
+			t.Errorf("%s: expected filter %+v but got %+v", e.name, e.expectedFilter, recorder.filter)
+		}
+	}
+}
+
+func Test_app_GetProduct(t *testing.T) {
+	var tests = []struct {
+		name               string
+		ref                string
+		expectedStatusCode int
+	}{
+		{"by id", "1", http.StatusOK},
+		{"by slug", "t-shirt", http.StatusOK},
+		{"missing id", "2", http.StatusNotFound},
+		{"missing slug", "red-wool-scarf", http.StatusNotFound},
+		{"old slug", "tee", http.StatusMovedPermanently},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("GET", "/products/"+e.ref, nil)
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("id", e.ref)
+		req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(app.GetProduct)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: returned wrong status code; expected %d but got %d", e.name, e.expectedStatusCode, rr.Code)
+			continue
+		}
+		if rr.Code == http.StatusMovedPermanently && rr.Header().Get("Location") != "/products/t-shirt" {
+			t.Errorf("%s: expected a redirect to /products/t-shirt but got %q", e.name, rr.Header().Get("Location"))
+		}
+		if rr.Code != http.StatusOK {
+			continue
+		}
+		var product schema.Product
+		if err := json.Unmarshal(rr.Body.Bytes(), &product); err != nil {
+			t.Fatal(err)
+		}
+		expected := map[int]int{1: 0, 2: 0, 3: 0, 4: 0, 5: 2}
+		if product.ID != 1 || !reflect.DeepEqual(product.RatingHistogram, expected) {
+			t.Errorf("%s: expected product 1 with histogram %v but got %d with %v", e.name, expected, product.ID, product.RatingHistogram)
+		}
+	}
+}
+
+func Test_app_UpdateProductSlug(t *testing.T) {
+	var tests = []struct {
+		name               string
+		ref                string
+		requestBody        string
+		expectedStatusCode int
+	}{
+		{"new slug", "1", `{"name": "T-shirt", "slug": "cotton-t-shirt"}`, http.StatusOK},
+		{"by old slug", "tee", `{"name": "T-shirt"}`, http.StatusOK},
+		{"invalid slug", "1", `{"name": "T-shirt", "slug": "Cotton T-shirt"}`, http.StatusBadRequest},
+		{"digits only", "1", `{"name": "T-shirt", "slug": "2024"}`, http.StatusBadRequest},
+		{"slug taken", "1", `{"name": "T-shirt", "slug": "taken"}`, http.StatusConflict},
+		{"unknown slug", "red-wool-scarf", `{"name": "Scarf"}`, http.StatusNotFound},
+	}
+
+	for _, e := range tests {
+		recorder := &productRecorder{}
+		testApp := app
+		testApp.DB = recorder
+
+		req, _ := http.NewRequest("PUT", "/products/"+e.ref, bytes.NewBufferString(e.requestBody))
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("id", e.ref)
+		req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(testApp.UpdateProduct)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: returned wrong status code; expected %d but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+		if rr.Code == http.StatusOK && recorder.product.ID != 1 {
+			t.Errorf("%s: expected product 1 to be updated but got %d", e.name, recorder.product.ID)
+		}
+	}
+}
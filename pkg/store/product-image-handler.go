@@ -0,0 +1,362 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	// Registered for image.Decode; JPEG and PNG come with the encoders.
+	_ "image/gif"
+
+	databases "github.com/MinhNHHH/online-store/pkg/databases/repositories"
+	"github.com/MinhNHHH/online-store/pkg/databases/schema"
+	"github.com/MinhNHHH/online-store/pkg/thumbnail"
+	"github.com/go-chi/chi"
+)
+
+// imageExtensions are the image types products can have, as sniffed from
+// the uploaded bytes, with the extension they are stored under.
+var imageExtensions = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/gif":  ".gif",
+}
+
+const (
+	// defaultImageMaxBytes is used when IMAGE_MAX_BYTES is not set.
+	defaultImageMaxBytes = 10 << 20
+	// maxImagePixels bounds decoded images, so that a small file cannot
+	// claim dimensions that take gigabytes to decode.
+	maxImagePixels = 40_000_000
+	// maxAltTextLength matches the alt_text column.
+	maxAltTextLength = 255
+)
+
+func (app *OnlineStore) GetProductImages(w http.ResponseWriter, r *http.Request) {
+	product, ok := app.productFromURL(w, r)
+	if !ok {
+		return
+	}
+	app.withImageURLs(product)
+	app.SendResponse(w, http.StatusOK, product.Images)
+}
+
+// UploadProductImage takes a multipart form with the image in the field
+// "image" and its alt text in "alt_text". The image is added after the
+// product's other images.
+func (app *OnlineStore) UploadProductImage(w http.ResponseWriter, r *http.Request) {
+	if app.Storage == nil {
+		app.SendError(w, http.StatusServiceUnavailable, errors.New("image uploads are not configured"))
+		return
+	}
+	product, ok := app.productFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	maxBytes := int64(app.Cfgs.IMAGE_MAX_BYTES)
+	if maxBytes < 1 {
+		maxBytes = defaultImageMaxBytes
+	}
+	tooLarge := fmt.Errorf("image must be at most %d bytes", maxBytes)
+
+	// Leave room for the rest of the form around the image.
+	r.Body = http.MaxBytesReader(w, r.Body, maxBytes+64<<10)
+	if err := r.ParseMultipartForm(maxBytes); err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			app.SendError(w, http.StatusRequestEntityTooLarge, tooLarge)
+			return
+		}
+		log.Printf("Error parsing upload: %v", err)
+		app.SendError(w, http.StatusBadRequest, errors.New("expected a multipart form"))
+		return
+	}
+	defer r.MultipartForm.RemoveAll()
+
+	file, header, err := r.FormFile("image")
+	if err != nil {
+		app.SendValidationError(w, []FieldError{{Field: "image", Code: "required", Message: "must be uploaded"}})
+		return
+	}
+	defer file.Close()
+	if header.Size > maxBytes {
+		app.SendError(w, http.StatusRequestEntityTooLarge, tooLarge)
+		return
+	}
+	data, err := io.ReadAll(file)
+	if err != nil {
+		log.Printf("Error reading upload: %v", err)
+		app.SendError(w, http.StatusBadRequest, errors.New("could not read image"))
+		return
+	}
+
+	// The declared content type is not trusted; the bytes decide.
+	contentType := http.DetectContentType(data)
+	ext, ok := imageExtensions[contentType]
+	if !ok {
+		app.SendError(w, http.StatusUnsupportedMediaType, errors.New("image must be a JPEG, PNG or GIF"))
+		return
+	}
+
+	altText := strings.TrimSpace(r.FormValue("alt_text"))
+	if len(altText) > maxAltTextLength {
+		app.SendValidationError(w, []FieldError{{Field: "alt_text", Code: "too_long", Message: fmt.Sprintf("must be at most %d characters long", maxAltTextLength)}})
+		return
+	}
+
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		app.SendValidationError(w, []FieldError{{Field: "image", Code: "invalid", Message: "could not be decoded"}})
+		return
+	}
+	if config.Width*config.Height > maxImagePixels {
+		app.SendValidationError(w, []FieldError{{Field: "image", Code: "too_large", Message: fmt.Sprintf("must have at most %d pixels", maxImagePixels)}})
+		return
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		app.SendValidationError(w, []FieldError{{Field: "image", Code: "invalid", Message: "could not be decoded"}})
+		return
+	}
+
+	name, err := randomToken(16)
+	if err != nil {
+		log.Printf("Error generating image name: %v", err)
+		app.SendError(w, http.StatusInternalServerError, errors.New("could not save image"))
+		return
+	}
+	productImage := schema.ProductImage{
+		ProductID:     product.ID,
+		Key:           fmt.Sprintf("products/%d/%s%s", product.ID, name, ext),
+		ContentType:   contentType,
+		Width:         config.Width,
+		Height:        config.Height,
+		Size:          int64(len(data)),
+		AltText:       altText,
+		ThumbnailKeys: map[string]string{},
+	}
+
+	stored, err := app.storeProductImage(r.Context(), &productImage, name, data, img)
+	if err != nil {
+		log.Printf("Error storing image: %v", err)
+		app.deleteStoredFiles(stored)
+		app.SendError(w, http.StatusInternalServerError, errors.New("could not save image"))
+		return
+	}
+
+	productImage.ID, err = app.DB.InsertProductImage(&productImage)
+	if err != nil {
+		log.Printf("Error inserting image: %v", err)
+		app.deleteStoredFiles(stored)
+		app.SendError(w, http.StatusInternalServerError, errors.New("could not save image"))
+		return
+	}
+
+	app.withImageURL(&productImage)
+	app.SendResponse(w, http.StatusCreated, productImage)
+}
+
+// storeProductImage puts the uploaded image and its thumbnails in storage,
+// recording the thumbnail keys on productImage. It returns the keys stored so
+// far, also on failure, so they can be cleaned up.
+func (app *OnlineStore) storeProductImage(ctx context.Context, productImage *schema.ProductImage, name string, data []byte, img image.Image) ([]string, error) {
+	var stored []string
+	err := app.Storage.Put(ctx, productImage.Key, bytes.NewReader(data), productImage.ContentType)
+	if err != nil {
+		return stored, err
+	}
+	stored = append(stored, productImage.Key)
+
+	sizes, err := app.Cfgs.ThumbnailSizes()
+	if err != nil {
+		return stored, err
+	}
+	for _, size := range sizes {
+		var buf bytes.Buffer
+		thumb := thumbnail.Fit(img, size)
+		// JPEGs stay JPEGs; PNG keeps the transparency of the others.
+		contentType, ext := "image/png", ".png"
+		if productImage.ContentType == "image/jpeg" {
+			contentType, ext = "image/jpeg", ".jpg"
+			err = jpeg.Encode(&buf, thumb, &jpeg.Options{Quality: 85})
+		} else {
+			err = png.Encode(&buf, thumb)
+		}
+		if err != nil {
+			return stored, err
+		}
+
+		key := fmt.Sprintf("products/%d/%s_%d%s", productImage.ProductID, name, size, ext)
+		if err := app.Storage.Put(ctx, key, &buf, contentType); err != nil {
+			return stored, err
+		}
+		stored = append(stored, key)
+		productImage.ThumbnailKeys[strconv.Itoa(size)] = key
+	}
+	return stored, nil
+}
+
+// UpdateProductImage changes the alt text of an image.
+func (app *OnlineStore) UpdateProductImage(w http.ResponseWriter, r *http.Request) {
+	productID, imageID, ok := app.productImageFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	var request struct {
+		AltText string `json:"alt_text"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		log.Printf("Error decoding request: %v", err)
+		app.SendError(w, http.StatusBadRequest, err)
+		return
+	}
+	altText := strings.TrimSpace(request.AltText)
+	if len(altText) > maxAltTextLength {
+		app.SendValidationError(w, []FieldError{{Field: "alt_text", Code: "too_long", Message: fmt.Sprintf("must be at most %d characters long", maxAltTextLength)}})
+		return
+	}
+
+	err := app.DB.SetProductImageAltText(productID, imageID, altText)
+	if errors.Is(err, sql.ErrNoRows) {
+		app.SendError(w, http.StatusNotFound, errors.New("image not found"))
+		return
+	}
+	if err != nil {
+		log.Printf("Error updating image: %v", err)
+		app.SendError(w, http.StatusInternalServerError, errors.New("could not update image"))
+		return
+	}
+	app.SendResponse(w, http.StatusOK, nil)
+}
+
+// ReorderProductImages takes the IDs of all of a product's images in their
+// new order.
+func (app *OnlineStore) ReorderProductImages(w http.ResponseWriter, r *http.Request) {
+	productID, ok := app.productIDFromParam(w, r, "id")
+	if !ok {
+		return
+	}
+
+	var request struct {
+		ImageIDs []int `json:"image_ids"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		log.Printf("Error decoding request: %v", err)
+		app.SendError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	err := app.DB.ReorderProductImages(productID, request.ImageIDs)
+	if errors.Is(err, databases.ErrImageOrder) {
+		app.SendValidationError(w, []FieldError{{Field: "image_ids", Code: "invalid", Message: "must list each image of the product once"}})
+		return
+	}
+	if err != nil {
+		log.Printf("Error reordering images: %v", err)
+		app.SendError(w, http.StatusInternalServerError, errors.New("could not reorder images"))
+		return
+	}
+	app.SendResponse(w, http.StatusOK, nil)
+}
+
+func (app *OnlineStore) DeleteProductImage(w http.ResponseWriter, r *http.Request) {
+	productID, imageID, ok := app.productImageFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	productImage, err := app.DB.DeleteProductImage(productID, imageID)
+	if errors.Is(err, sql.ErrNoRows) {
+		app.SendError(w, http.StatusNotFound, errors.New("image not found"))
+		return
+	}
+	if err != nil {
+		log.Printf("Error deleting image: %v", err)
+		app.SendError(w, http.StatusInternalServerError, errors.New("could not delete image"))
+		return
+	}
+	app.deleteImageFiles(*productImage)
+	app.SendResponse(w, http.StatusOK, nil)
+}
+
+func (app *OnlineStore) productImageFromURL(w http.ResponseWriter, r *http.Request) (int, int, bool) {
+	productID, ok := app.productIDFromParam(w, r, "id")
+	if !ok {
+		return 0, 0, false
+	}
+	imageID, err := strconv.Atoi(chi.URLParam(r, "image_id"))
+	if err != nil {
+		log.Printf("Error parsing image ID: %v", err)
+		app.SendError(w, http.StatusBadRequest, err)
+		return 0, 0, false
+	}
+	return productID, imageID, true
+}
+
+// deleteImageFiles removes the files of deleted images from storage. Failures
+// are only logged: the images are gone either way.
+func (app *OnlineStore) deleteImageFiles(images ...schema.ProductImage) {
+	for _, productImage := range images {
+		keys := []string{productImage.Key}
+		for _, key := range productImage.ThumbnailKeys {
+			keys = append(keys, key)
+		}
+		app.deleteStoredFiles(keys)
+	}
+}
+
+func (app *OnlineStore) deleteStoredFiles(keys []string) {
+	if app.Storage == nil {
+		return
+	}
+	for _, key := range keys {
+		if err := app.Storage.Delete(context.Background(), key); err != nil {
+			log.Printf("Error deleting %s from storage: %v", key, err)
+		}
+	}
+}
+
+// withImageURLs fills in the URLs of the images of products.
+func (app *OnlineStore) withImageURLs(products ...*schema.Product) {
+	for _, product := range products {
+		for i := range product.Images {
+			app.withImageURL(&product.Images[i])
+		}
+	}
+}
+
+func (app *OnlineStore) withImageURL(productImage *schema.ProductImage) {
+	if app.Storage == nil {
+		return
+	}
+	productImage.URL = app.Storage.URL(productImage.Key)
+	productImage.Thumbnails = make(map[string]string, len(productImage.ThumbnailKeys))
+	for size, key := range productImage.ThumbnailKeys {
+		productImage.Thumbnails[size] = app.Storage.URL(key)
+	}
+}
+
+// mediaPath is the path of MEDIA_BASE_URL, when the storage is served by this
+// server rather than from another host.
+func mediaPath(baseURL string) (string, bool) {
+	u, err := url.Parse(baseURL)
+	if err != nil || u.Host != "" {
+		return "", false
+	}
+	path := strings.TrimRight(u.Path, "/")
+	return path, path != ""
+}
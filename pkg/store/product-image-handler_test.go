@@ -0,0 +1,289 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"image"
+	"image/color"
+	"image/png"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/MinhNHHH/online-store/pkg/databases/schema"
+	"github.com/MinhNHHH/online-store/pkg/storage"
+	"github.com/go-chi/chi"
+)
+
+func testPNG(t *testing.T, width, height int) []byte {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	img.Set(0, 0, color.RGBA{R: 255, A: 255})
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// imageUpload builds a multipart form with content in the field "image",
+// unless content is nil.
+func imageUpload(t *testing.T, content []byte, altText string) (*bytes.Buffer, string) {
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	if content != nil {
+		part, err := form.CreateFormFile("image", "upload.png")
+		if err != nil {
+			t.Fatal(err)
+		}
+		part.Write(content)
+	}
+	form.WriteField("alt_text", altText)
+	form.Close()
+	return &body, form.FormDataContentType()
+}
+
+func mediaApp(t *testing.T) (OnlineStore, string) {
+	dir := t.TempDir()
+	testApp := app
+	testApp.Storage = storage.NewLocalStorage(dir, "/media")
+	testApp.Cfgs.MEDIA_BASE_URL = "/media"
+	testApp.Cfgs.IMAGE_MAX_BYTES = 1 << 20
+	testApp.Cfgs.IMAGE_THUMBNAIL_SIZES = "16,64"
+	return testApp, dir
+}
+
+func Test_app_UploadProductImage(t *testing.T) {
+	var tests = []struct {
+		name               string
+		productID          string
+		content            []byte
+		altText            string
+		noStorage          bool
+		expectedStatusCode int
+	}{
+		{
+			name:               "png",
+			productID:          "1",
+			content:            testPNG(t, 100, 50),
+			altText:            " Front view ",
+			expectedStatusCode: http.StatusCreated,
+		},
+		{
+			name:               "not an image",
+			productID:          "1",
+			content:            []byte("<html>not an image</html>"),
+			expectedStatusCode: http.StatusUnsupportedMediaType,
+		},
+		{
+			name:               "too large",
+			productID:          "1",
+			content:            append(testPNG(t, 10, 10), make([]byte, 2<<20)...),
+			expectedStatusCode: http.StatusRequestEntityTooLarge,
+		},
+		{
+			name:               "no image",
+			productID:          "1",
+			expectedStatusCode: http.StatusBadRequest,
+		},
+		{
+			name:               "alt text too long",
+			productID:          "1",
+			content:            testPNG(t, 10, 10),
+			altText:            strings.Repeat("a", 256),
+			expectedStatusCode: http.StatusBadRequest,
+		},
+		{
+			name:               "broken png",
+			productID:          "1",
+			content:            testPNG(t, 10, 10)[:40],
+			expectedStatusCode: http.StatusBadRequest,
+		},
+		{
+			name:               "unknown product",
+			productID:          "2",
+			content:            testPNG(t, 10, 10),
+			expectedStatusCode: http.StatusNotFound,
+		},
+		{
+			name:               "no storage",
+			productID:          "1",
+			content:            testPNG(t, 10, 10),
+			noStorage:          true,
+			expectedStatusCode: http.StatusServiceUnavailable,
+		},
+	}
+
+	for _, e := range tests {
+		testApp, dir := mediaApp(t)
+		if e.noStorage {
+			testApp.Storage = nil
+		}
+
+		body, contentType := imageUpload(t, e.content, e.altText)
+		req, _ := http.NewRequest("POST", "/products/"+e.productID+"/images", body)
+		req.Header.Set("Content-Type", contentType)
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("id", e.productID)
+		req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(testApp.UploadProductImage)
+		handler.ServeHTTP(rr, req)
+
+		if e.expectedStatusCode != rr.Code {
+			t.Errorf("%s: returned wrong status code; expected %d but got %d", e.name, e.expectedStatusCode, rr.Code)
+			continue
+		}
+
+		files, _ := filepath.Glob(filepath.Join(dir, "products", "1", "*"))
+		if rr.Code != http.StatusCreated {
+			if len(files) != 0 {
+				t.Errorf("%s: expected no files to be stored but found %v", e.name, files)
+			}
+			continue
+		}
+
+		var productImage schema.ProductImage
+		if err := json.Unmarshal(rr.Body.Bytes(), &productImage); err != nil {
+			t.Fatalf("%s: %v", e.name, err)
+		}
+		if productImage.Width != 100 || productImage.Height != 50 || productImage.ContentType != "image/png" || productImage.AltText != "Front view" {
+			t.Errorf("%s: unexpected image %+v", e.name, productImage)
+		}
+		if !strings.HasPrefix(productImage.URL, "/media/products/1/") || len(productImage.Thumbnails) != 2 {
+			t.Errorf("%s: unexpected urls %s %v", e.name, productImage.URL, productImage.Thumbnails)
+		}
+		if len(files) != 3 {
+			t.Errorf("%s: expected the image and two thumbnails to be stored but found %v", e.name, files)
+		}
+
+		thumb, err := os.Open(filepath.Join(dir, strings.TrimPrefix(productImage.Thumbnails["16"], "/media/")))
+		if err != nil {
+			t.Fatalf("%s: %v", e.name, err)
+		}
+		config, _, err := image.DecodeConfig(thumb)
+		thumb.Close()
+		if err != nil || config.Width != 16 || config.Height != 8 {
+			t.Errorf("%s: expected a 16x8 thumbnail but got %+v, %v", e.name, config, err)
+		}
+	}
+}
+
+func Test_app_GetProductImages(t *testing.T) {
+	testApp, _ := mediaApp(t)
+
+	req, _ := http.NewRequest("GET", "/products/1/images", nil)
+	rctx := chi.NewRouteContext()
+	rctx.URLParams.Add("id", "1")
+	req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(testApp.GetProductImages)
+	handler.ServeHTTP(rr, req)
+
+	var images []schema.ProductImage
+	if err := json.Unmarshal(rr.Body.Bytes(), &images); err != nil {
+		t.Fatal(err)
+	}
+	if len(images) != 2 || images[0].URL != "/media/products/1/image1.jpg" || images[0].Thumbnails["160"] != "/media/products/1/image1_160.jpg" {
+		t.Errorf("unexpected images %+v", images)
+	}
+}
+
+func Test_app_UpdateProductImage(t *testing.T) {
+	var tests = []struct {
+		name               string
+		imageID            string
+		requestBody        string
+		expectedStatusCode int
+	}{
+		{name: "alt text", imageID: "1", requestBody: `{"alt_text": "Back view"}`, expectedStatusCode: http.StatusOK},
+		{name: "unknown image", imageID: "9", requestBody: `{"alt_text": "Back view"}`, expectedStatusCode: http.StatusNotFound},
+		{name: "alt text too long", imageID: "1", requestBody: `{"alt_text": "` + strings.Repeat("a", 256) + `"}`, expectedStatusCode: http.StatusBadRequest},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("PATCH", "/products/1/images/"+e.imageID, strings.NewReader(e.requestBody))
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("id", "1")
+		rctx.URLParams.Add("image_id", e.imageID)
+		req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(app.UpdateProductImage)
+		handler.ServeHTTP(rr, req)
+
+		if e.expectedStatusCode != rr.Code {
+			t.Errorf("%s: returned wrong status code; expected %d but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+	}
+}
+
+func Test_app_ReorderProductImages(t *testing.T) {
+	var tests = []struct {
+		name               string
+		requestBody        string
+		expectedStatusCode int
+	}{
+		{name: "new order", requestBody: `{"image_ids": [2, 1]}`, expectedStatusCode: http.StatusOK},
+		{name: "missing image", requestBody: `{"image_ids": [2]}`, expectedStatusCode: http.StatusBadRequest},
+		{name: "repeated image", requestBody: `{"image_ids": [2, 2]}`, expectedStatusCode: http.StatusBadRequest},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("PUT", "/products/1/images/order", strings.NewReader(e.requestBody))
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("id", "1")
+		req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(app.ReorderProductImages)
+		handler.ServeHTTP(rr, req)
+
+		if e.expectedStatusCode != rr.Code {
+			t.Errorf("%s: returned wrong status code; expected %d but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+	}
+}
+
+func Test_app_DeleteProductImage(t *testing.T) {
+	testApp, dir := mediaApp(t)
+	for _, key := range []string{"products/1/image1.jpg", "products/1/image1_160.jpg", "products/1/image2.jpg"} {
+		if err := testApp.Storage.Put(context.Background(), key, strings.NewReader("x"), "image/jpeg"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	req, _ := http.NewRequest("DELETE", "/products/1/images/1", nil)
+	rctx := chi.NewRouteContext()
+	rctx.URLParams.Add("id", "1")
+	rctx.URLParams.Add("image_id", "1")
+	req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(testApp.DeleteProductImage)
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("returned wrong status code; expected %d but got %d", http.StatusOK, rr.Code)
+	}
+	files, _ := filepath.Glob(filepath.Join(dir, "products", "1", "*"))
+	if len(files) != 1 || filepath.Base(files[0]) != "image2.jpg" {
+		t.Errorf("expected only the other image to be left but found %v", files)
+	}
+}
+
+func Test_app_MediaRoute(t *testing.T) {
+	testApp, _ := mediaApp(t)
+	if err := testApp.Storage.Put(context.Background(), "products/1/a.png", bytes.NewReader(testPNG(t, 1, 1)), "image/png"); err != nil {
+		t.Fatal(err)
+	}
+
+	req, _ := http.NewRequest("GET", "/media/products/1/a.png", nil)
+	rr := httptest.NewRecorder()
+	testApp.Routes().ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK || rr.Header().Get("Content-Type") != "image/png" {
+		t.Errorf("expected the image to be served but got %d %s", rr.Code, rr.Header().Get("Content-Type"))
+	}
+}
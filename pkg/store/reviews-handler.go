@@ -1,24 +1,34 @@
 package store
 
 import (
+	"database/sql"
 	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
 	"strconv"
 
+	databases "github.com/MinhNHHH/online-store/pkg/databases/repositories"
 	"github.com/MinhNHHH/online-store/pkg/databases/schema"
 	"github.com/go-chi/chi"
 )
 
 func (app *OnlineStore) GetReviewsByProductID(w http.ResponseWriter, r *http.Request) {
-	productID, err := strconv.Atoi(chi.URLParam(r, "id"))
-	if err != nil {
-		log.Printf("Error parsing product ID: %v", err)
-		app.SendResponse(w, http.StatusBadRequest, err)
+	productID, ok := app.productIDFromParam(w, r, "product_id")
+	if !ok {
+		return
+	}
+	page, fields := readPage(r.URL.Query())
+	if len(fields) > 0 {
+		app.SendValidationError(w, fields)
 		return
 	}
 
-	reviews, err := app.DB.ReviewsByProductID(productID)
+	reviews, info, err := app.DB.ReviewsByProductID(productID, page)
+	if errors.Is(err, databases.ErrInvalidCursor) {
+		app.SendValidationError(w, []FieldError{invalidCursorField})
+		return
+	}
 	if err != nil {
 		log.Printf("Error getting reviews: %v", err)
 		app.SendResponse(w, http.StatusBadRequest, err)
@@ -27,13 +37,21 @@ func (app *OnlineStore) GetReviewsByProductID(w http.ResponseWriter, r *http.Req
 
 	response := struct {
 		Reviews []*schema.Review `json:"reviews"`
+		listPage
 	}{
-		Reviews: reviews,
+		Reviews:  reviews,
+		listPage: pageLinks(w, r, page, info),
 	}
 	app.SendResponse(w, http.StatusOK, response)
 }
 
+// CreateReview posts a review by the signed in user of the product in the
+// URL. Admins may post for another user by naming them with user_id.
 func (app *OnlineStore) CreateReview(w http.ResponseWriter, r *http.Request) {
+	productID, ok := app.productIDFromParam(w, r, "product_id")
+	if !ok {
+		return
+	}
 	var review schema.Review
 	err := json.NewDecoder(r.Body).Decode(&review)
 	if err != nil {
@@ -41,6 +59,17 @@ func (app *OnlineStore) CreateReview(w http.ResponseWriter, r *http.Request) {
 		app.SendResponse(w, http.StatusBadRequest, err)
 		return
 	}
+	review.ProductID = productID
+	p, err := app.requestPrincipal(w, r)
+	if err != nil {
+		app.SendError(w, http.StatusUnauthorized, errors.New("unauthorized"))
+		return
+	}
+	review.UserID, err = app.actingUserID(p, review.UserID)
+	if err != nil {
+		app.SendError(w, http.StatusForbidden, err)
+		return
+	}
 	id, err := app.DB.InsertReview(&review)
 	if err != nil {
 		log.Printf("Error inserting review: %v", err)
@@ -55,13 +84,40 @@ func (app *OnlineStore) CreateReview(w http.ResponseWriter, r *http.Request) {
 	app.SendResponse(w, http.StatusCreated, response)
 }
 
+// DeleteReview deletes a review of the product in the URL. Users may delete
+// their own reviews; admins may delete any, including those of erased users.
 func (app *OnlineStore) DeleteReview(w http.ResponseWriter, r *http.Request) {
+	productID, ok := app.productIDFromParam(w, r, "product_id")
+	if !ok {
+		return
+	}
 	id, err := strconv.Atoi(chi.URLParam(r, "id"))
 	if err != nil {
 		log.Printf("Error parsing review ID: %v", err)
 		app.SendResponse(w, http.StatusBadRequest, err)
 		return
 	}
+	p, err := app.requestPrincipal(w, r)
+	if err != nil {
+		app.SendError(w, http.StatusUnauthorized, errors.New("unauthorized"))
+		return
+	}
+
+	review, err := app.DB.GetReview(id)
+	if errors.Is(err, sql.ErrNoRows) || err == nil && review.ProductID != productID {
+		app.SendError(w, http.StatusNotFound, errors.New("review not found"))
+		return
+	}
+	if err != nil {
+		log.Printf("Error getting review: %v", err)
+		app.SendError(w, http.StatusInternalServerError, errors.New("could not delete review"))
+		return
+	}
+	if (review.UserID == 0 || review.UserID != p.UserID) && !app.actsAsAdmin(p) {
+		app.SendError(w, http.StatusForbidden, errors.New("forbidden: not your review"))
+		return
+	}
+
 	err = app.DB.DeleteReview(id)
 	if err != nil {
 		log.Printf("Error deleting review: %v", err)
@@ -0,0 +1,235 @@
+package store
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/MinhNHHH/online-store/pkg/cfgs"
+	"github.com/MinhNHHH/online-store/pkg/databases/schema"
+	"github.com/alexedwards/scs/v2"
+)
+
+const sessionCookie = "__Host-session"
+
+const csrfHeader = "X-CSRF-Token"
+
+// Keys of the values kept in a session.
+const (
+	sessionUserIDKey = "user_id"
+	sessionMFAKey    = "mfa"
+	sessionCSRFKey   = "csrf_token"
+	// sessionGenerationKey holds the session generation of the user at
+	// login; see revokeSessions.
+	sessionGenerationKey = "generation"
+)
+
+// NewSessionManager sets up cookie sessions kept in store. Sessions end after
+// SESSION_IDLE_TIMEOUT without requests, and SESSION_LIFETIME after login.
+func NewSessionManager(store scs.Store, c cfgs.Configs) *scs.SessionManager {
+	session := scs.New()
+	session.Store = store
+	session.IdleTimeout = c.SESSION_IDLE_TIMEOUT
+	session.Lifetime = c.SESSION_LIFETIME
+	session.Cookie.Name = sessionCookie
+	session.Cookie.Path = "/"
+	session.Cookie.HttpOnly = true
+	session.Cookie.Secure = true
+	session.Cookie.SameSite = http.SameSiteLaxMode
+	return session
+}
+
+// loadSession loads the cookie session, if sessions are set up.
+func (app *OnlineStore) loadSession(next http.Handler) http.Handler {
+	if app.Session == nil {
+		return next
+	}
+	return app.Session.LoadAndSave(next)
+}
+
+// requestClaims returns the claims of the user making the request, taken from
+// the bearer token, or from the cookie session when no token was sent.
+func (app *OnlineStore) requestClaims(w http.ResponseWriter, r *http.Request) (*Claims, error) {
+	if r.Header.Get("Authorization") != "" || app.Session == nil {
+		_, claims, err := app.getTokenFromHeaderandVerify(w, r)
+		if err != nil {
+			return nil, err
+		}
+		return app.tokenClaims(claims)
+	}
+	return app.sessionClaims(r)
+}
+
+// tokenClaims checks the claims of an access token against its user, who is
+// read on every request like for sessions: the tokens of a deactivated or
+// deleted user, or of one whose account is scheduled for deletion, are
+// refused, and the admin and email verified claims follow their user at once
+// instead of when the token expires. Logging in again cancels a scheduled
+// deletion, so the tokens of that login are accepted.
+func (app *OnlineStore) tokenClaims(claims *Claims) (*Claims, error) {
+	userID, err := strconv.Atoi(claims.Subject)
+	if err != nil {
+		return nil, err
+	}
+	user, err := app.DB.GetUser(userID)
+	if err != nil {
+		return nil, err
+	}
+	if user.DeactivatedAt != nil {
+		return nil, errAccountDeactivated
+	}
+	if user.DeletionScheduledAt != nil {
+		return nil, errDeletionScheduled
+	}
+	claims.Admin = user.IsAdmin
+	claims.EmailVerified = user.EmailVerifiedAt != nil
+	return claims, nil
+}
+
+// sessionClaims describes the user of the cookie session like an access token
+// would. The user is read on every request, so losing admin rights, being
+// deactivated or having the sessions revoked takes effect at once.
+func (app *OnlineStore) sessionClaims(r *http.Request) (*Claims, error) {
+	userID := app.Session.GetInt(r.Context(), sessionUserIDKey)
+	if userID == 0 {
+		return nil, errors.New("no session")
+	}
+	user, err := app.DB.GetUser(userID)
+	if err != nil {
+		return nil, err
+	}
+	if user.DeactivatedAt != nil {
+		return nil, errAccountDeactivated
+	}
+	if app.Session.GetInt(r.Context(), sessionGenerationKey) < user.SessionGeneration {
+		return nil, errors.New("session revoked")
+	}
+
+	claims := &Claims{
+		UserName:      user.Name,
+		Admin:         user.IsAdmin,
+		EmailVerified: user.EmailVerifiedAt != nil,
+		MFA:           app.Session.GetBool(r.Context(), sessionMFAKey),
+	}
+	claims.Subject = fmt.Sprint(user.ID)
+	return claims, nil
+}
+
+// sessionCSRFValid reports whether a request authenticated by the session
+// cookie carries the session's CSRF token. Safe methods need none.
+func (app *OnlineStore) sessionCSRFValid(r *http.Request) bool {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+	expected := app.Session.GetString(r.Context(), sessionCSRFKey)
+	given := r.Header.Get(csrfHeader)
+	return expected != "" && subtle.ConstantTimeCompare([]byte(expected), []byte(given)) == 1
+}
+
+type SessionInfo struct {
+	UserID    int    `json:"user_id"`
+	MFA       bool   `json:"mfa"`
+	CSRFToken string `json:"csrf_token"`
+}
+
+// startSession logs user in to a new cookie session. The session token is
+// replaced so one planted in the browser before login is worthless.
+func (app *OnlineStore) startSession(w http.ResponseWriter, r *http.Request, user *schema.User, mfa bool) {
+	if app.Session == nil {
+		app.SendError(w, http.StatusNotFound, errors.New("cookie sessions are not enabled"))
+		return
+	}
+
+	csrfToken, err := randomToken(32)
+	if err != nil {
+		log.Println("Error generating CSRF token:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if err := app.Session.RenewToken(r.Context()); err != nil {
+		log.Println("Error renewing session token:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	app.Session.Put(r.Context(), sessionUserIDKey, user.ID)
+	app.Session.Put(r.Context(), sessionMFAKey, mfa)
+	app.Session.Put(r.Context(), sessionCSRFKey, csrfToken)
+	app.Session.Put(r.Context(), sessionGenerationKey, user.SessionGeneration)
+
+	app.SendResponse(w, http.StatusOK, SessionInfo{
+		UserID:    user.ID,
+		MFA:       mfa,
+		CSRFToken: csrfToken,
+	})
+}
+
+// revokeSessions ends every cookie session of the user. Sessions are kept
+// encoded in the store and cannot be looked up by user, so the user moves to
+// a new session generation instead and sessionClaims refuses older ones. When
+// the request itself comes from a session of the user, that session gets a
+// new token and moves along, so the caller stays logged in.
+func (app *OnlineStore) revokeSessions(r *http.Request, userID int) error {
+	generation, err := app.DB.RevokeUserSessions(userID)
+	if err != nil {
+		return err
+	}
+	if app.Session == nil || r.Header.Get("Authorization") != "" || app.Session.GetInt(r.Context(), sessionUserIDKey) != userID {
+		return nil
+	}
+	if err := app.Session.RenewToken(r.Context()); err != nil {
+		return err
+	}
+	app.Session.Put(r.Context(), sessionGenerationKey, generation)
+	return nil
+}
+
+// sessionLogin logs in with an email and password like authenticate, but
+// answers with a session cookie instead of tokens.
+func (app *OnlineStore) sessionLogin(w http.ResponseWriter, r *http.Request) {
+	if app.Session == nil {
+		app.SendError(w, http.StatusNotFound, errors.New("cookie sessions are not enabled"))
+		return
+	}
+	user := app.checkCredentials(w, r)
+	if user == nil {
+		return
+	}
+	app.completeLogin(w, r, user, true)
+}
+
+// sessionInfo returns the current session, including the CSRF token that
+// unsafe requests must send in the X-CSRF-Token header.
+func (app *OnlineStore) sessionInfo(w http.ResponseWriter, r *http.Request) {
+	if app.Session == nil {
+		app.SendError(w, http.StatusNotFound, errors.New("cookie sessions are not enabled"))
+		return
+	}
+	claims, err := app.sessionClaims(r)
+	if err != nil {
+		app.SendError(w, http.StatusUnauthorized, errors.New("unauthorized"))
+		return
+	}
+	userID, _ := strconv.Atoi(claims.Subject)
+	app.SendResponse(w, http.StatusOK, SessionInfo{
+		UserID:    userID,
+		MFA:       claims.MFA,
+		CSRFToken: app.Session.GetString(r.Context(), sessionCSRFKey),
+	})
+}
+
+func (app *OnlineStore) sessionLogout(w http.ResponseWriter, r *http.Request) {
+	if app.Session == nil {
+		app.SendError(w, http.StatusNotFound, errors.New("cookie sessions are not enabled"))
+		return
+	}
+	if err := app.Session.Destroy(r.Context()); err != nil {
+		log.Println("Error destroying session:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	app.SendResponse(w, http.StatusOK, nil)
+}
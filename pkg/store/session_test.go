@@ -0,0 +1,266 @@
+package store
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/MinhNHHH/online-store/pkg/databases/repositories/dbrepo"
+	"github.com/MinhNHHH/online-store/pkg/databases/schema"
+	"github.com/MinhNHHH/online-store/pkg/totp"
+	"github.com/alexedwards/scs/v2/memstore"
+)
+
+func newSessionTestApp(idleTimeout, lifetime time.Duration) *OnlineStore {
+	testApp := app
+	testApp.Cfgs.SESSION_IDLE_TIMEOUT = idleTimeout
+	testApp.Cfgs.SESSION_LIFETIME = lifetime
+	testApp.Session = NewSessionManager(memstore.New(), testApp.Cfgs)
+	return &testApp
+}
+
+// sessionRequest sends a request with the session cookie and CSRF token, if
+// given, and returns the response.
+func sessionRequest(handler http.Handler, method, url, body string, cookie *http.Cookie, csrfToken string) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest(method, url, strings.NewReader(body))
+	if cookie != nil {
+		req.AddCookie(cookie)
+	}
+	if csrfToken != "" {
+		req.Header.Set(csrfHeader, csrfToken)
+	}
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	return rr
+}
+
+func sessionCookieFrom(t *testing.T, rr *httptest.ResponseRecorder) *http.Cookie {
+	for _, cookie := range rr.Result().Cookies() {
+		if cookie.Name == sessionCookie {
+			return cookie
+		}
+	}
+	t.Fatalf("no session cookie in response %d: %s", rr.Code, rr.Body)
+	return nil
+}
+
+func sessionLoginForTest(t *testing.T, handler http.Handler, cookie *http.Cookie) (*http.Cookie, SessionInfo) {
+	rr := sessionRequest(handler, "POST", "/api/v1/auth/session", `{"email":"admin@example.com", "password":"secret"}`, cookie, "")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200 but got %d", rr.Code)
+	}
+	var info SessionInfo
+	_ = json.NewDecoder(rr.Body).Decode(&info)
+	return sessionCookieFrom(t, rr), info
+}
+
+func Test_app_sessionAuth(t *testing.T) {
+	handler := newSessionTestApp(time.Hour, time.Hour).Routes()
+	cookie, info := sessionLoginForTest(t, handler, nil)
+	if info.UserID != 1 || info.CSRFToken == "" {
+		t.Fatalf("unexpected session %+v", info)
+	}
+	if !cookie.HttpOnly || !cookie.Secure || cookie.SameSite != http.SameSiteLaxMode {
+		t.Errorf("session cookie is missing security attributes: %+v", cookie)
+	}
+
+	var theTests = []struct {
+		name               string
+		method             string
+		url                string
+		body               string
+		cookie             *http.Cookie
+		csrfToken          string
+		expectedStatusCode int
+	}{
+		{"session info", "GET", "/api/v1/auth/session", "", cookie, "", http.StatusOK},
+		{"session info without cookie", "GET", "/api/v1/auth/session", "", nil, "", http.StatusUnauthorized},
+		{"safe request", "GET", "/api/v1/products/", "", cookie, "", http.StatusOK},
+		{"unsafe request", "POST", "/api/v1/auth/logout-all", "", cookie, info.CSRFToken, http.StatusOK},
+		{"unsafe request without CSRF token", "POST", "/api/v1/auth/logout-all", "", cookie, "", http.StatusForbidden},
+		{"unsafe request with wrong CSRF token", "POST", "/api/v1/auth/logout-all", "", cookie, "wrong", http.StatusForbidden},
+		{"non admin on admin route", "POST", "/api/v1/categories/", `{"name":"x"}`, cookie, info.CSRFToken, http.StatusForbidden},
+		{"unknown session", "GET", "/api/v1/products/", "", &http.Cookie{Name: sessionCookie, Value: "unknown"}, "", http.StatusUnauthorized},
+	}
+
+	for _, e := range theTests {
+		rr := sessionRequest(handler, e.method, e.url, e.body, e.cookie, e.csrfToken)
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: returned wrong status code; expected %d but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+	}
+}
+
+func Test_app_sessionLoginRenewsToken(t *testing.T) {
+	handler := newSessionTestApp(time.Hour, time.Hour).Routes()
+	first, _ := sessionLoginForTest(t, handler, nil)
+	second, _ := sessionLoginForTest(t, handler, first)
+
+	if first.Value == second.Value {
+		t.Fatal("expected a new session token at login")
+	}
+	if rr := sessionRequest(handler, "GET", "/api/v1/auth/session", "", first, ""); rr.Code != http.StatusUnauthorized {
+		t.Errorf("old session token: expected status 401 but got %d", rr.Code)
+	}
+	if rr := sessionRequest(handler, "GET", "/api/v1/auth/session", "", second, ""); rr.Code != http.StatusOK {
+		t.Errorf("new session token: expected status 200 but got %d", rr.Code)
+	}
+}
+
+func Test_app_sessionTimeouts(t *testing.T) {
+	var theTests = []struct {
+		name        string
+		idleTimeout time.Duration
+		lifetime    time.Duration
+		activeFor   time.Duration
+		idleFor     time.Duration
+		expectedOK  bool
+	}{
+		{"active within idle timeout", time.Millisecond * 150, time.Hour, time.Millisecond * 300, 0, true},
+		{"idle past idle timeout", time.Millisecond * 150, time.Hour, 0, time.Millisecond * 250, false},
+		{"active past lifetime", time.Hour, time.Millisecond * 200, time.Millisecond * 300, 0, false},
+	}
+
+	for _, e := range theTests {
+		handler := newSessionTestApp(e.idleTimeout, e.lifetime).Routes()
+		cookie, _ := sessionLoginForTest(t, handler, nil)
+
+		// a request every 50ms keeps the session from idling
+		for end := time.Now().Add(e.activeFor); time.Now().Before(end); {
+			time.Sleep(time.Millisecond * 50)
+			sessionRequest(handler, "GET", "/api/v1/auth/session", "", cookie, "")
+		}
+		time.Sleep(e.idleFor)
+
+		rr := sessionRequest(handler, "GET", "/api/v1/auth/session", "", cookie, "")
+		if (rr.Code == http.StatusOK) != e.expectedOK {
+			t.Errorf("%s: returned wrong status code; got %d", e.name, rr.Code)
+		}
+	}
+}
+
+func Test_app_sessionLogout(t *testing.T) {
+	handler := newSessionTestApp(time.Hour, time.Hour).Routes()
+	cookie, info := sessionLoginForTest(t, handler, nil)
+
+	if rr := sessionRequest(handler, "DELETE", "/api/v1/auth/session", "", cookie, ""); rr.Code != http.StatusForbidden {
+		t.Errorf("logout without CSRF token: expected status 403 but got %d", rr.Code)
+	}
+	if rr := sessionRequest(handler, "DELETE", "/api/v1/auth/session", "", cookie, info.CSRFToken); rr.Code != http.StatusOK {
+		t.Errorf("logout: expected status 200 but got %d", rr.Code)
+	}
+	if rr := sessionRequest(handler, "GET", "/api/v1/auth/session", "", cookie, ""); rr.Code != http.StatusUnauthorized {
+		t.Errorf("after logout: expected status 401 but got %d", rr.Code)
+	}
+}
+
+// sessionGenerationRecorder keeps the session generation of the test users.
+type sessionGenerationRecorder struct {
+	dbrepo.TestDBRepo
+	generation int
+}
+
+func (p *sessionGenerationRecorder) GetUser(id int) (*schema.User, error) {
+	user, err := p.TestDBRepo.GetUser(id)
+	if err == nil {
+		user.SessionGeneration = p.generation
+	}
+	return user, err
+}
+
+func (p *sessionGenerationRecorder) GetUserByEmail(email string) (*schema.User, error) {
+	user, err := p.TestDBRepo.GetUserByEmail(email)
+	if err == nil {
+		user.SessionGeneration = p.generation
+	}
+	return user, err
+}
+
+func (p *sessionGenerationRecorder) RevokeUserSessions(id int) (int, error) {
+	p.generation++
+	return p.generation, nil
+}
+
+func Test_app_sessionRevokedOnPasswordChange(t *testing.T) {
+	testApp := newSessionTestApp(time.Hour, time.Hour)
+	testApp.DB = &sessionGenerationRecorder{}
+	handler := testApp.Routes()
+
+	current, info := sessionLoginForTest(t, handler, nil)
+	other, _ := sessionLoginForTest(t, handler, nil)
+
+	rr := sessionRequest(handler, "POST", "/api/v1/users/me/password", `{"current_password":"secret", "new_password":"n3w-secret"}`, current, info.CSRFToken)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("change password: expected status 200 but got %d", rr.Code)
+	}
+	renewed := sessionCookieFrom(t, rr)
+	if renewed.Value == current.Value {
+		t.Error("expected a new session token after the password change")
+	}
+
+	if rr := sessionRequest(handler, "GET", "/api/v1/auth/session", "", renewed, ""); rr.Code != http.StatusOK {
+		t.Errorf("session that changed the password: expected status 200 but got %d", rr.Code)
+	}
+	if rr := sessionRequest(handler, "GET", "/api/v1/auth/session", "", other, ""); rr.Code != http.StatusUnauthorized {
+		t.Errorf("other session: expected status 401 but got %d", rr.Code)
+	}
+	if rr := sessionRequest(handler, "GET", "/api/v1/users/me/", "", other, ""); rr.Code != http.StatusUnauthorized {
+		t.Errorf("other session on user route: expected status 401 but got %d", rr.Code)
+	}
+}
+
+func Test_app_sessionsRevokedOnAccountDeletion(t *testing.T) {
+	testApp := newSessionTestApp(time.Hour, time.Hour)
+	testApp.DB = &sessionGenerationRecorder{}
+	handler := testApp.Routes()
+
+	current, info := sessionLoginForTest(t, handler, nil)
+	other, _ := sessionLoginForTest(t, handler, nil)
+
+	rr := sessionRequest(handler, "DELETE", "/api/v1/users/me", `{"password":"secret"}`, current, info.CSRFToken)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("delete account: expected status 200 but got %d", rr.Code)
+	}
+	for name, cookie := range map[string]*http.Cookie{"session that deleted the account": current, "other session": other} {
+		if rr := sessionRequest(handler, "GET", "/api/v1/auth/session", "", cookie, ""); rr.Code != http.StatusUnauthorized {
+			t.Errorf("%s: expected status 401 but got %d", name, rr.Code)
+		}
+	}
+}
+
+func Test_app_sessionLoginTwoFactor(t *testing.T) {
+	handler := newSessionTestApp(time.Hour, time.Hour).Routes()
+
+	rr := sessionRequest(handler, "POST", "/api/v1/auth/session", `{"email":"twofactor@example.com", "password":"secret"}`, nil, "")
+	var challenge TwoFactorChallenge
+	_ = json.NewDecoder(rr.Body).Decode(&challenge)
+	if !challenge.TwoFactorRequired {
+		t.Fatalf("expected a two-factor challenge, got %d", rr.Code)
+	}
+
+	code, _ := totp.CodeAt(dbrepo.TestTOTPSecret, totp.Step(time.Now()))
+	rr = sessionRequest(handler, "POST", "/api/v1/auth/2fa/verify", `{"challenge_token":"`+challenge.ChallengeToken+`", "code":"`+code+`"}`, nil, "")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200 but got %d", rr.Code)
+	}
+	var info SessionInfo
+	_ = json.NewDecoder(rr.Body).Decode(&info)
+	if info.UserID != 3 || !info.MFA {
+		t.Errorf("expected a two-factor session for user 3, got %+v", info)
+	}
+	cookie := sessionCookieFrom(t, rr)
+	if rr := sessionRequest(handler, "GET", "/api/v1/auth/session", "", cookie, ""); rr.Code != http.StatusOK {
+		t.Errorf("session info: expected status 200 but got %d", rr.Code)
+	}
+}
+
+func Test_app_sessionsDisabled(t *testing.T) {
+	routes := app.Routes()
+	rr := sessionRequest(routes, "POST", "/api/v1/auth/session", `{"email":"admin@example.com", "password":"secret"}`, nil, "")
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("expected status 404 but got %d", rr.Code)
+	}
+}
@@ -0,0 +1,136 @@
+package store
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/MinhNHHH/online-store/pkg/databases/schema"
+	"github.com/go-chi/chi"
+)
+
+// Products and categories are named in URLs by their ID or their slug. Slugs
+// are never numbers, so a number is an ID. A product keeps answering to its
+// old slugs: reads are redirected to the current slug, writes act on the
+// product.
+
+var invalidSlugField = FieldError{Field: "slug", Code: "invalid", Message: "must be lower-case letters and digits separated by single hyphens, not only digits"}
+
+// productFromURL loads the product named by the URL parameter "id".
+func (app *OnlineStore) productFromURL(w http.ResponseWriter, r *http.Request) (*schema.Product, bool) {
+	return app.productFromParam(w, r, "id")
+}
+
+func (app *OnlineStore) productFromParam(w http.ResponseWriter, r *http.Request, param string) (*schema.Product, bool) {
+	ref := chi.URLParam(r, param)
+	var product *schema.Product
+	var err error
+	if id, atoiErr := strconv.Atoi(ref); atoiErr == nil {
+		product, err = app.DB.GetProduct(id)
+	} else {
+		product, err = app.DB.GetProductBySlug(ref)
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		app.SendError(w, http.StatusNotFound, errors.New("product not found"))
+		return nil, false
+	}
+	if err != nil {
+		log.Printf("Error getting product: %v", err)
+		app.SendError(w, http.StatusInternalServerError, errors.New("could not get product"))
+		return nil, false
+	}
+	if redirectSlug(w, r, param, product.Slug) {
+		return nil, false
+	}
+	return product, true
+}
+
+// productIDFromParam is productFromParam for handlers that only need the ID.
+// IDs are taken as they are, without loading the product.
+func (app *OnlineStore) productIDFromParam(w http.ResponseWriter, r *http.Request, param string) (int, bool) {
+	if id, err := strconv.Atoi(chi.URLParam(r, param)); err == nil {
+		return id, true
+	}
+	product, ok := app.productFromParam(w, r, param)
+	if !ok {
+		return 0, false
+	}
+	return product.ID, true
+}
+
+// categoryFromURL loads the category named by the URL parameter "id".
+func (app *OnlineStore) categoryFromURL(w http.ResponseWriter, r *http.Request) (*schema.Category, bool) {
+	ref := chi.URLParam(r, "id")
+	var category *schema.Category
+	var err error
+	if id, atoiErr := strconv.Atoi(ref); atoiErr == nil {
+		category, err = app.DB.GetCategory(id)
+	} else {
+		category, err = app.DB.GetCategoryBySlug(ref)
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		app.SendError(w, http.StatusNotFound, errors.New("category not found"))
+		return nil, false
+	}
+	if err != nil {
+		log.Printf("Error getting category: %v", err)
+		app.SendError(w, http.StatusInternalServerError, errors.New("could not get category"))
+		return nil, false
+	}
+	if redirectSlug(w, r, "id", category.Slug) {
+		return nil, false
+	}
+	return category, true
+}
+
+// categoryIDFromURL is categoryFromURL for handlers that only need the ID.
+func (app *OnlineStore) categoryIDFromURL(w http.ResponseWriter, r *http.Request) (int, bool) {
+	if id, err := strconv.Atoi(chi.URLParam(r, "id")); err == nil {
+		return id, true
+	}
+	category, ok := app.categoryFromURL(w, r)
+	if !ok {
+		return 0, false
+	}
+	return category.ID, true
+}
+
+// redirectSlug answers a read of an old slug, in URL parameter param, with a
+// permanent redirect to the same URL with the current slug, and reports
+// whether it did.
+func redirectSlug(w http.ResponseWriter, r *http.Request, param, current string) bool {
+	ref := chi.URLParam(r, param)
+	if ref == current || r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+	if _, err := strconv.Atoi(ref); err == nil {
+		return false
+	}
+
+	segments := strings.Split(r.URL.Path, "/")
+	at := -1
+	if rctx := chi.RouteContext(r.Context()); rctx != nil {
+		pattern := strings.Split(rctx.RoutePattern(), "/")
+		if i := slices.Index(pattern, "{"+param+"}"); len(pattern) == len(segments) && i >= 0 && segments[i] == ref {
+			at = i
+		}
+	}
+	if at < 0 {
+		at = slices.Index(segments, ref)
+	}
+	if at < 0 {
+		return false
+	}
+	segments[at] = current
+
+	location := strings.Join(segments, "/")
+	if r.URL.RawQuery != "" {
+		location += "?" + r.URL.RawQuery
+	}
+	http.Redirect(w, r, location, http.StatusMovedPermanently)
+	return true
+}
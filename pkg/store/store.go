@@ -6,6 +6,9 @@ import (
 
 	"github.com/MinhNHHH/online-store/pkg/cfgs"
 	databases "github.com/MinhNHHH/online-store/pkg/databases/repositories"
+	"github.com/MinhNHHH/online-store/pkg/mailer"
+	"github.com/MinhNHHH/online-store/pkg/passwords"
+	"github.com/MinhNHHH/online-store/pkg/storage"
 	"github.com/alexedwards/scs/v2"
 	"github.com/go-chi/chi"
 	"github.com/go-chi/chi/middleware"
@@ -15,6 +18,16 @@ type OnlineStore struct {
 	Cfgs    cfgs.Configs
 	DB      databases.DatabaseRepo
 	Session *scs.SessionManager
+	Mailer  mailer.Mailer
+	// Keys signs and verifies tokens. When nil, HS256 with JWT_SECRET is used.
+	Keys *KeySet
+	// OIDC holds the OpenID Connect providers users can sign in with, by name.
+	OIDC map[string]*OIDCProvider
+	// Passwords is the policy new passwords must meet. When nil, only the
+	// configured lengths are checked.
+	Passwords *passwords.Policy
+	// Storage keeps uploaded product images. When nil, uploads are refused.
+	Storage storage.Storage
 }
 
 func (app *OnlineStore) SendResponse(w http.ResponseWriter, status int, data interface{}) {
@@ -23,45 +36,142 @@ func (app *OnlineStore) SendResponse(w http.ResponseWriter, status int, data int
 	json.NewEncoder(w).Encode(data)
 }
 
+type ErrorResponse struct {
+	Error string `json:"error"`
+}
+
+// SendError writes err as a JSON body of the form {"error": "..."}.
+func (app *OnlineStore) SendError(w http.ResponseWriter, status int, err error) {
+	app.SendResponse(w, status, ErrorResponse{Error: err.Error()})
+}
+
+// FieldError is one problem with one field of a request.
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+type ValidationErrorResponse struct {
+	Error  string       `json:"error"`
+	Fields []FieldError `json:"fields"`
+}
+
+// SendValidationError writes a 400 response listing what is wrong with each
+// field of the request.
+func (app *OnlineStore) SendValidationError(w http.ResponseWriter, fields []FieldError) {
+	app.SendResponse(w, http.StatusBadRequest, ValidationErrorResponse{Error: "validation failed", Fields: fields})
+}
+
 func (app *OnlineStore) Routes() http.Handler {
 	mux := chi.NewRouter()
 	// register middleware
 	mux.Use(middleware.Recoverer)
 	mux.Use(app.enableCORS)
+	mux.Use(app.loadSession)
 	// register routes
+	mux.Get("/.well-known/jwks.json", app.jwks)
+	if media, ok := app.Storage.(http.Handler); ok {
+		if path, ok := mediaPath(app.Cfgs.MEDIA_BASE_URL); ok {
+			mux.Handle(path+"/*", http.StripPrefix(path, media))
+		}
+	}
 	mux.Route("/api/v1", func(r chi.Router) {
 		r.Post("/auth", app.authenticate)
 		r.Post("/refresh-token", app.refresh)
 		r.Post("/auth/register", app.CreateUser)
+		r.Post("/auth/logout", app.logout)
+		r.Post("/auth/session", app.sessionLogin)
+		r.Get("/auth/session", app.sessionInfo)
+		r.With(app.authRequired).Delete("/auth/session", app.sessionLogout)
+		r.Post("/auth/forgot-password", app.forgotPassword)
+		r.Post("/auth/reset-password", app.resetPassword)
+		r.Post("/auth/verify-email", app.verifyEmail)
+		r.Post("/auth/resend-verification", app.resendVerification)
+		r.With(app.authRequired).Post("/auth/logout-all", app.logoutAll)
+		r.Post("/auth/2fa/verify", app.verifyTwoFactor)
+		r.Get("/auth/oidc", app.oidcProviders)
+		r.Get("/auth/oidc/{provider}", app.oidcLogin)
+		r.Get("/auth/oidc/{provider}/callback", app.oidcCallback)
+		r.Group(func(rTwoFactor chi.Router) {
+			rTwoFactor.Use(app.authRequired)
+			rTwoFactor.Post("/auth/2fa/enroll", app.enrollTwoFactor)
+			rTwoFactor.Post("/auth/2fa/confirm", app.confirmTwoFactor)
+			rTwoFactor.Post("/auth/2fa/disable", app.disableTwoFactor)
+		})
 		r.Route("/users", func(rUser chi.Router) {
-			// rUser.Put("/{id}", app.UpdateUser)
-			// rUser.Delete("/{id}", app.DeleteUser)
+			rUser.Route("/me", func(rMe chi.Router) {
+				rMe.Use(app.authRequired)
+				rMe.Get("/", app.GetCurrentUser)
+				rMe.Patch("/", app.UpdateCurrentUser)
+				rMe.Delete("/", app.DeleteCurrentUser)
+				rMe.Post("/password", app.ChangePassword)
+				rMe.Get("/export", app.ExportCurrentUser)
+			})
 			rUser.Route("/wishlist", func(rWishlist chi.Router) {
 				rWishlist.Use(app.authRequired)
-				rWishlist.Post("/", app.AddToWishlist)
+				rWishlist.With(app.verifiedEmailRequired).Post("/", app.AddToWishlist)
 				rWishlist.Delete("/", app.RemoveFromWishlist)
 				rWishlist.Get("/", app.GetWishlist)
 			})
 		})
 		r.Route("/products", func(rProduct chi.Router) {
-			rProduct.Use(app.authRequired)
-			rProduct.Get("/", app.GetProducts)
-			rProduct.Post("/", app.CreateProduct)
-			rProduct.Put("/{id}", app.UpdateProduct)
-			rProduct.Delete("/{id}", app.DeleteProduct)
+			rProduct.With(app.apiKeyScope(scopeProductsRead), app.authRequired).Get("/", app.GetProducts)
+			rProduct.With(app.apiKeyScope(scopeProductsRead), app.authRequired).Get("/{id}", app.GetProduct)
+			rProduct.With(app.apiKeyScope(scopeProductsRead), app.authRequired).Get("/{id}/images", app.GetProductImages)
+			rProduct.Group(func(rAdmin chi.Router) {
+				rAdmin.Use(app.apiKeyScope(scopeProductsWrite))
+				rAdmin.Use(app.authRequired)
+				rAdmin.Use(app.adminRequired)
+				rAdmin.Post("/", app.CreateProduct)
+				rAdmin.Put("/{id}", app.UpdateProduct)
+				rAdmin.Delete("/{id}", app.DeleteProduct)
+				rAdmin.Post("/{id}/variants", app.CreateProductVariant)
+				rAdmin.Put("/{id}/variants/{variant_id}", app.UpdateProductVariant)
+				rAdmin.Delete("/{id}/variants/{variant_id}", app.DeleteProductVariant)
+				rAdmin.Post("/{id}/images", app.UploadProductImage)
+				rAdmin.Put("/{id}/images/order", app.ReorderProductImages)
+				rAdmin.Patch("/{id}/images/{image_id}", app.UpdateProductImage)
+				rAdmin.Delete("/{id}/images/{image_id}", app.DeleteProductImage)
+			})
 		})
 		r.Route("/categories", func(rCategory chi.Router) {
-			rCategory.Use(app.authRequired)
-			rCategory.Get("/", app.GetCategories)
-			rCategory.Post("/", app.CreateCategory)
-			rCategory.Put("/{id}", app.UpdateCategory)
-			rCategory.Delete("/{id}", app.DeleteCategory)
+			rCategory.With(app.apiKeyScope(scopeCategoriesRead), app.authRequired).Get("/", app.GetCategories)
+			rCategory.With(app.apiKeyScope(scopeCategoriesRead), app.authRequired).Get("/tree", app.GetCategoryTree)
+			rCategory.With(app.apiKeyScope(scopeCategoriesRead), app.authRequired).Get("/{id}", app.GetCategory)
+			rCategory.Group(func(rAdmin chi.Router) {
+				rAdmin.Use(app.apiKeyScope(scopeCategoriesWrite))
+				rAdmin.Use(app.authRequired)
+				rAdmin.Use(app.adminRequired)
+				rAdmin.Post("/", app.CreateCategory)
+				rAdmin.Put("/{id}", app.UpdateCategory)
+				rAdmin.Delete("/{id}", app.DeleteCategory)
+				rAdmin.Put("/{id}/parent", app.MoveCategory)
+			})
+		})
+		r.Route("/admin", func(rAdmin chi.Router) {
+			rAdmin.Use(app.authRequired)
+			rAdmin.Use(app.adminRequired)
+			rAdmin.Get("/lockouts", app.GetLockouts)
+			rAdmin.Get("/users", app.GetUsers)
+			rAdmin.Get("/audit", app.GetAuthEvents)
+			rAdmin.Post("/users/{id}/unlock", app.UnlockUser)
+			rAdmin.Post("/users/{id}/promote", app.PromoteUser)
+			rAdmin.Post("/users/{id}/demote", app.DemoteUser)
+			rAdmin.Post("/users/{id}/deactivate", app.DeactivateUser)
+			rAdmin.Post("/users/{id}/reactivate", app.ReactivateUser)
+			rAdmin.Post("/users/{id}/reset-password", app.ForcePasswordReset)
+			rAdmin.Get("/api-keys", app.GetAPIKeys)
+			rAdmin.Post("/api-keys", app.CreateAPIKey)
+			rAdmin.Delete("/api-keys/{id}", app.RevokeAPIKey)
 		})
 		r.Route("/reviews", func(rReview chi.Router) {
-			rReview.Use(app.authRequired)
-			rReview.Get("/{product_id}", app.GetReviewsByProductID)
-			rReview.Post("/{product_id}", app.CreateReview)
-			rReview.Delete("/{product_id}", app.DeleteReview)
+			rReview.With(app.apiKeyScope(scopeReviewsRead), app.authRequired).Get("/{product_id}", app.GetReviewsByProductID)
+			rReview.Group(func(rUser chi.Router) {
+				rUser.Use(app.authRequired)
+				rUser.With(app.verifiedEmailRequired).Post("/{product_id}", app.CreateReview)
+				rUser.Delete("/{product_id}/{id}", app.DeleteReview)
+			})
 		})
 	})
 
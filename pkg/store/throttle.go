@@ -0,0 +1,219 @@
+package store
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/MinhNHHH/online-store/pkg/databases/schema"
+)
+
+// Scopes of login throttles. A challenge throttle counts the wrong codes sent
+// with one two-factor challenge, keyed by its ID.
+const (
+	throttleAccount   = "account"
+	throttleIP        = "ip"
+	throttleChallenge = "challenge"
+)
+
+const (
+	// loginDelayAfter is the number of failures allowed before each further
+	// attempt has to wait.
+	loginDelayAfter = 3
+	// accountLockAfter and ipLockAfter are the failure counts that lock an
+	// account or a client IP. An IP gets more room because it may be shared.
+	accountLockAfter = 10
+	ipLockAfter      = 50
+	// maxChallengeAttempts is the number of wrong codes after which a
+	// two-factor challenge is spent and the login has to start over.
+	maxChallengeAttempts = 5
+)
+
+var (
+	// loginFailureWindow is how long failures are remembered.
+	loginFailureWindow = time.Hour
+	maxLoginDelay      = time.Minute
+	loginLockDuration  = time.Minute * 15
+)
+
+// loginDelay is the wait imposed after the given number of consecutive
+// failures. It doubles with every failure past loginDelayAfter.
+func loginDelay(failures int) time.Duration {
+	if failures < loginDelayAfter {
+		return 0
+	}
+	shift := failures - loginDelayAfter
+	if shift > 10 {
+		return maxLoginDelay
+	}
+	delay := time.Second << shift
+	if delay > maxLoginDelay {
+		return maxLoginDelay
+	}
+	return delay
+}
+
+// throttleWait is how long the holder of throttle must wait before trying
+// again, or zero if they may try now.
+func throttleWait(throttle *schema.LoginThrottle, now time.Time) time.Duration {
+	if throttle.LockedUntil != nil && throttle.LockedUntil.After(now) {
+		return throttle.LockedUntil.Sub(now)
+	}
+	if throttle.LastFailureAt == nil {
+		return 0
+	}
+	if wait := throttle.LastFailureAt.Add(loginDelay(throttle.Failures)).Sub(now); wait > 0 {
+		return wait
+	}
+	return 0
+}
+
+// clientIP is the address of the peer. Forwarding headers are ignored since
+// they are trivial to spoof.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func accountThrottleKey(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// loginRetryAfter is how long a login for email from ip has to wait, taking
+// the longer of the account and the IP throttles.
+func (app *OnlineStore) loginRetryAfter(email, ip string) time.Duration {
+	now := time.Now()
+	var wait time.Duration
+	for _, t := range loginThrottleKeys(email, ip) {
+		throttle, err := app.DB.GetLoginThrottle(t.scope, t.key)
+		if err != nil {
+			if !errors.Is(err, sql.ErrNoRows) {
+				log.Printf("Error getting login throttle: %v", err)
+			}
+			continue
+		}
+		if w := throttleWait(throttle, now); w > wait {
+			wait = w
+		}
+	}
+	return wait
+}
+
+type loginThrottleKey struct {
+	scope, key string
+	lockAfter  int
+}
+
+// loginThrottleKeys are the throttles a login for email from ip counts
+// against.
+func loginThrottleKeys(email, ip string) []loginThrottleKey {
+	keys := []loginThrottleKey{}
+	if key := accountThrottleKey(email); key != "" {
+		keys = append(keys, loginThrottleKey{throttleAccount, key, accountLockAfter})
+	}
+	if ip != "" {
+		keys = append(keys, loginThrottleKey{throttleIP, ip, ipLockAfter})
+	}
+	return keys
+}
+
+// recordLoginFailure counts a failed login against the account and the IP and
+// locks whichever of them crossed its threshold. userID is nil when the email
+// does not belong to any user.
+func (app *OnlineStore) recordLoginFailure(email, ip string, userID *int) {
+	for _, t := range loginThrottleKeys(email, ip) {
+		throttle, err := app.DB.RecordLoginFailure(t.scope, t.key, loginFailureWindow)
+		if err != nil {
+			log.Printf("Error recording login failure: %v", err)
+			continue
+		}
+		if throttle.Failures >= t.lockAfter {
+			app.lockLogin(t, throttle.Failures, ip, userID)
+		}
+	}
+}
+
+// countLoginAttempt counts a password login as failed before the password is
+// checked, so parallel attempts cannot all pass loginRetryAfter while the
+// first ones are still hashing: raising and reading the count is one
+// statement. An attempt past the limit of the account or the IP locks it and
+// has to wait the returned time; otherwise the attempt may go on and is taken
+// back by forgiveLoginAttempt if the password is right.
+func (app *OnlineStore) countLoginAttempt(email, ip string, userID *int) time.Duration {
+	now := time.Now()
+	var wait time.Duration
+	for _, t := range loginThrottleKeys(email, ip) {
+		throttle, err := app.DB.RecordLoginFailure(t.scope, t.key, loginFailureWindow)
+		if err != nil {
+			log.Printf("Error recording login attempt: %v", err)
+			continue
+		}
+		if throttle.LockedUntil != nil && throttle.LockedUntil.After(now) {
+			wait = max(wait, throttle.LockedUntil.Sub(now))
+			continue
+		}
+		if throttle.Failures > t.lockAfter {
+			app.lockLogin(t, throttle.Failures, ip, userID)
+			wait = max(wait, loginLockDuration)
+		}
+	}
+	return wait
+}
+
+// lockFailedLogin locks the account or IP of a login counted by
+// countLoginAttempt whose password was wrong, if that used up its last
+// attempt.
+func (app *OnlineStore) lockFailedLogin(email, ip string, userID *int) {
+	for _, t := range loginThrottleKeys(email, ip) {
+		throttle, err := app.DB.GetLoginThrottle(t.scope, t.key)
+		if err != nil {
+			if !errors.Is(err, sql.ErrNoRows) {
+				log.Printf("Error getting login throttle: %v", err)
+			}
+			continue
+		}
+		if throttle.Failures >= t.lockAfter && (throttle.LockedUntil == nil || !throttle.LockedUntil.After(time.Now())) {
+			app.lockLogin(t, throttle.Failures, ip, userID)
+		}
+	}
+}
+
+// forgiveLoginAttempt takes back the attempt countLoginAttempt counted once
+// the password turned out right.
+func (app *OnlineStore) forgiveLoginAttempt(email, ip string) {
+	for _, t := range loginThrottleKeys(email, ip) {
+		if err := app.DB.ForgiveLoginFailure(t.scope, t.key); err != nil {
+			log.Printf("Error forgiving login attempt: %v", err)
+		}
+	}
+}
+
+func (app *OnlineStore) lockLogin(t loginThrottleKey, failures int, ip string, userID *int) {
+	lockout := &schema.Lockout{
+		Scope:       t.scope,
+		Key:         t.key,
+		IP:          ip,
+		Failures:    failures,
+		LockedUntil: time.Now().Add(loginLockDuration),
+	}
+	if t.scope == throttleAccount {
+		lockout.UserID = userID
+	}
+	if err := app.DB.LockLogin(lockout); err != nil {
+		log.Printf("Error locking login: %v", err)
+	}
+}
+
+func (app *OnlineStore) sendTooManyAttempts(w http.ResponseWriter, wait time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	app.SendError(w, http.StatusTooManyRequests, errors.New("too many failed login attempts, try again later"))
+}
@@ -0,0 +1,200 @@
+package store
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/MinhNHHH/online-store/pkg/databases/repositories/dbrepo"
+	"github.com/MinhNHHH/online-store/pkg/databases/schema"
+)
+
+func Test_loginDelay(t *testing.T) {
+	var tests = []struct {
+		failures int
+		expected time.Duration
+	}{
+		{0, 0},
+		{2, 0},
+		{3, time.Second},
+		{4, 2 * time.Second},
+		{6, 8 * time.Second},
+		{9, maxLoginDelay},
+		{100, maxLoginDelay},
+	}
+
+	for _, e := range tests {
+		if got := loginDelay(e.failures); got != e.expected {
+			t.Errorf("loginDelay(%d): expected %s but got %s", e.failures, e.expected, got)
+		}
+	}
+}
+
+func Test_throttleWait(t *testing.T) {
+	now := time.Now()
+	lockedUntil := now.Add(time.Minute)
+	expiredLock := now.Add(-time.Minute)
+	longAgo := now.Add(-time.Hour)
+
+	var tests = []struct {
+		name     string
+		throttle schema.LoginThrottle
+		wantWait bool
+	}{
+		{"no failures", schema.LoginThrottle{}, false},
+		{"few recent failures", schema.LoginThrottle{Failures: 2, LastFailureAt: &now}, false},
+		{"many recent failures", schema.LoginThrottle{Failures: 5, LastFailureAt: &now}, true},
+		{"many old failures", schema.LoginThrottle{Failures: 5, LastFailureAt: &longAgo}, false},
+		{"locked", schema.LoginThrottle{Failures: 10, LastFailureAt: &longAgo, LockedUntil: &lockedUntil}, true},
+		{"lock expired", schema.LoginThrottle{Failures: 10, LastFailureAt: &longAgo, LockedUntil: &expiredLock}, false},
+	}
+
+	for _, e := range tests {
+		wait := throttleWait(&e.throttle, now)
+		if e.wantWait && wait <= 0 {
+			t.Errorf("%s: expected a wait, got none", e.name)
+		}
+		if !e.wantWait && wait != 0 {
+			t.Errorf("%s: expected no wait, got %s", e.name, wait)
+		}
+	}
+}
+
+func Test_app_authenticateThrottled(t *testing.T) {
+	var theTests = []struct {
+		name               string
+		requestBody        string
+		expectedStatusCode int
+	}{
+		{"locked account", `{"email":"locked@example.com", "password":"secret"}`, http.StatusTooManyRequests},
+		{"locked account different case", `{"email":"Locked@Example.com", "password":"secret"}`, http.StatusTooManyRequests},
+		{"delayed account", `{"email":"slow@example.com", "password":"secret"}`, http.StatusTooManyRequests},
+		{"wrong password", `{"email":"admin@example.com", "password":"wrong"}`, http.StatusUnauthorized},
+	}
+
+	for _, e := range theTests {
+		req, _ := http.NewRequest("POST", "/api/v1/auth", strings.NewReader(e.requestBody))
+		req.RemoteAddr = "192.0.2.1:4321"
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(app.authenticate)
+		handler.ServeHTTP(rr, req)
+		if e.expectedStatusCode != rr.Code {
+			t.Errorf("%s: returned wrong status code; expected %d but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+		if rr.Code == http.StatusTooManyRequests && rr.Header().Get("Retry-After") == "" {
+			t.Errorf("%s: expected a Retry-After header", e.name)
+		}
+	}
+}
+
+type loginAttemptRecorder struct {
+	dbrepo.TestDBRepo
+	mu       sync.Mutex
+	failures map[string]int
+	locks    []schema.Lockout
+	dbErr    error
+}
+
+func (p *loginAttemptRecorder) GetUserByEmail(email string) (*schema.User, error) {
+	if p.dbErr != nil {
+		return nil, p.dbErr
+	}
+	return p.TestDBRepo.GetUserByEmail(email)
+}
+
+func (p *loginAttemptRecorder) GetLoginThrottle(scope, key string) (*schema.LoginThrottle, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return &schema.LoginThrottle{Scope: scope, Key: key, Failures: p.failures[scope+":"+key]}, nil
+}
+
+func (p *loginAttemptRecorder) RecordLoginFailure(scope, key string, window time.Duration) (*schema.LoginThrottle, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.failures[scope+":"+key]++
+	return &schema.LoginThrottle{Scope: scope, Key: key, Failures: p.failures[scope+":"+key]}, nil
+}
+
+func (p *loginAttemptRecorder) ForgiveLoginFailure(scope, key string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.failures[scope+":"+key]--
+	return nil
+}
+
+func (p *loginAttemptRecorder) LockLogin(l *schema.Lockout) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.locks = append(p.locks, *l)
+	return nil
+}
+
+func Test_app_authenticateCountsAttemptsFirst(t *testing.T) {
+	recorder := &loginAttemptRecorder{failures: map[string]int{}}
+	testApp := app
+	testApp.DB = recorder
+
+	// a burst of attempts sent together: none of them has failed yet when the
+	// next one arrives, so only counting before the password check stops it
+	var wg sync.WaitGroup
+	codes := make(chan int, accountLockAfter+2)
+	for i := 0; i < accountLockAfter+2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			req, _ := http.NewRequest("POST", "/api/v1/auth", strings.NewReader(`{"email":"admin@example.com", "password":"wrong"}`))
+			req.RemoteAddr = "192.0.2.1:4321"
+			rr := httptest.NewRecorder()
+			http.HandlerFunc(testApp.authenticate).ServeHTTP(rr, req)
+			codes <- rr.Code
+		}()
+	}
+	wg.Wait()
+	close(codes)
+
+	throttled := 0
+	for code := range codes {
+		if code == http.StatusTooManyRequests {
+			throttled++
+		}
+	}
+	if throttled != 2 {
+		t.Errorf("expected 2 attempts past the limit to be throttled but got %d", throttled)
+	}
+	if len(recorder.locks) == 0 {
+		t.Error("expected the account to be locked")
+	}
+}
+
+func Test_app_authenticateForgivesRightPassword(t *testing.T) {
+	recorder := &loginAttemptRecorder{failures: map[string]int{}}
+	testApp := app
+	testApp.DB = recorder
+
+	req, _ := http.NewRequest("POST", "/api/v1/auth", strings.NewReader(`{"email":"admin@example.com", "password":"secret"}`))
+	req.RemoteAddr = "192.0.2.1:4321"
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(testApp.authenticate).ServeHTTP(rr, req)
+
+	if got := recorder.failures[throttleIP+":192.0.2.1"]; got != 0 {
+		t.Errorf("expected the attempt to be forgiven but %d failures are left", got)
+	}
+}
+
+func Test_app_authenticateLookupError(t *testing.T) {
+	testApp := app
+	testApp.DB = &loginAttemptRecorder{failures: map[string]int{}, dbErr: errors.New("connection refused")}
+
+	req, _ := http.NewRequest("POST", "/api/v1/auth", strings.NewReader(`{"email":"admin@example.com", "password":"secret"}`))
+	req.RemoteAddr = "192.0.2.1:4321"
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(testApp.authenticate).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusInternalServerError {
+		t.Errorf("returned wrong status code; expected %d but got %d", http.StatusInternalServerError, rr.Code)
+	}
+}
@@ -0,0 +1,329 @@
+package store
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	databases "github.com/MinhNHHH/online-store/pkg/databases/repositories"
+	"github.com/MinhNHHH/online-store/pkg/databases/schema"
+	"github.com/MinhNHHH/online-store/pkg/totp"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+const twoFactorChallengeType = "2fa_challenge"
+
+var challengeTokenExpiry = time.Minute * 5
+
+const recoveryCodeCount = 10
+
+// TwoFactorChallenge is returned by authenticate instead of TokenPairs when
+// the user has two-factor authentication on.
+type TwoFactorChallenge struct {
+	TwoFactorRequired bool   `json:"two_factor_required"`
+	ChallengeToken    string `json:"challenge_token"`
+}
+
+// generateChallengeToken signs a short-lived token proving the password step
+// of a login succeeded. It is only accepted by verifyTwoFactor, which finishes
+// the login with a cookie session when session is set. Its jti keys the count
+// of wrong codes sent with it.
+func (app *OnlineStore) generateChallengeToken(user *schema.User, session bool) (string, error) {
+	challengeID, err := randomToken(16)
+	if err != nil {
+		return "", err
+	}
+	claims := jwt.MapClaims{}
+	claims["jti"] = challengeID
+	claims["sub"] = fmt.Sprint(user.ID)
+	claims["aud"] = app.Cfgs.DOMAIN
+	claims["typ"] = twoFactorChallengeType
+	claims["exp"] = time.Now().Add(challengeTokenExpiry).Unix()
+	if session {
+		claims["ses"] = true
+	}
+
+	return app.keys().Sign(claims)
+}
+
+// parseChallengeToken returns the claims of challenge, with the user ID in
+// it.
+func (app *OnlineStore) parseChallengeToken(challenge string) (*Claims, int, error) {
+	claims := &Claims{}
+	_, err := jwt.ParseWithClaims(challenge, claims, app.keys().Keyfunc)
+	if err != nil {
+		return nil, 0, err
+	}
+	if claims.Type != twoFactorChallengeType || claims.ID == "" {
+		return nil, 0, errors.New("not a challenge token")
+	}
+	userID, err := strconv.Atoi(claims.Subject)
+	return claims, userID, err
+}
+
+// challengeSpent reports whether the challenge with the given ID has had
+// maxChallengeAttempts wrong codes.
+func (app *OnlineStore) challengeSpent(challengeID string) bool {
+	throttle, err := app.DB.GetLoginThrottle(throttleChallenge, challengeID)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			log.Printf("Error getting challenge throttle: %v", err)
+		}
+		return false
+	}
+	return throttle.Failures >= maxChallengeAttempts
+}
+
+// generateRecoveryCodes returns recovery codes formatted as xxxxx-xxxxx
+// together with the hashes to store.
+func generateRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		raw, err := randomToken(5)
+		if err != nil {
+			return nil, nil, err
+		}
+		codes[i] = raw[:5] + "-" + raw[5:]
+		hashes[i] = hashRecoveryCode(codes[i])
+	}
+	return codes, hashes, nil
+}
+
+func hashRecoveryCode(code string) string {
+	return hashToken(strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", "")))
+}
+
+// checkTOTP validates code for user and burns its time step so it cannot be
+// used again.
+func (app *OnlineStore) checkTOTP(user *schema.User, code string) error {
+	step, ok := totp.Validate(user.TOTPSecret, code, time.Now())
+	if !ok {
+		return errors.New("invalid code")
+	}
+	return app.DB.UseTOTPStep(user.ID, step)
+}
+
+// enrollTwoFactor creates a new secret for the user. Two-factor stays off
+// until a code generated from it is confirmed.
+func (app *OnlineStore) enrollTwoFactor(w http.ResponseWriter, r *http.Request) {
+	userID, err := app.authenticatedUserID(w, r)
+	if err != nil {
+		app.SendError(w, http.StatusUnauthorized, errors.New("unauthorized"))
+		return
+	}
+
+	user, err := app.DB.GetUser(userID)
+	if err != nil {
+		log.Printf("Error getting user: %v", err)
+		app.SendError(w, http.StatusUnauthorized, errors.New("unauthorized"))
+		return
+	}
+
+	if user.TOTPEnabledAt != nil {
+		app.SendError(w, http.StatusConflict, errors.New("two-factor authentication is already enabled"))
+		return
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		log.Printf("Error generating totp secret: %v", err)
+		app.SendError(w, http.StatusInternalServerError, errors.New("could not start enrollment"))
+		return
+	}
+
+	if err := app.DB.SetTOTPSecret(user.ID, secret); err != nil {
+		log.Printf("Error storing totp secret: %v", err)
+		app.SendError(w, http.StatusInternalServerError, errors.New("could not start enrollment"))
+		return
+	}
+
+	response := struct {
+		Secret     string `json:"secret"`
+		OTPAuthURI string `json:"otpauth_uri"`
+	}{
+		Secret:     secret,
+		OTPAuthURI: totp.URI(app.Cfgs.DOMAIN, user.Email, secret),
+	}
+	app.SendResponse(w, http.StatusOK, response)
+}
+
+// confirmTwoFactor turns two-factor on once the user proves their app
+// produces valid codes, and returns the recovery codes. They are only shown
+// this once.
+func (app *OnlineStore) confirmTwoFactor(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Code string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		log.Printf("Error decoding request: %v", err)
+		app.SendError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	userID, err := app.authenticatedUserID(w, r)
+	if err != nil {
+		app.SendError(w, http.StatusUnauthorized, errors.New("unauthorized"))
+		return
+	}
+
+	user, err := app.DB.GetUser(userID)
+	if err != nil {
+		log.Printf("Error getting user: %v", err)
+		app.SendError(w, http.StatusUnauthorized, errors.New("unauthorized"))
+		return
+	}
+
+	if user.TOTPSecret == "" {
+		app.SendError(w, http.StatusBadRequest, errors.New("two-factor enrollment has not been started"))
+		return
+	}
+	if user.TOTPEnabledAt != nil {
+		app.SendError(w, http.StatusConflict, errors.New("two-factor authentication is already enabled"))
+		return
+	}
+
+	if err := app.checkTOTP(user, request.Code); err != nil {
+		app.SendError(w, http.StatusBadRequest, errors.New("invalid code"))
+		return
+	}
+
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		log.Printf("Error generating recovery codes: %v", err)
+		app.SendError(w, http.StatusInternalServerError, errors.New("could not enable two-factor authentication"))
+		return
+	}
+
+	if err := app.DB.EnableTOTP(user.ID, hashes); err != nil {
+		log.Printf("Error enabling totp: %v", err)
+		app.SendError(w, http.StatusInternalServerError, errors.New("could not enable two-factor authentication"))
+		return
+	}
+
+	app.recordAuthEvent(r, schema.AuthEvent{Type: eventTwoFactorEnabled, Outcome: outcomeSuccess, UserID: &user.ID, Email: user.Email})
+	response := struct {
+		RecoveryCodes []string `json:"recovery_codes"`
+	}{
+		RecoveryCodes: codes,
+	}
+	app.SendResponse(w, http.StatusOK, response)
+}
+
+func (app *OnlineStore) disableTwoFactor(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Code string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		log.Printf("Error decoding request: %v", err)
+		app.SendError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	userID, err := app.authenticatedUserID(w, r)
+	if err != nil {
+		app.SendError(w, http.StatusUnauthorized, errors.New("unauthorized"))
+		return
+	}
+
+	user, err := app.DB.GetUser(userID)
+	if err != nil {
+		log.Printf("Error getting user: %v", err)
+		app.SendError(w, http.StatusUnauthorized, errors.New("unauthorized"))
+		return
+	}
+
+	if user.TOTPEnabledAt == nil {
+		app.SendError(w, http.StatusBadRequest, errors.New("two-factor authentication is not enabled"))
+		return
+	}
+
+	if err := app.checkTOTP(user, request.Code); err != nil {
+		app.recordAuthEvent(r, schema.AuthEvent{Type: eventTwoFactorDisabled, Outcome: outcomeFailure, Reason: reasonInvalidCode, UserID: &user.ID, Email: user.Email})
+		app.SendError(w, http.StatusBadRequest, errors.New("invalid code"))
+		return
+	}
+
+	if err := app.DB.DisableTOTP(user.ID); err != nil {
+		log.Printf("Error disabling totp: %v", err)
+		app.SendError(w, http.StatusInternalServerError, errors.New("could not disable two-factor authentication"))
+		return
+	}
+	app.recordAuthEvent(r, schema.AuthEvent{Type: eventTwoFactorDisabled, Outcome: outcomeSuccess, UserID: &user.ID, Email: user.Email})
+	app.SendResponse(w, http.StatusOK, nil)
+}
+
+// verifyTwoFactor completes a login started by authenticate, taking either a
+// code from the authenticator app or one of the recovery codes. Wrong codes
+// count against the account and IP like wrong passwords, and spend the
+// challenge after maxChallengeAttempts.
+func (app *OnlineStore) verifyTwoFactor(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		ChallengeToken string `json:"challenge_token"`
+		Code           string `json:"code"`
+		RecoveryCode   string `json:"recovery_code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		log.Printf("Error decoding request: %v", err)
+		app.SendError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	claims, userID, err := app.parseChallengeToken(request.ChallengeToken)
+	if err != nil {
+		log.Printf("Error parsing challenge token: %v", err)
+		app.recordAuthEvent(r, schema.AuthEvent{Type: eventLogin, Outcome: outcomeFailure, Reason: reasonInvalidToken})
+		app.SendError(w, http.StatusUnauthorized, errors.New("invalid or expired challenge"))
+		return
+	}
+
+	user, err := app.DB.GetUser(userID)
+	if err != nil || user.TOTPEnabledAt == nil {
+		app.SendError(w, http.StatusUnauthorized, errors.New("invalid or expired challenge"))
+		return
+	}
+
+	ip := clientIP(r)
+	if wait := app.loginRetryAfter(user.Email, ip); wait > 0 {
+		app.recordAuthEvent(r, schema.AuthEvent{Type: eventLogin, Outcome: outcomeFailure, Reason: reasonThrottled, UserID: &user.ID, Email: user.Email})
+		app.sendTooManyAttempts(w, wait)
+		return
+	}
+	if app.challengeSpent(claims.ID) {
+		app.recordAuthEvent(r, schema.AuthEvent{Type: eventLogin, Outcome: outcomeFailure, Reason: reasonInvalidToken, UserID: &user.ID, Email: user.Email})
+		app.SendError(w, http.StatusUnauthorized, errors.New("invalid or expired challenge"))
+		return
+	}
+
+	switch {
+	case request.Code != "":
+		err = app.checkTOTP(user, request.Code)
+	case request.RecoveryCode != "":
+		err = app.DB.UseRecoveryCode(user.ID, hashRecoveryCode(request.RecoveryCode))
+	default:
+		err = errors.New("code or recovery_code is required")
+	}
+	if errors.Is(err, databases.ErrTOTPCodeUsed) || errors.Is(err, sql.ErrNoRows) {
+		err = errors.New("invalid code")
+	}
+	if err != nil {
+		app.recordLoginFailure(user.Email, ip, &user.ID)
+		if _, err := app.DB.RecordLoginFailure(throttleChallenge, claims.ID, challengeTokenExpiry); err != nil {
+			log.Printf("Error recording challenge failure: %v", err)
+		}
+		app.recordAuthEvent(r, schema.AuthEvent{Type: eventLogin, Outcome: outcomeFailure, Reason: reasonInvalidCode, UserID: &user.ID, Email: user.Email})
+		app.SendError(w, http.StatusUnauthorized, err)
+		return
+	}
+
+	if err := app.DB.ClearLoginFailures(throttleChallenge, claims.ID); err != nil {
+		log.Printf("Error clearing challenge failures: %v", err)
+	}
+	app.finishLogin(w, r, user, true, claims.Session)
+}
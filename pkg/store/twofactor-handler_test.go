@@ -0,0 +1,239 @@
+package store
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/MinhNHHH/online-store/pkg/databases/repositories/dbrepo"
+	"github.com/MinhNHHH/online-store/pkg/databases/schema"
+	"github.com/MinhNHHH/online-store/pkg/totp"
+)
+
+func Test_app_authenticateTwoFactorChallenge(t *testing.T) {
+	req, _ := http.NewRequest("POST", "/api/v1/auth", strings.NewReader(`{"email":"twofactor@example.com", "password":"secret"}`))
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(app.authenticate).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200 but got %d", rr.Code)
+	}
+
+	var response map[string]interface{}
+	_ = json.NewDecoder(rr.Body).Decode(&response)
+	if response["two_factor_required"] != true || response["challenge_token"] == "" {
+		t.Errorf("expected a two-factor challenge, got %v", response)
+	}
+	if _, ok := response["access_token"]; ok {
+		t.Error("expected no access token before the second factor")
+	}
+}
+
+func Test_app_verifyTwoFactor(t *testing.T) {
+	twoFactorUser := &schema.User{ID: 3}
+	challenge, _ := app.generateChallengeToken(twoFactorUser, false)
+	otherChallenge, _ := app.generateChallengeToken(&schema.User{ID: 1}, false)
+	accessTokens, _, _ := app.generateTokenPair(twoFactorUser, "", false)
+	code, _ := totp.CodeAt(dbrepo.TestTOTPSecret, totp.Step(time.Now()))
+
+	var theTests = []struct {
+		name               string
+		requestBody        string
+		expectedStatusCode int
+	}{
+		{"valid code", `{"challenge_token":"` + challenge + `", "code":"` + code + `"}`, http.StatusOK},
+		{"wrong code", `{"challenge_token":"` + challenge + `", "code":"12345"}`, http.StatusUnauthorized},
+		{"valid recovery code", `{"challenge_token":"` + challenge + `", "recovery_code":"AAAAA-BBBBB"}`, http.StatusOK},
+		{"wrong recovery code", `{"challenge_token":"` + challenge + `", "recovery_code":"aaaaa-ccccc"}`, http.StatusUnauthorized},
+		{"no code", `{"challenge_token":"` + challenge + `"}`, http.StatusUnauthorized},
+		{"user without two-factor", `{"challenge_token":"` + otherChallenge + `", "code":"` + code + `"}`, http.StatusUnauthorized},
+		{"access token as challenge", `{"challenge_token":"` + accessTokens.Token + `", "code":"` + code + `"}`, http.StatusUnauthorized},
+		{"not json", `i am not JSON`, http.StatusBadRequest},
+	}
+
+	for _, e := range theTests {
+		req, _ := http.NewRequest("POST", "/api/v1/auth/2fa/verify", strings.NewReader(e.requestBody))
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(app.verifyTwoFactor).ServeHTTP(rr, req)
+		if e.expectedStatusCode != rr.Code {
+			t.Errorf("%s: returned wrong status code; expected %d but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+	}
+}
+
+func Test_app_challengeTokenIsNotAnAccessToken(t *testing.T) {
+	challenge, _ := app.generateChallengeToken(&schema.User{ID: 3}, false)
+
+	req, _ := http.NewRequest("GET", "/", nil)
+	req.Header.Set("Authorization", "Bearer "+challenge)
+	rr := httptest.NewRecorder()
+	app.authRequired(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("expected status 401 but got %d", rr.Code)
+	}
+}
+
+func Test_app_enrollTwoFactor(t *testing.T) {
+	userTokens, _, _ := app.generateTokenPair(&schema.User{ID: 1}, "", false)
+	enabledTokens, _, _ := app.generateTokenPair(&schema.User{ID: 3}, "", false)
+
+	var theTests = []struct {
+		name               string
+		token              string
+		expectedStatusCode int
+	}{
+		{"user without two-factor", userTokens.Token, http.StatusOK},
+		{"user with two-factor", enabledTokens.Token, http.StatusConflict},
+	}
+
+	for _, e := range theTests {
+		req, _ := http.NewRequest("POST", "/api/v1/auth/2fa/enroll", nil)
+		req.Header.Set("Authorization", "Bearer "+e.token)
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(app.enrollTwoFactor).ServeHTTP(rr, req)
+		if e.expectedStatusCode != rr.Code {
+			t.Errorf("%s: returned wrong status code; expected %d but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+		if rr.Code == http.StatusOK && !strings.Contains(rr.Body.String(), "otpauth://totp/") {
+			t.Errorf("%s: expected an otpauth uri, got %s", e.name, rr.Body.String())
+		}
+	}
+}
+
+func Test_app_adminRequiredTwoFactorPolicy(t *testing.T) {
+	policyApp := app
+	policyApp.Cfgs.ADMIN_REQUIRE_2FA = true
+	nextHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	admin := &schema.User{ID: dbrepo.TestAdminID, Name: "Staff", IsAdmin: true}
+	passwordOnly, _, _ := policyApp.generateTokenPair(admin, "", false)
+	withTwoFactor, _, _ := policyApp.generateTokenPair(admin, "", true)
+
+	var tests = []struct {
+		name               string
+		token              string
+		expectedStatusCode int
+	}{
+		{"password only", passwordOnly.Token, http.StatusForbidden},
+		{"with two-factor", withTwoFactor.Token, http.StatusOK},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("POST", "/", nil)
+		req.Header.Set("Authorization", "Bearer "+e.token)
+		rr := httptest.NewRecorder()
+		policyApp.adminRequired(nextHandler).ServeHTTP(rr, req)
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: expected status %d but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+	}
+}
+
+func Test_generateRecoveryCodes(t *testing.T) {
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(codes) != recoveryCodeCount || len(hashes) != recoveryCodeCount {
+		t.Fatalf("expected %d codes, got %d", recoveryCodeCount, len(codes))
+	}
+	for i, code := range codes {
+		if hashRecoveryCode(strings.ToUpper(code)) != hashes[i] {
+			t.Errorf("code %q does not match its hash", code)
+		}
+	}
+}
+
+// throttleRecorder counts login failures and keeps locks like the database.
+type throttleRecorder struct {
+	dbrepo.TestDBRepo
+	throttles map[string]*schema.LoginThrottle
+}
+
+func (t *throttleRecorder) GetLoginThrottle(scope, key string) (*schema.LoginThrottle, error) {
+	throttle, ok := t.throttles[scope+" "+key]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	copied := *throttle
+	return &copied, nil
+}
+
+func (t *throttleRecorder) RecordLoginFailure(scope, key string, window time.Duration) (*schema.LoginThrottle, error) {
+	throttle, ok := t.throttles[scope+" "+key]
+	if !ok {
+		throttle = &schema.LoginThrottle{Scope: scope, Key: key}
+		t.throttles[scope+" "+key] = throttle
+	}
+	now := time.Now()
+	throttle.Failures++
+	throttle.LastFailureAt = &now
+	return t.GetLoginThrottle(scope, key)
+}
+
+func (t *throttleRecorder) LockLogin(lockout *schema.Lockout) error {
+	t.throttles[lockout.Scope+" "+lockout.Key].LockedUntil = &lockout.LockedUntil
+	return nil
+}
+
+func (t *throttleRecorder) ClearLoginFailures(scope, key string) error {
+	delete(t.throttles, scope+" "+key)
+	return nil
+}
+
+func Test_app_verifyTwoFactorThrottled(t *testing.T) {
+	recorder := &throttleRecorder{throttles: map[string]*schema.LoginThrottle{}}
+	testApp := app
+	testApp.DB = recorder
+	code, _ := totp.CodeAt(dbrepo.TestTOTPSecret, totp.Step(time.Now()))
+
+	verify := func(challenge, code string) int {
+		req, _ := http.NewRequest("POST", "/api/v1/auth/2fa/verify", strings.NewReader(`{"challenge_token":"`+challenge+`", "code":"`+code+`"}`))
+		req.RemoteAddr = "192.0.2.7:4321"
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(testApp.verifyTwoFactor).ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	// Wrong codes count against the account like wrong passwords, whatever
+	// challenge they come with, until even the right code has to wait.
+	for i := 0; i < loginDelayAfter; i++ {
+		challenge, _ := testApp.generateChallengeToken(&schema.User{ID: 3}, false)
+		if status := verify(challenge, "000000"); status != http.StatusUnauthorized {
+			t.Fatalf("wrong code %d: expected status 401 but got %d", i+1, status)
+		}
+	}
+	challenge, _ := testApp.generateChallengeToken(&schema.User{ID: 3}, false)
+	if status := verify(challenge, code); status != http.StatusTooManyRequests {
+		t.Errorf("after %d wrong codes: expected status 429 but got %d", loginDelayAfter, status)
+	}
+
+	// A challenge is spent after maxChallengeAttempts wrong codes.
+	recorder.throttles = map[string]*schema.LoginThrottle{}
+	challenge, _ = testApp.generateChallengeToken(&schema.User{ID: 3}, false)
+	claims, _, err := testApp.parseChallengeToken(challenge)
+	if err != nil || claims.ID == "" {
+		t.Fatalf("expected a challenge with an ID, got %v", err)
+	}
+	recorder.throttles[throttleChallenge+" "+claims.ID] = &schema.LoginThrottle{Failures: maxChallengeAttempts - 1}
+	if status := verify(challenge, "000000"); status != http.StatusUnauthorized {
+		t.Errorf("last wrong code: expected status 401 but got %d", status)
+	}
+	if status := verify(challenge, code); status != http.StatusUnauthorized {
+		t.Errorf("spent challenge: expected status 401 but got %d", status)
+	}
+
+	// The right code on a fresh challenge clears the account's failures.
+	challenge, _ = testApp.generateChallengeToken(&schema.User{ID: 3}, false)
+	if status := verify(challenge, code); status != http.StatusOK {
+		t.Errorf("right code: expected status 200 but got %d", status)
+	}
+	if _, ok := recorder.throttles[throttleAccount+" twofactor@example.com"]; ok {
+		t.Error("expected the account's failures to be cleared")
+	}
+}
@@ -1,14 +1,22 @@
 package store
 
 import (
+	"database/sql"
 	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
+	"net/mail"
 	"strconv"
+	"strings"
+	"time"
 
-	"github.com/go-chi/chi"
+	"github.com/MinhNHHH/online-store/pkg/databases/schema"
+	"golang.org/x/crypto/bcrypt"
 )
 
+// AddToWishlist adds a product to the wishlist of the signed in user. Admins
+// may name another user with user_id.
 func (app *OnlineStore) AddToWishlist(w http.ResponseWriter, r *http.Request) {
 	var request struct {
 		UserID    int `json:"user_id"`
@@ -19,9 +27,17 @@ func (app *OnlineStore) AddToWishlist(w http.ResponseWriter, r *http.Request) {
 		app.SendResponse(w, http.StatusBadRequest, err)
 		return
 	}
-	userID := request.UserID
-	productID := request.ProductID
-	err := app.DB.AddToWishlist(userID, productID)
+	p, err := app.requestPrincipal(w, r)
+	if err != nil {
+		app.SendError(w, http.StatusUnauthorized, errors.New("unauthorized"))
+		return
+	}
+	userID, err := app.actingUserID(p, request.UserID)
+	if err != nil {
+		app.SendError(w, http.StatusForbidden, err)
+		return
+	}
+	err = app.DB.AddToWishlist(userID, request.ProductID)
 	if err != nil {
 		app.SendResponse(w, http.StatusBadRequest, err)
 		return
@@ -29,6 +45,8 @@ func (app *OnlineStore) AddToWishlist(w http.ResponseWriter, r *http.Request) {
 	app.SendResponse(w, http.StatusCreated, nil)
 }
 
+// RemoveFromWishlist removes a product from the wishlist of the signed in
+// user. Admins may name another user with user_id.
 func (app *OnlineStore) RemoveFromWishlist(w http.ResponseWriter, r *http.Request) {
 	var request struct {
 		UserID    int `json:"user_id"`
@@ -39,9 +57,17 @@ func (app *OnlineStore) RemoveFromWishlist(w http.ResponseWriter, r *http.Reques
 		app.SendResponse(w, http.StatusBadRequest, err)
 		return
 	}
-	userID := request.UserID
-	productID := request.ProductID
-	err := app.DB.RemoveFromWishlist(userID, productID)
+	p, err := app.requestPrincipal(w, r)
+	if err != nil {
+		app.SendError(w, http.StatusUnauthorized, errors.New("unauthorized"))
+		return
+	}
+	userID, err := app.actingUserID(p, request.UserID)
+	if err != nil {
+		app.SendError(w, http.StatusForbidden, err)
+		return
+	}
+	err = app.DB.RemoveFromWishlist(userID, request.ProductID)
 	if err != nil {
 		log.Printf("Error removing from wishlist: %v", err)
 		app.SendResponse(w, http.StatusBadRequest, err)
@@ -50,11 +76,27 @@ func (app *OnlineStore) RemoveFromWishlist(w http.ResponseWriter, r *http.Reques
 	app.SendResponse(w, http.StatusOK, nil)
 }
 
+// GetWishlist returns the wishlist of the signed in user. Admins may name
+// another user with the user_id query parameter.
 func (app *OnlineStore) GetWishlist(w http.ResponseWriter, r *http.Request) {
-	userID, err := strconv.Atoi(chi.URLParam(r, "user_id"))
+	var requested int
+	if param := r.URL.Query().Get("user_id"); param != "" {
+		var err error
+		requested, err = strconv.Atoi(param)
+		if err != nil {
+			log.Printf("Error parsing user ID: %v", err)
+			app.SendResponse(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+	p, err := app.requestPrincipal(w, r)
 	if err != nil {
-		log.Printf("Error parsing user ID: %v", err)
-		app.SendResponse(w, http.StatusBadRequest, err)
+		app.SendError(w, http.StatusUnauthorized, errors.New("unauthorized"))
+		return
+	}
+	userID, err := app.actingUserID(p, requested)
+	if err != nil {
+		app.SendError(w, http.StatusForbidden, err)
 		return
 	}
 	products, err := app.DB.GetWishlist(userID)
@@ -63,5 +105,248 @@ func (app *OnlineStore) GetWishlist(w http.ResponseWriter, r *http.Request) {
 		app.SendResponse(w, http.StatusBadRequest, err)
 		return
 	}
-	app.SendResponse(w, http.StatusOK, products)
+	app.withImageURLs(products...)
+
+	response := struct {
+		Products []*schema.Product `json:"products"`
+	}{
+		Products: products,
+	}
+	app.SendResponse(w, http.StatusOK, response)
+}
+
+// UserProfile is what users, and admins, see of an account.
+type UserProfile struct {
+	ID               int        `json:"id"`
+	Name             string     `json:"name"`
+	Email            string     `json:"email"`
+	IsAdmin          bool       `json:"is_admin"`
+	EmailVerifiedAt  *time.Time `json:"email_verified_at,omitempty"`
+	TwoFactorEnabled bool       `json:"two_factor_enabled"`
+	DeactivatedAt    *time.Time `json:"deactivated_at,omitempty"`
+	// DeletionScheduledAt is set while the account is waiting to be purged.
+	DeletionScheduledAt *time.Time `json:"deletion_scheduled_at,omitempty"`
+	DeletionMode        string     `json:"deletion_mode,omitempty"`
+	CreatedAt           time.Time  `json:"created_at"`
+	UpdatedAt           time.Time  `json:"updated_at"`
+}
+
+func profileOf(user *schema.User) UserProfile {
+	return UserProfile{
+		ID:                  user.ID,
+		Name:                user.Name,
+		Email:               user.Email,
+		IsAdmin:             user.IsAdmin,
+		EmailVerifiedAt:     user.EmailVerifiedAt,
+		TwoFactorEnabled:    user.TOTPEnabledAt != nil,
+		DeactivatedAt:       user.DeactivatedAt,
+		DeletionScheduledAt: user.DeletionScheduledAt,
+		DeletionMode:        user.DeletionMode,
+		CreatedAt:           user.CreatedAt,
+		UpdatedAt:           user.UpdatedAt,
+	}
+}
+
+// currentUser loads the signed in user, answering the request itself when
+// that fails.
+func (app *OnlineStore) currentUser(w http.ResponseWriter, r *http.Request) *schema.User {
+	userID, err := app.authenticatedUserID(w, r)
+	if err != nil {
+		app.SendError(w, http.StatusUnauthorized, errors.New("unauthorized"))
+		return nil
+	}
+	user, err := app.DB.GetUser(userID)
+	if err != nil {
+		log.Printf("Error getting user: %v", err)
+		app.SendError(w, http.StatusUnauthorized, errors.New("unauthorized"))
+		return nil
+	}
+	return user
+}
+
+func (app *OnlineStore) GetCurrentUser(w http.ResponseWriter, r *http.Request) {
+	user := app.currentUser(w, r)
+	if user == nil {
+		return
+	}
+	app.SendResponse(w, http.StatusOK, profileOf(user))
+}
+
+// UpdateCurrentUser changes the name or email address of the signed in user.
+// A new email address needs the current password and has to be verified
+// again. Nobody can change their own admin flag.
+func (app *OnlineStore) UpdateCurrentUser(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Name            *string `json:"name"`
+		Email           *string `json:"email"`
+		CurrentPassword string  `json:"current_password"`
+		IsAdmin         *bool   `json:"is_admin"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		log.Printf("Error decoding request: %v", err)
+		app.SendError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	user := app.currentUser(w, r)
+	if user == nil {
+		return
+	}
+
+	if request.IsAdmin != nil && *request.IsAdmin != user.IsAdmin {
+		app.SendError(w, http.StatusForbidden, errors.New("forbidden: is_admin cannot be changed"))
+		return
+	}
+	if request.Name != nil {
+		name := strings.TrimSpace(*request.Name)
+		if name == "" {
+			app.SendError(w, http.StatusBadRequest, errors.New("name must not be empty"))
+			return
+		}
+		user.Name = name
+	}
+
+	emailChanged := false
+	if request.Email != nil && strings.TrimSpace(*request.Email) != user.Email {
+		email := strings.TrimSpace(*request.Email)
+		if _, err := mail.ParseAddress(email); err != nil {
+			app.SendError(w, http.StatusBadRequest, errors.New("invalid email address"))
+			return
+		}
+		if bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(request.CurrentPassword)) != nil {
+			app.recordAuthEvent(r, schema.AuthEvent{Type: eventEmailChange, Outcome: outcomeFailure, Reason: reasonWrongPassword, UserID: &user.ID, Email: user.Email})
+			app.SendError(w, http.StatusForbidden, errors.New("forbidden: current password is incorrect"))
+			return
+		}
+		_, err := app.DB.GetUserByEmail(email)
+		if err == nil {
+			app.SendError(w, http.StatusConflict, errors.New("email address already in use"))
+			return
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			log.Printf("Error getting user by email: %v", err)
+			app.SendError(w, http.StatusInternalServerError, errors.New("could not update user"))
+			return
+		}
+		user.Email = email
+		user.EmailVerifiedAt = nil
+		emailChanged = true
+	}
+
+	if err := app.DB.UpdateUser(*user); err != nil {
+		log.Printf("Error updating user: %v", err)
+		app.SendError(w, http.StatusInternalServerError, errors.New("could not update user"))
+		return
+	}
+	if emailChanged {
+		app.recordAuthEvent(r, schema.AuthEvent{Type: eventEmailChange, Outcome: outcomeSuccess, UserID: &user.ID, Email: user.Email})
+		if err := app.startEmailVerification(user.ID, user.Name, user.Email); err != nil {
+			log.Printf("Error starting email verification: %v", err)
+		}
+	}
+
+	app.SendResponse(w, http.StatusOK, profileOf(user))
+}
+
+// DeleteCurrentUser schedules the account of the signed in user to be purged
+// once the grace period is over, after checking their password. mode says
+// whether their reviews are deleted with it or kept anonymized. Every refresh
+// token and cookie session is revoked. Logging in before the purge restores
+// the account.
+func (app *OnlineStore) DeleteCurrentUser(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Password string `json:"password"`
+		Mode     string `json:"mode"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		log.Printf("Error decoding request: %v", err)
+		app.SendError(w, http.StatusBadRequest, err)
+		return
+	}
+	switch request.Mode {
+	case "":
+		request.Mode = schema.DeletionModeDelete
+	case schema.DeletionModeDelete, schema.DeletionModeAnonymize:
+	default:
+		app.SendError(w, http.StatusBadRequest, errors.New(`mode must be "delete" or "anonymize"`))
+		return
+	}
+
+	user := app.currentUser(w, r)
+	if user == nil {
+		return
+	}
+	if bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(request.Password)) != nil {
+		app.recordAuthEvent(r, schema.AuthEvent{Type: eventAccountDeletionRequested, Outcome: outcomeFailure, Reason: reasonWrongPassword, UserID: &user.ID, Email: user.Email})
+		app.SendError(w, http.StatusForbidden, errors.New("forbidden: password is incorrect"))
+		return
+	}
+
+	deletionAt := time.Now().Add(app.Cfgs.ACCOUNT_DELETION_GRACE)
+	if err := app.DB.ScheduleUserDeletion(user.ID, request.Mode, deletionAt); err != nil {
+		log.Printf("Error scheduling user deletion: %v", err)
+		app.SendError(w, http.StatusInternalServerError, errors.New("could not delete user"))
+		return
+	}
+	app.recordAuthEvent(r, schema.AuthEvent{Type: eventAccountDeletionRequested, Outcome: outcomeSuccess, UserID: &user.ID, Email: user.Email})
+	go app.sendDeletionScheduledEmail(user, deletionAt)
+
+	if err := app.DB.RevokeUserRefreshTokens(user.ID); err != nil {
+		log.Printf("Error revoking refresh tokens: %v", err)
+	}
+	if _, err := app.DB.RevokeUserSessions(user.ID); err != nil {
+		log.Printf("Error revoking sessions: %v", err)
+	}
+	if app.Session != nil {
+		if err := app.Session.Destroy(r.Context()); err != nil {
+			log.Printf("Error destroying session: %v", err)
+		}
+	}
+	app.setRefreshTokenCookie(w, "", -time.Second)
+
+	user.DeletionScheduledAt = &deletionAt
+	user.DeletionMode = request.Mode
+	app.SendResponse(w, http.StatusOK, profileOf(user))
+}
+
+// ChangePassword sets a new password for the signed in user, who has to know
+// the current one. Every refresh token and every other cookie session is
+// revoked, logging out other devices.
+func (app *OnlineStore) ChangePassword(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		CurrentPassword string `json:"current_password"`
+		NewPassword     string `json:"new_password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		log.Printf("Error decoding request: %v", err)
+		app.SendError(w, http.StatusBadRequest, err)
+		return
+	}
+	if !app.checkPassword(w, "new_password", request.NewPassword) {
+		return
+	}
+
+	user := app.currentUser(w, r)
+	if user == nil {
+		return
+	}
+	if bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(request.CurrentPassword)) != nil {
+		app.recordAuthEvent(r, schema.AuthEvent{Type: eventPasswordChange, Outcome: outcomeFailure, Reason: reasonWrongPassword, UserID: &user.ID, Email: user.Email})
+		app.SendError(w, http.StatusForbidden, errors.New("forbidden: current password is incorrect"))
+		return
+	}
+
+	if err := app.DB.ResetPassword(user.ID, request.NewPassword); err != nil {
+		log.Printf("Error changing password: %v", err)
+		app.SendError(w, http.StatusInternalServerError, errors.New("could not change password"))
+		return
+	}
+	if err := app.DB.RevokeUserRefreshTokens(user.ID); err != nil {
+		log.Printf("Error revoking refresh tokens: %v", err)
+	}
+	if err := app.revokeSessions(r, user.ID); err != nil {
+		log.Printf("Error revoking sessions: %v", err)
+	}
+	app.recordAuthEvent(r, schema.AuthEvent{Type: eventPasswordChange, Outcome: outcomeSuccess, UserID: &user.ID, Email: user.Email})
+	app.SendResponse(w, http.StatusOK, nil)
 }
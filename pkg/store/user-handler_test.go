@@ -0,0 +1,263 @@
+package store
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/MinhNHHH/online-store/pkg/databases/repositories/dbrepo"
+	"github.com/MinhNHHH/online-store/pkg/databases/schema"
+)
+
+func Test_app_Wishlist(t *testing.T) {
+	routes := app.Routes()
+
+	ownerTokens, _, _ := app.generateTokenPair(&schema.User{ID: 1, Name: "Owner"}, "", false)
+	userTokens, _, _ := app.generateTokenPair(&schema.User{ID: 2, Name: "User"}, "", false)
+	adminTokens, _, _ := app.generateTokenPair(&schema.User{ID: dbrepo.TestAdminID, Name: "Staff", IsAdmin: true}, "", false)
+
+	var theTests = []struct {
+		name               string
+		method             string
+		url                string
+		body               string
+		token              string
+		expectedStatusCode int
+		expectedProducts   int
+	}{
+		{"get own wishlist", "GET", "/api/v1/users/wishlist/", "", ownerTokens.Token, http.StatusOK, 1},
+		{"get empty wishlist", "GET", "/api/v1/users/wishlist/", "", userTokens.Token, http.StatusOK, 0},
+		{"get other user's wishlist", "GET", "/api/v1/users/wishlist/?user_id=1", "", userTokens.Token, http.StatusForbidden, 0},
+		{"admin gets user's wishlist", "GET", "/api/v1/users/wishlist/?user_id=1", "", adminTokens.Token, http.StatusOK, 1},
+		{"invalid user id", "GET", "/api/v1/users/wishlist/?user_id=abc", "", adminTokens.Token, http.StatusBadRequest, 0},
+		{"add to own wishlist", "POST", "/api/v1/users/wishlist/", `{"product_id":1}`, userTokens.Token, http.StatusCreated, 0},
+		{"add naming self", "POST", "/api/v1/users/wishlist/", `{"user_id":2, "product_id":1}`, userTokens.Token, http.StatusCreated, 0},
+		{"add to other user's wishlist", "POST", "/api/v1/users/wishlist/", `{"user_id":1, "product_id":1}`, userTokens.Token, http.StatusForbidden, 0},
+		{"admin adds for user", "POST", "/api/v1/users/wishlist/", `{"user_id":1, "product_id":1}`, adminTokens.Token, http.StatusCreated, 0},
+		{"remove from own wishlist", "DELETE", "/api/v1/users/wishlist/", `{"product_id":1}`, userTokens.Token, http.StatusOK, 0},
+		{"remove from other user's wishlist", "DELETE", "/api/v1/users/wishlist/", `{"user_id":1, "product_id":1}`, userTokens.Token, http.StatusForbidden, 0},
+		{"admin removes for user", "DELETE", "/api/v1/users/wishlist/", `{"user_id":1, "product_id":1}`, adminTokens.Token, http.StatusOK, 0},
+		{"no token", "GET", "/api/v1/users/wishlist/", "", "", http.StatusUnauthorized, 0},
+	}
+
+	for _, e := range theTests {
+		req, _ := http.NewRequest(e.method, e.url, strings.NewReader(e.body))
+		if e.token != "" {
+			req.Header.Set("Authorization", "Bearer "+e.token)
+		}
+		rr := httptest.NewRecorder()
+		routes.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: returned wrong status code; expected %d but got %d", e.name, e.expectedStatusCode, rr.Code)
+			continue
+		}
+		if e.method == "GET" && rr.Code == http.StatusOK {
+			var response struct {
+				Products []*schema.Product `json:"products"`
+			}
+			_ = json.NewDecoder(rr.Body).Decode(&response)
+			if len(response.Products) != e.expectedProducts {
+				t.Errorf("%s: expected %d products but got %d", e.name, e.expectedProducts, len(response.Products))
+			}
+		}
+	}
+}
+
+// reviewRecorder keeps the review last inserted.
+type reviewRecorder struct {
+	dbrepo.TestDBRepo
+	review schema.Review
+}
+
+func (p *reviewRecorder) InsertReview(review *schema.Review) (int, error) {
+	p.review = *review
+	return 1, nil
+}
+
+func Test_app_CreateReviewActsForPrincipal(t *testing.T) {
+	recorder := &reviewRecorder{}
+	testApp := app
+	testApp.DB = recorder
+	routes := testApp.Routes()
+
+	userTokens, _, _ := app.generateTokenPair(&schema.User{ID: 2, Name: "User"}, "", false)
+	adminTokens, _, _ := app.generateTokenPair(&schema.User{ID: dbrepo.TestAdminID, Name: "Staff", IsAdmin: true}, "", false)
+
+	var theTests = []struct {
+		name               string
+		body               string
+		token              string
+		expectedStatusCode int
+		expectedUserID     int
+	}{
+		{"own review", `{"rating":5}`, userTokens.Token, http.StatusCreated, 2},
+		{"product in body is ignored", `{"product_id":7, "rating":5}`, userTokens.Token, http.StatusCreated, 2},
+		{"review as other user", `{"user_id":1, "rating":5}`, userTokens.Token, http.StatusForbidden, 0},
+		{"admin reviews for user", `{"user_id":1, "rating":5}`, adminTokens.Token, http.StatusCreated, 1},
+	}
+
+	for _, e := range theTests {
+		recorder.review = schema.Review{}
+		req, _ := http.NewRequest("POST", "/api/v1/reviews/1", strings.NewReader(e.body))
+		req.Header.Set("Authorization", "Bearer "+e.token)
+		rr := httptest.NewRecorder()
+		routes.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: returned wrong status code; expected %d but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+		if rr.Code == http.StatusCreated && (recorder.review.ProductID != 1 || recorder.review.UserID != e.expectedUserID) {
+			t.Errorf("%s: expected a review of product 1 by user %d, got %+v", e.name, e.expectedUserID, recorder.review)
+		}
+	}
+}
+
+func Test_app_DeleteReview(t *testing.T) {
+	routes := app.Routes()
+
+	authorTokens, _, _ := app.generateTokenPair(&schema.User{ID: 1, Name: "Author"}, "", false)
+	userTokens, _, _ := app.generateTokenPair(&schema.User{ID: 2, Name: "User"}, "", false)
+	adminTokens, _, _ := app.generateTokenPair(&schema.User{ID: dbrepo.TestAdminID, Name: "Staff", IsAdmin: true}, "", false)
+
+	var theTests = []struct {
+		name               string
+		url                string
+		token              string
+		expectedStatusCode int
+	}{
+		{"author deletes", "/api/v1/reviews/1/1", authorTokens.Token, http.StatusOK},
+		{"other user deletes", "/api/v1/reviews/1/1", userTokens.Token, http.StatusForbidden},
+		{"admin deletes", "/api/v1/reviews/1/1", adminTokens.Token, http.StatusOK},
+		{"user deletes review of erased user", "/api/v1/reviews/1/2", userTokens.Token, http.StatusForbidden},
+		{"admin deletes review of erased user", "/api/v1/reviews/1/2", adminTokens.Token, http.StatusOK},
+		{"review of other product", "/api/v1/reviews/2/1", authorTokens.Token, http.StatusNotFound},
+		{"missing review", "/api/v1/reviews/1/9", authorTokens.Token, http.StatusNotFound},
+		{"invalid review id", "/api/v1/reviews/1/x", authorTokens.Token, http.StatusBadRequest},
+		{"missing product slug", "/api/v1/reviews/no-such-product/1", authorTokens.Token, http.StatusNotFound},
+	}
+
+	for _, e := range theTests {
+		req, _ := http.NewRequest("DELETE", e.url, nil)
+		req.Header.Set("Authorization", "Bearer "+e.token)
+		rr := httptest.NewRecorder()
+		routes.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: returned wrong status code; expected %d but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+	}
+}
+
+func Test_app_actingUserID(t *testing.T) {
+	testApp := app
+	testApp.Cfgs.ADMIN_REQUIRE_2FA = true
+
+	var theTests = []struct {
+		name      string
+		principal Principal
+		requested int
+		expected  int
+		expectErr bool
+	}{
+		{"self", Principal{UserID: 2}, 0, 2, false},
+		{"other user", Principal{UserID: 2}, 1, 0, true},
+		{"admin with second factor", Principal{UserID: 5, Admin: true, MFA: true}, 1, 1, false},
+		{"admin without second factor", Principal{UserID: 5, Admin: true}, 1, 0, true},
+		{"admin for self", Principal{UserID: 5, Admin: true}, 0, 5, false},
+		{"API key", Principal{APIKeyID: 1, Scopes: []string{scopeProductsRead}}, 0, 0, true},
+	}
+
+	for _, e := range theTests {
+		userID, err := testApp.actingUserID(&e.principal, e.requested)
+		if (err != nil) != e.expectErr {
+			t.Errorf("%s: unexpected error %v", e.name, err)
+		}
+		if userID != e.expected {
+			t.Errorf("%s: expected user %d but got %d", e.name, e.expected, userID)
+		}
+	}
+}
+
+func Test_app_CurrentUser(t *testing.T) {
+	routes := app.Routes()
+
+	userTokens, _, _ := app.generateTokenPair(&schema.User{ID: 1, Name: "Admin"}, "", false)
+	unknownTokens, _, _ := app.generateTokenPair(&schema.User{ID: 99, Name: "Gone"}, "", false)
+
+	var theTests = []struct {
+		name               string
+		method             string
+		url                string
+		body               string
+		token              string
+		expectedStatusCode int
+	}{
+		{"get profile", "GET", "/api/v1/users/me", "", userTokens.Token, http.StatusOK},
+		{"get profile of deleted user", "GET", "/api/v1/users/me", "", unknownTokens.Token, http.StatusUnauthorized},
+		{"get profile without token", "GET", "/api/v1/users/me", "", "", http.StatusUnauthorized},
+		{"rename", "PATCH", "/api/v1/users/me", `{"name":"New Name"}`, userTokens.Token, http.StatusOK},
+		{"empty name", "PATCH", "/api/v1/users/me", `{"name":" "}`, userTokens.Token, http.StatusBadRequest},
+		{"make self admin", "PATCH", "/api/v1/users/me", `{"is_admin":true}`, userTokens.Token, http.StatusForbidden},
+		{"same email", "PATCH", "/api/v1/users/me", `{"email":"admin@example.com"}`, userTokens.Token, http.StatusOK},
+		{"invalid email", "PATCH", "/api/v1/users/me", `{"email":"not an email", "current_password":"secret"}`, userTokens.Token, http.StatusBadRequest},
+		{"email without password", "PATCH", "/api/v1/users/me", `{"email":"new@example.com"}`, userTokens.Token, http.StatusForbidden},
+		{"email in use", "PATCH", "/api/v1/users/me", `{"email":"twofactor@example.com", "current_password":"secret"}`, userTokens.Token, http.StatusConflict},
+		{"change password without current", "POST", "/api/v1/users/me/password", `{"current_password":"wrong", "new_password":"n3w-secret"}`, userTokens.Token, http.StatusForbidden},
+		{"change password empty", "POST", "/api/v1/users/me/password", `{"current_password":"secret", "new_password":""}`, userTokens.Token, http.StatusBadRequest},
+		{"change password too short", "POST", "/api/v1/users/me/password", `{"current_password":"secret", "new_password":"n3w"}`, userTokens.Token, http.StatusBadRequest},
+		{"change password", "POST", "/api/v1/users/me/password", `{"current_password":"secret", "new_password":"n3w-secret"}`, userTokens.Token, http.StatusOK},
+		{"delete with wrong password", "DELETE", "/api/v1/users/me", `{"password":"wrong"}`, userTokens.Token, http.StatusForbidden},
+		{"delete with unknown mode", "DELETE", "/api/v1/users/me", `{"password":"secret", "mode":"shred"}`, userTokens.Token, http.StatusBadRequest},
+		{"delete", "DELETE", "/api/v1/users/me", `{"password":"secret"}`, userTokens.Token, http.StatusOK},
+	}
+
+	for _, e := range theTests {
+		req, _ := http.NewRequest(e.method, e.url, strings.NewReader(e.body))
+		if e.token != "" {
+			req.Header.Set("Authorization", "Bearer "+e.token)
+		}
+		rr := httptest.NewRecorder()
+		routes.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: returned wrong status code; expected %d but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+		if strings.Contains(rr.Body.String(), "password") && rr.Code == http.StatusOK {
+			t.Errorf("%s: response leaks the password: %s", e.name, rr.Body)
+		}
+	}
+}
+
+func Test_app_UpdateCurrentUserEmail(t *testing.T) {
+	routes := app.Routes()
+	userTokens, _, _ := app.generateTokenPair(&schema.User{ID: 1, Name: "Admin"}, "", false)
+
+	req, _ := http.NewRequest("PATCH", "/api/v1/users/me", strings.NewReader(`{"email":"new@example.com", "current_password":"secret"}`))
+	req.Header.Set("Authorization", "Bearer "+userTokens.Token)
+	rr := httptest.NewRecorder()
+	routes.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200 but got %d", rr.Code)
+	}
+	var profile UserProfile
+	_ = json.NewDecoder(rr.Body).Decode(&profile)
+	if profile.Email != "new@example.com" || profile.EmailVerifiedAt != nil {
+		t.Errorf("expected an unverified new email, got %+v", profile)
+	}
+
+	// the verification email is sent in the background
+	for i := 0; i < 50; i++ {
+		for _, msg := range outbox.Sent() {
+			if msg.To[0] == "new@example.com" && strings.Contains(msg.Body, "/verify-email?token=") {
+				return
+			}
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+	t.Error("expected a verification link sent to the new address")
+}
@@ -0,0 +1,180 @@
+package store
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	databases "github.com/MinhNHHH/online-store/pkg/databases/repositories"
+	"github.com/MinhNHHH/online-store/pkg/databases/schema"
+	"github.com/go-chi/chi"
+)
+
+// maxSKULength matches the sku column.
+const maxSKULength = 64
+
+func (app *OnlineStore) CreateProductVariant(w http.ResponseWriter, r *http.Request) {
+	product, ok := app.productFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	var variant schema.ProductVariant
+	if err := json.NewDecoder(r.Body).Decode(&variant); err != nil {
+		log.Printf("Error decoding variant: %v", err)
+		app.SendError(w, http.StatusBadRequest, err)
+		return
+	}
+	variant.ProductID = product.ID
+	if fields := validateVariant(product, &variant); len(fields) > 0 {
+		app.SendValidationError(w, fields)
+		return
+	}
+
+	id, err := app.DB.InsertProductVariant(&variant)
+	if err != nil {
+		app.sendVariantError(w, err)
+		return
+	}
+	variant.ID = id
+	app.SendResponse(w, http.StatusCreated, variant)
+}
+
+func (app *OnlineStore) UpdateProductVariant(w http.ResponseWriter, r *http.Request) {
+	product, ok := app.productFromURL(w, r)
+	if !ok {
+		return
+	}
+	variantID, err := strconv.Atoi(chi.URLParam(r, "variant_id"))
+	if err != nil {
+		log.Printf("Error parsing variant ID: %v", err)
+		app.SendError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	var variant schema.ProductVariant
+	if err := json.NewDecoder(r.Body).Decode(&variant); err != nil {
+		log.Printf("Error decoding variant: %v", err)
+		app.SendError(w, http.StatusBadRequest, err)
+		return
+	}
+	variant.ID = variantID
+	variant.ProductID = product.ID
+	if fields := validateVariant(product, &variant); len(fields) > 0 {
+		app.SendValidationError(w, fields)
+		return
+	}
+
+	if err := app.DB.UpdateProductVariant(&variant); err != nil {
+		app.sendVariantError(w, err)
+		return
+	}
+	app.SendResponse(w, http.StatusOK, variant)
+}
+
+func (app *OnlineStore) DeleteProductVariant(w http.ResponseWriter, r *http.Request) {
+	productID, ok := app.productIDFromParam(w, r, "id")
+	if !ok {
+		return
+	}
+	variantID, err := strconv.Atoi(chi.URLParam(r, "variant_id"))
+	if err != nil {
+		log.Printf("Error parsing variant ID: %v", err)
+		app.SendError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := app.DB.DeleteProductVariant(productID, variantID); err != nil {
+		app.sendVariantError(w, err)
+		return
+	}
+	app.SendResponse(w, http.StatusOK, nil)
+}
+
+func (app *OnlineStore) sendVariantError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		app.SendError(w, http.StatusNotFound, errors.New("variant not found"))
+	case errors.Is(err, databases.ErrDuplicateSKU), errors.Is(err, databases.ErrDuplicateVariant):
+		app.SendError(w, http.StatusConflict, err)
+	default:
+		log.Printf("Error saving variant: %v", err)
+		app.SendError(w, http.StatusInternalServerError, errors.New("could not save variant"))
+	}
+}
+
+// validateVariant normalizes variant and checks it against the options of
+// its product: it needs a value for each of them and no others. SKUs are
+// trimmed and upper-cased, and a variant without a status is in stock.
+func validateVariant(product *schema.Product, variant *schema.ProductVariant) []FieldError {
+	var fields []FieldError
+
+	variant.SKU = strings.ToUpper(strings.TrimSpace(variant.SKU))
+	switch {
+	case variant.SKU == "":
+		fields = append(fields, FieldError{Field: "sku", Code: "required", Message: "must not be empty"})
+	case len(variant.SKU) > maxSKULength:
+		fields = append(fields, FieldError{Field: "sku", Code: "too_long", Message: fmt.Sprintf("must be at most %d characters long", maxSKULength)})
+	}
+
+	options := make(map[string]string, len(variant.Options))
+	for _, name := range slices.Sorted(maps.Keys(variant.Options)) {
+		value := variant.Options[name]
+		if !slices.Contains(product.Options, name) {
+			fields = append(fields, FieldError{Field: "options." + name, Code: "unknown", Message: "is not an option of this product"})
+			continue
+		}
+		options[name] = strings.TrimSpace(value)
+	}
+	for _, name := range product.Options {
+		if options[name] == "" {
+			fields = append(fields, FieldError{Field: "options." + name, Code: "required", Message: "must not be empty"})
+		}
+	}
+	variant.Options = options
+
+	if variant.Price != nil && *variant.Price < 0 {
+		fields = append(fields, FieldError{Field: "price", Code: "invalid", Message: "must not be negative"})
+	}
+	if variant.StockQuantity < 0 {
+		fields = append(fields, FieldError{Field: "stock_quantity", Code: "invalid", Message: "must not be negative"})
+	}
+
+	if variant.Status == "" {
+		variant.Status = schema.ProductStatusInStock
+	}
+	if !validProductStatus(variant.Status) {
+		fields = append(fields, FieldError{Field: "status", Code: "invalid", Message: "must be in_stock, out_of_stock or draft"})
+	}
+	return fields
+}
+
+func validProductStatus(status string) bool {
+	switch status {
+	case schema.ProductStatusInStock, schema.ProductStatusOutOfStock, schema.ProductStatusDraft:
+		return true
+	}
+	return false
+}
+
+// normalizeOptions trims product options and reports whether they are usable:
+// none empty and no two the same.
+func normalizeOptions(options []string) ([]FieldError, bool) {
+	seen := make(map[string]bool, len(options))
+	for i, name := range options {
+		name = strings.TrimSpace(name)
+		if name == "" || seen[name] {
+			return []FieldError{{Field: "options", Code: "invalid", Message: "must be distinct, non-empty names"}}, false
+		}
+		seen[name] = true
+		options[i] = name
+	}
+	return nil, true
+}
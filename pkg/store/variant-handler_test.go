@@ -0,0 +1,215 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi"
+)
+
+func Test_app_CreateProductVariant(t *testing.T) {
+	var tests = []struct {
+		name               string
+		productID          string
+		requestBody        string
+		expectedStatusCode int
+		expectedFields     []string
+	}{
+		{
+			name:               "valid variant",
+			productID:          "1",
+			requestBody:        `{"sku": " tee-m ", "options": {"size": "M"}, "price": 22.5, "stock_quantity": 3}`,
+			expectedStatusCode: http.StatusCreated,
+		},
+		{
+			name:               "duplicate sku",
+			productID:          "1",
+			requestBody:        `{"sku": "tee-s", "options": {"size": "S"}}`,
+			expectedStatusCode: http.StatusConflict,
+		},
+		{
+			name:               "missing and unknown options",
+			productID:          "1",
+			requestBody:        `{"sku": "TEE-RED", "options": {"color": "red"}}`,
+			expectedStatusCode: http.StatusBadRequest,
+			expectedFields:     []string{"options.color", "options.size"},
+		},
+		{
+			name:               "invalid fields",
+			productID:          "1",
+			requestBody:        `{"sku": "", "options": {"size": "L"}, "price": -1, "stock_quantity": -1, "status": "sold"}`,
+			expectedStatusCode: http.StatusBadRequest,
+			expectedFields:     []string{"sku", "price", "stock_quantity", "status"},
+		},
+		{
+			name:               "unknown product",
+			productID:          "2",
+			requestBody:        `{"sku": "TEE-M", "options": {"size": "M"}}`,
+			expectedStatusCode: http.StatusNotFound,
+		},
+		{
+			name:               "unknown product slug",
+			productID:          "hoodie",
+			requestBody:        `{"sku": "TEE-M", "options": {"size": "M"}}`,
+			expectedStatusCode: http.StatusNotFound,
+		},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("POST", "/products/"+e.productID+"/variants", bytes.NewBufferString(e.requestBody))
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("id", e.productID)
+		req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(app.CreateProductVariant)
+		handler.ServeHTTP(rr, req)
+
+		if e.expectedStatusCode != rr.Code {
+			t.Errorf("%s: returned wrong status code; expected %d but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+
+		if e.expectedFields != nil {
+			var response ValidationErrorResponse
+			if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+				t.Fatalf("%s: %v", e.name, err)
+			}
+			if len(response.Fields) != len(e.expectedFields) {
+				t.Fatalf("%s: expected fields %v but got %+v", e.name, e.expectedFields, response.Fields)
+			}
+			for i, field := range response.Fields {
+				if field.Field != e.expectedFields[i] {
+					t.Errorf("%s: expected field %s but got %s", e.name, e.expectedFields[i], field.Field)
+				}
+			}
+		}
+	}
+}
+
+func Test_app_CreateProductVariantNormalizes(t *testing.T) {
+	req, _ := http.NewRequest("POST", "/products/1/variants", bytes.NewBufferString(`{"sku": " tee-m ", "options": {"size": " M "}}`))
+	rctx := chi.NewRouteContext()
+	rctx.URLParams.Add("id", "1")
+	req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(app.CreateProductVariant)
+	handler.ServeHTTP(rr, req)
+
+	var response struct {
+		SKU     string            `json:"sku"`
+		Options map[string]string `json:"options"`
+		Status  string            `json:"status"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	if response.SKU != "TEE-M" || response.Options["size"] != "M" || response.Status != "in_stock" {
+		t.Errorf("unexpected variant %+v", response)
+	}
+}
+
+func Test_app_UpdateProductVariant(t *testing.T) {
+	var tests = []struct {
+		name               string
+		variantID          string
+		expectedStatusCode int
+	}{
+		{name: "existing variant", variantID: "1", expectedStatusCode: http.StatusOK},
+		{name: "unknown variant", variantID: "9", expectedStatusCode: http.StatusNotFound},
+		{name: "invalid variant id", variantID: "abc", expectedStatusCode: http.StatusBadRequest},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("PUT", "/products/1/variants/"+e.variantID, bytes.NewBufferString(`{"sku": "TEE-S", "options": {"size": "S"}, "stock_quantity": 0}`))
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("id", "1")
+		rctx.URLParams.Add("variant_id", e.variantID)
+		req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(app.UpdateProductVariant)
+		handler.ServeHTTP(rr, req)
+
+		if e.expectedStatusCode != rr.Code {
+			t.Errorf("%s: returned wrong status code; expected %d but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+	}
+}
+
+func Test_app_DeleteProductVariant(t *testing.T) {
+	var tests = []struct {
+		name               string
+		productID          string
+		variantID          string
+		expectedStatusCode int
+	}{
+		{name: "existing variant", productID: "1", variantID: "1", expectedStatusCode: http.StatusOK},
+		{name: "variant of another product", productID: "2", variantID: "1", expectedStatusCode: http.StatusNotFound},
+		{name: "invalid variant id", productID: "1", variantID: "abc", expectedStatusCode: http.StatusBadRequest},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("DELETE", "/products/"+e.productID+"/variants/"+e.variantID, nil)
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("id", e.productID)
+		rctx.URLParams.Add("variant_id", e.variantID)
+		req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(app.DeleteProductVariant)
+		handler.ServeHTTP(rr, req)
+
+		if e.expectedStatusCode != rr.Code {
+			t.Errorf("%s: returned wrong status code; expected %d but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+	}
+}
+
+func Test_app_UpdateProductOptions(t *testing.T) {
+	var tests = []struct {
+		name               string
+		productID          string
+		requestBody        string
+		expectedStatusCode int
+	}{
+		{
+			name:               "same options",
+			productID:          "1",
+			requestBody:        `{"name": "T-shirt", "price": 20, "options": ["size"]}`,
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			name:               "options changed under variants",
+			productID:          "1",
+			requestBody:        `{"name": "T-shirt", "price": 20, "options": ["size", "color"]}`,
+			expectedStatusCode: http.StatusConflict,
+		},
+		{
+			name:               "duplicate options",
+			productID:          "2",
+			requestBody:        `{"name": "Hoodie", "price": 20, "options": ["size", " size"]}`,
+			expectedStatusCode: http.StatusBadRequest,
+		},
+		{
+			name:               "options of a product without variants",
+			productID:          "2",
+			requestBody:        `{"name": "Hoodie", "price": 20, "options": ["size", "color"]}`,
+			expectedStatusCode: http.StatusOK,
+		},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("PUT", "/products/"+e.productID, bytes.NewBufferString(e.requestBody))
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("id", e.productID)
+		req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(app.UpdateProduct)
+		handler.ServeHTTP(rr, req)
+
+		if e.expectedStatusCode != rr.Code {
+			t.Errorf("%s: returned wrong status code; expected %d but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package store
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/MinhNHHH/online-store/pkg/cfgs"
+	"github.com/MinhNHHH/online-store/pkg/mailer"
+)
+
+// Values of Cfgs.EMAIL_VERIFICATION.
+const (
+	// emailVerificationOptional puts no restriction on unverified accounts.
+	emailVerificationOptional = "optional"
+	// emailVerificationLimited lets unverified accounts log in and browse but
+	// not write reviews or wishlists.
+	emailVerificationLimited = "limited"
+	// emailVerificationRequired refuses to log in unverified accounts.
+	emailVerificationRequired = "required"
+)
+
+var emailVerificationExpiry = time.Hour * 48
+
+// CheckEmailVerification reports an EMAIL_VERIFICATION that is not one of the
+// modes, so that a typo does not quietly leave accounts unverified.
+func CheckEmailVerification(c cfgs.Configs) error {
+	switch c.EMAIL_VERIFICATION {
+	case emailVerificationOptional, emailVerificationLimited, emailVerificationRequired:
+		return nil
+	}
+	return fmt.Errorf("unsupported email verification mode %q", c.EMAIL_VERIFICATION)
+}
+
+// startEmailVerification stores a new verification token for email and mails
+// it to the user in the background.
+func (app *OnlineStore) startEmailVerification(userID int, name, email string) error {
+	token, err := randomToken(32)
+	if err != nil {
+		return err
+	}
+
+	err = app.DB.InsertEmailVerificationToken(userID, email, hashToken(token), time.Now().Add(emailVerificationExpiry))
+	if err != nil {
+		return err
+	}
+
+	go app.sendVerificationEmail(name, email, token)
+	return nil
+}
+
+func (app *OnlineStore) sendVerificationEmail(name, email, token string) {
+	link := fmt.Sprintf("%s/verify-email?token=%s", app.Cfgs.APP_URL, url.QueryEscape(token))
+	msg := mailer.Message{
+		To:      []string{email},
+		Subject: "Verify your email address",
+		Body: fmt.Sprintf("Hi %s,\n\nPlease confirm this is your email address by opening the link below. It expires in %d hours.\n\n%s\n",
+			name, int(emailVerificationExpiry.Hours()), link),
+	}
+	if err := app.Mailer.Send(msg); err != nil {
+		log.Printf("Error sending verification email: %v", err)
+	}
+}
+
+func (app *OnlineStore) verifyEmail(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Token string `json:"token"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		log.Printf("Error decoding request: %v", err)
+		app.SendError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	_, err := app.DB.VerifyEmailWithToken(hashToken(request.Token))
+	if errors.Is(err, sql.ErrNoRows) {
+		app.SendError(w, http.StatusBadRequest, errors.New("invalid or expired verification token"))
+		return
+	}
+	if err != nil {
+		log.Printf("Error verifying email: %v", err)
+		app.SendError(w, http.StatusInternalServerError, errors.New("could not verify email"))
+		return
+	}
+
+	app.SendResponse(w, http.StatusOK, nil)
+}
+
+// resendVerification sends a fresh verification link to an unverified
+// account. Like forgotPassword it answers the same way for unknown addresses.
+func (app *OnlineStore) resendVerification(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Email string `json:"email"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		log.Printf("Error decoding request: %v", err)
+		app.SendError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	response := struct {
+		Message string `json:"message"`
+	}{
+		Message: "if the email is registered and unverified, a verification link has been sent",
+	}
+
+	user, err := app.DB.GetUserByEmail(request.Email)
+	if err != nil || user.EmailVerifiedAt != nil {
+		app.SendResponse(w, http.StatusAccepted, response)
+		return
+	}
+
+	if err := app.startEmailVerification(user.ID, user.Name, user.Email); err != nil {
+		log.Printf("Error starting email verification: %v", err)
+	}
+	app.SendResponse(w, http.StatusAccepted, response)
+}
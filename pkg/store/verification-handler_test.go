@@ -0,0 +1,141 @@
+package store
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/MinhNHHH/online-store/pkg/databases/schema"
+)
+
+func Test_app_verifyEmail(t *testing.T) {
+	var theTests = []struct {
+		name               string
+		requestBody        string
+		expectedStatusCode int
+	}{
+		{"valid token", `{"token":"valid-verify-token"}`, http.StatusOK},
+		{"invalid token", `{"token":"stale-verify-token"}`, http.StatusBadRequest},
+		{"not json", `i am not JSON`, http.StatusBadRequest},
+	}
+
+	for _, e := range theTests {
+		req, _ := http.NewRequest("POST", "/api/v1/auth/verify-email", strings.NewReader(e.requestBody))
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(app.verifyEmail)
+		handler.ServeHTTP(rr, req)
+		if e.expectedStatusCode != rr.Code {
+			t.Errorf("%s: returned wrong status code; expected %d but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+	}
+}
+
+func Test_app_resendVerification(t *testing.T) {
+	var theTests = []struct {
+		name               string
+		requestBody        string
+		expectedStatusCode int
+	}{
+		{"unverified email", `{"email":"unverified@example.com"}`, http.StatusAccepted},
+		{"verified email", `{"email":"admin@example.com"}`, http.StatusAccepted},
+		{"unknown email", `{"email":"nobody@example.com"}`, http.StatusAccepted},
+		{"not json", `i am not JSON`, http.StatusBadRequest},
+	}
+
+	for _, e := range theTests {
+		req, _ := http.NewRequest("POST", "/api/v1/auth/resend-verification", strings.NewReader(e.requestBody))
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(app.resendVerification)
+		handler.ServeHTTP(rr, req)
+		if e.expectedStatusCode != rr.Code {
+			t.Errorf("%s: returned wrong status code; expected %d but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+	}
+}
+
+func Test_app_authenticateEmailVerificationRequired(t *testing.T) {
+	requiredApp := app
+	requiredApp.Cfgs.EMAIL_VERIFICATION = emailVerificationRequired
+
+	var theTests = []struct {
+		name               string
+		requestBody        string
+		expectedStatusCode int
+	}{
+		{"verified user", `{"email":"admin@example.com", "password":"secret"}`, http.StatusOK},
+		{"unverified user", `{"email":"unverified@example.com", "password":"secret"}`, http.StatusForbidden},
+	}
+
+	for _, e := range theTests {
+		req, _ := http.NewRequest("POST", "/api/v1/auth", strings.NewReader(e.requestBody))
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(requiredApp.authenticate)
+		handler.ServeHTTP(rr, req)
+		if e.expectedStatusCode != rr.Code {
+			t.Errorf("%s: returned wrong status code; expected %d but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+	}
+}
+
+func Test_app_verifiedEmailRequired(t *testing.T) {
+	nextHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	verifiedAt := time.Now()
+	verifiedTokens, _, _ := app.generateTokenPair(&schema.User{ID: 1, Name: "Admin", EmailVerifiedAt: &verifiedAt}, "", false)
+	unverifiedTokens, _, _ := app.generateTokenPair(&schema.User{ID: 2, Name: "Unverified"}, "", false)
+	staleTokens, _, _ := app.generateTokenPair(&schema.User{ID: 2, Name: "Unverified", EmailVerifiedAt: &verifiedAt}, "", false)
+
+	var tests = []struct {
+		name               string
+		mode               string
+		token              string
+		expectedStatusCode int
+	}{
+		{"limited verified", emailVerificationLimited, verifiedTokens.Token, http.StatusOK},
+		{"limited unverified", emailVerificationLimited, unverifiedTokens.Token, http.StatusForbidden},
+		{"limited no longer verified", emailVerificationLimited, staleTokens.Token, http.StatusForbidden},
+		{"optional unverified", emailVerificationOptional, unverifiedTokens.Token, http.StatusOK},
+	}
+
+	for _, e := range tests {
+		testApp := app
+		testApp.Cfgs.EMAIL_VERIFICATION = e.mode
+
+		req, _ := http.NewRequest("POST", "/", nil)
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", e.token))
+		rr := httptest.NewRecorder()
+		testApp.verifiedEmailRequired(nextHandler).ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: expected status %d but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+	}
+}
+
+func Test_CheckEmailVerification(t *testing.T) {
+	var tests = []struct {
+		mode    string
+		wantErr bool
+	}{
+		{emailVerificationOptional, false},
+		{emailVerificationLimited, false},
+		{emailVerificationRequired, false},
+		{"requried", true},
+		{"", true},
+	}
+
+	for _, e := range tests {
+		testApp := app
+		testApp.Cfgs.EMAIL_VERIFICATION = e.mode
+		err := CheckEmailVerification(testApp.Cfgs)
+		if e.wantErr && err == nil {
+			t.Errorf("%q: expected an error", e.mode)
+		}
+		if !e.wantErr && err != nil {
+			t.Errorf("%q: unexpected error %v", e.mode, err)
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package thumbnail
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestFit(t *testing.T) {
+	var tests = []struct {
+		name           string
+		width, height  int
+		size           int
+		expectedWidth  int
+		expectedHeight int
+	}{
+		{name: "landscape", width: 400, height: 200, size: 100, expectedWidth: 100, expectedHeight: 50},
+		{name: "portrait", width: 200, height: 400, size: 100, expectedWidth: 50, expectedHeight: 100},
+		{name: "already fits", width: 80, height: 60, size: 100, expectedWidth: 80, expectedHeight: 60},
+		{name: "very thin", width: 1000, height: 2, size: 100, expectedWidth: 100, expectedHeight: 1},
+	}
+
+	for _, e := range tests {
+		img := image.NewRGBA(image.Rect(0, 0, e.width, e.height))
+		bounds := Fit(img, e.size).Bounds()
+		if bounds.Dx() != e.expectedWidth || bounds.Dy() != e.expectedHeight {
+			t.Errorf("%s: expected %dx%d but got %dx%d", e.name, e.expectedWidth, e.expectedHeight, bounds.Dx(), bounds.Dy())
+		}
+	}
+}
+
+func TestFit_Averages(t *testing.T) {
+	// Black and white columns average to grey.
+	img := image.NewGray(image.Rect(10, 10, 14, 12))
+	for x := 10; x < 14; x += 2 {
+		for y := 10; y < 12; y++ {
+			img.SetGray(x, y, color.Gray{Y: 255})
+		}
+	}
+
+	thumb := Fit(img, 2)
+	r, g, b, a := thumb.At(0, 0).RGBA()
+	if r != 0x7f7f || g != 0x7f7f || b != 0x7f7f || a != 0xffff {
+		t.Errorf("expected grey but got %x %x %x %x", r, g, b, a)
+	}
+}
@@ -0,0 +1,82 @@
+package totp
+
+import (
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA-1 seed from RFC 6238 appendix B.
+var rfcSecret = base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+
+func TestCodeAt(t *testing.T) {
+	// RFC 6238 appendix B lists 8 digit codes; the last 6 digits are the
+	// 6 digit codes for the same times.
+	var tests = []struct {
+		unix     int64
+		expected string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+
+	for _, e := range tests {
+		code, err := CodeAt(rfcSecret, Step(time.Unix(e.unix, 0)))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if code != e.expected {
+			t.Errorf("at %d: expected %s but got %s", e.unix, e.expected, code)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	current, _ := CodeAt(rfcSecret, Step(now))
+	previous, _ := CodeAt(rfcSecret, Step(now)-1)
+	tooOld, _ := CodeAt(rfcSecret, Step(now)-2)
+
+	var tests = []struct {
+		name  string
+		code  string
+		valid bool
+	}{
+		{"current step", current, true},
+		{"previous step", previous, true},
+		{"two steps old", tooOld, false},
+		{"wrong code", "000000", current == "000000"},
+		{"wrong length", "12345", false},
+	}
+
+	for _, e := range tests {
+		_, ok := Validate(rfcSecret, e.code, now)
+		if ok != e.valid {
+			t.Errorf("%s: expected valid=%v", e.name, e.valid)
+		}
+	}
+}
+
+func TestURI(t *testing.T) {
+	uri := URI("example.com", "alice@example.com", "ABC")
+	for _, want := range []string{"otpauth://totp/example.com:alice@example.com?", "secret=ABC", "issuer=example.com", "digits=6", "period=30"} {
+		if !strings.Contains(uri, want) {
+			t.Errorf("expected %q in %q", want, uri)
+		}
+	}
+}
+
+func TestGenerateSecret(t *testing.T) {
+	secret, err := GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := CodeAt(secret, 1); err != nil {
+		t.Errorf("generated secret is not usable: %v", err)
+	}
+}